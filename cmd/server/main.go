@@ -1,251 +1,202 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"nvim-smart-keybind-search/internal/chromadb"
+	"nvim-smart-keybind-search/internal/config"
 	"nvim-smart-keybind-search/internal/ollama"
 	"nvim-smart-keybind-search/internal/rag"
 	"nvim-smart-keybind-search/internal/server"
 )
 
-// JSONRPCRequest represents a JSON-RPC 2.0 request
-type JSONRPCRequest struct {
-	JSONRPC string      `json:"jsonrpc"`
-	Method  string      `json:"method"`
-	Params  interface{} `json:"params"`
-	ID      interface{} `json:"id"`
-}
-
-// JSONRPCResponse represents a JSON-RPC 2.0 response
-type JSONRPCResponse struct {
-	JSONRPC string      `json:"jsonrpc"`
-	Result  interface{} `json:"result,omitempty"`
-	Error   *RPCError   `json:"error,omitempty"`
-	ID      interface{} `json:"id"`
-}
-
-// RPCError represents a JSON-RPC error
-type RPCError struct {
-	Code    int         `json:"code"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
-}
-
 func main() {
-	// Initialize dependencies
-	vectorDB, err := chromadb.NewClient(chromadb.DefaultConfig())
-	if err != nil {
-		log.Fatalf("Failed to create ChromaDB client: %v", err)
-	}
-
-	// Create collection manager
-	collectionManager := chromadb.NewCollectionManager(vectorDB)
-
-	llmClient := ollama.NewClient("")
+	daemonMode := flag.Bool("daemon", false, "serve many clients over a Unix socket instead of stdin/stdout")
+	connectMode := flag.Bool("connect", false, "relay stdin/stdout to the shared daemon, starting it if needed")
 
-	// Initialize the clients (this will auto-install and start services if needed)
-	log.Println("Initializing ChromaDB...")
-	if err := vectorDB.Initialize(); err != nil {
-		log.Fatalf("Failed to initialize ChromaDB: %v", err)
-	}
-
-	log.Println("Initializing Collection Manager...")
-	if err := collectionManager.Initialize(); err != nil {
-		log.Fatalf("Failed to initialize Collection Manager: %v", err)
+	// Every other flag is a setting, layered over the config file and environment
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	dispatcherConfig := cfg.DispatcherConfig()
+	daemonConfig := cfg.DaemonConfig()
 
-	log.Println("Initializing Ollama...")
-	if err := llmClient.Initialize(); err != nil {
-		log.Fatalf("Failed to initialize Ollama: %v", err)
+	if *connectMode {
+		runProxy(daemonConfig)
+		return
 	}
 
-	// Create RAG agent with collection manager
-	ragAgent := rag.NewAgent(vectorDB, collectionManager, llmClient, rag.DefaultAgentConfig())
-
-	// Create RPC service with actual dependencies
-	rpcService := server.NewRPCService(ragAgent, vectorDB, llmClient)
-
-	log.Println("Starting JSON-RPC server on stdin/stdout")
+	// Methods are registered below once the service exists; requests are only read once
+	// serving starts
+	registry := server.NewRegistry("nvim-smart-keybind-search", server.Version)
 
-	// Read from stdin and write to stdout for JSON-RPC communication
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
+	// Dispatch requests concurrently so a slow Query cannot block health checks
+	dispatcher := server.NewDispatcher(registry.Handle, dispatcherConfig)
 
-		// Parse JSON-RPC request
-		var req JSONRPCRequest
-		if err := json.Unmarshal([]byte(line), &req); err != nil {
-			sendErrorResponse(-32700, "Parse error", req.ID)
-			continue
-		}
-
-		// Validate JSON-RPC version
-		if req.JSONRPC != "2.0" {
-			sendErrorResponse(-32600, "Invalid Request", req.ID)
-			continue
-		}
-
-		// Handle different methods
-		var result interface{}
-		var rpcErr *RPCError
-
-		switch req.Method {
-		case "Query":
-			result, rpcErr = handleQuery(rpcService, req.Params)
-		case "SyncKeybindings":
-			result, rpcErr = handleSyncKeybindings(rpcService, req.Params)
-		case "UpdateKeybindings":
-			result, rpcErr = handleUpdateKeybindings(rpcService, req.Params)
-		case "HealthCheck":
-			result, rpcErr = handleHealthCheck(rpcService, req.Params)
-		case "DetailedHealthCheck":
-			result, rpcErr = handleDetailedHealthCheck(rpcService, req.Params)
-		case "GetMetrics":
-			result, rpcErr = handleGetMetrics(rpcService, req.Params)
-		default:
-			rpcErr = &RPCError{
-				Code:    -32601,
-				Message: "Method not found",
+	// Bind the socket before the slow dependency setup so clients can connect right away
+	// and a second daemon started concurrently exits early
+	var daemon *server.Daemon
+	if *daemonMode {
+		daemon = server.NewDaemon(dispatcher, daemonConfig)
+		if err := daemon.Listen(); err != nil {
+			if errors.Is(err, server.ErrDaemonRunning) {
+				log.Printf("Daemon already running on %s", daemonConfig.SocketPath)
+				return
 			}
+			log.Fatalf("Failed to start daemon: %v", err)
 		}
+	}
 
-		// Send response
-		response := JSONRPCResponse{
-			JSONRPC: "2.0",
-			ID:      req.ID,
-		}
-
-		if rpcErr != nil {
-			response.Error = rpcErr
-		} else {
-			response.Result = result
+	serviceManager := newServiceManager(cfg)
+	serviceManager.GetRPCService().SetConfig(cfg)
+	serviceManager.SetDispatcher(dispatcher)
+	if daemon != nil {
+		serviceManager.SetDaemon(daemon)
+	}
+	serviceManager.RegisterMethods(registry)
+
+	// SIGINT and SIGTERM shut down gracefully, like a Shutdown request followed by Exit
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v, shutting down", sig)
+		serviceManager.Shutdown(context.Background())
+		serviceManager.Exit()
+	}()
+
+	// On Exit, stop reading requests; Serve returns once the pending responses are written
+	go func() {
+		<-serviceManager.Done()
+		serviceManager.Shutdown(context.Background())
+		dispatcher.Close()
+		if daemon != nil {
+			daemon.Close()
 		}
-
-		responseBytes, err := json.Marshal(response)
-		if err != nil {
-			log.Printf("Failed to marshal response: %v", err)
-			continue
+	}()
+
+	if daemon != nil {
+		runDaemon(daemon, daemonConfig)
+	} else {
+		log.Printf("Starting RPC server on stdin/stdout (%s transport, %s framing)", dispatcherConfig.Transport, dispatcherConfig.Framing)
+		if err := dispatcher.Serve(os.Stdin, os.Stdout); err != nil {
+			log.Printf("Error reading from stdin: %v", err)
 		}
-
-		os.Stdout.Write(responseBytes)
-		os.Stdout.Write([]byte("\n"))
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatalf("Error reading from stdin: %v", err)
+	// The client went away or asked us to exit: release everything before leaving
+	if err := serviceManager.Shutdown(context.Background()); err != nil {
+		log.Printf("Error during shutdown: %v", err)
 	}
+	os.Exit(serviceManager.ExitCode())
 }
 
-func handleQuery(service *server.RPCService, params interface{}) (interface{}, *RPCError) {
-	// Parse params
-	paramsBytes, err := json.Marshal(params)
+// newServiceManager creates the dependencies and starts the service manager, which
+// initializes them and owns their lifecycle
+func newServiceManager(cfg *config.Config) *server.ServiceManager {
+	// Initialize dependencies
+	vectorDB, err := chromadb.NewClient(cfg.ChromaConfig())
 	if err != nil {
-		return nil, &RPCError{Code: -32602, Message: "Invalid params"}
+		log.Fatalf("Failed to create ChromaDB client: %v", err)
 	}
 
-	var args server.QueryArgs
-	if err := json.Unmarshal(paramsBytes, &args); err != nil {
-		return nil, &RPCError{Code: -32602, Message: "Invalid params"}
-	}
+	// Create collection manager
+	collectionManager := chromadb.NewCollectionManager(vectorDB)
 
-	var result server.QueryResult
-	if err := service.Query(&args, &result); err != nil {
-		return nil, &RPCError{Code: -32603, Message: err.Error()}
-	}
+	llmClient := ollama.NewClient(cfg.Ollama.URL)
 
-	return result, nil
-}
+	// Create RAG agent with collection manager
+	ragAgent := rag.NewAgent(vectorDB, collectionManager, llmClient, cfg.AgentConfig())
 
-func handleSyncKeybindings(service *server.RPCService, params interface{}) (interface{}, *RPCError) {
-	// Parse params
-	paramsBytes, err := json.Marshal(params)
-	if err != nil {
-		return nil, &RPCError{Code: -32602, Message: "Invalid params"}
+	// Initialize the clients (this will auto-install and start services if needed)
+	log.Println("Initializing ChromaDB, collections and Ollama...")
+	serviceManager := server.NewServiceManager(ragAgent, vectorDB, llmClient, cfg.ServiceManagerConfig())
+	if err := serviceManager.Start(); err != nil {
+		// Stop whatever was started before the failure
+		vectorDB.Close()
+		llmClient.Close()
+		log.Fatalf("Failed to start service: %v", err)
+	}
+
+	if cfg.Ollama.Model != "" {
+		if err := llmClient.LoadModel(cfg.Ollama.Model); err != nil {
+			log.Printf("Warning: failed to load model %s: %v", cfg.Ollama.Model, err)
+		}
 	}
 
-	var args server.SyncKeybindingsArgs
-	if err := json.Unmarshal(paramsBytes, &args); err != nil {
-		return nil, &RPCError{Code: -32602, Message: "Invalid params"}
-	}
+	return serviceManager
+}
 
-	var result server.SyncKeybindingsResult
-	if err := service.SyncKeybindings(&args, &result); err != nil {
-		return nil, &RPCError{Code: -32603, Message: err.Error()}
+// runDaemon serves clients on the daemon socket until it goes idle or is closed
+func runDaemon(daemon *server.Daemon, config *server.DaemonConfig) {
+	log.Printf("Starting RPC daemon on %s (idle timeout %v)", config.SocketPath, config.IdleTimeout)
+	if err := daemon.Serve(); err != nil {
+		log.Fatalf("Daemon error: %v", err)
 	}
-
-	return result, nil
+	log.Println("Daemon stopped")
 }
 
-func handleUpdateKeybindings(service *server.RPCService, params interface{}) (interface{}, *RPCError) {
-	// Parse params
-	paramsBytes, err := json.Marshal(params)
-	if err != nil {
-		return nil, &RPCError{Code: -32602, Message: "Invalid params"}
+// runProxy relays stdin/stdout to the shared daemon, starting one in the background if
+// none is listening yet
+func runProxy(daemonConfig *server.DaemonConfig) {
+	start := func() error {
+		return startDaemon(daemonConfig)
 	}
 
-	var args server.UpdateKeybindingsArgs
-	if err := json.Unmarshal(paramsBytes, &args); err != nil {
-		return nil, &RPCError{Code: -32602, Message: "Invalid params"}
+	conn, err := server.ConnectDaemon(daemonConfig.SocketPath, start, 30*time.Second)
+	if err != nil {
+		log.Fatalf("Failed to connect to daemon: %v", err)
 	}
 
-	var result server.UpdateKeybindingsResult
-	if err := service.UpdateKeybindings(&args, &result); err != nil {
-		return nil, &RPCError{Code: -32603, Message: err.Error()}
+	if err := server.ProxyConn(conn, os.Stdin, os.Stdout); err != nil {
+		log.Fatalf("Proxy error: %v", err)
 	}
-
-	return result, nil
 }
 
-func handleHealthCheck(service *server.RPCService, params interface{}) (interface{}, *RPCError) {
-	var result server.HealthStatus
-	if err := service.HealthCheck(&server.HealthCheckArgs{}, &result); err != nil {
-		return nil, &RPCError{Code: -32603, Message: err.Error()}
+// startDaemon launches this binary in daemon mode, detached from the calling client so
+// it outlives it. The daemon gets the same flags and environment, so it loads the same
+// configuration, and logs next to its socket.
+func startDaemon(daemonConfig *server.DaemonConfig) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate server binary: %w", err)
 	}
 
-	return result, nil
-}
-
-func handleDetailedHealthCheck(service *server.RPCService, params interface{}) (interface{}, *RPCError) {
-	var result server.DetailedHealthStatus
-	if err := service.DetailedHealthCheck(&server.DetailedHealthCheckArgs{}, &result); err != nil {
-		return nil, &RPCError{Code: -32603, Message: err.Error()}
+	// The log is kept next to the socket, in the directory only this user may enter
+	if err := server.EnsureSocketDir(daemonConfig.SocketPath); err != nil {
+		return fmt.Errorf("refusing socket directory: %w", err)
 	}
-
-	return result, nil
-}
-
-func handleGetMetrics(service *server.RPCService, params interface{}) (interface{}, *RPCError) {
-	var result server.PerformanceMetrics
-	if err := service.GetMetrics(&server.GetMetricsArgs{}, &result); err != nil {
-		return nil, &RPCError{Code: -32603, Message: err.Error()}
+	logFile, err := os.OpenFile(daemonConfig.SocketPath+".log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open daemon log: %w", err)
 	}
+	defer logFile.Close()
 
-	return result, nil
-}
+	args := []string{"-daemon"}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "connect" {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
 
-func sendErrorResponse(code int, message string, id interface{}) {
-	response := JSONRPCResponse{
-		JSONRPC: "2.0",
-		Error: &RPCError{
-			Code:    code,
-			Message: message,
-		},
-		ID: id,
-	}
+	cmd := exec.Command(executable, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = server.DetachedProcAttr()
 
-	responseBytes, err := json.Marshal(response)
-	if err != nil {
-		log.Printf("Failed to marshal error response: %v", err)
-		return
+	log.Printf("Starting daemon on %s", daemonConfig.SocketPath)
+	if err := cmd.Start(); err != nil {
+		return err
 	}
-
-	os.Stdout.Write(responseBytes)
-	os.Stdout.Write([]byte("\n"))
+	return cmd.Process.Release()
 }
@@ -2,12 +2,16 @@ module nvim-smart-keybind-search
 
 go 1.24.5
 
-require github.com/amikos-tech/chroma-go v0.2.3
+require (
+	github.com/amikos-tech/chroma-go v0.2.3
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+)
 
 require (
 	github.com/go-viper/mapstructure/v2 v2.2.1 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/oklog/ulid v1.3.1 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	github.com/yalue/onnxruntime_go v1.19.0 // indirect
 )
@@ -3,13 +3,17 @@ package chromadb
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"nvim-smart-keybind-search/internal/interfaces"
@@ -23,8 +27,23 @@ type Client struct {
 	collection *chroma.Collection
 	config     *Config
 	ctx        context.Context
+
+	// rebuildMu is held for reading while ChromaDB is in use and for writing while the
+	// database is rebuilt, during which calls are refused with ErrRebuilding
+	rebuildMu  sync.RWMutex
+	rebuilding atomic.Bool
+
+	// service is the ChromaDB process started by this client, stopped again on Close
+	serviceMu sync.Mutex
+	service   *exec.Cmd
 }
 
+// ErrRebuilding is returned by calls made while the database is being rebuilt
+var ErrRebuilding = errors.New("ChromaDB is being rebuilt")
+
+// serviceStopTimeout bounds how long Close waits for a started ChromaDB to exit
+const serviceStopTimeout = 10 * time.Second
+
 // Config holds ChromaDB client configuration
 type Config struct {
 	Host           string
@@ -67,6 +86,12 @@ func NewClient(config *Config) (*Client, error) {
 
 // Initialize sets up the vector database connection and collections
 func (c *Client) Initialize() error {
+	release, err := c.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// Check if ChromaDB is installed
 	if !c.isChromaInstalled() {
 		if err := c.installChroma(); err != nil {
@@ -75,11 +100,14 @@ func (c *Client) Initialize() error {
 	}
 
 	// Check if ChromaDB service is running
+	c.serviceMu.Lock()
 	if !c.isServiceRunning() {
-		if err := c.startService(); err != nil {
+		if err := c.startServiceLocked(); err != nil {
+			c.serviceMu.Unlock()
 			return fmt.Errorf("failed to start ChromaDB service: %w", err)
 		}
 	}
+	c.serviceMu.Unlock()
 
 	// Ensure database directory exists
 	if err := os.MkdirAll(c.config.DatabasePath, 0755); err != nil {
@@ -100,6 +128,21 @@ func (c *Client) Initialize() error {
 	return nil
 }
 
+// use marks the start of a call using ChromaDB and returns the function marking its
+// end. Calls are refused while the database is rebuilt, as ChromaDB is stopped then.
+func (c *Client) use() (func(), error) {
+	if !c.rebuildMu.TryRLock() {
+		return nil, ErrRebuilding
+	}
+	return c.rebuildMu.RUnlock, nil
+}
+
+// Rebuilding reports whether the database is being rebuilt, during which ChromaDB is
+// stopped on purpose and must not be restarted
+func (c *Client) Rebuilding() bool {
+	return c.rebuilding.Load()
+}
+
 // getOrCreateCollection gets an existing collection or creates a new one
 func (c *Client) getOrCreateCollection(name string) (chroma.Collection, error) {
 	ctx, cancel := context.WithTimeout(c.ctx, c.config.Timeout)
@@ -125,6 +168,99 @@ func (c *Client) getCollection(name string) (*chroma.Collection, error) {
 	return &collection, nil
 }
 
+// EnsureCollection creates a collection unless one of that name exists
+func (c *Client) EnsureCollection(name string) error {
+	release, err := c.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, err = c.getOrCreateCollection(name)
+	return err
+}
+
+// CollectionExists reports whether a collection of that name exists, without creating it
+func (c *Client) CollectionExists(name string) (bool, error) {
+	release, err := c.use()
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	names, err := c.ListCollections()
+	if err != nil {
+		return false, err
+	}
+	for _, existing := range names {
+		if existing == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ResetCollection creates an empty collection, deleting any collection of that name first
+func (c *Client) ResetCollection(name string) error {
+	release, err := c.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	exists, err := c.CollectionExists(name)
+	if err != nil {
+		return fmt.Errorf("failed to look up collection %s: %w", name, err)
+	}
+	if exists {
+		if err := c.DeleteCollection(name); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.getOrCreateCollection(name); err != nil {
+		return fmt.Errorf("failed to create collection %s: %w", name, err)
+	}
+	return nil
+}
+
+// RenameCollection renames a collection, keeping its documents
+func (c *Client) RenameCollection(name, newName string) error {
+	release, err := c.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	collection, err := c.getCollection(name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.config.Timeout)
+	defer cancel()
+	if err := (*collection).ModifyName(ctx, newName); err != nil {
+		return fmt.Errorf("failed to rename collection %s to %s: %w", name, newName, err)
+	}
+	return nil
+}
+
+// DeleteCollection deletes a collection with all its documents
+func (c *Client) DeleteCollection(name string) error {
+	release, err := c.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.config.Timeout)
+	defer cancel()
+	if err := c.client.DeleteCollection(ctx, name); err != nil {
+		return fmt.Errorf("failed to delete collection %s: %w", name, err)
+	}
+	return nil
+}
+
 // Store stores documents in the vector database
 func (c *Client) Store(documents []interfaces.Document) error {
 	return c.StoreInCollection(documents, c.config.CollectionName)
@@ -136,6 +272,12 @@ func (c *Client) StoreInCollection(documents []interfaces.Document, collectionNa
 		return nil
 	}
 
+	release, err := c.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// Ensure collection exists
 	collection, err := c.getOrCreateCollection(collectionName)
 	if err != nil {
@@ -177,21 +319,42 @@ func (c *Client) Search(query string, limit int) ([]interfaces.VectorSearchResul
 
 // SearchInCollection performs semantic search in a specific collection
 func (c *Client) SearchInCollection(query string, limit int, collectionName string) ([]interfaces.VectorSearchResult, error) {
-	// Get the collection
-	collection, err := c.getCollection(collectionName)
+	return c.SearchInCollectionContext(c.ctx, query, limit, collectionName)
+}
+
+// SearchInCollectionContext performs semantic search in a specific collection, aborting when ctx is cancelled
+func (c *Client) SearchInCollectionContext(ctx context.Context, query string, limit int, collectionName string) ([]interfaces.VectorSearchResult, error) {
+	return c.SearchInCollectionWhere(ctx, query, limit, collectionName, nil)
+}
+
+// SearchInCollectionWhere performs semantic search in a specific collection among the
+// documents whose metadata matches where, which may be nil
+func (c *Client) SearchInCollectionWhere(ctx context.Context, query string, limit int, collectionName string, where chroma.WhereFilter) ([]interfaces.VectorSearchResult, error) {
+	release, err := c.use()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get collection %s: %w", collectionName, err)
+		return nil, err
 	}
+	defer release()
 
-	ctx, cancel := context.WithTimeout(c.ctx, c.config.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
 	defer cancel()
 
+	// Get the collection
+	collection, err := c.client.GetOrCreateCollection(ctx, collectionName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collection %s: %w", collectionName, err)
+	}
+
 	// Execute query operation
-	results, err := (*collection).Query(ctx,
+	options := []chroma.CollectionQueryOption{
 		chroma.WithQueryTexts(query),
 		chroma.WithNResults(limit),
 		chroma.WithIncludeQuery(chroma.IncludeDocuments, chroma.IncludeMetadatas),
-	)
+	}
+	if where != nil {
+		options = append(options, chroma.WithWhereQuery(where))
+	}
+	results, err := collection.Query(ctx, options...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query collection: %w", err)
 	}
@@ -199,6 +362,58 @@ func (c *Client) SearchInCollection(query string, limit int, collectionName stri
 	return c.convertQueryResults(&results), nil
 }
 
+// GetFromCollection returns the documents of a collection with one of ids and metadata
+// matching where, in storage order. Empty ids and a nil where do not restrict anything,
+// and a limit of 0 returns every document from offset on.
+func (c *Client) GetFromCollection(ctx context.Context, collectionName string, ids []string, where chroma.WhereFilter, offset, limit int) ([]interfaces.Document, error) {
+	release, err := c.use()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	collection, err := c.client.GetOrCreateCollection(ctx, collectionName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collection %s: %w", collectionName, err)
+	}
+
+	options := []chroma.CollectionGetOption{
+		chroma.WithIncludeGet(chroma.IncludeDocuments, chroma.IncludeMetadatas),
+		chroma.WithOffsetGet(offset),
+	}
+	if len(ids) > 0 {
+		options = append(options, chroma.WithIDsGet(convertStringsToDocumentIDs(ids)...))
+	}
+	if where != nil {
+		options = append(options, chroma.WithWhereGet(where))
+	}
+	if limit > 0 {
+		options = append(options, chroma.WithLimitGet(limit))
+	}
+	result, err := collection.Get(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get documents from collection %s: %w", collectionName, err)
+	}
+
+	documentIDs := result.GetIDs()
+	contents := result.GetDocuments()
+	metadatas := result.GetMetadatas()
+	documents := make([]interfaces.Document, len(documentIDs))
+	for i, id := range documentIDs {
+		documents[i].ID = id
+		if i < len(contents) && contents[i] != nil {
+			documents[i].Content = contents[i].ContentString()
+		}
+		if i < len(metadatas) {
+			documents[i].Metadata = metadatas[i]
+		}
+	}
+	return documents, nil
+}
+
 // convertStringsToDocumentIDs converts []string to []DocumentID
 func convertStringsToDocumentIDs(ids []string) []chroma.DocumentID {
 	result := make([]chroma.DocumentID, len(ids))
@@ -296,6 +511,12 @@ func (c *Client) DeleteFromCollection(ids []string, collectionName string) error
 		return nil
 	}
 
+	release, err := c.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// Get the collection
 	collection, err := c.getCollection(collectionName)
 	if err != nil {
@@ -318,8 +539,56 @@ func (c *Client) DeleteFromCollection(ids []string, collectionName string) error
 	return nil
 }
 
+// KnowledgeBaseVersion returns the version of the pre-built knowledge base in the database
+func (c *Client) KnowledgeBaseVersion() string {
+	return NewDatabaseInitializer(c.config).KnowledgeBaseVersion()
+}
+
+// RebuildDatabase replaces the database with a fresh copy of the pre-built knowledge
+// base, dropping the user's keybindings. ChromaDB is stopped during the copy and started
+// again afterwards, so this only works when this client started it. Calls in progress
+// are waited for, and calls made until the rebuild ends are refused with ErrRebuilding.
+func (c *Client) RebuildDatabase() error {
+	c.rebuilding.Store(true)
+	defer c.rebuilding.Store(false)
+
+	c.rebuildMu.Lock()
+	defer c.rebuildMu.Unlock()
+	c.serviceMu.Lock()
+	defer c.serviceMu.Unlock()
+
+	if c.service == nil {
+		return fmt.Errorf("ChromaDB at %s:%d was not started by this server and cannot be rebuilt while running", c.config.Host, c.config.Port)
+	}
+
+	if err := c.stopServiceLocked(); err != nil {
+		return fmt.Errorf("failed to stop ChromaDB service: %w", err)
+	}
+
+	rebuildErr := NewDatabaseInitializer(c.config).RepairDatabase()
+
+	// Bring the service back even when the rebuild failed, the old database was restored
+	if err := c.startServiceLocked(); err != nil {
+		return fmt.Errorf("failed to restart ChromaDB service: %w", err)
+	}
+	if rebuildErr != nil {
+		return rebuildErr
+	}
+
+	if _, err := c.getOrCreateCollection(c.config.CollectionName); err != nil {
+		return fmt.Errorf("failed to initialize collection: %w", err)
+	}
+	return nil
+}
+
 // HealthCheck checks if ChromaDB is healthy
 func (c *Client) HealthCheck() error {
+	release, err := c.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// Use HTTP request to v2 API
 	resp, err := http.Get(fmt.Sprintf("http://%s:%d/api/v2/heartbeat", c.config.Host, c.config.Port))
 	if err != nil {
@@ -334,9 +603,15 @@ func (c *Client) HealthCheck() error {
 	return nil
 }
 
-// Close closes the database connection
+// Close closes the database connection and stops the ChromaDB service if this client
+// started it
 func (c *Client) Close() error {
 	// ChromaDB Go client doesn't require explicit closing
+	c.serviceMu.Lock()
+	defer c.serviceMu.Unlock()
+	if err := c.stopServiceLocked(); err != nil {
+		return fmt.Errorf("failed to stop ChromaDB service: %w", err)
+	}
 	log.Println("ChromaDB client closed")
 	return nil
 }
@@ -348,6 +623,12 @@ func (c *Client) GetCollectionCount() (int, error) {
 
 // GetCollectionCountByName returns the number of documents in a specific collection
 func (c *Client) GetCollectionCountByName(collectionName string) (int, error) {
+	release, err := c.use()
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
 	ctx, cancel := context.WithTimeout(c.ctx, c.config.Timeout)
 	defer cancel()
 
@@ -383,6 +664,12 @@ func (c *Client) GetCollectionCountByName(collectionName string) (int, error) {
 
 // ListCollections returns all available collections
 func (c *Client) ListCollections() ([]string, error) {
+	release, err := c.use()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	ctx, cancel := context.WithTimeout(c.ctx, c.config.Timeout)
 	defer cancel()
 
@@ -622,7 +909,7 @@ func (c *Client) isChromaInstalled() bool {
 // isServiceRunning checks if ChromaDB service is running
 func (c *Client) isServiceRunning() bool {
 	// Try to connect to the ChromaDB service
-	resp, err := http.Get("http://localhost:8000/api/v2/heartbeat")
+	resp, err := http.Get(fmt.Sprintf("http://%s:%d/api/v2/heartbeat", c.config.Host, c.config.Port))
 	if err != nil {
 		return false
 	}
@@ -630,8 +917,8 @@ func (c *Client) isServiceRunning() bool {
 	return resp.StatusCode == 200
 }
 
-// startService starts the ChromaDB service
-func (c *Client) startService() error {
+// startServiceLocked starts the ChromaDB service; c.serviceMu must be held
+func (c *Client) startServiceLocked() error {
 	fmt.Println("Starting ChromaDB service...")
 
 	// Ensure database directory exists before starting service
@@ -643,24 +930,25 @@ func (c *Client) startService() error {
 
 	// Try different ways to start ChromaDB based on installation method
 	var cmd *exec.Cmd
+	runArgs := []string{"run", "--path", c.config.DatabasePath, "--host", c.config.Host, "--port", strconv.Itoa(c.config.Port)}
 
 	// Check if uv project exists and use it
 	projectPath := filepath.Join(c.config.DatabasePath, "chromadb-project")
 	if _, err := os.Stat(projectPath); err == nil {
 		fmt.Printf("Found uv project at: %s\n", projectPath)
 		// Use uv project to run chroma
-		cmd = exec.Command("uv", "run", "chroma", "run", "--path", c.config.DatabasePath)
+		cmd = exec.Command("uv", append([]string{"run", "chroma"}, runArgs...)...)
 		cmd.Dir = projectPath
 	} else {
 		fmt.Printf("No uv project found, checking for system chroma\n")
 		// Try chroma binary first (but exclude npm installations)
 		if chromaPath, err := exec.LookPath("chroma"); err == nil && !strings.Contains(chromaPath, ".nvm") && !strings.Contains(chromaPath, "node") {
 			fmt.Printf("Found system chroma binary: %s\n", chromaPath)
-			cmd = exec.Command("chroma", "run", "--path", c.config.DatabasePath)
+			cmd = exec.Command("chroma", runArgs...)
 		} else {
 			fmt.Printf("No system chroma binary found, trying python module\n")
 			// Try using python module
-			cmd = exec.Command("python3", "-m", "chromadb", "run", "--path", c.config.DatabasePath)
+			cmd = exec.Command("python3", append([]string{"-m", "chromadb"}, runArgs...)...)
 		}
 	}
 
@@ -672,6 +960,7 @@ func (c *Client) startService() error {
 	}
 
 	fmt.Printf("ChromaDB process started with PID: %d\n", cmd.Process.Pid)
+	c.service = cmd
 
 	// Wait for service to be ready
 	maxRetries := 30
@@ -686,3 +975,35 @@ func (c *Client) startService() error {
 
 	return fmt.Errorf("ChromaDB service failed to start within timeout")
 }
+
+// stopServiceLocked stops the ChromaDB process started by this client, if any; c.serviceMu
+// must be held. It is asked to exit first so it can persist the database, and killed if
+// it does not in time.
+func (c *Client) stopServiceLocked() error {
+	cmd := c.service
+	if cmd == nil {
+		return nil
+	}
+	c.service = nil
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	log.Printf("Stopping ChromaDB service (PID %d)", cmd.Process.Pid)
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		// Interrupt is not supported everywhere, so fall back to killing the process
+		if err := cmd.Process.Kill(); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case <-exited:
+		return nil
+	case <-time.After(serviceStopTimeout):
+		log.Printf("ChromaDB service did not exit within %v, killing it", serviceStopTimeout)
+		return cmd.Process.Kill()
+	}
+}
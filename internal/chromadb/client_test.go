@@ -1,6 +1,7 @@
 package chromadb
 
 import (
+	"errors"
 	"nvim-smart-keybind-search/internal/interfaces"
 	"testing"
 
@@ -24,6 +25,48 @@ func TestNewClient(t *testing.T) {
 	}
 }
 
+func TestClientRefusesCallsDuringRebuild(t *testing.T) {
+	client, err := NewClient(DefaultConfig())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// Hold the rebuild lock as RebuildDatabase does while ChromaDB is stopped
+	client.rebuildMu.Lock()
+	calls := map[string]func() error{
+		"Initialize":  client.Initialize,
+		"HealthCheck": client.HealthCheck,
+		"Store": func() error {
+			return client.Store([]interfaces.Document{{ID: "1", Content: "x"}})
+		},
+		"ListCollections": func() error {
+			_, err := client.ListCollections()
+			return err
+		},
+		"Search": func() error {
+			_, err := client.Search("x", 1)
+			return err
+		},
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrRebuilding) {
+			t.Errorf("Expected %s to be refused during the rebuild, got %v", name, err)
+		}
+	}
+	client.rebuildMu.Unlock()
+
+	// A client that did not start ChromaDB refuses to rebuild it and is usable again
+	if err := client.RebuildDatabase(); err == nil {
+		t.Error("Expected rebuilding a ChromaDB not started by the client to fail")
+	}
+	if client.Rebuilding() {
+		t.Error("Expected the rebuild to be over")
+	}
+	if err := client.HealthCheck(); errors.Is(err, ErrRebuilding) {
+		t.Errorf("Expected calls to be accepted after the rebuild, got %v", err)
+	}
+}
+
 func TestDefaultConfig(t *testing.T) {
 	config := DefaultConfig()
 
@@ -1,24 +1,54 @@
 package chromadb
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"nvim-smart-keybind-search/internal/interfaces"
+
+	chroma "github.com/amikos-tech/chroma-go/pkg/api/v2"
 )
 
+// CollectionStore is where a CollectionManager keeps its collections. *Client keeps them
+// in ChromaDB.
+type CollectionStore interface {
+	EnsureCollection(name string) error
+	CollectionExists(name string) (bool, error)
+	ResetCollection(name string) error
+	RenameCollection(name, newName string) error
+	DeleteCollection(name string) error
+	GetCollectionCountByName(collectionName string) (int, error)
+
+	StoreInCollection(documents []interfaces.Document, collectionName string) error
+	SearchInCollectionWhere(ctx context.Context, query string, limit int, collectionName string, where chroma.WhereFilter) ([]interfaces.VectorSearchResult, error)
+	GetFromCollection(ctx context.Context, collectionName string, ids []string, where chroma.WhereFilter, offset, limit int) ([]interfaces.Document, error)
+	DeleteFromCollection(ids []string, collectionName string) error
+}
+
 // CollectionManager manages multiple ChromaDB collections
 type CollectionManager struct {
-	client          *Client
+	client          CollectionStore
 	builtinCollName string
 	userCollName    string
 	generalCollName string
+
+	// swapMu is held for reading while the collections are used and for writing while
+	// a staged user collection is swapped in
+	swapMu sync.RWMutex
 }
 
 // NewCollectionManager creates a new collection manager
 func NewCollectionManager(client *Client) *CollectionManager {
+	return NewCollectionManagerWithStore(client)
+}
+
+// NewCollectionManagerWithStore creates a collection manager keeping its collections in store
+func NewCollectionManagerWithStore(store CollectionStore) *CollectionManager {
 	return &CollectionManager{
-		client:          client,
+		client:          store,
 		builtinCollName: "vim_knowledge",
 		userCollName:    "user_keybindings",
 		generalCollName: "general_knowledge",
@@ -28,17 +58,17 @@ func NewCollectionManager(client *Client) *CollectionManager {
 // Initialize sets up all collections
 func (cm *CollectionManager) Initialize() error {
 	// Initialize built-in vim knowledge collection
-	if _, err := cm.client.getOrCreateCollection(cm.builtinCollName); err != nil {
+	if err := cm.client.EnsureCollection(cm.builtinCollName); err != nil {
 		return fmt.Errorf("failed to initialize built-in collection: %w", err)
 	}
 
 	// Initialize user keybindings collection
-	if _, err := cm.client.getOrCreateCollection(cm.userCollName); err != nil {
+	if err := cm.client.EnsureCollection(cm.userCollName); err != nil {
 		return fmt.Errorf("failed to initialize user collection: %w", err)
 	}
 
 	// Initialize general knowledge collection
-	if _, err := cm.client.getOrCreateCollection(cm.generalCollName); err != nil {
+	if err := cm.client.EnsureCollection(cm.generalCollName); err != nil {
 		return fmt.Errorf("failed to initialize general knowledge collection: %w", err)
 	}
 
@@ -54,6 +84,8 @@ func (cm *CollectionManager) StoreBuiltinKnowledge(documents []interfaces.Docume
 
 // StoreUserKeybindings stores documents in the user keybindings collection
 func (cm *CollectionManager) StoreUserKeybindings(documents []interfaces.Document) error {
+	cm.swapMu.RLock()
+	defer cm.swapMu.RUnlock()
 	return cm.client.StoreInCollection(documents, cm.userCollName)
 }
 
@@ -64,28 +96,59 @@ func (cm *CollectionManager) StoreGeneralKnowledge(documents []interfaces.Docume
 
 // SearchAllCollections searches all collections and returns combined results
 func (cm *CollectionManager) SearchAllCollections(query string, limit int) ([]interfaces.VectorSearchResult, error) {
+	return cm.SearchAllCollectionsContext(context.Background(), query, limit)
+}
+
+// SearchAllCollectionsContext searches all collections, stopping as soon as ctx is cancelled
+func (cm *CollectionManager) SearchAllCollectionsContext(ctx context.Context, query string, limit int) ([]interfaces.VectorSearchResult, error) {
+	return cm.SearchAllCollectionsFiltered(ctx, query, limit, nil)
+}
+
+// SearchAllCollectionsFiltered searches the collections of the filter's sources, letting
+// the vector store match its modes and plugins. filter may be nil.
+func (cm *CollectionManager) SearchAllCollectionsFiltered(ctx context.Context, query string, limit int, filter *interfaces.SearchFilter) ([]interfaces.VectorSearchResult, error) {
+	where := whereClause(filter)
+
 	// Distribute limit across collections
-	limitPerCollection := limit / 3
+	searched := 0
+	for _, source := range []string{interfaces.SourceUser, interfaces.SourceBuiltin, interfaces.SourceGeneral} {
+		if filter.IncludesSource(source) {
+			searched++
+		}
+	}
+	if searched == 0 {
+		return []interfaces.VectorSearchResult{}, nil
+	}
+	limitPerCollection := limit / searched
 	if limitPerCollection < 1 {
 		limitPerCollection = 1
 	}
 
 	// Search user keybindings (highest priority)
-	userResults, err := cm.searchCollection(cm.userCollName, query, limitPerCollection)
+	userResults, err := cm.searchSource(ctx, filter, interfaces.SourceUser, query, limitPerCollection, where)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil {
 		log.Printf("Warning: failed to search user collection: %v", err)
 		userResults = []interfaces.VectorSearchResult{}
 	}
 
 	// Search built-in knowledge
-	builtinResults, err := cm.searchCollection(cm.builtinCollName, query, limitPerCollection)
+	builtinResults, err := cm.searchSource(ctx, filter, interfaces.SourceBuiltin, query, limitPerCollection, where)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil {
 		log.Printf("Warning: failed to search built-in collection: %v", err)
 		builtinResults = []interfaces.VectorSearchResult{}
 	}
 
 	// Search general knowledge
-	generalResults, err := cm.searchCollection(cm.generalCollName, query, limitPerCollection)
+	generalResults, err := cm.searchSource(ctx, filter, interfaces.SourceGeneral, query, limitPerCollection, where)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil {
 		log.Printf("Warning: failed to search general knowledge collection: %v", err)
 		generalResults = []interfaces.VectorSearchResult{}
@@ -97,8 +160,28 @@ func (cm *CollectionManager) SearchAllCollections(query string, limit int) ([]in
 
 // SearchBoth searches both keybinding collections and merges results with user keybindings prioritized
 func (cm *CollectionManager) SearchBoth(query string, limit int) ([]interfaces.VectorSearchResult, error) {
+	return cm.SearchBothContext(context.Background(), query, limit)
+}
+
+// SearchBothContext searches both keybinding collections, stopping as soon as ctx is cancelled
+func (cm *CollectionManager) SearchBothContext(ctx context.Context, query string, limit int) ([]interfaces.VectorSearchResult, error) {
+	return cm.SearchBothFiltered(ctx, query, limit, nil)
+}
+
+// SearchBothFiltered searches the keybinding collections of the filter's sources, letting
+// the vector store match its modes and plugins. filter may be nil.
+func (cm *CollectionManager) SearchBothFiltered(ctx context.Context, query string, limit int, filter *interfaces.SearchFilter) ([]interfaces.VectorSearchResult, error) {
+	where := whereClause(filter)
+
 	// Search user keybindings first (higher priority)
-	userResults, err := cm.searchCollection(cm.userCollName, query, limit/2)
+	userLimit := limit / 2
+	if !filter.IncludesSource(interfaces.SourceBuiltin) {
+		userLimit = limit
+	}
+	userResults, err := cm.searchSource(ctx, filter, interfaces.SourceUser, query, userLimit, where)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil {
 		log.Printf("Warning: failed to search user collection: %v", err)
 		userResults = []interfaces.VectorSearchResult{}
@@ -110,7 +193,10 @@ func (cm *CollectionManager) SearchBoth(query string, limit int) ([]interfaces.V
 		remainingLimit = limit / 2 // Ensure we get some built-in results
 	}
 
-	builtinResults, err := cm.searchCollection(cm.builtinCollName, query, remainingLimit)
+	builtinResults, err := cm.searchSource(ctx, filter, interfaces.SourceBuiltin, query, remainingLimit, where)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil {
 		log.Printf("Warning: failed to search built-in collection: %v", err)
 		builtinResults = []interfaces.VectorSearchResult{}
@@ -122,12 +208,99 @@ func (cm *CollectionManager) SearchBoth(query string, limit int) ([]interfaces.V
 
 // SearchGeneralKnowledge searches only the general knowledge collection
 func (cm *CollectionManager) SearchGeneralKnowledge(query string, limit int) ([]interfaces.VectorSearchResult, error) {
-	return cm.searchCollection(cm.generalCollName, query, limit)
+	return cm.searchCollection(context.Background(), cm.generalCollName, query, limit, nil)
+}
+
+// whereClause turns the modes and plugins of a filter into a where clause, or returns
+// nil when they do not restrict anything
+func whereClause(filter *interfaces.SearchFilter) chroma.WhereFilter {
+	if filter == nil {
+		return nil
+	}
+
+	var clauses []chroma.WhereClause
+	if len(filter.Modes) > 0 {
+		clauses = append(clauses, chroma.InString("mode", filter.Modes...))
+	}
+	if len(filter.Plugins) > 0 {
+		clauses = append(clauses, chroma.InString("plugin", filter.Plugins...))
+	}
+
+	// ChromaDB rejects $and with a single clause
+	switch len(clauses) {
+	case 0:
+		return nil
+	case 1:
+		return clauses[0]
+	}
+	return chroma.And(clauses...)
+}
+
+// searchSource searches the collection of a source, or returns no results when the
+// filter leaves the source out
+func (cm *CollectionManager) searchSource(ctx context.Context, filter *interfaces.SearchFilter, source string, query string, limit int, where chroma.WhereFilter) ([]interfaces.VectorSearchResult, error) {
+	if !filter.IncludesSource(source) {
+		return []interfaces.VectorSearchResult{}, nil
+	}
+
+	return cm.searchCollection(ctx, cm.sourceCollection(source), query, limit, where)
+}
+
+// sourceCollection returns the name of the collection a source is kept in
+func (cm *CollectionManager) sourceCollection(source string) string {
+	switch source {
+	case interfaces.SourceBuiltin:
+		return cm.builtinCollName
+	case interfaces.SourceGeneral:
+		return cm.generalCollName
+	}
+	return cm.userCollName
+}
+
+// ListDocuments returns up to limit documents of a source's collection whose mode and
+// plugin pass the filter, skipping the first offset of them
+func (cm *CollectionManager) ListDocuments(ctx context.Context, source string, filter *interfaces.SearchFilter, offset, limit int) ([]interfaces.Document, error) {
+	cm.swapMu.RLock()
+	defer cm.swapMu.RUnlock()
+	return cm.client.GetFromCollection(ctx, cm.sourceCollection(source), nil, whereClause(filter), offset, limit)
 }
 
-// searchCollection searches a specific collection
-func (cm *CollectionManager) searchCollection(collectionName string, query string, limit int) ([]interfaces.VectorSearchResult, error) {
-	return cm.client.SearchInCollection(query, limit, collectionName)
+// SelectUserKeybindings returns the ids of the user keybindings the selector matches
+func (cm *CollectionManager) SelectUserKeybindings(ctx context.Context, selector *interfaces.KeybindingSelector) ([]string, error) {
+	filter := &interfaces.SearchFilter{Modes: selector.Modes, Plugins: selector.Plugins}
+
+	cm.swapMu.RLock()
+	documents, err := cm.client.GetFromCollection(ctx, cm.userCollName, selector.IDs, whereClause(filter), 0, 0)
+	cm.swapMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(documents))
+	for i, document := range documents {
+		ids[i] = string(document.ID)
+	}
+	return ids, nil
+}
+
+// searchCollection searches a specific collection among the documents matching where
+func (cm *CollectionManager) searchCollection(ctx context.Context, collectionName string, query string, limit int, where chroma.WhereFilter) ([]interfaces.VectorSearchResult, error) {
+	defer interfaces.RecordStage(ctx, interfaces.SearchStage(collectionName), time.Now())
+	cm.swapMu.RLock()
+	results, err := cm.client.SearchInCollectionWhere(ctx, query, limit, collectionName, where)
+	cm.swapMu.RUnlock()
+
+	if trace := interfaces.QueryTraceFromContext(ctx); trace != nil && err == nil {
+		if trace.CollectionHits == nil {
+			trace.CollectionHits = make(map[string][]interfaces.TracedHit)
+		}
+		hits := make([]interfaces.TracedHit, len(results))
+		for i, result := range results {
+			hits[i] = interfaces.NewTracedHit(collectionName, result)
+		}
+		trace.CollectionHits[collectionName] = hits
+	}
+	return results, err
 }
 
 // mergeAllResults merges results from all collections with priority ordering
@@ -203,6 +376,8 @@ func (cm *CollectionManager) mergeResults(userResults, builtinResults []interfac
 
 // DeleteUserKeybindings deletes documents from the user keybindings collection
 func (cm *CollectionManager) DeleteUserKeybindings(ids []string) error {
+	cm.swapMu.RLock()
+	defer cm.swapMu.RUnlock()
 	return cm.client.DeleteFromCollection(ids, cm.userCollName)
 }
 
@@ -213,6 +388,8 @@ func (cm *CollectionManager) DeleteGeneralKnowledge(ids []string) error {
 
 // GetUserCollectionCount returns the number of documents in the user collection
 func (cm *CollectionManager) GetUserCollectionCount() (int, error) {
+	cm.swapMu.RLock()
+	defer cm.swapMu.RUnlock()
 	return cm.client.GetCollectionCountByName(cm.userCollName)
 }
 
@@ -226,16 +403,99 @@ func (cm *CollectionManager) GetGeneralKnowledgeCount() (int, error) {
 	return cm.client.GetCollectionCountByName(cm.generalCollName)
 }
 
+// CollectionCounts returns the number of documents in each collection, by collection name
+func (cm *CollectionManager) CollectionCounts() (map[string]int, error) {
+	cm.swapMu.RLock()
+	defer cm.swapMu.RUnlock()
+
+	counts := make(map[string]int, 3)
+	for _, name := range []string{cm.builtinCollName, cm.userCollName, cm.generalCollName} {
+		count, err := cm.client.GetCollectionCountByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count collection %s: %w", name, err)
+		}
+		counts[name] = count
+	}
+	return counts, nil
+}
+
 // ClearUserCollection deletes all documents from the user collection
 func (cm *CollectionManager) ClearUserCollection() error {
-	// Get all document IDs from the user collection
-	// This is a simplified approach - in a real implementation, you might want to
-	// get all IDs first, then delete them
 	log.Printf("Clearing user collection: %s", cm.userCollName)
 
-	// For now, we'll just log that we're clearing the collection
-	// In a real implementation, you'd need to get all IDs first
-	return fmt.Errorf("clear user collection not implemented - need to get all IDs first")
+	staging, err := cm.StageUserCollection()
+	if err != nil {
+		return err
+	}
+	return staging.Commit()
+}
+
+// UserStaging is an empty user collection that replaces the live one on Commit. Searches
+// keep using the live collection until then.
+type UserStaging struct {
+	cm   *CollectionManager
+	name string
+}
+
+// StageUserCollection creates an empty staging collection for a new set of user keybindings,
+// dropping whatever an earlier, unfinished staging left behind
+func (cm *CollectionManager) StageUserCollection() (*UserStaging, error) {
+	name := cm.userCollName + "_staging"
+	if err := cm.client.ResetCollection(name); err != nil {
+		return nil, fmt.Errorf("failed to create staging collection: %w", err)
+	}
+	return &UserStaging{cm: cm, name: name}, nil
+}
+
+// Store stores documents in the staging collection
+func (s *UserStaging) Store(documents []interfaces.Document) error {
+	return s.cm.client.StoreInCollection(documents, s.name)
+}
+
+// Discard deletes the staging collection, leaving the live user collection untouched
+func (s *UserStaging) Discard() error {
+	return s.cm.client.DeleteCollection(s.name)
+}
+
+// Commit swaps the staging collection in as the user collection. Searches wait for the
+// swap, so they see either the old or the new keybindings but never a mix or nothing.
+// When the swap fails the old user collection is kept.
+func (s *UserStaging) Commit() error {
+	cm := s.cm
+	cm.swapMu.Lock()
+	defer cm.swapMu.Unlock()
+
+	previous := cm.userCollName + "_previous"
+	// A collection left over from an interrupted swap would block the rename below
+	leftover, err := cm.client.CollectionExists(previous)
+	if err != nil {
+		return fmt.Errorf("failed to prepare user collection swap: %w", err)
+	}
+	if leftover {
+		if err := cm.client.DeleteCollection(previous); err != nil {
+			return fmt.Errorf("failed to prepare user collection swap: %w", err)
+		}
+	}
+
+	// Make sure there is a live collection to move aside
+	if err := cm.client.EnsureCollection(cm.userCollName); err != nil {
+		return fmt.Errorf("failed to get user collection: %w", err)
+	}
+	if err := cm.client.RenameCollection(cm.userCollName, previous); err != nil {
+		return fmt.Errorf("failed to move user collection aside: %w", err)
+	}
+
+	if err := cm.client.RenameCollection(s.name, cm.userCollName); err != nil {
+		if restoreErr := cm.client.RenameCollection(previous, cm.userCollName); restoreErr != nil {
+			return fmt.Errorf("failed to swap in staged user collection: %w (restoring the previous one also failed: %v)", err, restoreErr)
+		}
+		return fmt.Errorf("failed to swap in staged user collection: %w", err)
+	}
+
+	if err := cm.client.DeleteCollection(previous); err != nil {
+		log.Printf("Warning: failed to delete previous user collection: %v", err)
+	}
+	return nil
 }
 
 // ClearGeneralKnowledge deletes all documents from the general knowledge collection
@@ -323,3 +323,42 @@ func TestCollectionManager_RequirementCompliance(t *testing.T) {
 	t.Log("- Functions to merge user and built-in knowledge during search: ✓")
 	t.Log("- Prioritization logic to favor user keybindings in results: ✓")
 }
+
+func TestWhereClause(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *interfaces.SearchFilter
+		want   string
+	}{
+		{"no filter", nil, ""},
+		{"sources only", &interfaces.SearchFilter{Sources: []string{interfaces.SourceUser}}, ""},
+		{"modes", &interfaces.SearchFilter{Modes: []string{"n", "x"}}, `{"mode":{"$in":["n","x"]}}`},
+		{
+			"modes and plugins",
+			&interfaces.SearchFilter{Modes: []string{"n"}, Plugins: []string{"telescope"}},
+			`{"$and":[{"mode":{"$in":["n"]}},{"plugin":{"$in":["telescope"]}}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		where := whereClause(tt.filter)
+		if tt.want == "" {
+			if where != nil {
+				t.Errorf("%s: expected no where clause, got %s", tt.name, where.String())
+			}
+			continue
+		}
+		if where == nil {
+			t.Errorf("%s: expected where clause %s, got none", tt.name, tt.want)
+			continue
+		}
+
+		data, err := where.MarshalJSON()
+		if err != nil {
+			t.Fatalf("%s: failed to marshal where clause: %v", tt.name, err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s: expected where clause %s, got %s", tt.name, tt.want, data)
+		}
+	}
+}
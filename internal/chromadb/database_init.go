@@ -291,6 +291,26 @@ func (di *DatabaseInitializer) IsEmptyDatabase() bool {
 	return err == nil
 }
 
+// KnowledgeBaseVersion identifies the pre-built knowledge base the database was created
+// from, using the checksum recorded when it was copied. It returns "empty" for a
+// database created without pre-built data and "" when the version is unknown.
+func (di *DatabaseInitializer) KnowledgeBaseVersion() string {
+	if di.IsEmptyDatabase() {
+		return "empty"
+	}
+
+	checksum, err := os.ReadFile(filepath.Join(di.userDatabasePath, ".checksum"))
+	if err != nil {
+		return ""
+	}
+
+	version := strings.TrimSpace(string(checksum))
+	if len(version) > 12 {
+		version = version[:12]
+	}
+	return version
+}
+
 // GetDatabaseInfo returns information about the current database
 func (di *DatabaseInitializer) GetDatabaseInfo() map[string]interface{} {
 	info := make(map[string]interface{})
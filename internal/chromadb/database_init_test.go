@@ -0,0 +1,32 @@
+package chromadb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDatabaseInitializer_KnowledgeBaseVersion(t *testing.T) {
+	config := DefaultConfig()
+	config.DatabasePath = t.TempDir()
+	initializer := NewDatabaseInitializer(config)
+
+	if version := initializer.KnowledgeBaseVersion(); version != "" {
+		t.Errorf("expected unknown version without checksum, got %q", version)
+	}
+
+	checksum := "0123456789abcdef0123456789abcdef"
+	if err := os.WriteFile(filepath.Join(config.DatabasePath, ".checksum"), []byte(checksum+"\n"), 0644); err != nil {
+		t.Fatalf("failed to write checksum: %v", err)
+	}
+	if version := initializer.KnowledgeBaseVersion(); version != "0123456789ab" {
+		t.Errorf("expected shortened checksum, got %q", version)
+	}
+
+	if err := initializer.createEmptyDatabase(); err != nil {
+		t.Fatalf("failed to create empty database: %v", err)
+	}
+	if version := initializer.KnowledgeBaseVersion(); version != "empty" {
+		t.Errorf("expected empty version, got %q", version)
+	}
+}
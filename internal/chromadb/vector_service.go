@@ -1,6 +1,7 @@
 package chromadb
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"sort"
@@ -218,14 +219,7 @@ func (vs *VectorService) Search(query string, options *SearchOptions) ([]interfa
 
 // searchUserOnly searches only user keybindings
 func (vs *VectorService) searchUserOnly(query string, limit int) ([]interfaces.VectorSearchResult, error) {
-	// Temporarily switch to user collection
-	originalCollection := vs.client.collection
-	vs.client.collection = vs.collectionManager.client.collection
-	defer func() {
-		vs.client.collection = originalCollection
-	}()
-
-	return vs.client.Search(query, limit)
+	return vs.collectionManager.searchCollection(context.Background(), vs.collectionManager.userCollName, query, limit, nil)
 }
 
 // searchBuiltinOnly searches only built-in knowledge
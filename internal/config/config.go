@@ -0,0 +1,569 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"nvim-smart-keybind-search/internal/chromadb"
+	"nvim-smart-keybind-search/internal/ollama"
+	"nvim-smart-keybind-search/internal/rag"
+	"nvim-smart-keybind-search/internal/server"
+)
+
+// EnvPrefix prefixes the environment variable of every setting. The variable name is
+// the flag name upper-cased with dashes turned into underscores, so -chroma-port is
+// NVIM_SMART_KEYBIND_SEARCH_CHROMA_PORT.
+const EnvPrefix = "NVIM_SMART_KEYBIND_SEARCH_"
+
+// Config holds every tunable of the server. It is built in layers by Load: defaults,
+// then the config file, then environment variables, then command line flags.
+type Config struct {
+	Server            ServerConfig            `json:"server"`
+	Chroma            ChromaConfig            `json:"chroma"`
+	Ollama            OllamaConfig            `json:"ollama"`
+	Agent             AgentConfig             `json:"agent"`
+	QueryProcessor    QueryProcessorConfig    `json:"query_processor"`
+	ResponseGenerator ResponseGeneratorConfig `json:"response_generator"`
+	RateLimit         RateLimitConfig         `json:"rate_limit"`
+}
+
+// ServerConfig holds the RPC transport, daemon and lifecycle settings
+type ServerConfig struct {
+	Transport           string   `json:"transport"`
+	Framing             string   `json:"framing"`
+	MaxMessageSize      int      `json:"max_message_size"`
+	MaxInFlight         int      `json:"max_in_flight"`
+	Strict              bool     `json:"strict"`
+	Socket              string   `json:"socket"`
+	IdleTimeout         Duration `json:"idle_timeout"`
+	ShutdownTimeout     Duration `json:"shutdown_timeout"`
+	HealthCheckInterval Duration `json:"health_check_interval"`
+	MaxRestarts         int      `json:"max_restarts"`
+	JobsFile            string   `json:"jobs_file"`
+	MetricsAddress      string   `json:"metrics_address"`
+}
+
+// ChromaConfig holds the ChromaDB connection settings
+type ChromaConfig struct {
+	Host         string   `json:"host"`
+	Port         int      `json:"port"`
+	DatabasePath string   `json:"database_path"`
+	Collection   string   `json:"collection"`
+	Timeout      Duration `json:"timeout"`
+}
+
+// OllamaConfig holds the Ollama connection settings
+type OllamaConfig struct {
+	URL   string `json:"url"`
+	Model string `json:"model"`
+}
+
+// AgentConfig holds the RAG agent settings
+type AgentConfig struct {
+	MaxSearchResults     int      `json:"max_search_results"`
+	SimilarityThreshold  float64  `json:"similarity_threshold"`
+	ContextWindowSize    int      `json:"context_window_size"`
+	MaxResponseTokens    int      `json:"max_response_tokens"`
+	Temperature          float64  `json:"temperature"`
+	QueryExpansion       bool     `json:"query_expansion"`
+	UserBoostFactor      float64  `json:"user_boost_factor"`
+	ResponseTimeout      Duration `json:"response_timeout"`
+	SearchAllCollections bool     `json:"search_all_collections"`
+}
+
+// QueryProcessorConfig holds the query processing settings
+type QueryProcessorConfig struct {
+	EnableIntentDetection bool    `json:"enable_intent_detection"`
+	EnableQueryExpansion  bool    `json:"enable_query_expansion"`
+	EnableContextBuilding bool    `json:"enable_context_building"`
+	MaxExpansionTerms     int     `json:"max_expansion_terms"`
+	ContextWindowSize     int     `json:"context_window_size"`
+	SynonymBoostFactor    float64 `json:"synonym_boost_factor"`
+	IntentBoostFactor     float64 `json:"intent_boost_factor"`
+}
+
+// ResponseGeneratorConfig holds the response generation settings
+type ResponseGeneratorConfig struct {
+	MaxResponseTokens  int      `json:"max_response_tokens"`
+	Temperature        float64  `json:"temperature"`
+	ResponseTimeout    Duration `json:"response_timeout"`
+	EnableExplanations bool     `json:"enable_explanations"`
+	EnableRanking      bool     `json:"enable_ranking"`
+	UserBoostFactor    float64  `json:"user_boost_factor"`
+	RelevanceThreshold float64  `json:"relevance_threshold"`
+	MaxFinalResults    int      `json:"max_final_results"`
+}
+
+// RateLimitConfig holds the limits on calls of each client connection
+type RateLimitConfig struct {
+	Enabled    bool                 `json:"enabled"`
+	Connection RateLimit            `json:"connection"`
+	Methods    map[string]RateLimit `json:"methods"`
+}
+
+// RateLimit allows Rate calls per second in bursts of up to Burst calls; a zero Rate
+// means no limit
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in config files
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes a duration string such as "1m30s"
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Default returns the built-in configuration, matching the defaults of each package
+func Default() *Config {
+	dispatcher := server.DefaultDispatcherConfig()
+	daemon := server.DefaultDaemonConfig()
+	serviceManager := server.DefaultServiceManagerConfig()
+	chroma := chromadb.DefaultConfig()
+	agent := rag.DefaultAgentConfig()
+	queryProcessor := rag.DefaultQueryProcessorConfig()
+	responseGenerator := rag.DefaultResponseGeneratorConfig()
+	rateLimits := server.DefaultRateLimitConfig()
+
+	methodLimits := make(map[string]RateLimit, len(rateLimits.Methods))
+	for method, limit := range rateLimits.Methods {
+		methodLimits[method] = RateLimit(limit)
+	}
+
+	return &Config{
+		Server: ServerConfig{
+			Transport:           dispatcher.Transport,
+			Framing:             dispatcher.Framing,
+			MaxMessageSize:      dispatcher.MaxMessageSize,
+			MaxInFlight:         dispatcher.MaxInFlight,
+			Strict:              dispatcher.RequireInitialize,
+			Socket:              daemon.SocketPath,
+			IdleTimeout:         Duration(daemon.IdleTimeout),
+			ShutdownTimeout:     Duration(serviceManager.ShutdownTimeout),
+			HealthCheckInterval: Duration(serviceManager.HealthCheckInterval),
+			MaxRestarts:         serviceManager.MaxRestarts,
+			JobsFile:            DefaultJobsPath(),
+		},
+		Chroma: ChromaConfig{
+			Host:         chroma.Host,
+			Port:         chroma.Port,
+			DatabasePath: chroma.DatabasePath,
+			Collection:   chroma.CollectionName,
+			Timeout:      Duration(chroma.Timeout),
+		},
+		Ollama: OllamaConfig{
+			URL: ollama.DefaultURL,
+		},
+		Agent: AgentConfig{
+			MaxSearchResults:     agent.MaxSearchResults,
+			SimilarityThreshold:  agent.SimilarityThreshold,
+			ContextWindowSize:    agent.ContextWindowSize,
+			MaxResponseTokens:    agent.MaxResponseTokens,
+			Temperature:          agent.Temperature,
+			QueryExpansion:       agent.QueryExpansion,
+			UserBoostFactor:      agent.UserBoostFactor,
+			ResponseTimeout:      Duration(agent.ResponseTimeout),
+			SearchAllCollections: agent.SearchAllCollections,
+		},
+		QueryProcessor: QueryProcessorConfig{
+			EnableIntentDetection: queryProcessor.EnableIntentDetection,
+			EnableQueryExpansion:  queryProcessor.EnableQueryExpansion,
+			EnableContextBuilding: queryProcessor.EnableContextBuilding,
+			MaxExpansionTerms:     queryProcessor.MaxExpansionTerms,
+			ContextWindowSize:     queryProcessor.ContextWindowSize,
+			SynonymBoostFactor:    queryProcessor.SynonymBoostFactor,
+			IntentBoostFactor:     queryProcessor.IntentBoostFactor,
+		},
+		ResponseGenerator: ResponseGeneratorConfig{
+			MaxResponseTokens:  responseGenerator.MaxResponseTokens,
+			Temperature:        responseGenerator.Temperature,
+			ResponseTimeout:    Duration(responseGenerator.ResponseTimeout),
+			EnableExplanations: responseGenerator.EnableExplanations,
+			EnableRanking:      responseGenerator.EnableRanking,
+			UserBoostFactor:    responseGenerator.UserBoostFactor,
+			RelevanceThreshold: responseGenerator.RelevanceThreshold,
+			MaxFinalResults:    responseGenerator.MaxFinalResults,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:    true,
+			Connection: RateLimit(rateLimits.Connection),
+			Methods:    methodLimits,
+		},
+	}
+}
+
+// DefaultPath returns the config file read when -config is not given
+func DefaultPath() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".config", "nvim-smart-keybind-search", "config.json")
+}
+
+// DefaultJobsPath returns the file background jobs are saved to by default
+func DefaultJobsPath() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".local", "share", "nvim-smart-keybind-search", "jobs.json")
+}
+
+// Load builds the effective configuration from the defaults, the JSON config file,
+// the environment and the flags in args, each layer overriding the previous one.
+// The settings are registered as flags on fs, next to any flags the caller defined;
+// fs is parsed with args. A missing config file is only an error when -config names
+// it explicitly.
+func Load(fs *flag.FlagSet, args []string, getenv func(string) string) (*Config, error) {
+	cfg := Default()
+	cfg.RegisterFlags(fs)
+	configPath := fs.String("config", DefaultPath(), "path of the JSON config file")
+
+	// The first pass only finds the config file; flags are applied again on top of it
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	explicit := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicit = true
+		}
+	})
+	if err := cfg.LoadFile(*configPath); err != nil {
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	if err := cfg.ApplyEnv(getenv); err != nil {
+		return nil, err
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadFile overrides the settings present in a JSON config file. Unknown keys are
+// rejected so that typos do not go unnoticed.
+func (c *Config) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// ApplyEnv overrides the settings whose environment variable is set
+func (c *Config) ApplyEnv(getenv func(string) string) error {
+	settings := flag.NewFlagSet("env", flag.ContinueOnError)
+	c.RegisterFlags(settings)
+
+	var errs []error
+	settings.VisitAll(func(f *flag.Flag) {
+		name := EnvVar(f.Name)
+		if value := getenv(name); value != "" {
+			if err := f.Value.Set(value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %w", name, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// EnvVar returns the environment variable of the setting with the given flag name
+func EnvVar(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// RegisterFlags defines a flag for every setting on fs, bound to c
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	s := &c.Server
+	fs.StringVar(&s.Transport, "transport", s.Transport,
+		"wire protocol: \"json\" (JSON-RPC 2.0) or \"msgpack\" (msgpack-RPC, as spoken by Neovim)")
+	fs.StringVar(&s.Framing, "framing", s.Framing, "message framing on stdin/stdout: \"line\" or \"content-length\"")
+	fs.IntVar(&s.MaxMessageSize, "max-message-size", s.MaxMessageSize, "maximum size of a single request in bytes (0 for no limit)")
+	fs.IntVar(&s.MaxInFlight, "max-in-flight", s.MaxInFlight, "maximum number of requests handled concurrently")
+	fs.BoolVar(&s.Strict, "strict", s.Strict, "reject calls made before the Initialize handshake")
+	fs.StringVar(&s.Socket, "socket", s.Socket, "Unix socket path of the shared daemon")
+	fs.DurationVar((*time.Duration)(&s.IdleTimeout), "idle-timeout", time.Duration(s.IdleTimeout),
+		"shut the daemon down after this long without clients (0 to never)")
+	fs.DurationVar((*time.Duration)(&s.ShutdownTimeout), "shutdown-timeout", time.Duration(s.ShutdownTimeout),
+		"how long shutdown waits for in-flight requests")
+	fs.DurationVar((*time.Duration)(&s.HealthCheckInterval), "health-check-interval", time.Duration(s.HealthCheckInterval),
+		"interval between dependency health checks")
+	fs.IntVar(&s.MaxRestarts, "max-restarts", s.MaxRestarts, "maximum attempts to reinitialize unhealthy dependencies")
+	fs.StringVar(&s.JobsFile, "jobs-file", s.JobsFile, "file saving background jobs so they resume after a restart (empty to keep them in memory)")
+	fs.StringVar(&s.MetricsAddress, "metrics-address", s.MetricsAddress,
+		"localhost address such as 127.0.0.1:9464 to export OpenMetrics on for Prometheus (empty to disable)")
+
+	ch := &c.Chroma
+	fs.StringVar(&ch.Host, "chroma-host", ch.Host, "ChromaDB host")
+	fs.IntVar(&ch.Port, "chroma-port", ch.Port, "ChromaDB port")
+	fs.StringVar(&ch.DatabasePath, "chroma-path", ch.DatabasePath, "ChromaDB database directory")
+	fs.StringVar(&ch.Collection, "chroma-collection", ch.Collection, "default ChromaDB collection")
+	fs.DurationVar((*time.Duration)(&ch.Timeout), "chroma-timeout", time.Duration(ch.Timeout), "ChromaDB request timeout")
+
+	o := &c.Ollama
+	fs.StringVar(&o.URL, "ollama-url", o.URL, "Ollama base URL")
+	fs.StringVar(&o.Model, "ollama-model", o.Model, "Ollama model to load at startup (empty to load none)")
+
+	a := &c.Agent
+	fs.IntVar(&a.MaxSearchResults, "agent-max-search-results", a.MaxSearchResults, "maximum results retrieved per query")
+	fs.Float64Var(&a.SimilarityThreshold, "agent-similarity-threshold", a.SimilarityThreshold, "minimum similarity of retrieved results (0-1)")
+	fs.IntVar(&a.ContextWindowSize, "agent-context-window-size", a.ContextWindowSize, "size of the context passed to the model")
+	fs.IntVar(&a.MaxResponseTokens, "agent-max-response-tokens", a.MaxResponseTokens, "maximum tokens generated per response")
+	fs.Float64Var(&a.Temperature, "agent-temperature", a.Temperature, "model temperature (0-2)")
+	fs.BoolVar(&a.QueryExpansion, "agent-query-expansion", a.QueryExpansion, "expand queries with related terms")
+	fs.Float64Var(&a.UserBoostFactor, "agent-user-boost-factor", a.UserBoostFactor, "relevance boost for the user's own keybindings")
+	fs.DurationVar((*time.Duration)(&a.ResponseTimeout), "agent-response-timeout", time.Duration(a.ResponseTimeout), "timeout of a single query")
+	fs.BoolVar(&a.SearchAllCollections, "agent-search-all-collections", a.SearchAllCollections, "search every collection instead of keybindings only")
+
+	q := &c.QueryProcessor
+	fs.BoolVar(&q.EnableIntentDetection, "query-intent-detection", q.EnableIntentDetection, "detect the intent of queries")
+	fs.BoolVar(&q.EnableQueryExpansion, "query-expansion", q.EnableQueryExpansion, "expand queries with synonyms")
+	fs.BoolVar(&q.EnableContextBuilding, "query-context-building", q.EnableContextBuilding, "build context for the model from search results")
+	fs.IntVar(&q.MaxExpansionTerms, "query-max-expansion-terms", q.MaxExpansionTerms, "maximum terms added by query expansion")
+	fs.IntVar(&q.ContextWindowSize, "query-context-window-size", q.ContextWindowSize, "size of the context built for the model")
+	fs.Float64Var(&q.SynonymBoostFactor, "query-synonym-boost-factor", q.SynonymBoostFactor, "relevance boost for synonym matches")
+	fs.Float64Var(&q.IntentBoostFactor, "query-intent-boost-factor", q.IntentBoostFactor, "relevance boost for intent matches")
+
+	r := &c.ResponseGenerator
+	fs.IntVar(&r.MaxResponseTokens, "response-max-tokens", r.MaxResponseTokens, "maximum tokens generated per response")
+	fs.Float64Var(&r.Temperature, "response-temperature", r.Temperature, "model temperature (0-2)")
+	fs.DurationVar((*time.Duration)(&r.ResponseTimeout), "response-timeout", time.Duration(r.ResponseTimeout), "timeout of response generation")
+	fs.BoolVar(&r.EnableExplanations, "response-explanations", r.EnableExplanations, "explain why each result matches")
+	fs.BoolVar(&r.EnableRanking, "response-ranking", r.EnableRanking, "rank results by relevance")
+	fs.Float64Var(&r.UserBoostFactor, "response-user-boost-factor", r.UserBoostFactor, "relevance boost for the user's own keybindings")
+	fs.Float64Var(&r.RelevanceThreshold, "response-relevance-threshold", r.RelevanceThreshold, "minimum relevance of returned results (0-1)")
+	fs.IntVar(&r.MaxFinalResults, "response-max-results", r.MaxFinalResults, "maximum results returned per query that does not set a limit")
+
+	l := &c.RateLimit
+	fs.BoolVar(&l.Enabled, "rate-limit", l.Enabled, "reject calls exceeding the rate limits (per-method limits are set in the config file)")
+	fs.Float64Var(&l.Connection.Rate, "rate-limit-connection", l.Connection.Rate, "calls per second allowed on one connection (0 for no limit)")
+	fs.IntVar(&l.Connection.Burst, "rate-limit-connection-burst", l.Connection.Burst, "calls allowed in a burst on one connection")
+}
+
+// Validate checks every setting and reports all problems at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	s := c.Server
+	check(s.Transport == server.TransportJSON || s.Transport == server.TransportMsgpack,
+		"server.transport: unknown transport %q", s.Transport)
+	check(s.Framing == server.FramingLine || s.Framing == server.FramingContentLength,
+		"server.framing: unknown framing %q", s.Framing)
+	check(s.MaxMessageSize >= 0, "server.max_message_size: must not be negative")
+	check(s.MaxInFlight >= 1, "server.max_in_flight: must be at least 1")
+	check(s.Socket != "", "server.socket: must not be empty")
+	check(s.IdleTimeout >= 0, "server.idle_timeout: must not be negative")
+	check(s.ShutdownTimeout > 0, "server.shutdown_timeout: must be positive")
+	check(s.HealthCheckInterval > 0, "server.health_check_interval: must be positive")
+	check(s.MaxRestarts >= 0, "server.max_restarts: must not be negative")
+	check(s.MetricsAddress == "" || isLoopbackAddress(s.MetricsAddress),
+		"server.metrics_address: %q is not a localhost address", s.MetricsAddress)
+
+	ch := c.Chroma
+	check(ch.Host != "", "chroma.host: must not be empty")
+	check(ch.Port >= 1 && ch.Port <= 65535, "chroma.port: %d is not a valid port", ch.Port)
+	check(ch.DatabasePath != "", "chroma.database_path: must not be empty")
+	check(ch.Collection != "", "chroma.collection: must not be empty")
+	check(ch.Timeout > 0, "chroma.timeout: must be positive")
+
+	if u, err := url.Parse(c.Ollama.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("ollama.url: %q is not an http(s) URL", c.Ollama.URL))
+	}
+
+	a := c.Agent
+	check(a.MaxSearchResults >= 1, "agent.max_search_results: must be at least 1")
+	check(a.SimilarityThreshold >= 0 && a.SimilarityThreshold <= 1, "agent.similarity_threshold: must be between 0 and 1")
+	check(a.ContextWindowSize >= 1, "agent.context_window_size: must be at least 1")
+	check(a.MaxResponseTokens >= 1, "agent.max_response_tokens: must be at least 1")
+	check(a.Temperature >= 0 && a.Temperature <= 2, "agent.temperature: must be between 0 and 2")
+	check(a.UserBoostFactor >= 0, "agent.user_boost_factor: must not be negative")
+	check(a.ResponseTimeout > 0, "agent.response_timeout: must be positive")
+
+	q := c.QueryProcessor
+	check(q.MaxExpansionTerms >= 0, "query_processor.max_expansion_terms: must not be negative")
+	check(q.ContextWindowSize >= 1, "query_processor.context_window_size: must be at least 1")
+	check(q.SynonymBoostFactor >= 0, "query_processor.synonym_boost_factor: must not be negative")
+	check(q.IntentBoostFactor >= 0, "query_processor.intent_boost_factor: must not be negative")
+
+	r := c.ResponseGenerator
+	check(r.MaxResponseTokens >= 1, "response_generator.max_response_tokens: must be at least 1")
+	check(r.Temperature >= 0 && r.Temperature <= 2, "response_generator.temperature: must be between 0 and 2")
+	check(r.ResponseTimeout > 0, "response_generator.response_timeout: must be positive")
+	check(r.UserBoostFactor >= 0, "response_generator.user_boost_factor: must not be negative")
+	check(r.RelevanceThreshold >= 0 && r.RelevanceThreshold <= 1, "response_generator.relevance_threshold: must be between 0 and 1")
+	check(r.MaxFinalResults >= 1, "response_generator.max_final_results: must be at least 1")
+
+	checkLimit := func(name string, limit RateLimit) {
+		check(limit.Rate >= 0, "%s.rate: must not be negative", name)
+		check(limit.Rate == 0 || limit.Burst >= 1, "%s.burst: must be at least 1", name)
+	}
+	checkLimit("rate_limit.connection", c.RateLimit.Connection)
+	for _, method := range sortedKeys(c.RateLimit.Methods) {
+		checkLimit("rate_limit.methods."+method, c.RateLimit.Methods[method])
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// DispatcherConfig returns the dispatcher settings
+func (c *Config) DispatcherConfig() *server.DispatcherConfig {
+	return &server.DispatcherConfig{
+		MaxInFlight:       c.Server.MaxInFlight,
+		Transport:         c.Server.Transport,
+		Framing:           c.Server.Framing,
+		MaxMessageSize:    c.Server.MaxMessageSize,
+		RequireInitialize: c.Server.Strict,
+	}
+}
+
+// DaemonConfig returns the daemon settings
+func (c *Config) DaemonConfig() *server.DaemonConfig {
+	return &server.DaemonConfig{
+		SocketPath:  c.Server.Socket,
+		IdleTimeout: time.Duration(c.Server.IdleTimeout),
+	}
+}
+
+// ServiceManagerConfig returns the service manager settings
+func (c *Config) ServiceManagerConfig() *server.ServiceManagerConfig {
+	config := server.DefaultServiceManagerConfig()
+	config.MaxRestarts = c.Server.MaxRestarts
+	config.HealthCheckInterval = time.Duration(c.Server.HealthCheckInterval)
+	config.ShutdownTimeout = time.Duration(c.Server.ShutdownTimeout)
+	config.JobsPath = c.Server.JobsFile
+	config.RateLimits = c.RateLimitConfig()
+	config.MetricsAddress = c.Server.MetricsAddress
+	return config
+}
+
+// RateLimitConfig returns the rate limits, or nil when rate limiting is disabled
+func (c *Config) RateLimitConfig() *server.RateLimitConfig {
+	if !c.RateLimit.Enabled {
+		return nil
+	}
+
+	methods := make(map[string]server.RateLimit, len(c.RateLimit.Methods))
+	for method, limit := range c.RateLimit.Methods {
+		methods[method] = server.RateLimit(limit)
+	}
+	return &server.RateLimitConfig{
+		Connection: server.RateLimit(c.RateLimit.Connection),
+		Methods:    methods,
+	}
+}
+
+// isLoopbackAddress reports whether a host:port address only listens on this machine
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// sortedKeys returns the keys of m in sorted order, so problems are reported in a
+// stable order
+func sortedKeys(m map[string]RateLimit) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ChromaConfig returns the ChromaDB client settings
+func (c *Config) ChromaConfig() *chromadb.Config {
+	return &chromadb.Config{
+		Host:           c.Chroma.Host,
+		Port:           c.Chroma.Port,
+		DatabasePath:   c.Chroma.DatabasePath,
+		CollectionName: c.Chroma.Collection,
+		Timeout:        time.Duration(c.Chroma.Timeout),
+	}
+}
+
+// AgentConfig returns the RAG agent settings, including those of its query processor
+// and response generator
+func (c *Config) AgentConfig() *rag.AgentConfig {
+	a, q, r := c.Agent, c.QueryProcessor, c.ResponseGenerator
+	return &rag.AgentConfig{
+		MaxSearchResults:     a.MaxSearchResults,
+		SimilarityThreshold:  a.SimilarityThreshold,
+		ContextWindowSize:    a.ContextWindowSize,
+		MaxResponseTokens:    a.MaxResponseTokens,
+		Temperature:          a.Temperature,
+		QueryExpansion:       a.QueryExpansion,
+		UserBoostFactor:      a.UserBoostFactor,
+		ResponseTimeout:      time.Duration(a.ResponseTimeout),
+		SearchAllCollections: a.SearchAllCollections,
+		QueryProcessor: &rag.QueryProcessorConfig{
+			EnableIntentDetection: q.EnableIntentDetection,
+			EnableQueryExpansion:  q.EnableQueryExpansion,
+			EnableContextBuilding: q.EnableContextBuilding,
+			MaxExpansionTerms:     q.MaxExpansionTerms,
+			ContextWindowSize:     q.ContextWindowSize,
+			SynonymBoostFactor:    q.SynonymBoostFactor,
+			IntentBoostFactor:     q.IntentBoostFactor,
+		},
+		ResponseGenerator: &rag.ResponseGeneratorConfig{
+			MaxResponseTokens:  r.MaxResponseTokens,
+			Temperature:        r.Temperature,
+			ResponseTimeout:    time.Duration(r.ResponseTimeout),
+			EnableExplanations: r.EnableExplanations,
+			EnableRanking:      r.EnableRanking,
+			UserBoostFactor:    r.UserBoostFactor,
+			RelevanceThreshold: r.RelevanceThreshold,
+			MaxFinalResults:    r.MaxFinalResults,
+		},
+	}
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad_Layers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	file := `{
+		"chroma": {"host": "db.local", "port": 9000},
+		"ollama": {"model": "llama3.2:3b"},
+		"agent": {"similarity_threshold": 0.5, "response_timeout": "45s"}
+	}`
+	if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	env := map[string]string{
+		"NVIM_SMART_KEYBIND_SEARCH_CHROMA_PORT":                "9100",
+		"NVIM_SMART_KEYBIND_SEARCH_AGENT_SIMILARITY_THRESHOLD": "0.6",
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := Load(fs, []string{"-config", path, "-agent-similarity-threshold", "0.7"}, func(name string) string {
+		return env[name]
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	defaults := Default()
+	if cfg.Chroma.Host != "db.local" || cfg.Ollama.Model != "llama3.2:3b" {
+		t.Errorf("expected settings from the file, got %+v %+v", cfg.Chroma, cfg.Ollama)
+	}
+	if cfg.Chroma.Port != 9100 {
+		t.Errorf("expected environment to override the file, got port %d", cfg.Chroma.Port)
+	}
+	if cfg.Agent.SimilarityThreshold != 0.7 {
+		t.Errorf("expected flag to override the environment, got %v", cfg.Agent.SimilarityThreshold)
+	}
+	if time.Duration(cfg.Agent.ResponseTimeout) != 45*time.Second {
+		t.Errorf("expected duration from the file, got %v", time.Duration(cfg.Agent.ResponseTimeout))
+	}
+	if cfg.Server.Transport != defaults.Server.Transport || cfg.Ollama.URL != defaults.Ollama.URL {
+		t.Errorf("expected defaults for unset settings, got %+v", cfg.Server)
+	}
+
+	agent := cfg.AgentConfig()
+	if agent.SimilarityThreshold != 0.7 || agent.QueryProcessor == nil || agent.ResponseGenerator == nil {
+		t.Errorf("unexpected agent config: %+v", agent)
+	}
+	if chroma := cfg.ChromaConfig(); chroma.Host != "db.local" || chroma.Port != 9100 {
+		t.Errorf("unexpected chroma config: %+v", chroma)
+	}
+}
+
+func TestLoad_ConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	noEnv := func(string) string { return "" }
+
+	// The default file is optional, an explicit one is not
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if _, err := Load(fs, []string{"-config", filepath.Join(dir, "missing.json")}, noEnv); err == nil {
+		t.Errorf("expected error for a missing explicit config file")
+	}
+
+	unknown := filepath.Join(dir, "unknown.json")
+	os.WriteFile(unknown, []byte(`{"chroma": {"prot": 9000}}`), 0644)
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	if _, err := Load(fs, []string{"-config", unknown}, noEnv); err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Errorf("expected unknown key to be rejected, got %v", err)
+	}
+}
+
+func TestLoad_RateLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"rate_limit": {"methods": {"UpdateKeybindings": {"rate": 0.5, "burst": 1}}}}`), 0644)
+	noEnv := func(string) string { return "" }
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := Load(fs, []string{"-config", path, "-rate-limit-connection", "10"}, noEnv)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	limits := cfg.ServiceManagerConfig().RateLimits
+	if limits == nil || limits.Connection.Rate != 10 {
+		t.Fatalf("expected connection rate from flag, got %+v", limits)
+	}
+	if limit := limits.Methods["UpdateKeybindings"]; limit.Rate != 0.5 || limit.Burst != 1 {
+		t.Errorf("expected UpdateKeybindings limit from file, got %+v", limit)
+	}
+	if _, ok := limits.Methods["Query"]; !ok {
+		t.Errorf("expected default limits of other methods to be kept, got %+v", limits.Methods)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err = Load(fs, []string{"-config", path, "-rate-limit=false"}, noEnv)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if limits := cfg.ServiceManagerConfig().RateLimits; limits != nil {
+		t.Errorf("expected rate limiting to be disabled, got %+v", limits)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("expected defaults to be valid, got %v", err)
+	}
+
+	cfg := Default()
+	cfg.Server.Transport = "xml"
+	cfg.Chroma.Port = 70000
+	cfg.Ollama.URL = "localhost:11434"
+	cfg.Agent.SimilarityThreshold = 1.5
+	cfg.RateLimit.Methods["Query"] = RateLimit{Rate: 1}
+	cfg.Server.MetricsAddress = ":9464"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatalf("expected validation error")
+	}
+	for _, setting := range []string{"server.transport", "chroma.port", "ollama.url", "agent.similarity_threshold", "rate_limit.methods.Query.burst", "server.metrics_address"} {
+		if !strings.Contains(err.Error(), setting) {
+			t.Errorf("expected %s to be reported, got %v", setting, err)
+		}
+	}
+}
+
+func TestApplyEnv_InvalidValue(t *testing.T) {
+	cfg := Default()
+	err := cfg.ApplyEnv(func(name string) string {
+		if name == EnvVar("chroma-timeout") {
+			return "soon"
+		}
+		return ""
+	})
+	if err == nil || !strings.Contains(err.Error(), "NVIM_SMART_KEYBIND_SEARCH_CHROMA_TIMEOUT") {
+		t.Errorf("expected invalid environment variable to be reported, got %v", err)
+	}
+}
+
+func TestDuration_JSON(t *testing.T) {
+	data, err := json.Marshal(Default().Server)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	if !strings.Contains(string(data), `"idle_timeout":"5m0s"`) {
+		t.Errorf("expected durations to be written as strings, got %s", data)
+	}
+
+	var d Duration
+	if err := json.Unmarshal([]byte(`300`), &d); err == nil {
+		t.Errorf("expected numeric duration to be rejected")
+	}
+}
@@ -1,5 +1,7 @@
 package interfaces
 
+import "context"
+
 // LLMRequest represents a request to the language model
 type LLMRequest struct {
 	Prompt      string            `json:"prompt"`
@@ -30,6 +32,9 @@ type LLMClient interface {
 	// Generate generates text based on the given request
 	Generate(request LLMRequest) (*LLMResponse, error)
 	
+	// GenerateContext generates text and aborts when ctx is cancelled
+	GenerateContext(ctx context.Context, request LLMRequest) (*LLMResponse, error)
+	
 	// Embed generates embeddings for the given text
 	Embed(text string) ([]float64, error)
 	
@@ -1,12 +1,113 @@
 package interfaces
 
+import "context"
+
 // QueryRequest represents a search query from the client
 type QueryRequest struct {
 	Query   string            `json:"query"`
 	Context map[string]string `json:"context,omitempty"`
 	Limit   int               `json:"limit,omitempty"`
+	Filter  *SearchFilter     `json:"filter,omitempty"`
+}
+
+// Sources of keybindings, each kept in its own collection
+const (
+	SourceUser    = "user"
+	SourceBuiltin = "builtin"
+	SourceGeneral = "general"
+)
+
+// SearchFilter restricts the documents a query retrieves. Modes and plugins are matched
+// by the vector store itself; empty fields do not restrict anything.
+type SearchFilter struct {
+	// Modes are mapping modes as stored with the keybindings, e.g. "n" or "x"
+	Modes   []string `json:"modes,omitempty"`
+	Plugins []string `json:"plugins,omitempty"`
+
+	// Sources selects the collections searched: SourceUser, SourceBuiltin or SourceGeneral
+	Sources []string `json:"sources,omitempty"`
+
+	// MinRelevance is the minimum similarity of a retrieved document, raising the
+	// configured similarity threshold
+	MinRelevance float64 `json:"min_relevance,omitempty"`
+}
+
+// IncludesSource reports whether the filter allows documents from source
+func (f *SearchFilter) IncludesSource(source string) bool {
+	if f == nil || len(f.Sources) == 0 {
+		return true
+	}
+	for _, s := range f.Sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// KeybindingSelector selects stored user keybindings. Its fields combine, and an empty
+// field does not restrict anything.
+type KeybindingSelector struct {
+	IDs     []string `json:"ids,omitempty"`
+	Modes   []string `json:"modes,omitempty"`
+	Plugins []string `json:"plugins,omitempty"`
+}
+
+// IsEmpty reports whether the selector leaves every keybinding selected
+func (s *KeybindingSelector) IsEmpty() bool {
+	return s == nil || len(s.IDs)+len(s.Modes)+len(s.Plugins) == 0
+}
+
+// ListKeybindingsRequest asks for a page of the stored keybindings
+type ListKeybindingsRequest struct {
+	// Filter restricts the keybindings by source, mode and plugin. Without sources only
+	// the user and built-in keybindings are listed; MinRelevance does not apply.
+	Filter *SearchFilter
+
+	// Prefix keeps the keybindings whose keys, command or description start with it,
+	// ignoring case
+	Prefix string
+
+	// Start is where the page begins, nil for the first page
+	Start *ListPosition
+
+	Limit int
 }
 
+// ListPosition is a place in the listing of stored keybindings: an offset among the
+// documents of a source's collection that pass the filter
+type ListPosition struct {
+	Source string
+	Offset int
+}
+
+// StoredKeybinding is a keybinding along with the source it is stored under
+type StoredKeybinding struct {
+	Keybinding
+	Source string `json:"source"`
+}
+
+// KeybindingPage is a page of stored keybindings. Next is where the following page
+// begins, nil after the last page.
+type KeybindingPage struct {
+	Keybindings []StoredKeybinding
+	Next        *ListPosition
+}
+
+// Keys of QueryRequest.Context, describing the editor state the query was made in
+const (
+	// ContextMode is the editor mode as reported by mode(), e.g. "n", "v", "V" or "i"
+	ContextMode = "mode"
+	// ContextFiletype is the filetype of the current buffer
+	ContextFiletype = "filetype"
+	// ContextBufferMaps lists the mappings local to the current buffer, one "mode lhs" per line
+	ContextBufferMaps = "buffer_maps"
+	// ContextCwd is the working directory of the editor
+	ContextCwd = "cwd"
+	// ContextNvimVersion is the Neovim version, e.g. "0.10.1"
+	ContextNvimVersion = "nvim_version"
+)
+
 // QueryResult represents the response to a search query
 type QueryResult struct {
 	Results   []SearchResult `json:"results"`
@@ -14,6 +115,37 @@ type QueryResult struct {
 	Error     string         `json:"error,omitempty"`
 }
 
+// Stages of a streamed query, in the order their updates are emitted
+const (
+	// QueryStageRetrieval carries the raw vector hits, before any LLM enrichment
+	QueryStageRetrieval = "retrieval"
+	// QueryStageRanked carries the results reranked and explained by the LLM
+	QueryStageRanked = "ranked"
+)
+
+// QueryUpdate is an intermediate result emitted while a streamed query is still running
+type QueryUpdate struct {
+	Stage     string         `json:"stage"`
+	Results   []SearchResult `json:"results"`
+	Reasoning string         `json:"reasoning,omitempty"`
+}
+
+// Phases of a keybinding sync, in the order they run
+const (
+	SyncPhaseValidate  = "validate"
+	SyncPhaseVectorize = "vectorize"
+	SyncPhaseStore     = "store"
+)
+
+// SyncProgress reports how far a keybinding sync has got within a phase. Error
+// describes keybindings or a batch that failed; the sync carries on without them.
+type SyncProgress struct {
+	Phase     string `json:"phase"`
+	Processed int    `json:"processed"`
+	Total     int    `json:"total"`
+	Error     string `json:"error,omitempty"`
+}
+
 // SearchResult represents a single keybinding search result
 type SearchResult struct {
 	Keybinding  Keybinding `json:"keybinding"`
@@ -37,6 +169,12 @@ type RAGAgent interface {
 	// ProcessQuery processes a natural language query and returns relevant keybindings
 	ProcessQuery(query string) (*QueryResult, error)
 
+	// ProcessQueryContext processes a query and stops early when ctx is cancelled
+	ProcessQueryContext(ctx context.Context, query string) (*QueryResult, error)
+
+	// ProcessRequest processes a query, using its editor context to filter and rank results
+	ProcessRequest(ctx context.Context, request *QueryRequest) (*QueryResult, error)
+
 	// UpdateVectorDB updates the vector database with new keybindings
 	UpdateVectorDB(keybindings []Keybinding) error
 
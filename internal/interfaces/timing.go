@@ -0,0 +1,38 @@
+package interfaces
+
+import (
+	"context"
+	"time"
+)
+
+// Stages of processing a query whose durations are recorded
+const (
+	StageIntentDetection = "intent_detection"
+	StageQueryExpansion  = "query_expansion"
+	StageContextBuilding = "context_building"
+	StageLLMGeneration   = "llm_generation"
+	StageRanking         = "ranking"
+)
+
+// SearchStage returns the stage of searching a single collection
+func SearchStage(collection string) string {
+	return "search:" + collection
+}
+
+// StageRecorder receives how long a stage of processing a request took
+type StageRecorder func(stage string, duration time.Duration)
+
+type stageRecorderKey struct{}
+
+// WithStageRecorder returns a context whose stage timings are sent to record
+func WithStageRecorder(ctx context.Context, record StageRecorder) context.Context {
+	return context.WithValue(ctx, stageRecorderKey{}, record)
+}
+
+// RecordStage reports the time since start as the duration of stage to the recorder of
+// ctx, if it has one
+func RecordStage(ctx context.Context, stage string, start time.Time) {
+	if record, ok := ctx.Value(stageRecorderKey{}).(StageRecorder); ok && record != nil {
+		record(stage, time.Since(start))
+	}
+}
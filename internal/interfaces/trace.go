@@ -0,0 +1,91 @@
+package interfaces
+
+import "context"
+
+// QueryTrace records how a query was processed, so a bad answer can be traced back to
+// the step that caused it. Each component the query passes through fills in its part.
+// It is not safe for concurrent use; a query is processed by one goroutine.
+type QueryTrace struct {
+	// ProcessedQuery is the query after intent detection and expansion
+	ProcessedQuery *TracedQuery `json:"processed_query,omitempty"`
+
+	// CollectionHits are the raw vector search hits, by collection
+	CollectionHits map[string][]TracedHit `json:"collection_hits,omitempty"`
+
+	// Dropped are the hits scoring below SimilarityThreshold
+	SimilarityThreshold float64     `json:"similarity_threshold"`
+	Dropped             []TracedHit `json:"dropped,omitempty"`
+
+	// NotApplicable are the hits dropped because they cannot be used in the editor the
+	// query was made in, such as mappings local to another filetype
+	NotApplicable []TracedHit `json:"not_applicable,omitempty"`
+
+	// Prompt is the exact prompt sent to the LLM, and LLMResponse its raw answer
+	Prompt      string   `json:"prompt,omitempty"`
+	LLMResponse string   `json:"llm_response,omitempty"`
+	LLMError    string   `json:"llm_error,omitempty"`
+	ParseErrors []string `json:"parse_errors,omitempty"`
+
+	// Ranking shows how the final relevance of every candidate was computed
+	Ranking []TracedRanking `json:"ranking,omitempty"`
+}
+
+// TracedQuery describes a processed query
+type TracedQuery struct {
+	Original         string             `json:"original"`
+	Expanded         string             `json:"expanded"`
+	Intent           string             `json:"intent,omitempty"`
+	IntentConfidence float64            `json:"intent_confidence,omitempty"`
+	IntentKeywords   []string           `json:"intent_keywords,omitempty"`
+	Synonyms         []string           `json:"synonyms,omitempty"`
+	SearchTerms      []string           `json:"search_terms,omitempty"`
+	BoostFactors     map[string]float64 `json:"boost_factors,omitempty"`
+	Context          map[string]string  `json:"context,omitempty"`
+}
+
+// TracedHit describes a single vector search hit
+type TracedHit struct {
+	ID         string  `json:"id"`
+	Keys       string  `json:"keys,omitempty"`
+	Collection string  `json:"collection,omitempty"`
+	Score      float64 `json:"score"`
+}
+
+// TracedRanking shows the inputs and result of ranking one candidate. Returned is false
+// for candidates cut by the result limit.
+type TracedRanking struct {
+	ID             string             `json:"id"`
+	Keys           string             `json:"keys"`
+	VectorScore    float64            `json:"vector_score"`
+	LLMScore       float64            `json:"llm_score"`
+	Factors        map[string]float64 `json:"factors,omitempty"`
+	MatchedTerms   []string           `json:"matched_terms,omitempty"`
+	FinalRelevance float64            `json:"final_relevance"`
+	Returned       bool               `json:"returned"`
+}
+
+// NewTracedHit describes a vector search hit; collection is empty when unknown
+func NewTracedHit(collection string, result VectorSearchResult) TracedHit {
+	hit := TracedHit{
+		ID:         string(result.Document.ID),
+		Collection: collection,
+		Score:      result.Score,
+	}
+	if result.Document.Metadata != nil {
+		hit.Keys, _ = result.Document.Metadata.GetString("keys")
+	}
+	return hit
+}
+
+type queryTraceKey struct{}
+
+// WithQueryTrace returns a context whose processing is recorded in trace
+func WithQueryTrace(ctx context.Context, trace *QueryTrace) context.Context {
+	return context.WithValue(ctx, queryTraceKey{}, trace)
+}
+
+// QueryTraceFromContext returns the trace of ctx, or nil when the query is not traced
+func QueryTraceFromContext(ctx context.Context) *QueryTrace {
+	trace, _ := ctx.Value(queryTraceKey{}).(*QueryTrace)
+	return trace
+}
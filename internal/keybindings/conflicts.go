@@ -0,0 +1,405 @@
+package keybindings
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"nvim-smart-keybind-search/internal/interfaces"
+)
+
+// Kinds of keybinding conflicts
+const (
+	// ConflictDuplicate is the same keys mapped more than once in the same mode; only the
+	// mapping defined last takes effect
+	ConflictDuplicate = "duplicate"
+	// ConflictPrefix is keys that start other keys of the same mode, so Neovim waits
+	// 'timeoutlen' before running the shorter mapping
+	ConflictPrefix = "prefix"
+	// ConflictModeOverlap is a mapping for several modes, such as "" or "!", clashing with
+	// a mapping of the same keys for one of those modes
+	ConflictModeOverlap = "mode_overlap"
+)
+
+// Severities of keybinding conflicts
+const (
+	// SeverityError means a mapping can never be used as it stands
+	SeverityError = "error"
+	// SeverityWarning means a mapping works, but not the way it is likely meant to
+	SeverityWarning = "warning"
+	// SeverityInfo means a mapping is overridden on purpose, such as by a buffer-local one
+	SeverityInfo = "info"
+)
+
+// mappingModeNames names the modes mappings are looked up in
+var mappingModeNames = map[string]string{
+	"n": "normal",
+	"x": "visual",
+	"s": "select",
+	"o": "operator-pending",
+	"i": "insert",
+	"c": "command-line",
+	"t": "terminal",
+}
+
+// Conflict is a problem between two or more keybindings
+type Conflict struct {
+	Kind     string `json:"kind"`
+	Severity string `json:"severity"`
+	Keys     string `json:"keys"`
+
+	// Modes are the modes the keybindings clash in, out of "n", "x", "s", "o", "i", "c"
+	// and "t"
+	Modes []string `json:"modes"`
+
+	// Keybindings are the keybindings involved; for prefix conflicts the first one is the
+	// mapping that has to wait
+	Keybindings []interfaces.Keybinding `json:"keybindings"`
+
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion"`
+}
+
+// ConflictReport is the outcome of analyzing a set of keybindings
+type ConflictReport struct {
+	// Analyzed is the number of keybindings looked at
+	Analyzed  int        `json:"analyzed"`
+	Conflicts []Conflict `json:"conflicts"`
+
+	// Counts is the number of conflicts of each severity
+	Counts map[string]int `json:"counts"`
+}
+
+// MappingModes returns the modes a mapping of mode applies to, out of "n", "x", "s", "o",
+// "i", "c" and "t", or nil when mode is not valid. "" is :map, covering normal, visual,
+// select and operator-pending mode, "v" covers visual and select mode, and "!" and "ic"
+// insert and command-line mode.
+func (p *KeybindingParser) MappingModes(mode string) []string {
+	if !p.validModes[mode] {
+		return nil
+	}
+
+	switch mode {
+	case "":
+		return []string{"n", "x", "s", "o"}
+	case "v":
+		return []string{"x", "s"}
+	case "!", "ic":
+		return []string{"i", "c"}
+	}
+	return []string{mode}
+}
+
+// mappedKeys is a keybinding as seen in one of the modes it applies to
+type mappedKeys struct {
+	keybinding *interfaces.Keybinding
+	mode       string
+	keys       string // normalized key sequence, each key followed by a NUL
+}
+
+// AnalyzeConflicts reports the duplicates, prefix ambiguities and mode overlaps among
+// keybindings. The same mapping reported once per mode, as a scan of each mode returns it,
+// is not a conflict with itself. Keybindings with an invalid mode are skipped.
+func (p *KeybindingParser) AnalyzeConflicts(keybindings []interfaces.Keybinding) *ConflictReport {
+	// Look at every keybinding in each mode it applies to, once per distinct mapping
+	byMode := make(map[string][]mappedKeys)
+	seen := make(map[string]bool)
+	for i := range keybindings {
+		kb := &keybindings[i]
+		keys := normalizeKeys(kb.Keys)
+		if keys == "" {
+			continue
+		}
+		for _, mode := range p.MappingModes(kb.Mode) {
+			identity := mode + "\x01" + keybindingIdentity(kb)
+			if seen[identity] {
+				continue
+			}
+			seen[identity] = true
+			byMode[mode] = append(byMode[mode], mappedKeys{keybinding: kb, mode: mode, keys: keys})
+		}
+	}
+
+	conflicts := make(map[string]*Conflict)
+	var order []string
+	add := func(id string, mode string, build func() *Conflict, involved ...*interfaces.Keybinding) {
+		conflict, ok := conflicts[id]
+		if !ok {
+			conflict = build()
+			conflicts[id] = conflict
+			order = append(order, id)
+		}
+		if len(conflict.Modes) == 0 || conflict.Modes[len(conflict.Modes)-1] != mode {
+			conflict.Modes = append(conflict.Modes, mode)
+		}
+		for _, kb := range involved {
+			if !containsKeybinding(conflict.Keybindings, kb) {
+				conflict.Keybindings = append(conflict.Keybindings, *kb)
+			}
+		}
+	}
+
+	for _, mode := range []string{"n", "x", "s", "o", "i", "c", "t"} {
+		entries := byMode[mode]
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].keys < entries[j].keys })
+
+		for i := 0; i < len(entries); {
+			// Mappings of the same keys
+			j := i + 1
+			for j < len(entries) && entries[j].keys == entries[i].keys {
+				j++
+			}
+			same := entries[i:j]
+			for a := 0; a < len(same); a++ {
+				for b := a + 1; b < len(same); b++ {
+					first, second := same[a].keybinding, same[b].keybinding
+					id := fmt.Sprintf("same\x00%s\x00%p\x00%p", same[a].keys, first, second)
+					add(id, mode, func() *Conflict { return p.sameKeysConflict(first, second) }, first, second)
+				}
+			}
+
+			// Longer mappings starting with these keys follow them in sorted order
+			for k := j; k < len(entries) && strings.HasPrefix(entries[k].keys, entries[i].keys); k++ {
+				for _, short := range same {
+					shortKb, long := short.keybinding, entries[k].keybinding
+					if isPlugMapping(shortKb.Keys) {
+						continue
+					}
+					id := fmt.Sprintf("prefix\x00%p", shortKb)
+					add(id, mode, func() *Conflict { return prefixConflict(shortKb) }, shortKb, long)
+				}
+			}
+			i = j
+		}
+	}
+
+	report := &ConflictReport{Analyzed: len(keybindings), Conflicts: []Conflict{}, Counts: make(map[string]int)}
+	for _, id := range order {
+		conflict := conflicts[id]
+		if conflict.Kind == ConflictPrefix {
+			finishPrefixConflict(conflict)
+		}
+		if conflict.Kind == ConflictModeOverlap {
+			p.finishModeOverlap(conflict)
+		}
+		report.Conflicts = append(report.Conflicts, *conflict)
+		report.Counts[conflict.Severity]++
+	}
+
+	// Most severe first, keeping the order of the keys otherwise
+	rank := map[string]int{SeverityError: 0, SeverityWarning: 1, SeverityInfo: 2}
+	sort.SliceStable(report.Conflicts, func(i, j int) bool {
+		return rank[report.Conflicts[i].Severity] < rank[report.Conflicts[j].Severity]
+	})
+	return report
+}
+
+// sameKeysConflict describes two different mappings of the same keys in a mode
+func (p *KeybindingParser) sameKeysConflict(first, second *interfaces.Keybinding) *Conflict {
+	conflict := &Conflict{Keys: first.Keys}
+
+	switch {
+	case (first.Metadata["buffer_local"] == "true") != (second.Metadata["buffer_local"] == "true"):
+		conflict.Kind = ConflictDuplicate
+		conflict.Severity = SeverityInfo
+		conflict.Message = fmt.Sprintf("The buffer-local mapping of %s overrides the global one in its buffer", first.Keys)
+		conflict.Suggestion = "Nothing to do if the override is intended; otherwise map the buffer-local keybinding to other keys"
+
+	case first.Mode != second.Mode:
+		conflict.Kind = ConflictModeOverlap
+		conflict.Severity = SeverityWarning
+
+	default:
+		conflict.Kind = ConflictDuplicate
+		conflict.Severity = SeverityError
+		conflict.Message = fmt.Sprintf("%s is mapped %s; only the mapping defined last takes effect",
+			first.Keys, describeOwners(first, second))
+		conflict.Suggestion = fmt.Sprintf("Remove the unused mapping of %s or move one of them to other keys", first.Keys)
+		if first.Plugin != second.Plugin {
+			conflict.Suggestion += fmt.Sprintf(", for example through the keymap options of %s", pluginName(second))
+		}
+	}
+	return conflict
+}
+
+// finishModeOverlap writes the message of a mode overlap once all the modes it happens
+// in are known
+func (p *KeybindingParser) finishModeOverlap(conflict *Conflict) {
+	// The keybinding covering the most modes is the one to narrow down
+	wide, narrow := conflict.Keybindings[0], conflict.Keybindings[1]
+	if len(p.MappingModes(narrow.Mode)) > len(p.MappingModes(wide.Mode)) {
+		wide, narrow = narrow, wide
+	}
+
+	conflict.Message = fmt.Sprintf("The %s mapping of %s and the %s mapping of it clash in %s mode",
+		describeMode(wide.Mode), conflict.Keys, describeMode(narrow.Mode), describeModes(conflict.Modes))
+
+	var rest []string
+	for _, mode := range p.MappingModes(wide.Mode) {
+		if !containsString(conflict.Modes, mode) {
+			rest = append(rest, fmt.Sprintf("%q", mode))
+		}
+	}
+	if len(rest) == 0 {
+		conflict.Suggestion = fmt.Sprintf("Remove one of the mappings of %s or move one of them to other keys", conflict.Keys)
+		return
+	}
+	conflict.Suggestion = fmt.Sprintf("Map %s for the modes it is meant for only, e.g. vim.keymap.set({ %s }, %q, ...), or move it to other keys",
+		wide.Keys, strings.Join(rest, ", "), wide.Keys)
+}
+
+// prefixConflict starts a prefix conflict for a mapping that other mappings extend
+func prefixConflict(short *interfaces.Keybinding) *Conflict {
+	conflict := &Conflict{Kind: ConflictPrefix, Severity: SeverityWarning, Keys: short.Keys}
+	if short.Metadata["nowait"] == "true" {
+		conflict.Severity = SeverityError
+	}
+	return conflict
+}
+
+// finishPrefixConflict writes the message of a prefix conflict once all the longer
+// mappings are known
+func finishPrefixConflict(conflict *Conflict) {
+	short := conflict.Keybindings[0]
+	longer := make([]string, 0, len(conflict.Keybindings)-1)
+	for _, kb := range conflict.Keybindings[1:] {
+		if !containsString(longer, kb.Keys) {
+			longer = append(longer, kb.Keys)
+		}
+	}
+	list := strings.Join(longer, ", ")
+
+	if conflict.Severity == SeverityError {
+		conflict.Message = fmt.Sprintf("%s is mapped with <nowait>, so %s can never be typed in %s mode",
+			short.Keys, list, describeModes(conflict.Modes))
+		conflict.Suggestion = fmt.Sprintf("Drop <nowait> from %s or move %s to another prefix", short.Keys, list)
+		return
+	}
+	conflict.Message = fmt.Sprintf("%s waits 'timeoutlen' in %s mode because %s start with it",
+		short.Keys, describeModes(conflict.Modes), list)
+	conflict.Suggestion = fmt.Sprintf("Move %s to keys no other mapping starts with, or move %s to another prefix",
+		short.Keys, list)
+}
+
+// keyAliases maps special keys to the name Neovim reports them by
+var keyAliases = map[string]string{
+	"<space>":     " ",
+	"<lt>":        "<",
+	"<bar>":       "|",
+	"<bslash>":    "\\",
+	"<enter>":     "<cr>",
+	"<return>":    "<cr>",
+	"<backspace>": "<bs>",
+	"<escape>":    "<esc>",
+}
+
+// normalizeKeys splits a key sequence into its keys, spelling each one the same way
+// whatever its case or alias, and ends each with a NUL so that one normalized sequence
+// starts with another exactly when its keys do
+func normalizeKeys(keys string) string {
+	var b strings.Builder
+	for _, key := range splitKeys(keys) {
+		if len(key) > 1 {
+			key = strings.ToLower(key)
+			if alias, ok := keyAliases[key]; ok {
+				key = alias
+			}
+		}
+		b.WriteString(key)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// splitKeys splits a key sequence into its keys as written, a special key such as <C-w>
+// being one key
+func splitKeys(keys string) []string {
+	var split []string
+	for len(keys) > 0 {
+		key := keys[:1]
+		if keys[0] == '<' {
+			if end := strings.IndexByte(keys, '>'); end > 1 && !strings.ContainsAny(keys[1:end], "< ") {
+				key = keys[:end+1]
+			}
+		}
+		keys = keys[len(key):]
+		split = append(split, key)
+	}
+	return split
+}
+
+// isPlugMapping reports whether keys are a <Plug> mapping, which is never typed
+func isPlugMapping(keys string) bool {
+	return strings.HasPrefix(strings.ToLower(keys), "<plug>")
+}
+
+// describeOwners names who defined the keybindings, e.g. `by telescope and by the user`
+func describeOwners(keybindings ...*interfaces.Keybinding) string {
+	owners := make([]string, len(keybindings))
+	for i, kb := range keybindings {
+		owners[i] = "by " + pluginName(kb)
+	}
+	if len(owners) == 2 && owners[0] == owners[1] {
+		return "twice " + owners[0]
+	}
+	return strings.Join(owners, " and ")
+}
+
+// pluginName names the plugin that defined a keybinding
+func pluginName(kb *interfaces.Keybinding) string {
+	if kb.Plugin == "" {
+		return "the user"
+	}
+	return kb.Plugin
+}
+
+// describeMode names the mode of a keybinding as written in its mapping
+func describeMode(mode string) string {
+	switch mode {
+	case "":
+		return `"" (:map)`
+	case "!":
+		return `"!" (:map!)`
+	}
+	return fmt.Sprintf("%q", mode)
+}
+
+// describeModes names modes for a message, e.g. "normal and visual"
+func describeModes(modes []string) string {
+	names := make([]string, len(modes))
+	for i, mode := range modes {
+		names[i] = mappingModeNames[mode]
+	}
+	if len(names) <= 1 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// keybindingIdentity identifies a mapping whatever its id, as the scanner derives ids
+// from the mode, keys and plugin only, so a buffer-local override shares the id of the
+// global mapping, and keybindings sent in a payload may have none
+func keybindingIdentity(kb *interfaces.Keybinding) string {
+	return strings.Join([]string{normalizeKeys(kb.Keys), kb.Command, kb.Description, kb.Plugin, kb.Metadata["buffer_local"]}, "\x01")
+}
+
+// containsKeybinding reports whether keybindings holds the mapping kb
+func containsKeybinding(keybindings []interfaces.Keybinding, kb *interfaces.Keybinding) bool {
+	identity := keybindingIdentity(kb)
+	for i := range keybindings {
+		if keybindingIdentity(&keybindings[i]) == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString reports whether values holds value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
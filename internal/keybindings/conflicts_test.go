@@ -0,0 +1,155 @@
+package keybindings
+
+import (
+	"strings"
+	"testing"
+
+	"nvim-smart-keybind-search/internal/interfaces"
+)
+
+func TestMappingModes(t *testing.T) {
+	parser := NewKeybindingParser()
+
+	tests := map[string]string{
+		"":   "nxso",
+		"v":  "xs",
+		"!":  "ic",
+		"ic": "ic",
+		"n":  "n",
+		"t":  "t",
+		"q":  "",
+	}
+	for mode, want := range tests {
+		if got := strings.Join(parser.MappingModes(mode), ""); got != want {
+			t.Errorf("MappingModes(%q) = %q, want %q", mode, got, want)
+		}
+	}
+}
+
+func TestNormalizeKeys(t *testing.T) {
+	if normalizeKeys("<Leader>G") != normalizeKeys("<leader>G") {
+		t.Error("Expected special keys to be compared ignoring case")
+	}
+	if normalizeKeys("<Space>f") != normalizeKeys(" f") {
+		t.Error("Expected <Space> to match a literal space")
+	}
+	if normalizeKeys("<leader>g") == normalizeKeys("<leader>G") {
+		t.Error("Expected plain keys to keep their case")
+	}
+	if strings.HasPrefix(normalizeKeys("<Tab>"), normalizeKeys("<T")) {
+		t.Error("Expected <Tab> to be a single key")
+	}
+}
+
+// findConflict returns the first conflict of a kind on keys
+func findConflict(report *ConflictReport, kind, keys string) *Conflict {
+	for i := range report.Conflicts {
+		if report.Conflicts[i].Kind == kind && report.Conflicts[i].Keys == keys {
+			return &report.Conflicts[i]
+		}
+	}
+	return nil
+}
+
+func TestAnalyzeConflicts(t *testing.T) {
+	parser := NewKeybindingParser()
+	report := parser.AnalyzeConflicts([]interfaces.Keybinding{
+		{ID: "1", Keys: "<leader>g", Command: ":Git<CR>", Mode: "n", Plugin: "fugitive"},
+		{ID: "2", Keys: "<leader>gs", Command: ":Git status<CR>", Mode: "n"},
+		{ID: "3", Keys: "<leader>gc", Command: ":Git commit<CR>", Mode: "n"},
+		{ID: "4", Keys: "<leader>ff", Command: ":Telescope find_files<CR>", Mode: "n", Plugin: "telescope"},
+		{ID: "5", Keys: "<leader>ff", Command: ":FzfLua files<CR>", Mode: "n", Plugin: "fzf-lua"},
+		{ID: "6", Keys: "gx", Command: ":Open<CR>", Mode: ""},
+		{ID: "7", Keys: "gx", Command: ":Browse<CR>", Mode: "n"},
+		{ID: "8", Keys: "<C-s>", Command: "<Esc>:w<CR>", Mode: "!"},
+		{ID: "9", Keys: "<c-s>", Command: "<Esc>:update<CR>", Mode: "i"},
+	})
+
+	if report.Analyzed != 9 {
+		t.Errorf("Expected 9 keybindings analyzed, got %d", report.Analyzed)
+	}
+
+	prefix := findConflict(report, ConflictPrefix, "<leader>g")
+	if prefix == nil || prefix.Severity != SeverityWarning || len(prefix.Keybindings) != 3 || prefix.Keybindings[0].ID != "1" {
+		t.Errorf("Expected <leader>g to wait for two longer mappings, got %+v", prefix)
+	} else if !strings.Contains(prefix.Message, "<leader>gc, <leader>gs") || prefix.Suggestion == "" {
+		t.Errorf("Expected the longer mappings in the message, got %q", prefix.Message)
+	}
+
+	duplicate := findConflict(report, ConflictDuplicate, "<leader>ff")
+	if duplicate == nil || duplicate.Severity != SeverityError || !strings.Contains(duplicate.Message, "telescope and by fzf-lua") {
+		t.Errorf("Expected a duplicate across plugins, got %+v", duplicate)
+	}
+
+	overlap := findConflict(report, ConflictModeOverlap, "gx")
+	if overlap == nil || strings.Join(overlap.Modes, "") != "n" {
+		t.Fatalf("Expected a mode overlap of gx in normal mode, got %+v", overlap)
+	}
+	if !strings.Contains(overlap.Suggestion, `"x", "s", "o"`) {
+		t.Errorf("Expected the remaining modes to be suggested, got %q", overlap.Suggestion)
+	}
+
+	bang := findConflict(report, ConflictModeOverlap, "<C-s>")
+	if bang == nil || strings.Join(bang.Modes, "") != "i" || !strings.Contains(bang.Suggestion, `"c"`) {
+		t.Errorf("Expected ! and i to clash in insert mode, got %+v", bang)
+	}
+
+	if report.Conflicts[0].Severity != SeverityError {
+		t.Errorf("Expected the most severe conflicts first, got %+v", report.Conflicts[0])
+	}
+	if report.Counts[SeverityError] != 1 || report.Counts[SeverityWarning] != 3 {
+		t.Errorf("Unexpected counts %v", report.Counts)
+	}
+}
+
+func TestAnalyzeConflictsIgnoresScanRepeats(t *testing.T) {
+	parser := NewKeybindingParser()
+
+	// A visual mapping is reported by the scans of "v", "x" and "s"
+	report := parser.AnalyzeConflicts([]interfaces.Keybinding{
+		{ID: "v:J", Keys: "J", Command: ":m '>+1<CR>gv", Mode: "v"},
+		{ID: "x:J", Keys: "J", Command: ":m '>+1<CR>gv", Mode: "x"},
+		{ID: "s:J", Keys: "J", Command: ":m '>+1<CR>gv", Mode: "s"},
+		{ID: "n:<Plug>(a)", Keys: "<Plug>(a)", Command: "a", Mode: "n"},
+		{ID: "n:<Plug>(a)b", Keys: "<Plug>(a)b", Command: "b", Mode: "n"},
+	})
+	if len(report.Conflicts) != 0 {
+		t.Errorf("Expected no conflicts, got %+v", report.Conflicts)
+	}
+}
+
+func TestAnalyzeConflictsKeepsMappingsSharingAnID(t *testing.T) {
+	parser := NewKeybindingParser()
+
+	// The scanner gives a buffer-local override the id of the global mapping, and payload
+	// keybindings may have no id at all
+	report := parser.AnalyzeConflicts([]interfaces.Keybinding{
+		{ID: "n_q_", Keys: "q", Command: "q", Mode: "n"},
+		{ID: "n_q_", Keys: "q", Command: ":close<CR>", Mode: "n", Metadata: map[string]string{"buffer_local": "true"}},
+		{Keys: "x", Command: "d", Mode: "n"},
+		{Keys: "x", Command: "\"_x", Mode: "n"},
+	})
+
+	for _, keys := range []string{"q", "x"} {
+		duplicate := findConflict(report, ConflictDuplicate, keys)
+		if duplicate == nil || len(duplicate.Keybindings) != 2 || duplicate.Keybindings[0].Command == duplicate.Keybindings[1].Command {
+			t.Errorf("Expected both mappings of %s in the conflict, got %+v", keys, duplicate)
+		}
+	}
+}
+
+func TestAnalyzeConflictsSeverities(t *testing.T) {
+	parser := NewKeybindingParser()
+	report := parser.AnalyzeConflicts([]interfaces.Keybinding{
+		{ID: "1", Keys: "q", Command: ":close<CR>", Mode: "n", Metadata: map[string]string{"nowait": "true", "buffer_local": "true"}},
+		{ID: "2", Keys: "q", Command: "q", Mode: "n"},
+		{ID: "3", Keys: "qq", Command: "qq", Mode: "n"},
+	})
+
+	if c := findConflict(report, ConflictPrefix, "q"); c == nil || c.Severity != SeverityError {
+		t.Errorf("Expected a <nowait> prefix to make longer mappings unreachable, got %+v", report.Conflicts)
+	}
+	if c := findConflict(report, ConflictDuplicate, "q"); c == nil || c.Severity != SeverityInfo {
+		t.Errorf("Expected a buffer-local override to be informational, got %+v", report.Conflicts)
+	}
+}
@@ -0,0 +1,379 @@
+package keybindings
+
+import (
+	"fmt"
+	"strings"
+
+	"nvim-smart-keybind-search/internal/interfaces"
+)
+
+// Kinds of the parts of a key sequence
+const (
+	PartRegister   = "register"
+	PartCount      = "count"
+	PartOperator   = "operator"
+	PartMotion     = "motion"
+	PartTextObject = "text_object"
+	PartCommand    = "command"
+	PartArgument   = "argument"
+)
+
+// KeyPart is one part of a key sequence, such as the operator of d2w
+type KeyPart struct {
+	Keys        string `json:"keys"`
+	Kind        string `json:"kind"`
+	Explanation string `json:"explanation"`
+}
+
+// KeySequence is a key sequence broken into the parts of a Vim command
+type KeySequence struct {
+	Keys  string    `json:"keys"`
+	Parts []KeyPart `json:"parts"`
+
+	// Complete is unset when the sequence stops before the end of a command, such as an
+	// operator still waiting for its motion
+	Complete bool `json:"complete"`
+
+	// Remainder are the keys after the first complete command, or the keys that could
+	// not be parsed
+	Remainder string `json:"remainder,omitempty"`
+}
+
+// operators are the operators of normal mode, which take a motion or text object
+var operators = map[string]string{
+	"c":  "change",
+	"d":  "delete",
+	"y":  "yank",
+	"<":  "shift left",
+	">":  "shift right",
+	"=":  "indent",
+	"!":  "filter through an external program",
+	"g~": "swap case",
+	"gu": "make lowercase",
+	"gU": "make uppercase",
+	"g?": "ROT13 encode",
+	"gq": "format",
+	"gw": "format keeping the cursor",
+	"zf": "create a fold",
+	"g@": "call 'operatorfunc'",
+}
+
+// motions are the motions of normal mode that take no argument
+var motions = map[string]string{
+	"h":       "one character left",
+	"j":       "one line down",
+	"k":       "one line up",
+	"l":       "one character right",
+	"<Left>":  "one character left",
+	"<Down>":  "one line down",
+	"<Up>":    "one line up",
+	"<Right>": "one character right",
+	"w":       "to the start of the next word",
+	"W":       "to the start of the next WORD",
+	"b":       "to the start of the previous word",
+	"B":       "to the start of the previous WORD",
+	"e":       "to the end of the word",
+	"E":       "to the end of the WORD",
+	"ge":      "to the end of the previous word",
+	"gE":      "to the end of the previous WORD",
+	"0":       "to the first character of the line",
+	"^":       "to the first non-blank character of the line",
+	"$":       "to the end of the line",
+	"g_":      "to the last non-blank character of the line",
+	"|":       "to a column of the line",
+	"gg":      "to the first line, or to line [count]",
+	"G":       "to the last line, or to line [count]",
+	"H":       "to the top of the window",
+	"M":       "to the middle of the window",
+	"L":       "to the bottom of the window",
+	"{":       "to the previous blank line",
+	"}":       "to the next blank line",
+	"(":       "to the previous sentence",
+	")":       "to the next sentence",
+	"%":       "to the matching bracket, or to [count] percent of the file",
+	"n":       "to the next match of the last search",
+	"N":       "to the previous match of the last search",
+	"*":       "to the next match of the word under the cursor",
+	"#":       "to the previous match of the word under the cursor",
+	";":       "repeat the last f, t, F or T",
+	",":       "repeat the last f, t, F or T backwards",
+	"-":       "to the first non-blank character of the previous line",
+	"+":       "to the first non-blank character of the next line",
+	"_":       "to the first non-blank character of the line, [count] - 1 lines down",
+}
+
+// argumentMotions are the motions followed by a character, such as fx
+var argumentMotions = map[string]string{
+	"f": "to the next occurrence of the character",
+	"F": "to the previous occurrence of the character",
+	"t": "till before the next occurrence of the character",
+	"T": "till after the previous occurrence of the character",
+	"'": "to the first non-blank character of the line of the mark",
+	"`": "to the position of the mark",
+}
+
+// textObjects are the objects selected after "a" (with white space or delimiters) or "i"
+// (inner) in operator-pending and visual mode
+var textObjects = map[string]string{
+	"w":  "word",
+	"W":  "WORD",
+	"s":  "sentence",
+	"p":  "paragraph",
+	"(":  "parenthesis block",
+	")":  "parenthesis block",
+	"b":  "parenthesis block",
+	"{":  "brace block",
+	"}":  "brace block",
+	"B":  "brace block",
+	"[":  "bracket block",
+	"]":  "bracket block",
+	"<":  "angle bracket block",
+	">":  "angle bracket block",
+	"t":  "tag block",
+	"\"": "double quoted string",
+	"'":  "single quoted string",
+	"`":  "backtick string",
+}
+
+// windowCommands are the commands following <C-w>
+var windowCommands = map[string]string{
+	"o": "close every other window",
+	"s": "split the window horizontally",
+	"v": "split the window vertically",
+	"w": "go to the next window",
+	"p": "go to the previous window",
+	"q": "quit the window",
+	"c": "close the window",
+	"n": "open a new window",
+	"h": "go to the window on the left",
+	"j": "go to the window below",
+	"k": "go to the window above",
+	"l": "go to the window on the right",
+	"H": "move the window to the far left",
+	"J": "move the window to the bottom",
+	"K": "move the window to the top",
+	"L": "move the window to the far right",
+	"T": "move the window to a new tab page",
+	"x": "exchange the window with the next one",
+	"r": "rotate the windows down",
+	"=": "make the windows the same size",
+	"+": "increase the height of the window",
+	"-": "decrease the height of the window",
+	">": "increase the width of the window",
+	"<": "decrease the width of the window",
+	"_": "maximize the height of the window",
+	"|": "maximize the width of the window",
+}
+
+// registerNames are the registers that can follow "
+const registerNames = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789\"-*+_/:.%#="
+
+// ParseKeySequence breaks a normal mode key sequence into a register, count, operator,
+// motion or text object and command, explaining each part. Sequences only mappings define,
+// such as <leader> ones, have no parts.
+func (p *KeybindingParser) ParseKeySequence(keys string) *KeySequence {
+	sequence := &KeySequence{Keys: keys, Parts: []KeyPart{}}
+	split := splitKeys(keys)
+	if len(split) == 0 {
+		return sequence
+	}
+	switch strings.ToLower(split[0]) {
+	case "<leader>", "<localleader>", "<plug>":
+		sequence.Remainder = keys
+		return sequence
+	}
+
+	s := &sequenceScanner{keys: split, sequence: sequence}
+	s.register()
+	s.count()
+	if len(sequence.Parts) > 0 && sequence.Parts[len(sequence.Parts)-1].Kind == PartCount {
+		s.register() // "a3dd and 3"add are the same
+	}
+
+	switch {
+	case s.operator():
+		s.count()
+		sequence.Complete = s.linewise() || s.textObject() || s.motion()
+		if last := &sequence.Parts[len(sequence.Parts)-1]; len(s.keys) == 0 && (last.Kind == PartOperator || last.Kind == PartCount) {
+			for i := range sequence.Parts {
+				if sequence.Parts[i].Kind == PartOperator {
+					sequence.Parts[i].Explanation += ", waiting for a motion or text object"
+				}
+			}
+		}
+	case s.motion():
+		sequence.Complete = true
+	default:
+		sequence.Complete = s.command()
+	}
+
+	sequence.Remainder = strings.Join(s.keys, "")
+	return sequence
+}
+
+// ExpandLeader replaces <leader> and <localleader> in keys with the keys they stand for,
+// as Neovim does when a mapping is defined; an empty leader is the default backslash
+func ExpandLeader(keys, leader, localLeader string) string {
+	if leader == "" {
+		leader = "\\"
+	}
+	if localLeader == "" {
+		localLeader = "\\"
+	}
+
+	var b strings.Builder
+	for _, key := range splitKeys(keys) {
+		switch strings.ToLower(key) {
+		case "<leader>":
+			key = leader
+		case "<localleader>":
+			key = localLeader
+		}
+		b.WriteString(key)
+	}
+	return b.String()
+}
+
+// FindKeys returns the keybindings mapped to keys in mode, comparing keys whatever their
+// case or alias and counting a mapping for several modes, such as "", in each of them
+func (p *KeybindingParser) FindKeys(keybindings []interfaces.Keybinding, keys, mode string) []interfaces.Keybinding {
+	normalized := normalizeKeys(keys)
+	modes := p.MappingModes(mode)
+
+	found := []interfaces.Keybinding{}
+	for _, kb := range keybindings {
+		if normalizeKeys(kb.Keys) != normalized {
+			continue
+		}
+		for _, kbMode := range p.MappingModes(kb.Mode) {
+			if containsString(modes, kbMode) {
+				found = append(found, kb)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// sequenceScanner consumes the keys of a sequence part by part
+type sequenceScanner struct {
+	keys           []string
+	sequence       *KeySequence
+	parsedOperator string // repeated to act on lines, as in dd
+}
+
+// add records a part made of the next n keys
+func (s *sequenceScanner) add(n int, kind, explanation string) {
+	s.sequence.Parts = append(s.sequence.Parts, KeyPart{Keys: strings.Join(s.keys[:n], ""), Kind: kind, Explanation: explanation})
+	s.keys = s.keys[n:]
+}
+
+// peek returns the next n keys joined, or "" when there are fewer
+func (s *sequenceScanner) peek(n int) string {
+	if len(s.keys) < n {
+		return ""
+	}
+	return strings.Join(s.keys[:n], "")
+}
+
+// register parses a register, such as "a
+func (s *sequenceScanner) register() {
+	if s.peek(1) == "\"" && len(s.keys) > 1 && len(s.keys[1]) == 1 && strings.Contains(registerNames, s.keys[1]) {
+		s.add(2, PartRegister, fmt.Sprintf("use register %s", s.keys[1]))
+	}
+}
+
+// count parses a count
+func (s *sequenceScanner) count() {
+	n := 0
+	for n < len(s.keys) && len(s.keys[n]) == 1 && s.keys[n][0] >= '0' && s.keys[n][0] <= '9' {
+		if n == 0 && s.keys[0] == "0" {
+			return // 0 is a motion, not a count
+		}
+		n++
+	}
+	if n > 0 {
+		s.add(n, PartCount, fmt.Sprintf("repeat %s times", s.peek(n)))
+	}
+}
+
+// operator parses an operator
+func (s *sequenceScanner) operator() bool {
+	for _, n := range []int{2, 1} {
+		if name, ok := operators[s.peek(n)]; ok {
+			s.parsedOperator = s.peek(n)
+			s.add(n, PartOperator, name)
+			return true
+		}
+	}
+	return false
+}
+
+// linewise parses an operator repeated to act on whole lines, such as dd or gUU
+func (s *sequenceScanner) linewise() bool {
+	repeat := s.parsedOperator
+	if len(repeat) == 2 && s.peek(1) == repeat[1:] {
+		repeat = repeat[1:] // gUU as well as gUgU
+	}
+	if s.peek(len(splitKeys(repeat))) != repeat {
+		return false
+	}
+	s.add(len(splitKeys(repeat)), PartMotion, "the current line, or [count] lines")
+	return true
+}
+
+// textObject parses a text object, such as ip
+func (s *sequenceScanner) textObject() bool {
+	if len(s.keys) < 2 || (s.keys[0] != "a" && s.keys[0] != "i") {
+		return false
+	}
+	object, ok := textObjects[s.keys[1]]
+	if !ok {
+		return false
+	}
+	scope := "a " + object + " with the white space around it"
+	if s.keys[0] == "i" {
+		scope = "inner " + object
+	}
+	s.add(2, PartTextObject, scope)
+	return true
+}
+
+// motion parses a motion and the character or mark it takes
+func (s *sequenceScanner) motion() bool {
+	for _, n := range []int{2, 1} {
+		if explanation, ok := motions[s.peek(n)]; ok {
+			s.add(n, PartMotion, explanation)
+			return true
+		}
+	}
+	if explanation, ok := argumentMotions[s.peek(1)]; ok {
+		if len(s.keys) < 2 {
+			s.add(1, PartMotion, explanation+", waiting for it")
+			return false
+		}
+		noun := "the character"
+		if s.keys[0] == "'" || s.keys[0] == "`" {
+			noun = "the mark"
+		}
+		s.add(1, PartMotion, explanation)
+		s.add(1, PartArgument, fmt.Sprintf("%s %s", noun, s.keys[0]))
+		return true
+	}
+	return false
+}
+
+// command parses the commands that are neither operators nor motions
+func (s *sequenceScanner) command() bool {
+	if strings.EqualFold(s.peek(1), "<C-w>") {
+		if len(s.keys) < 2 {
+			s.add(1, PartCommand, "window command, waiting for its key")
+			return false
+		}
+		if explanation, ok := windowCommands[s.keys[1]]; ok {
+			s.add(2, PartCommand, explanation)
+			return true
+		}
+	}
+	return false
+}
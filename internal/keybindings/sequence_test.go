@@ -0,0 +1,100 @@
+package keybindings
+
+import (
+	"strings"
+	"testing"
+
+	"nvim-smart-keybind-search/internal/interfaces"
+)
+
+// describeParts lists the kinds and keys of the parts of a sequence, e.g. "operator:d motion:w"
+func describeParts(sequence *KeySequence) string {
+	parts := make([]string, len(sequence.Parts))
+	for i, part := range sequence.Parts {
+		parts[i] = part.Kind + ":" + part.Keys
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestParseKeySequence(t *testing.T) {
+	parser := NewKeybindingParser()
+
+	tests := []struct {
+		keys      string
+		parts     string
+		complete  bool
+		remainder string
+	}{
+		{"d2w", "operator:d count:2 motion:w", true, ""},
+		{"\"a3yy", "register:\"a count:3 operator:y motion:y", true, ""},
+		{"3\"add", "count:3 register:\"a operator:d motion:d", true, ""},
+		{"gUU", "operator:gU motion:U", true, ""},
+		{"gqip", "operator:gq text_object:ip", true, ""},
+		{"ci\"", "operator:c text_object:i\"", true, ""},
+		{"dtx", "operator:d motion:t argument:x", true, ""},
+		{"d0", "operator:d motion:0", true, ""},
+		{"5G", "count:5 motion:G", true, ""},
+		{"<C-w>o", "command:<C-w>o", true, ""},
+		{"gq", "operator:gq", false, ""},
+		{"ddp", "operator:d motion:d", true, "p"},
+		{"<leader>ff", "", false, "<leader>ff"},
+		{"Q", "", false, "Q"},
+	}
+	for _, tt := range tests {
+		sequence := parser.ParseKeySequence(tt.keys)
+		if got := describeParts(sequence); got != tt.parts {
+			t.Errorf("ParseKeySequence(%q) parts = %q, want %q", tt.keys, got, tt.parts)
+		}
+		if sequence.Complete != tt.complete || sequence.Remainder != tt.remainder {
+			t.Errorf("ParseKeySequence(%q) complete = %v, remainder = %q", tt.keys, sequence.Complete, sequence.Remainder)
+		}
+	}
+}
+
+func TestParseKeySequenceExplanations(t *testing.T) {
+	parser := NewKeybindingParser()
+
+	sequence := parser.ParseKeySequence("gq")
+	if !strings.Contains(sequence.Parts[0].Explanation, "waiting for a motion") {
+		t.Errorf("Expected gq to wait for a motion, got %q", sequence.Parts[0].Explanation)
+	}
+
+	sequence = parser.ParseKeySequence("daw")
+	if sequence.Parts[0].Explanation != "delete" || !strings.Contains(sequence.Parts[1].Explanation, "word with the white space") {
+		t.Errorf("Unexpected explanations %+v", sequence.Parts)
+	}
+
+	sequence = parser.ParseKeySequence("y'a")
+	if sequence.Parts[2].Explanation != "the mark a" {
+		t.Errorf("Expected the mark to be explained, got %+v", sequence.Parts)
+	}
+}
+
+func TestExpandLeader(t *testing.T) {
+	if got := ExpandLeader("<Leader>ff", " ", ""); got != " ff" {
+		t.Errorf("Expected the leader to be expanded, got %q", got)
+	}
+	if got := ExpandLeader("<localleader>r<leader>", "", ","); got != ",r\\" {
+		t.Errorf("Expected the default leader to be a backslash, got %q", got)
+	}
+}
+
+func TestFindKeys(t *testing.T) {
+	parser := NewKeybindingParser()
+	keybindings := []interfaces.Keybinding{
+		{ID: "1", Keys: "<Space>ff", Mode: "n"},
+		{ID: "2", Keys: "<C-W>o", Mode: ""},
+		{ID: "3", Keys: "<C-w>o", Mode: "i"},
+		{ID: "4", Keys: "<leader>ff", Mode: "n"},
+	}
+
+	if found := parser.FindKeys(keybindings, ExpandLeader("<leader>ff", " ", ""), "n"); len(found) != 1 || found[0].ID != "1" {
+		t.Errorf("Expected the expanded leader to match <Space>, got %+v", found)
+	}
+	if found := parser.FindKeys(keybindings, "<c-w>o", "n"); len(found) != 1 || found[0].ID != "2" {
+		t.Errorf("Expected a :map mapping to be found in normal mode only, got %+v", found)
+	}
+	if found := parser.FindKeys(keybindings, "<C-w>o", "v"); len(found) != 1 || found[0].ID != "2" {
+		t.Errorf("Expected a :map mapping to be found in visual mode, got %+v", found)
+	}
+}
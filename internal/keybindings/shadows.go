@@ -0,0 +1,156 @@
+package keybindings
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"nvim-smart-keybind-search/internal/interfaces"
+)
+
+// ShadowedBuiltin is a built-in keybinding a user mapping takes the keys of
+type ShadowedBuiltin struct {
+	Builtin interfaces.Keybinding `json:"builtin"`
+	Mapping interfaces.Keybinding `json:"mapping"`
+
+	// Modes are the modes the built-in keybinding is lost in, out of "n", "x", "s", "o",
+	// "i", "c" and "t"
+	Modes []string `json:"modes"`
+
+	// Kept is set when the mapping runs the built-in keybinding itself, such as n mapped
+	// to nzz
+	Kept bool `json:"kept"`
+
+	// RestoredBy are the user mappings running the built-in keybinding from other keys
+	RestoredBy []interfaces.Keybinding `json:"restored_by"`
+
+	Message string `json:"message"`
+}
+
+// ShadowReport is the outcome of comparing user mappings with the built-in keybindings
+type ShadowReport struct {
+	// Analyzed is the number of user mappings looked at
+	Analyzed int               `json:"analyzed"`
+	Shadowed []ShadowedBuiltin `json:"shadowed"`
+
+	// Lost is the number of shadowed built-in keybindings neither kept nor restored
+	Lost int `json:"lost"`
+}
+
+// FindShadowedBuiltins reports the built-in keybindings that user mappings take the keys
+// of, comparing normalized keys in each mode a mapping applies to. A built-in keybinding
+// is restored when another user mapping of the same mode has its keys as right-hand side,
+// such as ; mapped to : after : is mapped to ;. Recursive mappings do not restore it, as
+// their right-hand side runs the user mapping again.
+func (p *KeybindingParser) FindShadowedBuiltins(user, builtins []interfaces.Keybinding) *ShadowReport {
+	// Built-in keybindings by mode and normalized keys
+	builtinByKeys := make(map[string][]*interfaces.Keybinding)
+	for i := range builtins {
+		kb := &builtins[i]
+		keys := normalizeKeys(kb.Keys)
+		if keys == "" {
+			continue
+		}
+		for _, mode := range p.MappingModes(kb.Mode) {
+			builtinByKeys[mode+"\x01"+keys] = append(builtinByKeys[mode+"\x01"+keys], kb)
+		}
+	}
+
+	// User mappings by mode and normalized right-hand side, for finding restores
+	userByCommand := make(map[string][]*interfaces.Keybinding)
+	for i := range user {
+		kb := &user[i]
+		if kb.Metadata["noremap"] == "false" || isPlugMapping(kb.Keys) {
+			continue
+		}
+		for _, mode := range p.MappingModes(kb.Mode) {
+			key := mode + "\x01" + normalizeKeys(kb.Command)
+			userByCommand[key] = append(userByCommand[key], kb)
+		}
+	}
+
+	shadows := make(map[string]*ShadowedBuiltin)
+	var order []string
+	for i := range user {
+		kb := &user[i]
+		keys := normalizeKeys(kb.Keys)
+		if keys == "" || isPlugMapping(kb.Keys) {
+			continue
+		}
+
+		for _, mode := range p.MappingModes(kb.Mode) {
+			for _, builtin := range builtinByKeys[mode+"\x01"+keys] {
+				// The same mapping reported once per scanned mode is one shadow
+				id := strings.Join([]string{builtin.ID, builtin.Keys, kb.Keys, kb.Command, kb.Plugin, kb.Metadata["buffer_local"]}, "\x01")
+				shadow, ok := shadows[id]
+				if !ok {
+					shadow = &ShadowedBuiltin{
+						Builtin:    *builtin,
+						Mapping:    *kb,
+						Kept:       kb.Metadata["noremap"] != "false" && strings.HasPrefix(normalizeKeys(kb.Command), keys),
+						RestoredBy: []interfaces.Keybinding{},
+					}
+					shadows[id] = shadow
+					order = append(order, id)
+				}
+				if containsString(shadow.Modes, mode) {
+					continue
+				}
+				shadow.Modes = append(shadow.Modes, mode)
+
+				for _, restore := range userByCommand[mode+"\x01"+keys] {
+					if normalizeKeys(restore.Keys) != keys && !containsKeybinding(shadow.RestoredBy, restore) {
+						shadow.RestoredBy = append(shadow.RestoredBy, *restore)
+					}
+				}
+			}
+		}
+	}
+
+	report := &ShadowReport{Analyzed: len(user), Shadowed: []ShadowedBuiltin{}}
+	for _, id := range order {
+		shadow := shadows[id]
+		finishShadow(shadow)
+		if !shadow.Kept && len(shadow.RestoredBy) == 0 {
+			report.Lost++
+		}
+		report.Shadowed = append(report.Shadowed, *shadow)
+	}
+
+	// Lost built-in keybindings first, keeping the order of the user mappings otherwise
+	sort.SliceStable(report.Shadowed, func(i, j int) bool {
+		iLost := !report.Shadowed[i].Kept && len(report.Shadowed[i].RestoredBy) == 0
+		jLost := !report.Shadowed[j].Kept && len(report.Shadowed[j].RestoredBy) == 0
+		return iLost && !jLost
+	})
+	return report
+}
+
+// finishShadow writes the message of a shadowed built-in keybinding
+func finishShadow(shadow *ShadowedBuiltin) {
+	builtin, mapping := &shadow.Builtin, &shadow.Mapping
+	lost := builtin.Description
+	if lost == "" {
+		lost = builtin.Command
+	}
+
+	scope := ""
+	if mapping.Metadata["buffer_local"] == "true" {
+		scope = " in its buffer"
+	}
+	shadow.Message = fmt.Sprintf("%s mapped %s replaces the built-in %s in %s mode%s (%s)",
+		mapping.Keys, describeOwners(mapping), builtin.Keys, describeModes(shadow.Modes), scope, lost)
+
+	switch {
+	case shadow.Kept:
+		shadow.Message += "; the mapping still runs it first"
+	case len(shadow.RestoredBy) > 0:
+		keys := make([]string, len(shadow.RestoredBy))
+		for i, restore := range shadow.RestoredBy {
+			keys[i] = restore.Keys
+		}
+		shadow.Message += "; it is restored on " + strings.Join(keys, ", ")
+	default:
+		shadow.Message += "; it is no longer available"
+	}
+}
@@ -0,0 +1,100 @@
+package keybindings
+
+import (
+	"strings"
+	"testing"
+
+	"nvim-smart-keybind-search/internal/interfaces"
+)
+
+// findShadow returns the shadow of the built-in keybinding on keys
+func findShadow(report *ShadowReport, keys string) *ShadowedBuiltin {
+	for i := range report.Shadowed {
+		if report.Shadowed[i].Builtin.Keys == keys {
+			return &report.Shadowed[i]
+		}
+	}
+	return nil
+}
+
+func TestFindShadowedBuiltins(t *testing.T) {
+	parser := NewKeybindingParser()
+	builtins := []interfaces.Keybinding{
+		{ID: "builtin_1", Keys: "s", Command: "substitute", Description: "Delete character and start insert", Mode: "n"},
+		{ID: "builtin_2", Keys: "<C-a>", Command: "increment", Description: "Add to the number under the cursor", Mode: "n"},
+		{ID: "builtin_3", Keys: "n", Command: "next match", Description: "Repeat the last search", Mode: "n"},
+		{ID: "builtin_4", Keys: "J", Command: "join", Description: "Join lines", Mode: "v"},
+		{ID: "builtin_5", Keys: "w", Command: "word forward", Mode: "n"},
+	}
+	report := parser.FindShadowedBuiltins([]interfaces.Keybinding{
+		{ID: "1", Keys: "s", Command: "<Plug>(leap)", Mode: "n", Plugin: "leap"},
+		{ID: "2", Keys: "<c-a>", Command: "ggVG", Mode: "n", Metadata: map[string]string{"noremap": "true"}},
+		{ID: "3", Keys: "<leader>i", Command: "<C-a>", Mode: "n", Metadata: map[string]string{"noremap": "true"}},
+		{ID: "4", Keys: "n", Command: "nzzzv", Mode: "n", Metadata: map[string]string{"noremap": "true"}},
+		{ID: "5", Keys: "J", Command: ":m '>+1<CR>gv", Mode: "x"},
+		{ID: "6", Keys: "J", Command: ":m '>+1<CR>gv", Mode: "v"},
+		{ID: "7", Keys: "<leader>w", Command: ":w<CR>", Mode: "n"},
+	}, builtins)
+
+	if report.Analyzed != 7 || len(report.Shadowed) != 4 {
+		t.Fatalf("Expected 4 shadowed built-in keybindings, got %+v", report.Shadowed)
+	}
+	if report.Lost != 2 {
+		t.Errorf("Expected s and J to be lost, got %d", report.Lost)
+	}
+
+	s := findShadow(report, "s")
+	if s == nil || s.Kept || len(s.RestoredBy) != 0 || !strings.Contains(s.Message, "no longer available") || !strings.Contains(s.Message, "by leap") {
+		t.Errorf("Expected s to be lost to leap, got %+v", s)
+	}
+
+	increment := findShadow(report, "<C-a>")
+	if increment == nil || len(increment.RestoredBy) != 1 || increment.RestoredBy[0].ID != "3" || !strings.Contains(increment.Message, "restored on <leader>i") {
+		t.Errorf("Expected <C-a> to be restored on <leader>i, got %+v", increment)
+	}
+
+	next := findShadow(report, "n")
+	if next == nil || !next.Kept {
+		t.Errorf("Expected n to be kept by nzzzv, got %+v", next)
+	}
+
+	join := findShadow(report, "J")
+	if join == nil || strings.Join(join.Modes, "") != "xs" || join.Mapping.ID != "5" && join.Mapping.ID != "6" {
+		t.Errorf("Expected J to be shadowed in visual and select mode, got %+v", join)
+	}
+
+	if report.Shadowed[0].Kept || len(report.Shadowed[0].RestoredBy) != 0 {
+		t.Errorf("Expected lost built-in keybindings first, got %+v", report.Shadowed[0])
+	}
+}
+
+func TestFindShadowedBuiltinsKeepsRestoresSharingAnID(t *testing.T) {
+	parser := NewKeybindingParser()
+	report := parser.FindShadowedBuiltins([]interfaces.Keybinding{
+		{Keys: "<C-a>", Command: "ggVG", Mode: "n"},
+		{Keys: "<leader>i", Command: "<C-a>", Mode: "n"},
+		{Keys: "<leader>i", Command: "<C-a>", Mode: "n", Metadata: map[string]string{"buffer_local": "true"}},
+	}, []interfaces.Keybinding{
+		{ID: "builtin_1", Keys: "<C-a>", Command: "increment", Mode: "n"},
+	})
+
+	increment := findShadow(report, "<C-a>")
+	if increment == nil || len(increment.RestoredBy) != 2 {
+		t.Errorf("Expected the global and buffer-local restores to be listed, got %+v", increment)
+	}
+}
+
+func TestFindShadowedBuiltinsIgnoresRecursiveRestores(t *testing.T) {
+	parser := NewKeybindingParser()
+	report := parser.FindShadowedBuiltins([]interfaces.Keybinding{
+		{ID: "1", Keys: ":", Command: ";", Mode: "n", Metadata: map[string]string{"noremap": "false"}},
+		{ID: "2", Keys: ";", Command: ":", Mode: "n", Metadata: map[string]string{"noremap": "false"}},
+	}, []interfaces.Keybinding{
+		{ID: "builtin_1", Keys: ":", Command: "command-line", Mode: "n"},
+		{ID: "builtin_2", Keys: ";", Command: "repeat f/t", Mode: "n"},
+	})
+
+	if len(report.Shadowed) != 2 || report.Lost != 2 {
+		t.Errorf("Expected recursive mappings to restore nothing, got %+v", report)
+	}
+}
@@ -1,6 +1,7 @@
 package keybindings
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -109,36 +110,78 @@ func (v *KeybindingVectorizer) VectorizeKeybindings(keybindings []interfaces.Key
 
 // BatchVectorizeAndStore vectorizes and stores keybindings in batches for efficiency
 func (v *KeybindingVectorizer) BatchVectorizeAndStore(keybindings []interfaces.Keybinding) error {
+	return v.BatchVectorizeAndStoreProgress(keybindings, nil)
+}
+
+// BatchVectorizeAndStoreProgress vectorizes and stores keybindings like
+// BatchVectorizeAndStore, passing the progress of each phase to report. Invalid
+// keybindings and failed batches are reported and skipped, and the returned error lists
+// them once the remaining batches are stored. report may be nil.
+func (v *KeybindingVectorizer) BatchVectorizeAndStoreProgress(keybindings []interfaces.Keybinding, report func(interfaces.SyncProgress)) error {
 	if len(keybindings) == 0 {
 		return nil
 	}
+	if report == nil {
+		report = func(interfaces.SyncProgress) {}
+	}
 
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
 	log.Printf("Starting batch vectorization of %d keybindings", len(keybindings))
 	start := time.Now()
+	var errs []error
+
+	// Validate everything up front so one bad keybinding does not sink its whole batch
+	valid := make([]interfaces.Keybinding, 0, len(keybindings))
+	var invalid []string
+	for i, kb := range keybindings {
+		if err := v.parser.ValidateKeybinding(&kb); err != nil {
+			invalid = append(invalid, fmt.Sprintf("keybinding at index %d: %v", i, err))
+			continue
+		}
+		valid = append(valid, kb)
+	}
+	progress := interfaces.SyncProgress{Phase: interfaces.SyncPhaseValidate, Processed: len(keybindings), Total: len(keybindings)}
+	if len(invalid) > 0 {
+		err := fmt.Errorf("invalid keybindings: %s", strings.Join(invalid, "; "))
+		errs = append(errs, err)
+		progress.Error = err.Error()
+	}
+	report(progress)
 
 	// Process in batches
 	batchSize := v.config.BatchSize
-	for i := 0; i < len(keybindings); i += batchSize {
+	stored := 0
+	for i := 0; i < len(valid); i += batchSize {
 		end := i + batchSize
-		if end > len(keybindings) {
-			end = len(keybindings)
+		if end > len(valid) {
+			end = len(valid)
 		}
 
-		batch := keybindings[i:end]
+		batch := valid[i:end]
 
 		// Vectorize batch
 		documents, err := v.VectorizeKeybindings(batch)
+		progress := interfaces.SyncProgress{Phase: interfaces.SyncPhaseVectorize, Processed: end, Total: len(valid)}
 		if err != nil {
-			return fmt.Errorf("failed to vectorize batch %d-%d: %w", i, end, err)
+			err = fmt.Errorf("failed to vectorize batch %d-%d: %w", i, end, err)
+			errs = append(errs, err)
+			progress.Error = err.Error()
+			report(progress)
+			continue
 		}
+		report(progress)
 
-		// Store batch
+		// Store batch; batches are stored as they are vectorized, so both phases advance together
 		if err := v.vectorDB.Store(documents); err != nil {
-			return fmt.Errorf("failed to store batch %d-%d: %w", i, end, err)
+			err = fmt.Errorf("failed to store batch %d-%d: %w", i, end, err)
+			errs = append(errs, err)
+			report(interfaces.SyncProgress{Phase: interfaces.SyncPhaseStore, Processed: stored, Total: len(valid), Error: err.Error()})
+			continue
 		}
+		stored += len(batch)
+		report(interfaces.SyncProgress{Phase: interfaces.SyncPhaseStore, Processed: stored, Total: len(valid)})
 
 		// Update hash store for change detection
 		if v.config.EnableChangeDetection {
@@ -153,6 +196,9 @@ func (v *KeybindingVectorizer) BatchVectorizeAndStore(keybindings []interfaces.K
 	duration := time.Since(start)
 	log.Printf("Batch vectorization completed in %v", duration)
 
+	if len(errs) > 0 {
+		return fmt.Errorf("stored %d of %d keybindings: %w", stored, len(keybindings), errors.Join(errs...))
+	}
 	return nil
 }
 
@@ -1,6 +1,7 @@
 package keybindings
 
 import (
+	"context"
 	"strings"
 	"testing"
 
@@ -100,6 +101,13 @@ func (m *MockLLMClient) Generate(request interfaces.LLMRequest) (*interfaces.LLM
 	}, nil
 }
 
+func (m *MockLLMClient) GenerateContext(ctx context.Context, request interfaces.LLMRequest) (*interfaces.LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.Generate(request)
+}
+
 func (m *MockLLMClient) Embed(text string) ([]float64, error) {
 	// Return a mock embedding based on text hash for consistency
 	if embedding, exists := m.embeddings[text]; exists {
@@ -315,6 +323,44 @@ func TestBatchVectorizeAndStore(t *testing.T) {
 	}
 }
 
+func TestBatchVectorizeAndStoreProgress(t *testing.T) {
+	mockDB := NewMockVectorDB()
+	config := DefaultVectorizerConfig()
+	config.BatchSize = 2
+	vectorizer := NewKeybindingVectorizer(mockDB, NewMockLLMClient(), config)
+
+	keybindings := []interfaces.Keybinding{
+		{ID: "test1", Keys: "dd", Command: "delete line", Mode: "n"},
+		{ID: "test2", Keys: "yy", Command: "yank line", Mode: "n"},
+		{ID: "broken", Keys: "", Command: "nothing", Mode: "n"},
+		{ID: "test3", Keys: "p", Command: "paste", Mode: "n"},
+	}
+
+	var updates []interfaces.SyncProgress
+	err := vectorizer.BatchVectorizeAndStoreProgress(keybindings, func(progress interfaces.SyncProgress) {
+		updates = append(updates, progress)
+	})
+	if err == nil || !strings.Contains(err.Error(), "index 2") {
+		t.Errorf("expected error naming the invalid keybinding, got %v", err)
+	}
+
+	// The invalid keybinding is skipped, the rest is still stored
+	if len(mockDB.documents) != 3 {
+		t.Errorf("expected 3 stored documents, got %d", len(mockDB.documents))
+	}
+
+	if len(updates) != 5 {
+		t.Fatalf("expected validate plus 2 vectorize and 2 store updates, got %+v", updates)
+	}
+	if updates[0].Phase != interfaces.SyncPhaseValidate || updates[0].Total != 4 || updates[0].Error == "" {
+		t.Errorf("unexpected validate update: %+v", updates[0])
+	}
+	last := updates[len(updates)-1]
+	if last.Phase != interfaces.SyncPhaseStore || last.Processed != 3 || last.Total != 3 || last.Error != "" {
+		t.Errorf("unexpected final update: %+v", last)
+	}
+}
+
 func TestDetectChanges(t *testing.T) {
 	mockDB := NewMockVectorDB()
 	mockLLM := NewMockLLMClient()
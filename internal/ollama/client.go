@@ -16,11 +16,14 @@ import (
 	"nvim-smart-keybind-search/internal/interfaces"
 )
 
+// DefaultURL is the address Ollama listens on unless configured otherwise
+const DefaultURL = "http://localhost:11434"
+
 const (
-	defaultOllamaURL = "http://localhost:11434"
-	ollamaBinary     = "ollama"
-	installTimeout   = 300 * time.Second
-	requestTimeout   = 30 * time.Second
+	ollamaBinary   = "ollama"
+	installTimeout = 300 * time.Second
+	requestTimeout = 30 * time.Second
+	stopTimeout    = 10 * time.Second
 )
 
 // Client implements the LLMClient interface for Ollama
@@ -30,12 +33,15 @@ type Client struct {
 	modelName      string
 	modelManager   *ModelManager
 	responseParser *ResponseParser
+
+	// service is the Ollama daemon started by this client, stopped again on Close
+	service *exec.Cmd
 }
 
 // NewClient creates a new Ollama client
 func NewClient(baseURL string) *Client {
 	if baseURL == "" {
-		baseURL = defaultOllamaURL
+		baseURL = DefaultURL
 	}
 
 	client := &Client{
@@ -176,11 +182,16 @@ func (c *Client) startService() error {
 
 	// Start Ollama serve in background
 	cmd := exec.Command(ollamaBinary, "serve")
+	if c.baseURL != DefaultURL {
+		// Make the daemon listen where this client expects it
+		cmd.Env = append(os.Environ(), "OLLAMA_HOST="+c.baseURL)
+	}
 
 	// Start the service in background
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start Ollama service: %w", err)
 	}
+	c.service = cmd
 
 	// Wait for service to be ready
 	maxRetries := 30
@@ -195,16 +206,34 @@ func (c *Client) startService() error {
 	return fmt.Errorf("Ollama service failed to start within timeout")
 }
 
-// stopService stops the Ollama daemon
+// stopService stops the Ollama daemon if this client started it. Daemons started by
+// the user or the system are left running.
 func (c *Client) stopService() error {
-	// Find and kill Ollama processes
-	cmd := exec.Command("pkill", "-f", "ollama serve")
-	_ = cmd.Run() // Ignore error if no process found
+	cmd := c.service
+	if cmd == nil {
+		return nil
+	}
+	c.service = nil
 
-	// Wait a moment for graceful shutdown
-	time.Sleep(2 * time.Second)
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
 
-	return nil
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		// Interrupt is not supported everywhere, so fall back to killing the process
+		if err := cmd.Process.Kill(); err != nil {
+			return err
+		}
+	}
+
+	// Wait a moment for graceful shutdown
+	select {
+	case <-exited:
+		return nil
+	case <-time.After(stopTimeout):
+		return cmd.Process.Kill()
+	}
 }
 
 // HealthCheck verifies the Ollama service is available and responsive
@@ -232,7 +261,10 @@ func (c *Client) HealthCheck() error {
 
 // Close closes the Ollama client connection
 func (c *Client) Close() error {
-	// No persistent connections to close for HTTP client
+	// No persistent connections to close for HTTP client, only the daemon we may have started
+	if err := c.stopService(); err != nil {
+		return fmt.Errorf("failed to stop Ollama service: %w", err)
+	}
 	return nil
 }
 
@@ -277,6 +309,11 @@ type OllamaListResponse struct {
 
 // Generate generates text based on the given request
 func (c *Client) Generate(request interfaces.LLMRequest) (*interfaces.LLMResponse, error) {
+	return c.GenerateContext(context.Background(), request)
+}
+
+// GenerateContext generates text based on the given request, aborting the HTTP call when ctx is done
+func (c *Client) GenerateContext(ctx context.Context, request interfaces.LLMRequest) (*interfaces.LLMResponse, error) {
 	if c.modelName == "" {
 		return nil, fmt.Errorf("no model loaded")
 	}
@@ -292,7 +329,7 @@ func (c *Client) Generate(request interfaces.LLMRequest) (*interfaces.LLMRespons
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/generate", bytes.NewBuffer(reqBody))
@@ -303,6 +340,9 @@ func (c *Client) Generate(request interfaces.LLMRequest) (*interfaces.LLMRespons
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		if ctx.Err() == context.Canceled {
+			return nil, fmt.Errorf("request cancelled: %w", ctx.Err())
+		}
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
@@ -390,6 +430,16 @@ func (c *Client) LoadModel(modelName string) error {
 	return nil
 }
 
+// PullModel downloads a model, reporting the status and the bytes completed out of the
+// total of the layer being downloaded. It stops when ctx is cancelled.
+func (c *Client) PullModel(ctx context.Context, modelName string, report func(status string, completed, total int64)) error {
+	return c.modelManager.PullModelContext(ctx, modelName, func(update OllamaPullResponse) {
+		if report != nil {
+			report(update.Status, update.Completed, update.Total)
+		}
+	})
+}
+
 // GetModelInfo returns information about the currently loaded model
 func (c *Client) GetModelInfo() (*interfaces.ModelInfo, error) {
 	if c.modelName == "" {
@@ -1,6 +1,7 @@
 package ollama
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -213,6 +214,47 @@ func TestClient_LoadModel(t *testing.T) {
 	}
 }
 
+func TestClient_PullModel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/pull" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var req OllamaPullRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		encoder := json.NewEncoder(w)
+		if req.Name == "missing-model" {
+			encoder.Encode(OllamaPullResponse{Error: "pull model manifest: file does not exist"})
+			return
+		}
+		encoder.Encode(OllamaPullResponse{Status: "pulling manifest"})
+		encoder.Encode(OllamaPullResponse{Status: "downloading", Total: 100, Completed: 40})
+		encoder.Encode(OllamaPullResponse{Status: "success"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+
+	var statuses []string
+	err := client.PullModel(context.Background(), "test-model", func(status string, completed, total int64) {
+		statuses = append(statuses, status)
+		if status == "downloading" && (completed != 40 || total != 100) {
+			t.Errorf("unexpected download progress %d/%d", completed, total)
+		}
+	})
+	if err != nil {
+		t.Fatalf("PullModel failed: %v", err)
+	}
+	if len(statuses) != 3 || statuses[2] != "success" {
+		t.Errorf("expected every status to be reported, got %v", statuses)
+	}
+
+	if err := client.PullModel(context.Background(), "missing-model", nil); err == nil {
+		t.Error("expected error for a model that cannot be pulled")
+	}
+}
+
 func TestClient_GetModelInfo(t *testing.T) {
 	// Create a mock server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -118,6 +118,55 @@ func (m *ModelManager) pullModelViaCLI(modelName string) error {
 	return nil
 }
 
+// PullModelContext pulls a model through the Ollama API, passing each status update to
+// report (which may be nil) as the download advances. It gives up when ctx is cancelled
+// or after pullTimeout.
+func (m *ModelManager) PullModelContext(ctx context.Context, modelName string, report func(OllamaPullResponse)) error {
+	ctx, cancel := context.WithTimeout(ctx, pullTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(OllamaPullRequest{Name: modelName, Stream: true})
+	if err != nil {
+		return fmt.Errorf("failed to marshal pull request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.baseURL+"/api/pull", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create pull request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// The client's request timeout is far too short for a download, ctx bounds it instead
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to pull model %s: %w", modelName, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to pull model %s: status %d: %s", modelName, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	// Ollama streams one JSON status object per line until the pull completes
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var update OllamaPullResponse
+		if err := decoder.Decode(&update); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read pull progress for %s: %w", modelName, err)
+		}
+
+		if update.Error != "" {
+			return fmt.Errorf("model pull failed: %s", update.Error)
+		}
+		if report != nil {
+			report(update)
+		}
+	}
+}
+
 // VerifyModel verifies model integrity and loading capability
 func (m *ModelManager) VerifyModel(modelName string) error {
 	fmt.Printf("Verifying model: %s\n", modelName)
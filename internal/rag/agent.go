@@ -1,6 +1,8 @@
 package rag
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sort"
@@ -36,6 +38,10 @@ type AgentConfig struct {
 	UserBoostFactor      float64
 	ResponseTimeout      time.Duration
 	SearchAllCollections bool // Whether to search all collections or just keybindings
+
+	// Settings of the query processor and response generator, defaults when nil
+	QueryProcessor    *QueryProcessorConfig
+	ResponseGenerator *ResponseGeneratorConfig
 }
 
 // DefaultAgentConfig returns default configuration for the RAG agent
@@ -67,10 +73,18 @@ func NewAgent(vectorDB interfaces.VectorDB, collectionManager *chromadb.Collecti
 	}
 
 	// Initialize query processor
-	agent.queryProcessor = NewQueryProcessor(llmClient, vectorDB, DefaultQueryProcessorConfig())
+	queryProcessorConfig := config.QueryProcessor
+	if queryProcessorConfig == nil {
+		queryProcessorConfig = DefaultQueryProcessorConfig()
+	}
+	agent.queryProcessor = NewQueryProcessor(llmClient, vectorDB, queryProcessorConfig)
 
 	// Initialize response generator
-	agent.responseGenerator = NewResponseGenerator(llmClient, DefaultResponseGeneratorConfig())
+	responseGeneratorConfig := config.ResponseGenerator
+	if responseGeneratorConfig == nil {
+		responseGeneratorConfig = DefaultResponseGeneratorConfig()
+	}
+	agent.responseGenerator = NewResponseGenerator(llmClient, responseGeneratorConfig)
 
 	return agent
 }
@@ -114,6 +128,36 @@ func (a *Agent) Initialize() error {
 
 // ProcessQuery processes a natural language query and returns relevant keybindings
 func (a *Agent) ProcessQuery(query string) (*interfaces.QueryResult, error) {
+	return a.ProcessQueryContext(context.Background(), query)
+}
+
+// ProcessQueryContext processes a natural language query and returns relevant keybindings.
+// Once ctx is cancelled the pending vector search or LLM call is aborted and ctx.Err() is returned.
+func (a *Agent) ProcessQueryContext(ctx context.Context, query string) (*interfaces.QueryResult, error) {
+	return a.ProcessRequestStream(ctx, &interfaces.QueryRequest{Query: query}, nil)
+}
+
+// ProcessRequest processes a query in the editor context it was made in: keybindings local
+// to another buffer or filetype are dropped, and those fitting the mode and filetype rank higher
+func (a *Agent) ProcessRequest(ctx context.Context, request *interfaces.QueryRequest) (*interfaces.QueryResult, error) {
+	return a.ProcessRequestStream(ctx, request, nil)
+}
+
+// ProcessQueryStream processes a query like ProcessQueryContext, passing intermediate
+// results to emit as soon as each stage has them
+func (a *Agent) ProcessQueryStream(ctx context.Context, query string, emit func(interfaces.QueryUpdate)) (*interfaces.QueryResult, error) {
+	return a.ProcessRequestStream(ctx, &interfaces.QueryRequest{Query: query}, emit)
+}
+
+// ProcessRequestStream processes a query like ProcessRequest, passing intermediate
+// results to emit as soon as each stage has them: the raw vector hits right after the
+// search, then the LLM-ranked results. emit runs synchronously and may be nil.
+func (a *Agent) ProcessRequestStream(ctx context.Context, request *interfaces.QueryRequest, emit func(interfaces.QueryUpdate)) (*interfaces.QueryResult, error) {
+	if emit == nil {
+		emit = func(interfaces.QueryUpdate) {}
+	}
+	query := request.Query
+
 	if strings.TrimSpace(query) == "" {
 		return &interfaces.QueryResult{
 			Results:   []interfaces.SearchResult{},
@@ -129,7 +173,10 @@ func (a *Agent) ProcessQuery(query string) (*interfaces.QueryResult, error) {
 	start := time.Now()
 
 	// Step 1: Process query with intelligent understanding
-	processedQuery, err := a.queryProcessor.ProcessQuery(query)
+	processedQuery, err := a.queryProcessor.ProcessQueryContext(ctx, query)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil {
 		log.Printf("Query processing failed: %v", err)
 		// Continue with original query
@@ -138,21 +185,38 @@ func (a *Agent) ProcessQuery(query string) (*interfaces.QueryResult, error) {
 			Expanded: query,
 		}
 	}
+	processedQuery.Context = request.Context
+	processedQuery.Editor = ParseEditorContext(request.Context)
+
+	if trace := interfaces.QueryTraceFromContext(ctx); trace != nil {
+		trace.ProcessedQuery = traceProcessedQuery(processedQuery)
+	}
 
-	// Step 2: Perform vector search with processed query
+	// Step 2: Perform vector search with processed query, retrieving at least as many
+	// results as were asked for
 	var searchResults []interfaces.VectorSearchResult
 	var searchErr error
 
-	if a.config.SearchAllCollections {
+	searchLimit := a.config.MaxSearchResults
+	if request.Limit > searchLimit {
+		searchLimit = request.Limit
+	}
+
+	filter := request.Filter
+	askedForGeneral := filter != nil && len(filter.Sources) > 0 && filter.IncludesSource(interfaces.SourceGeneral)
+	if a.config.SearchAllCollections || askedForGeneral {
 		// Search all collections including general knowledge
-		searchResults, searchErr = a.collectionManager.SearchAllCollections(processedQuery.Expanded, a.config.MaxSearchResults)
+		searchResults, searchErr = a.collectionManager.SearchAllCollectionsFiltered(ctx, processedQuery.Expanded, searchLimit, filter)
 		log.Printf("Searching all collections (including general knowledge)")
 	} else {
 		// Search only keybinding collections
-		searchResults, searchErr = a.collectionManager.SearchBoth(processedQuery.Expanded, a.config.MaxSearchResults)
+		searchResults, searchErr = a.collectionManager.SearchBothFiltered(ctx, processedQuery.Expanded, searchLimit, filter)
 		log.Printf("Searching keybinding collections only")
 	}
 
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if searchErr != nil {
 		return &interfaces.QueryResult{
 			Results:   []interfaces.SearchResult{},
@@ -163,8 +227,13 @@ func (a *Agent) ProcessQuery(query string) (*interfaces.QueryResult, error) {
 
 	log.Printf("Found %d vector search results", len(searchResults))
 
-	// Step 3: Filter by similarity threshold
-	filteredResults := a.filterBySimilarity(searchResults)
+	// Step 3: Drop keybindings that cannot be used in the editor, then filter by similarity threshold
+	searchResults = a.filterByEditorContext(ctx, searchResults, processedQuery.Editor)
+	threshold := a.similarityThreshold(filter)
+	filteredResults := a.filterByThreshold(searchResults, threshold)
+	if trace := interfaces.QueryTraceFromContext(ctx); trace != nil {
+		traceFiltering(trace, searchResults, threshold)
+	}
 	log.Printf("Filtered to %d results above similarity threshold", len(filteredResults))
 
 	if len(filteredResults) == 0 {
@@ -175,25 +244,50 @@ func (a *Agent) ProcessQuery(query string) (*interfaces.QueryResult, error) {
 		}, nil
 	}
 
+	// The vector hits are useful on their own while the LLM is still working
+	emit(interfaces.QueryUpdate{
+		Stage:     interfaces.QueryStageRetrieval,
+		Results:   a.vectorResultsToSearchResults(query, filteredResults, request.Limit),
+		Reasoning: fmt.Sprintf("Found %d keybindings using vector similarity search, ranking with LLM", len(filteredResults)),
+	})
+
 	// Step 4: Build context for LLM using intelligent context building
-	context := a.queryProcessor.BuildContextFromResults(query, filteredResults, processedQuery.Intent)
+	stageStart := time.Now()
+	llmContext := a.queryProcessor.BuildContextFromResults(query, filteredResults, processedQuery.Intent)
+	if description := processedQuery.Editor.Describe(); description != "" {
+		llmContext = description + "\n\n" + llmContext
+	}
+	interfaces.RecordStage(ctx, interfaces.StageContextBuilding, stageStart)
 
 	// Step 5: Generate and parse LLM response
-	llmAnalysis, err := a.responseGenerator.GenerateAndParseResponse(query, context)
+	stageStart = time.Now()
+	llmAnalysis, err := a.responseGenerator.GenerateAndParseResponseContext(ctx, query, llmContext)
+	interfaces.RecordStage(ctx, interfaces.StageLLMGeneration, stageStart)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil {
 		log.Printf("LLM response generation and parsing failed: %v", err)
 		// Fallback to basic results without LLM enhancement
-		return a.createFallbackResult(query, filteredResults), nil
+		return a.createFallbackResult(query, filteredResults, request.Limit), nil
 	}
 
 	// Step 6: Rank and combine results
-	finalResults, reasoning, err := a.responseGenerator.RankAndCombineResults(query, filteredResults, llmAnalysis, processedQuery)
+	stageStart = time.Now()
+	finalResults, reasoning, err := a.responseGenerator.RankAndCombineResultsContext(ctx, query, filteredResults, llmAnalysis, processedQuery, request.Limit)
+	interfaces.RecordStage(ctx, interfaces.StageRanking, stageStart)
 	if err != nil {
 		log.Printf("Result ranking and combination failed: %v", err)
 		// Fallback to basic results
-		return a.createFallbackResult(query, filteredResults), nil
+		return a.createFallbackResult(query, filteredResults, request.Limit), nil
 	}
 
+	emit(interfaces.QueryUpdate{
+		Stage:     interfaces.QueryStageRanked,
+		Results:   finalResults,
+		Reasoning: reasoning,
+	})
+
 	duration := time.Since(start)
 	log.Printf("Query processed in %v, returning %d results", duration, len(finalResults))
 
@@ -206,10 +300,24 @@ func (a *Agent) ProcessQuery(query string) (*interfaces.QueryResult, error) {
 
 // filterBySimilarity filters search results by similarity threshold
 func (a *Agent) filterBySimilarity(results []interfaces.VectorSearchResult) []interfaces.VectorSearchResult {
+	return a.filterByThreshold(results, a.config.SimilarityThreshold)
+}
+
+// similarityThreshold returns the similarity threshold of a query: the configured one,
+// unless the query asks for a higher minimum relevance
+func (a *Agent) similarityThreshold(filter *interfaces.SearchFilter) float64 {
+	if filter != nil && filter.MinRelevance > a.config.SimilarityThreshold {
+		return filter.MinRelevance
+	}
+	return a.config.SimilarityThreshold
+}
+
+// filterByThreshold keeps the search results scoring at least threshold
+func (a *Agent) filterByThreshold(results []interfaces.VectorSearchResult, threshold float64) []interfaces.VectorSearchResult {
 	filtered := make([]interfaces.VectorSearchResult, 0, len(results))
 
 	for _, result := range results {
-		if result.Score >= a.config.SimilarityThreshold {
+		if result.Score >= threshold {
 			filtered = append(filtered, result)
 		}
 	}
@@ -217,12 +325,74 @@ func (a *Agent) filterBySimilarity(results []interfaces.VectorSearchResult) []in
 	return filtered
 }
 
+// filterByEditorContext drops search results that do not apply in the editor the query
+// was made in, recording them in the query trace
+func (a *Agent) filterByEditorContext(ctx context.Context, results []interfaces.VectorSearchResult, editor *EditorContext) []interfaces.VectorSearchResult {
+	if editor == nil {
+		return results
+	}
+
+	trace := interfaces.QueryTraceFromContext(ctx)
+	filtered := make([]interfaces.VectorSearchResult, 0, len(results))
+	for _, result := range results {
+		if editor.appliesTo(result) {
+			filtered = append(filtered, result)
+		} else if trace != nil {
+			trace.NotApplicable = append(trace.NotApplicable, interfaces.NewTracedHit("", result))
+		}
+	}
+	return filtered
+}
+
+// traceFiltering records the hits filterByThreshold drops
+func traceFiltering(trace *interfaces.QueryTrace, results []interfaces.VectorSearchResult, threshold float64) {
+	trace.SimilarityThreshold = threshold
+	for _, result := range results {
+		if result.Score < threshold {
+			trace.Dropped = append(trace.Dropped, interfaces.NewTracedHit("", result))
+		}
+	}
+}
+
+// traceProcessedQuery describes a processed query for the query trace
+func traceProcessedQuery(processed *ProcessedQuery) *interfaces.TracedQuery {
+	traced := &interfaces.TracedQuery{
+		Original:     processed.Original,
+		Expanded:     processed.Expanded,
+		Synonyms:     processed.Synonyms,
+		SearchTerms:  processed.SearchTerms,
+		BoostFactors: processed.BoostFactors,
+		Context:      processed.Context,
+	}
+	if processed.Intent != nil {
+		traced.Intent = processed.Intent.Type
+		traced.IntentConfidence = processed.Intent.Confidence
+		traced.IntentKeywords = processed.Intent.Keywords
+	}
+	return traced
+}
+
 // createFallbackResult creates a basic result when LLM processing fails
-func (a *Agent) createFallbackResult(query string, vectorResults []interfaces.VectorSearchResult) *interfaces.QueryResult {
+func (a *Agent) createFallbackResult(query string, vectorResults []interfaces.VectorSearchResult, limit int) *interfaces.QueryResult {
+	results := a.vectorResultsToSearchResults(query, vectorResults, limit)
+
+	return &interfaces.QueryResult{
+		Results:   results,
+		Reasoning: fmt.Sprintf("Found %d keybindings using vector similarity search (LLM enhancement unavailable)", len(results)),
+		Error:     "",
+	}
+}
+
+// vectorResultsToSearchResults converts the top vector results to search results with
+// basic explanations, sorted by boosted relevance
+func (a *Agent) vectorResultsToSearchResults(query string, vectorResults []interfaces.VectorSearchResult, limit int) []interfaces.SearchResult {
 	var results []interfaces.SearchResult
 
 	// Convert top vector results to search results
 	maxResults := a.config.MaxSearchResults
+	if limit > maxResults {
+		maxResults = limit
+	}
 	if len(vectorResults) < maxResults {
 		maxResults = len(vectorResults)
 	}
@@ -248,11 +418,7 @@ func (a *Agent) createFallbackResult(query string, vectorResults []interfaces.Ve
 		return results[i].Relevance > results[j].Relevance
 	})
 
-	return &interfaces.QueryResult{
-		Results:   results,
-		Reasoning: fmt.Sprintf("Found %d keybindings using vector similarity search (LLM enhancement unavailable)", len(results)),
-		Error:     "",
-	}
+	return results
 }
 
 // vectorResultToKeybinding converts a vector search result to a keybinding
@@ -321,18 +487,171 @@ func (a *Agent) applyUserBoost(results []interfaces.SearchResult) []interfaces.S
 	return results
 }
 
-// UpdateVectorDB updates the vector database with new keybindings
+// updateBatchSize is the number of keybindings vectorized and stored at a time during
+// an update, which is also how often progress is reported
+const updateBatchSize = 100
+
+// UpdateVectorDB adds keybindings to the stored user keybindings
 func (a *Agent) UpdateVectorDB(keybindings []interfaces.Keybinding) error {
+	return a.UpdateVectorDBProgress(context.Background(), keybindings, nil)
+}
+
+// UpdateVectorDBProgress updates the vector database like UpdateVectorDB, passing the
+// progress of each phase to report as it goes. Keybindings or batches that fail are
+// reported and skipped, and the returned error lists them once the rest is stored.
+// Once ctx is cancelled no further batch is stored. report runs synchronously and may
+// be nil.
+func (a *Agent) UpdateVectorDBProgress(ctx context.Context, keybindings []interfaces.Keybinding, report func(interfaces.SyncProgress)) error {
+	if report == nil {
+		report = func(interfaces.SyncProgress) {}
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
 	if len(keybindings) == 0 {
 		return nil
 	}
+	if a.collectionManager == nil {
+		return fmt.Errorf("updating keybindings requires the collection manager")
+	}
 
 	log.Printf("Updating vector database with %d keybindings", len(keybindings))
+	documents, errs := a.prepareDocuments(keybindings, report)
+
+	// Store documents in vector database
+	stored := 0
+	for i := 0; i < len(documents); i += updateBatchSize {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("stopped after storing %d of %d keybindings: %w", stored, len(keybindings), err)
+		}
+
+		batch := documents[i:min(i+updateBatchSize, len(documents))]
+		progress := interfaces.SyncProgress{Phase: interfaces.SyncPhaseStore, Processed: i + len(batch), Total: len(documents)}
+
+		if err := a.collectionManager.StoreUserKeybindings(batch); err != nil {
+			err = fmt.Errorf("failed to store keybindings %d-%d in vector database: %w", i, i+len(batch), err)
+			errs = append(errs, err)
+			progress.Error = err.Error()
+		} else {
+			stored += len(batch)
+		}
+		report(progress)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("stored %d of %d keybindings: %w", stored, len(keybindings), errors.Join(errs...))
+	}
+
+	log.Printf("Successfully updated vector database with %d keybindings", len(keybindings))
+	return nil
+}
+
+// ReplaceVectorDBProgress replaces all stored user keybindings with keybindings, reporting
+// progress like UpdateVectorDBProgress. The keybindings are stored in a staging collection
+// that is swapped in only once every one of them is stored, so on any error, or when ctx
+// is cancelled, the previous keybindings stay in place. An empty keybindings clears them.
+func (a *Agent) ReplaceVectorDBProgress(ctx context.Context, keybindings []interfaces.Keybinding, report func(interfaces.SyncProgress)) error {
+	if report == nil {
+		report = func(interfaces.SyncProgress) {}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.collectionManager == nil {
+		return fmt.Errorf("replacing keybindings requires the collection manager")
+	}
+
+	log.Printf("Replacing user keybindings with %d keybindings", len(keybindings))
+	documents, errs := a.prepareDocuments(keybindings, report)
+	if len(errs) > 0 {
+		return fmt.Errorf("kept previous keybindings: %w", errors.Join(errs...))
+	}
+
+	staging, err := a.collectionManager.StageUserCollection()
+	if err != nil {
+		return fmt.Errorf("kept previous keybindings: %w", err)
+	}
+	discard := func(err error) error {
+		if discardErr := staging.Discard(); discardErr != nil {
+			log.Printf("Warning: failed to discard staged keybindings: %v", discardErr)
+		}
+		return fmt.Errorf("kept previous keybindings: %w", err)
+	}
+
+	for i := 0; i < len(documents); i += updateBatchSize {
+		if err := ctx.Err(); err != nil {
+			return discard(err)
+		}
+
+		batch := documents[i:min(i+updateBatchSize, len(documents))]
+		progress := interfaces.SyncProgress{Phase: interfaces.SyncPhaseStore, Processed: i + len(batch), Total: len(documents)}
+
+		if err := staging.Store(batch); err != nil {
+			err = fmt.Errorf("failed to store keybindings %d-%d in vector database: %w", i, i+len(batch), err)
+			progress.Error = err.Error()
+			report(progress)
+			return discard(err)
+		}
+		report(progress)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return discard(err)
+	}
+	if err := staging.Commit(); err != nil {
+		return discard(err)
+	}
+
+	log.Printf("Successfully replaced user keybindings with %d keybindings", len(keybindings))
+	return nil
+}
+
+// prepareDocuments runs the validate and vectorize phases of a sync, returning the documents
+// of the keybindings that passed along with the errors of those that did not
+func (a *Agent) prepareDocuments(keybindings []interfaces.Keybinding, report func(interfaces.SyncProgress)) ([]interfaces.Document, []error) {
+	var errs []error
+
+	// Keybindings without an id or keys cannot be stored or found
+	valid := make([]interfaces.Keybinding, 0, len(keybindings))
+	var invalid []string
+	for i, kb := range keybindings {
+		if kb.ID == "" || kb.Keys == "" {
+			invalid = append(invalid, fmt.Sprintf("keybinding at index %d has no id or keys", i))
+			continue
+		}
+		valid = append(valid, kb)
+	}
+	progress := interfaces.SyncProgress{Phase: interfaces.SyncPhaseValidate, Processed: len(keybindings), Total: len(keybindings)}
+	if len(invalid) > 0 {
+		err := errors.New(strings.Join(invalid, "; "))
+		errs = append(errs, err)
+		progress.Error = err.Error()
+	}
+	report(progress)
 
 	// Convert keybindings to documents
+	documents := make([]interfaces.Document, 0, len(valid))
+	for i := 0; i < len(valid); i += updateBatchSize {
+		batch := valid[i:min(i+updateBatchSize, len(valid))]
+		progress := interfaces.SyncProgress{Phase: interfaces.SyncPhaseVectorize, Processed: i + len(batch), Total: len(valid)}
+
+		batchDocuments, err := a.keybindingsToDocuments(batch)
+		if err != nil {
+			errs = append(errs, err)
+			progress.Error = err.Error()
+		} else {
+			documents = append(documents, batchDocuments...)
+		}
+		report(progress)
+	}
+
+	return documents, errs
+}
+
+// keybindingsToDocuments converts user keybindings to documents for the vector database
+func (a *Agent) keybindingsToDocuments(keybindings []interfaces.Keybinding) ([]interfaces.Document, error) {
 	documents := make([]interfaces.Document, len(keybindings))
 	for i, kb := range keybindings {
 		// Generate content for vectorization
@@ -359,7 +678,7 @@ func (a *Agent) UpdateVectorDB(keybindings []interfaces.Keybinding) error {
 		// Convert metadata map to DocumentMetadata
 		chromaMetadata, err := chroma.NewDocumentMetadataFromMap(convertStringMapToInterface(metadata))
 		if err != nil {
-			return fmt.Errorf("failed to create metadata for keybinding %s: %w", kb.ID, err)
+			return nil, fmt.Errorf("failed to create metadata for keybinding %s: %w", kb.ID, err)
 		}
 
 		documents[i] = interfaces.Document{
@@ -368,14 +687,7 @@ func (a *Agent) UpdateVectorDB(keybindings []interfaces.Keybinding) error {
 			Metadata: chromaMetadata,
 		}
 	}
-
-	// Store documents in vector database
-	if err := a.vectorDB.Store(documents); err != nil {
-		return fmt.Errorf("failed to store keybindings in vector database: %w", err)
-	}
-
-	log.Printf("Successfully updated vector database with %d keybindings", len(keybindings))
-	return nil
+	return documents, nil
 }
 
 // generateKeybindingContent generates searchable content for a keybinding
@@ -418,6 +730,134 @@ func (a *Agent) generateKeybindingContent(kb interfaces.Keybinding) string {
 	return content
 }
 
+// CollectionCounts returns the number of documents in each collection the agent searches
+func (a *Agent) CollectionCounts() (map[string]int, error) {
+	if a.collectionManager == nil {
+		return nil, fmt.Errorf("collection manager not initialized")
+	}
+	return a.collectionManager.CollectionCounts()
+}
+
+// listBatchSize is the number of documents fetched at a time while listing keybindings
+const listBatchSize = 200
+
+// listedMetadata is the metadata listed keybindings carry, as conflict and shadow analysis
+// need it
+var listedMetadata = []string{"buffer_local", "filetype", "noremap", "nowait"}
+
+// listSources returns the sources ListKeybindings walks, in order. General knowledge
+// holds no keybindings and is only listed when asked for.
+func listSources(filter *interfaces.SearchFilter) []string {
+	var sources []string
+	for _, source := range []string{interfaces.SourceUser, interfaces.SourceBuiltin, interfaces.SourceGeneral} {
+		if source == interfaces.SourceGeneral && (filter == nil || len(filter.Sources) == 0) {
+			continue
+		}
+		if filter.IncludesSource(source) {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// ListKeybindings returns a page of the stored keybindings, user keybindings first
+func (a *Agent) ListKeybindings(ctx context.Context, request *interfaces.ListKeybindingsRequest) (*interfaces.KeybindingPage, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.collectionManager == nil {
+		return nil, fmt.Errorf("collection manager not initialized")
+	}
+
+	sources := listSources(request.Filter)
+	first := 0
+	if request.Start != nil {
+		for first < len(sources) && sources[first] != request.Start.Source {
+			first++
+		}
+		if first == len(sources) {
+			return nil, fmt.Errorf("listing does not include source %q", request.Start.Source)
+		}
+	}
+	prefix := strings.ToLower(request.Prefix)
+
+	page := &interfaces.KeybindingPage{Keybindings: []interfaces.StoredKeybinding{}}
+	for _, source := range sources[first:] {
+		offset := 0
+		if request.Start != nil && source == request.Start.Source {
+			offset = request.Start.Offset
+		}
+
+		for {
+			documents, err := a.collectionManager.ListDocuments(ctx, source, request.Filter, offset, listBatchSize)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s keybindings: %w", source, err)
+			}
+
+			for i, document := range documents {
+				if len(page.Keybindings) == request.Limit {
+					page.Next = &interfaces.ListPosition{Source: source, Offset: offset + i}
+					return page, nil
+				}
+
+				keybinding := a.vectorResultToKeybinding(interfaces.VectorSearchResult{Document: document})
+				if keybinding.ID == "" {
+					keybinding.ID = string(document.ID)
+				}
+				for _, key := range listedMetadata {
+					if value := getMetadataString(document, key); value != "" {
+						keybinding.Metadata[key] = value
+					}
+				}
+				if prefix == "" || hasPrefixFold(prefix, keybinding.Keys, keybinding.Command, keybinding.Description) {
+					page.Keybindings = append(page.Keybindings, interfaces.StoredKeybinding{Keybinding: keybinding, Source: source})
+				}
+			}
+
+			offset += len(documents)
+			if len(documents) < listBatchSize {
+				break
+			}
+		}
+	}
+	return page, nil
+}
+
+// hasPrefixFold reports whether any of values starts with the lower case prefix, ignoring case
+func hasPrefixFold(prefix string, values ...string) bool {
+	for _, value := range values {
+		if strings.HasPrefix(strings.ToLower(value), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteKeybindings deletes the stored user keybindings the selector matches and returns
+// their ids. An empty selector matches every user keybinding.
+func (a *Agent) DeleteKeybindings(ctx context.Context, selector *interfaces.KeybindingSelector) ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.collectionManager == nil {
+		return nil, fmt.Errorf("collection manager not initialized")
+	}
+
+	ids, err := a.collectionManager.SelectUserKeybindings(ctx, selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find keybindings to delete: %w", err)
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	if err := a.collectionManager.DeleteUserKeybindings(ids); err != nil {
+		return nil, fmt.Errorf("failed to delete %d keybindings: %w", len(ids), err)
+	}
+	log.Printf("Deleted %d user keybindings", len(ids))
+	return ids, nil
+}
+
 // HealthCheck verifies the agent is functioning properly
 func (a *Agent) HealthCheck() error {
 	a.mu.RLock()
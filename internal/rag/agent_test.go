@@ -1,6 +1,9 @@
 package rag
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"testing"
 
 	"nvim-smart-keybind-search/internal/chromadb"
@@ -42,6 +45,127 @@ func (m *MockVectorDB) Close() error {
 	return nil
 }
 
+// memoryStore keeps collections in memory for testing. Searches return every document
+// in insertion order, and where filters are ignored.
+type memoryStore struct {
+	collections map[string][]interfaces.Document
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{collections: make(map[string][]interfaces.Document)}
+}
+
+func (m *memoryStore) EnsureCollection(name string) error {
+	if _, ok := m.collections[name]; !ok {
+		m.collections[name] = []interfaces.Document{}
+	}
+	return nil
+}
+
+func (m *memoryStore) CollectionExists(name string) (bool, error) {
+	_, ok := m.collections[name]
+	return ok, nil
+}
+
+func (m *memoryStore) ResetCollection(name string) error {
+	m.collections[name] = []interfaces.Document{}
+	return nil
+}
+
+func (m *memoryStore) RenameCollection(name, newName string) error {
+	documents, ok := m.collections[name]
+	if !ok {
+		return fmt.Errorf("collection %s does not exist", name)
+	}
+	if _, taken := m.collections[newName]; taken {
+		return fmt.Errorf("collection %s already exists", newName)
+	}
+	delete(m.collections, name)
+	m.collections[newName] = documents
+	return nil
+}
+
+func (m *memoryStore) DeleteCollection(name string) error {
+	delete(m.collections, name)
+	return nil
+}
+
+func (m *memoryStore) GetCollectionCountByName(name string) (int, error) {
+	return len(m.collections[name]), nil
+}
+
+func (m *memoryStore) StoreInCollection(documents []interfaces.Document, name string) error {
+	m.collections[name] = append(m.collections[name], documents...)
+	return nil
+}
+
+func (m *memoryStore) SearchInCollectionWhere(ctx context.Context, query string, limit int, name string, where chroma.WhereFilter) ([]interfaces.VectorSearchResult, error) {
+	var results []interfaces.VectorSearchResult
+	for _, document := range m.collections[name] {
+		if len(results) == limit {
+			break
+		}
+		results = append(results, interfaces.VectorSearchResult{Document: document, Score: 1})
+	}
+	return results, nil
+}
+
+func (m *memoryStore) GetFromCollection(ctx context.Context, name string, ids []string, where chroma.WhereFilter, offset, limit int) ([]interfaces.Document, error) {
+	var documents []interfaces.Document
+	for _, document := range m.collections[name] {
+		if len(ids) > 0 && !containsID(ids, string(document.ID)) {
+			continue
+		}
+		documents = append(documents, document)
+	}
+	if offset >= len(documents) {
+		return nil, nil
+	}
+	documents = documents[offset:]
+	if limit > 0 && limit < len(documents) {
+		documents = documents[:limit]
+	}
+	return documents, nil
+}
+
+func (m *memoryStore) DeleteFromCollection(ids []string, name string) error {
+	var kept []interfaces.Document
+	for _, document := range m.collections[name] {
+		if !containsID(ids, string(document.ID)) {
+			kept = append(kept, document)
+		}
+	}
+	m.collections[name] = kept
+	return nil
+}
+
+// containsID reports whether ids holds id
+func containsID(ids []string, id string) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+// listedKeys returns the keys of every listed user keybinding
+func listedKeys(t *testing.T, agent *Agent) []string {
+	t.Helper()
+	page, err := agent.ListKeybindings(context.Background(), &interfaces.ListKeybindingsRequest{
+		Filter: &interfaces.SearchFilter{Sources: []string{interfaces.SourceUser}},
+		Limit:  100,
+	})
+	if err != nil {
+		t.Fatalf("Failed to list keybindings: %v", err)
+	}
+	keys := make([]string, len(page.Keybindings))
+	for i, keybinding := range page.Keybindings {
+		keys[i] = keybinding.Keys
+	}
+	return keys
+}
+
 // MockLLMClient is a mock implementation of LLMClient for testing
 type MockLLMClient struct {
 	generateResponse *interfaces.LLMResponse
@@ -63,6 +187,13 @@ func (m *MockLLMClient) Generate(request interfaces.LLMRequest) (*interfaces.LLM
 	}, nil
 }
 
+func (m *MockLLMClient) GenerateContext(ctx context.Context, request interfaces.LLMRequest) (*interfaces.LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.Generate(request)
+}
+
 func (m *MockLLMClient) Embed(text string) ([]float64, error) {
 	if m.embedError != nil {
 		return nil, m.embedError
@@ -334,13 +465,8 @@ func TestAgentUpdateVectorDB(t *testing.T) {
 	mockVectorDB := &MockVectorDB{}
 	mockLLMClient := &MockLLMClient{}
 
-	// Create a real ChromaDB client with default config for testing
-	chromaClient, err := chromadb.NewClient(chromadb.DefaultConfig())
-	if err != nil {
-		t.Skipf("Skipping test due to ChromaDB client creation error: %v", err)
-	}
-
-	mockCollectionManager := chromadb.NewCollectionManager(chromaClient)
+	// Keybindings are stored through the collection manager, kept in memory here
+	mockCollectionManager := chromadb.NewCollectionManagerWithStore(newMemoryStore())
 
 	agent := NewAgent(mockVectorDB, mockCollectionManager, mockLLMClient, nil)
 
@@ -360,6 +486,84 @@ func TestAgentUpdateVectorDB(t *testing.T) {
 	}
 }
 
+func TestAgentUpdateThenReplaceUserKeybindings(t *testing.T) {
+	store := newMemoryStore()
+	agent := NewAgent(&MockVectorDB{}, chromadb.NewCollectionManagerWithStore(store), &MockLLMClient{}, nil)
+
+	if err := agent.UpdateVectorDB([]interfaces.Keybinding{{ID: "n:gd", Keys: "gd", Command: "definition", Mode: "n"}}); err != nil {
+		t.Fatalf("UpdateVectorDB failed: %v", err)
+	}
+	if keys := listedKeys(t, agent); strings.Join(keys, ",") != "gd" {
+		t.Fatalf("Expected the updated keybinding to be listed, got %v", keys)
+	}
+
+	err := agent.ReplaceVectorDBProgress(context.Background(), []interfaces.Keybinding{
+		{ID: "n:gr", Keys: "gr", Command: "references", Mode: "n"},
+		{ID: "n:K", Keys: "K", Command: "hover", Mode: "n"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("ReplaceVectorDBProgress failed: %v", err)
+	}
+	if keys := listedKeys(t, agent); strings.Join(keys, ",") != "gr,K" {
+		t.Errorf("Expected the resync to replace the updated keybinding, got %v", keys)
+	}
+
+	results, err := agent.collectionManager.SearchAllCollectionsFiltered(context.Background(), "references", 10,
+		&interfaces.SearchFilter{Sources: []string{interfaces.SourceUser}})
+	if err != nil || len(results) != 2 {
+		t.Errorf("Expected search to see the replaced keybindings, got %d results, %v", len(results), err)
+	}
+	if len(store.collections) != 1 || store.collections["user_keybindings"] == nil {
+		t.Errorf("Expected only the user collection to be written, got %v", store.collections)
+	}
+}
+
+func TestAgentUpdatedKeybindingsCanBeDeleted(t *testing.T) {
+	agent := NewAgent(&MockVectorDB{}, chromadb.NewCollectionManagerWithStore(newMemoryStore()), &MockLLMClient{}, nil)
+
+	err := agent.UpdateVectorDB([]interfaces.Keybinding{
+		{ID: "n:gd", Keys: "gd", Command: "definition", Mode: "n"},
+		{ID: "n:gr", Keys: "gr", Command: "references", Mode: "n"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateVectorDB failed: %v", err)
+	}
+
+	ids, err := agent.DeleteKeybindings(context.Background(), &interfaces.KeybindingSelector{IDs: []string{"n:gd"}})
+	if err != nil || len(ids) != 1 || ids[0] != "n:gd" {
+		t.Fatalf("Expected the updated keybinding to be deleted, got %v, %v", ids, err)
+	}
+	if keys := listedKeys(t, agent); strings.Join(keys, ",") != "gr" {
+		t.Errorf("Expected only gr to be left, got %v", keys)
+	}
+}
+
+func TestAgentReplaceVectorDBKeepsPreviousOnInvalidKeybindings(t *testing.T) {
+	chromaClient, err := chromadb.NewClient(chromadb.DefaultConfig())
+	if err != nil {
+		t.Skipf("Skipping test due to ChromaDB client creation error: %v", err)
+	}
+
+	agent := NewAgent(&MockVectorDB{}, chromadb.NewCollectionManager(chromaClient), &MockLLMClient{}, nil)
+
+	var phases []string
+	err = agent.ReplaceVectorDBProgress(context.Background(), []interfaces.Keybinding{
+		{ID: "test1", Keys: "dd", Command: "delete"},
+		{ID: "test2", Command: "no keys"},
+	}, func(progress interfaces.SyncProgress) {
+		phases = append(phases, progress.Phase)
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "kept previous keybindings") {
+		t.Fatalf("Expected the resync to be aborted, got %v", err)
+	}
+	for _, phase := range phases {
+		if phase == interfaces.SyncPhaseStore {
+			t.Errorf("Expected nothing to be stored, got phases %v", phases)
+		}
+	}
+}
+
 func TestAgentFilterBySimilarity(t *testing.T) {
 	mockVectorDB := &MockVectorDB{}
 	mockLLMClient := &MockLLMClient{}
@@ -396,3 +600,88 @@ func TestAgentFilterBySimilarity(t *testing.T) {
 		}
 	}
 }
+
+func TestAgentTraceFiltering(t *testing.T) {
+	config := DefaultAgentConfig()
+	config.SimilarityThreshold = 0.5
+
+	chromaClient, err := chromadb.NewClient(chromadb.DefaultConfig())
+	if err != nil {
+		t.Skipf("Skipping test due to ChromaDB client creation error: %v", err)
+	}
+	agent := NewAgent(&MockVectorDB{}, chromadb.NewCollectionManager(chromaClient), &MockLLMClient{}, config)
+
+	metadata, _ := chroma.NewDocumentMetadataFromMap(map[string]interface{}{"keys": "x"})
+	results := []interfaces.VectorSearchResult{
+		{Document: interfaces.Document{ID: "kept"}, Score: 0.9},
+		{Document: interfaces.Document{ID: "dropped", Metadata: metadata}, Score: 0.4},
+	}
+
+	trace := &interfaces.QueryTrace{}
+	traceFiltering(trace, results, agent.config.SimilarityThreshold)
+
+	if trace.SimilarityThreshold != 0.5 {
+		t.Errorf("Expected threshold 0.5 in trace, got %f", trace.SimilarityThreshold)
+	}
+	if len(trace.Dropped) != 1 || trace.Dropped[0].ID != "dropped" || trace.Dropped[0].Keys != "x" || trace.Dropped[0].Score != 0.4 {
+		t.Errorf("Expected only the hit below the threshold to be dropped, got %+v", trace.Dropped)
+	}
+}
+
+func TestRankAndCombineResultsLimit(t *testing.T) {
+	rg := NewResponseGenerator(&MockLLMClient{}, nil)
+
+	var vectorResults []interfaces.VectorSearchResult
+	for i := 0; i < 8; i++ {
+		metadata, _ := chroma.NewDocumentMetadataFromMap(map[string]interface{}{
+			"keybinding_id": fmt.Sprintf("kb%d", i),
+			"keys":          fmt.Sprintf("<leader>%d", i),
+		})
+		vectorResults = append(vectorResults, interfaces.VectorSearchResult{
+			Document: interfaces.Document{Metadata: metadata},
+			Score:    0.9,
+		})
+	}
+
+	for limit, want := range map[int]int{0: rg.config.MaxFinalResults, 7: 7, 20: 8} {
+		results, _, err := rg.RankAndCombineResultsContext(context.Background(), "leader", vectorResults, &ResponseAnalysis{}, nil, limit)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(results) != want {
+			t.Errorf("Expected %d results for limit %d, got %d", want, limit, len(results))
+		}
+	}
+}
+
+func TestAgentSimilarityThreshold(t *testing.T) {
+	config := DefaultAgentConfig()
+	config.SimilarityThreshold = 0.3
+	agent := &Agent{config: config}
+
+	if got := agent.similarityThreshold(nil); got != 0.3 {
+		t.Errorf("Expected the configured threshold without a filter, got %f", got)
+	}
+	if got := agent.similarityThreshold(&interfaces.SearchFilter{MinRelevance: 0.6}); got != 0.6 {
+		t.Errorf("Expected the filter to raise the threshold, got %f", got)
+	}
+	if got := agent.similarityThreshold(&interfaces.SearchFilter{MinRelevance: 0.1}); got != 0.3 {
+		t.Errorf("Expected the filter not to lower the threshold, got %f", got)
+	}
+}
+
+func TestListSources(t *testing.T) {
+	tests := []struct {
+		filter *interfaces.SearchFilter
+		want   string
+	}{
+		{nil, "user,builtin"},
+		{&interfaces.SearchFilter{Modes: []string{"n"}}, "user,builtin"},
+		{&interfaces.SearchFilter{Sources: []string{interfaces.SourceGeneral, interfaces.SourceUser}}, "user,general"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(listSources(tt.filter), ","); got != tt.want {
+			t.Errorf("listSources(%+v) = %s, want %s", tt.filter, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package rag
+
+import (
+	"fmt"
+	"strings"
+
+	"nvim-smart-keybind-search/internal/interfaces"
+)
+
+// Ranking boosts for keybindings that fit the editor state of the query
+const (
+	modeMatchBoost     = 0.1
+	filetypeMatchBoost = 0.15
+)
+
+// modeNames names the mapping modes for the LLM prompt
+var modeNames = map[string]string{
+	"n": "normal",
+	"x": "visual",
+	"s": "select",
+	"o": "operator-pending",
+	"i": "insert",
+	"c": "command-line",
+	"t": "terminal",
+}
+
+// modeWords maps spelled out mode names, as used by the built-in knowledge and the LLM,
+// to mapping modes
+var modeWords = map[string][]string{
+	"normal":           {"n"},
+	"visual":           {"x", "s"},
+	"select":           {"s"},
+	"operator":         {"o"},
+	"operator-pending": {"o"},
+	"insert":           {"i"},
+	"command":          {"c"},
+	"command-line":     {"c"},
+	"cmdline":          {"c"},
+	"terminal":         {"t"},
+}
+
+// EditorContext is the editor state a query was made in, parsed from the context of the
+// query request. A nil EditorContext neither filters nor boosts anything.
+type EditorContext struct {
+	// Mode is the mapping mode the query was made in: "n", "x", "s", "o", "i", "c" or "t"
+	Mode     string
+	Filetype string
+
+	// BufferMaps holds the mappings local to the current buffer, keyed by "mode lhs".
+	// It is nil when the client did not send them.
+	BufferMaps map[string]bool
+
+	Cwd         string
+	NvimVersion string
+}
+
+// ParseEditorContext parses the context of a query request, returning nil when it is empty
+func ParseEditorContext(values map[string]string) *EditorContext {
+	if len(values) == 0 {
+		return nil
+	}
+
+	editor := &EditorContext{
+		Mode:        mappingMode(values[interfaces.ContextMode]),
+		Filetype:    strings.TrimSpace(values[interfaces.ContextFiletype]),
+		Cwd:         strings.TrimSpace(values[interfaces.ContextCwd]),
+		NvimVersion: strings.TrimSpace(values[interfaces.ContextNvimVersion]),
+	}
+
+	if maps, ok := values[interfaces.ContextBufferMaps]; ok {
+		editor.BufferMaps = make(map[string]bool)
+		for _, line := range strings.Split(maps, "\n") {
+			mode, lhs, found := strings.Cut(strings.TrimSpace(line), " ")
+			if found && lhs != "" {
+				editor.BufferMaps[mode+" "+lhs] = true
+			}
+		}
+	}
+
+	return editor
+}
+
+// mappingMode converts the result of mode() to the mapping mode it uses
+func mappingMode(mode string) string {
+	mode = strings.TrimSpace(mode)
+	if modes, ok := modeWords[strings.ToLower(mode)]; ok {
+		return modes[0]
+	}
+	if mode == "" {
+		return ""
+	}
+
+	switch mode[0] {
+	case 'n':
+		if strings.HasPrefix(mode, "no") {
+			return "o"
+		}
+		return "n"
+	case 'v', 'V', '\x16':
+		return "x"
+	case 's', 'S', '\x13':
+		return "s"
+	case 'i', 'R':
+		return "i"
+	case 'c':
+		return "c"
+	case 't':
+		return "t"
+	}
+	return ""
+}
+
+// bindingModes returns the mapping modes a keybinding is active in, or nil when its mode
+// is unknown
+func bindingModes(mode string) []string {
+	mode = strings.TrimSpace(mode)
+	if modes, ok := modeWords[strings.ToLower(mode)]; ok {
+		return modes
+	}
+
+	var modes []string
+	for _, letter := range mode {
+		switch letter {
+		case 'v':
+			modes = append(modes, "x", "s")
+		case '!':
+			modes = append(modes, "i", "c")
+		case 'n', 'x', 's', 'o', 'i', 'c', 't':
+			modes = append(modes, string(letter))
+		}
+	}
+	return modes
+}
+
+// appliesTo reports whether a vector hit can be used in the editor: mappings local to
+// another buffer or filetype cannot
+func (e *EditorContext) appliesTo(result interfaces.VectorSearchResult) bool {
+	if e == nil {
+		return true
+	}
+
+	filetype := getMetadataString(result.Document, "filetype")
+	if filetype != "" && e.Filetype != "" && filetype != e.Filetype {
+		return false
+	}
+
+	if getMetadataString(result.Document, "buffer_local") == "true" && e.BufferMaps != nil {
+		mode := getMetadataString(result.Document, "mode")
+		keys := getMetadataString(result.Document, "keys")
+		return e.BufferMaps[mode+" "+keys]
+	}
+
+	return true
+}
+
+// rankingFactors returns the boosts of a keybinding that fits the editor state
+func (e *EditorContext) rankingFactors(keybinding interfaces.Keybinding) map[string]float64 {
+	factors := make(map[string]float64)
+	if e == nil {
+		return factors
+	}
+
+	if e.Mode != "" {
+		for _, mode := range bindingModes(keybinding.Mode) {
+			if mode == e.Mode {
+				factors["mode_match"] = modeMatchBoost
+				break
+			}
+		}
+	}
+
+	if e.Filetype != "" && keybinding.Metadata["filetype"] == e.Filetype {
+		factors["filetype_match"] = filetypeMatchBoost
+	} else if keybinding.Metadata["buffer_local"] == "true" && e.BufferMaps[keybinding.Mode+" "+keybinding.Keys] {
+		factors["filetype_match"] = filetypeMatchBoost
+	}
+
+	return factors
+}
+
+// Describe summarizes the editor state for the LLM prompt
+func (e *EditorContext) Describe() string {
+	if e == nil {
+		return ""
+	}
+
+	var parts []string
+	if name, ok := modeNames[e.Mode]; ok {
+		parts = append(parts, fmt.Sprintf("in %s mode", name))
+	}
+	if e.Filetype != "" {
+		parts = append(parts, fmt.Sprintf("editing a %s file", e.Filetype))
+	}
+	if e.NvimVersion != "" {
+		parts = append(parts, fmt.Sprintf("running Neovim %s", e.NvimVersion))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "The user is " + strings.Join(parts, ", ") + "."
+}
@@ -0,0 +1,109 @@
+package rag
+
+import (
+	"testing"
+
+	"nvim-smart-keybind-search/internal/interfaces"
+
+	chroma "github.com/amikos-tech/chroma-go/pkg/api/v2"
+)
+
+func TestParseEditorContext(t *testing.T) {
+	if ParseEditorContext(nil) != nil {
+		t.Error("Expected no editor context for an empty request context")
+	}
+
+	editor := ParseEditorContext(map[string]string{
+		interfaces.ContextMode:        "V",
+		interfaces.ContextFiletype:    "python",
+		interfaces.ContextBufferMaps:  "n <leader>r\nx <leader>f\n",
+		interfaces.ContextNvimVersion: "0.10.1",
+	})
+
+	if editor.Mode != "x" || editor.Filetype != "python" {
+		t.Errorf("Expected visual mode in a python buffer, got %+v", editor)
+	}
+	if len(editor.BufferMaps) != 2 || !editor.BufferMaps["n <leader>r"] || !editor.BufferMaps["x <leader>f"] {
+		t.Errorf("Expected two buffer maps, got %v", editor.BufferMaps)
+	}
+	if want := "The user is in visual mode, editing a python file, running Neovim 0.10.1."; editor.Describe() != want {
+		t.Errorf("Expected description %q, got %q", want, editor.Describe())
+	}
+}
+
+func TestMappingMode(t *testing.T) {
+	tests := map[string]string{
+		"n":      "n",
+		"no":     "o",
+		"nov":    "o",
+		"v":      "x",
+		"\x16":   "x",
+		"i":      "i",
+		"R":      "i",
+		"c":      "c",
+		"t":      "t",
+		"visual": "x",
+		"":       "",
+	}
+	for mode, want := range tests {
+		if got := mappingMode(mode); got != want {
+			t.Errorf("mappingMode(%q) = %q, want %q", mode, got, want)
+		}
+	}
+}
+
+func TestEditorContextAppliesTo(t *testing.T) {
+	editor := ParseEditorContext(map[string]string{
+		interfaces.ContextMode:       "n",
+		interfaces.ContextFiletype:   "go",
+		interfaces.ContextBufferMaps: "n <leader>t",
+	})
+
+	hit := func(values map[string]interface{}) interfaces.VectorSearchResult {
+		metadata, _ := chroma.NewDocumentMetadataFromMap(values)
+		return interfaces.VectorSearchResult{Document: interfaces.Document{Metadata: metadata}}
+	}
+
+	tests := []struct {
+		name   string
+		result interfaces.VectorSearchResult
+		want   bool
+	}{
+		{"global mapping", hit(map[string]interface{}{"keys": "dd", "mode": "n"}), true},
+		{"mapping of this buffer", hit(map[string]interface{}{"keys": "<leader>t", "mode": "n", "buffer_local": "true"}), true},
+		{"mapping of another buffer", hit(map[string]interface{}{"keys": "<leader>x", "mode": "n", "buffer_local": "true"}), false},
+		{"mapping of this filetype", hit(map[string]interface{}{"keys": "gd", "mode": "n", "filetype": "go"}), true},
+		{"mapping of another filetype", hit(map[string]interface{}{"keys": "gd", "mode": "n", "filetype": "lua"}), false},
+	}
+	for _, tt := range tests {
+		if got := editor.appliesTo(tt.result); got != tt.want {
+			t.Errorf("%s: appliesTo = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	var none *EditorContext
+	if !none.appliesTo(tests[2].result) {
+		t.Error("Expected every hit to apply without an editor context")
+	}
+}
+
+func TestApplyFinalRankingPrefersEditorMode(t *testing.T) {
+	rg := NewResponseGenerator(&MockLLMClient{}, nil)
+	processedQuery := &ProcessedQuery{
+		Editor: ParseEditorContext(map[string]string{interfaces.ContextMode: "v"}),
+	}
+
+	results := []RankedResult{
+		{Keybinding: interfaces.Keybinding{Keys: "dd", Mode: "n"}, VectorScore: 0.5, RankingFactors: map[string]float64{}},
+		{Keybinding: interfaces.Keybinding{Keys: "d", Mode: "x"}, VectorScore: 0.5, RankingFactors: map[string]float64{}},
+	}
+	rg.applyFinalRanking(results, processedQuery)
+
+	if results[1].FinalRelevance <= results[0].FinalRelevance {
+		t.Errorf("Expected the visual mode binding to rank higher, got %f and %f",
+			results[0].FinalRelevance, results[1].FinalRelevance)
+	}
+	if results[1].RankingFactors["mode_match"] != modeMatchBoost {
+		t.Errorf("Expected a mode_match factor, got %v", results[1].RankingFactors)
+	}
+}
@@ -1,6 +1,7 @@
 package rag
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"regexp"
@@ -63,6 +64,9 @@ type ProcessedQuery struct {
 	SearchTerms    []string
 	BoostFactors   map[string]float64
 	ProcessingTime time.Duration
+
+	// Editor is the editor state the query was made in, or nil when unknown
+	Editor *EditorContext
 }
 
 // ContextBuilder defines interface for building context from search results
@@ -94,6 +98,12 @@ func NewQueryProcessor(llmClient interfaces.LLMClient, vectorDB interfaces.Vecto
 
 // ProcessQuery performs intelligent processing of a user query
 func (qp *QueryProcessor) ProcessQuery(query string) (*ProcessedQuery, error) {
+	return qp.ProcessQueryContext(context.Background(), query)
+}
+
+// ProcessQueryContext performs intelligent processing of a user query, skipping
+// the remaining LLM-backed steps once ctx is cancelled
+func (qp *QueryProcessor) ProcessQueryContext(ctx context.Context, query string) (*ProcessedQuery, error) {
 	start := time.Now()
 
 	log.Printf("Processing query with intelligent understanding: %s", query)
@@ -106,7 +116,9 @@ func (qp *QueryProcessor) ProcessQuery(query string) (*ProcessedQuery, error) {
 
 	// Step 1: Detect intent
 	if qp.config.EnableIntentDetection {
-		intent, err := qp.detectIntent(query)
+		stageStart := time.Now()
+		intent, err := qp.detectIntent(ctx, query)
+		interfaces.RecordStage(ctx, interfaces.StageIntentDetection, stageStart)
 		if err != nil {
 			log.Printf("Intent detection failed: %v", err)
 		} else {
@@ -117,7 +129,9 @@ func (qp *QueryProcessor) ProcessQuery(query string) (*ProcessedQuery, error) {
 
 	// Step 2: Expand query with synonyms and variations
 	if qp.config.EnableQueryExpansion {
-		expanded, synonyms, err := qp.expandQuery(query, processed.Intent)
+		stageStart := time.Now()
+		expanded, synonyms, err := qp.expandQuery(ctx, query, processed.Intent)
+		interfaces.RecordStage(ctx, interfaces.StageQueryExpansion, stageStart)
 		if err != nil {
 			log.Printf("Query expansion failed: %v", err)
 			processed.Expanded = query
@@ -130,6 +144,11 @@ func (qp *QueryProcessor) ProcessQuery(query string) (*ProcessedQuery, error) {
 		processed.Expanded = query
 	}
 
+	// Bail out before vector search if the caller gave up while the LLM was busy
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Step 3: Extract search terms
 	processed.SearchTerms = qp.extractSearchTerms(processed.Expanded)
 
@@ -143,7 +162,7 @@ func (qp *QueryProcessor) ProcessQuery(query string) (*ProcessedQuery, error) {
 }
 
 // detectIntent analyzes the query to determine user intent
-func (qp *QueryProcessor) detectIntent(query string) (*QueryIntent, error) {
+func (qp *QueryProcessor) detectIntent(ctx context.Context, query string) (*QueryIntent, error) {
 	// First, try pattern-based intent detection
 	patternIntent := qp.detectIntentByPatterns(query)
 	if patternIntent != nil && patternIntent.Confidence > 0.7 {
@@ -151,7 +170,7 @@ func (qp *QueryProcessor) detectIntent(query string) (*QueryIntent, error) {
 	}
 
 	// If pattern detection is not confident enough, use LLM
-	llmIntent, err := qp.detectIntentByLLM(query)
+	llmIntent, err := qp.detectIntentByLLM(ctx, query)
 	if err != nil {
 		// Fallback to pattern-based result even if confidence is low
 		if patternIntent != nil {
@@ -194,7 +213,7 @@ func (qp *QueryProcessor) detectIntentByPatterns(query string) *QueryIntent {
 }
 
 // detectIntentByLLM uses LLM to detect query intent
-func (qp *QueryProcessor) detectIntentByLLM(query string) (*QueryIntent, error) {
+func (qp *QueryProcessor) detectIntentByLLM(ctx context.Context, query string) (*QueryIntent, error) {
 	prompt := qp.buildIntentDetectionPrompt(query)
 
 	llmRequest := interfaces.LLMRequest{
@@ -203,7 +222,7 @@ func (qp *QueryProcessor) detectIntentByLLM(query string) (*QueryIntent, error)
 		Temperature: 0.1, // Low temperature for consistent intent detection
 	}
 
-	response, err := qp.llmClient.Generate(llmRequest)
+	response, err := qp.llmClient.GenerateContext(ctx, llmRequest)
 	if err != nil {
 		return nil, fmt.Errorf("failed to detect intent via LLM: %w", err)
 	}
@@ -297,7 +316,7 @@ func (qp *QueryProcessor) parseIntentFromLLMResponse(response, query string) (*Q
 }
 
 // expandQuery expands the query with synonyms and related terms
-func (qp *QueryProcessor) expandQuery(query string, intent *QueryIntent) (string, []string, error) {
+func (qp *QueryProcessor) expandQuery(ctx context.Context, query string, intent *QueryIntent) (string, []string, error) {
 	var allSynonyms []string
 
 	// Get synonyms from built-in map
@@ -311,7 +330,7 @@ func (qp *QueryProcessor) expandQuery(query string, intent *QueryIntent) (string
 	}
 
 	// Use LLM for additional expansion
-	llmSynonyms, err := qp.getLLMBasedExpansion(query, intent)
+	llmSynonyms, err := qp.getLLMBasedExpansion(ctx, query, intent)
 	if err != nil {
 		log.Printf("LLM-based expansion failed: %v", err)
 	} else {
@@ -370,7 +389,7 @@ func (qp *QueryProcessor) getIntentBasedSynonyms(query string, intent *QueryInte
 }
 
 // getLLMBasedExpansion uses LLM to expand query terms
-func (qp *QueryProcessor) getLLMBasedExpansion(query string, intent *QueryIntent) ([]string, error) {
+func (qp *QueryProcessor) getLLMBasedExpansion(ctx context.Context, query string, intent *QueryIntent) ([]string, error) {
 	prompt := qp.buildExpansionPrompt(query, intent)
 
 	llmRequest := interfaces.LLMRequest{
@@ -379,7 +398,7 @@ func (qp *QueryProcessor) getLLMBasedExpansion(query string, intent *QueryIntent
 		Temperature: 0.3,
 	}
 
-	response, err := qp.llmClient.Generate(llmRequest)
+	response, err := qp.llmClient.GenerateContext(ctx, llmRequest)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get LLM expansion: %w", err)
 	}
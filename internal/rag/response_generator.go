@@ -1,6 +1,7 @@
 package rag
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"regexp"
@@ -27,7 +28,7 @@ type ResponseGeneratorConfig struct {
 	EnableRanking      bool
 	UserBoostFactor    float64
 	RelevanceThreshold float64
-	MaxFinalResults    int
+	MaxFinalResults    int // Results returned when the query does not set a limit
 }
 
 // DefaultResponseGeneratorConfig returns default configuration
@@ -90,13 +91,18 @@ func NewResponseGenerator(llmClient interfaces.LLMClient, config *ResponseGenera
 }
 
 // GenerateAndParseResponse generates LLM response and parses it into structured results
-func (rg *ResponseGenerator) GenerateAndParseResponse(query, context string) (*ResponseAnalysis, error) {
+func (rg *ResponseGenerator) GenerateAndParseResponse(query, promptContext string) (*ResponseAnalysis, error) {
+	return rg.GenerateAndParseResponseContext(context.Background(), query, promptContext)
+}
+
+// GenerateAndParseResponseContext generates and parses an LLM response, aborting when ctx is cancelled
+func (rg *ResponseGenerator) GenerateAndParseResponseContext(ctx context.Context, query, promptContext string) (*ResponseAnalysis, error) {
 	start := time.Now()
 
 	log.Printf("Generating LLM response for query: %s", query)
 
 	// Generate LLM response
-	llmResponse, err := rg.generateResponse(query, context)
+	llmResponse, err := rg.generateResponse(ctx, query, promptContext)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate LLM response: %w", err)
 	}
@@ -104,8 +110,14 @@ func (rg *ResponseGenerator) GenerateAndParseResponse(query, context string) (*R
 	// Parse the response
 	analysis, err := rg.parseResponse(llmResponse.Text, query)
 	if err != nil {
+		if trace := interfaces.QueryTraceFromContext(ctx); trace != nil {
+			trace.ParseErrors = append(trace.ParseErrors, err.Error())
+		}
 		return nil, fmt.Errorf("failed to parse LLM response: %w", err)
 	}
+	if trace := interfaces.QueryTraceFromContext(ctx); trace != nil {
+		trace.ParseErrors = append(trace.ParseErrors, analysis.ParseErrors...)
+	}
 
 	analysis.ProcessingTime = time.Since(start)
 	log.Printf("Response generation and parsing completed in %v", analysis.ProcessingTime)
@@ -114,17 +126,28 @@ func (rg *ResponseGenerator) GenerateAndParseResponse(query, context string) (*R
 }
 
 // generateResponse generates LLM response using specialized prompt
-func (rg *ResponseGenerator) generateResponse(query, context string) (*interfaces.LLMResponse, error) {
-	prompt := rg.buildResponsePrompt(query, context)
+func (rg *ResponseGenerator) generateResponse(ctx context.Context, query, promptContext string) (*interfaces.LLMResponse, error) {
+	prompt := rg.buildResponsePrompt(query, promptContext)
 
 	llmRequest := interfaces.LLMRequest{
 		Prompt:      prompt,
-		Context:     context,
+		Context:     promptContext,
 		MaxTokens:   rg.config.MaxResponseTokens,
 		Temperature: rg.config.Temperature,
 	}
 
-	response, err := rg.llmClient.Generate(llmRequest)
+	response, err := rg.llmClient.GenerateContext(ctx, llmRequest)
+
+	if trace := interfaces.QueryTraceFromContext(ctx); trace != nil {
+		trace.Prompt = prompt
+		if err != nil {
+			trace.LLMError = err.Error()
+		} else {
+			trace.LLMResponse = response.Text
+			trace.LLMError = response.Error
+		}
+	}
+
 	if err != nil {
 		return nil, fmt.Errorf("LLM generation failed: %w", err)
 	}
@@ -452,6 +475,23 @@ func (rg *ResponseGenerator) RankAndCombineResults(
 	llmAnalysis *ResponseAnalysis,
 	processedQuery *ProcessedQuery,
 ) ([]interfaces.SearchResult, string, error) {
+	return rg.RankAndCombineResultsContext(context.Background(), query, vectorResults, llmAnalysis, processedQuery, 0)
+}
+
+// RankAndCombineResultsContext ranks results like RankAndCombineResults, returning at most
+// limit results (MaxFinalResults when limit is 0) and recording the ranking of every
+// candidate in the query trace of ctx
+func (rg *ResponseGenerator) RankAndCombineResultsContext(
+	ctx context.Context,
+	query string,
+	vectorResults []interfaces.VectorSearchResult,
+	llmAnalysis *ResponseAnalysis,
+	processedQuery *ProcessedQuery,
+	limit int,
+) ([]interfaces.SearchResult, string, error) {
+	if limit <= 0 {
+		limit = rg.config.MaxFinalResults
+	}
 
 	log.Printf("Ranking and combining %d vector results with %d LLM results",
 		len(vectorResults), len(llmAnalysis.ParsedResults))
@@ -510,9 +550,13 @@ func (rg *ResponseGenerator) RankAndCombineResults(
 		return rankedResults[i].FinalRelevance > rankedResults[j].FinalRelevance
 	})
 
+	if trace := interfaces.QueryTraceFromContext(ctx); trace != nil {
+		trace.Ranking = traceRanking(rankedResults, limit)
+	}
+
 	// Limit results
-	if len(rankedResults) > rg.config.MaxFinalResults {
-		rankedResults = rankedResults[:rg.config.MaxFinalResults]
+	if len(rankedResults) > limit {
+		rankedResults = rankedResults[:limit]
 	}
 
 	// Convert to final search results
@@ -532,6 +576,28 @@ func (rg *ResponseGenerator) RankAndCombineResults(
 	return finalResults, reasoning, nil
 }
 
+// traceRanking describes the ranking of sorted candidates, of which the first limit are returned
+func traceRanking(rankedResults []RankedResult, limit int) []interfaces.TracedRanking {
+	ranking := make([]interfaces.TracedRanking, len(rankedResults))
+	for i, ranked := range rankedResults {
+		factors := make(map[string]float64, len(ranked.RankingFactors))
+		for factor, value := range ranked.RankingFactors {
+			factors[factor] = value
+		}
+		ranking[i] = interfaces.TracedRanking{
+			ID:             ranked.Keybinding.ID,
+			Keys:           ranked.Keybinding.Keys,
+			VectorScore:    ranked.VectorScore,
+			LLMScore:       ranked.LLMScore,
+			Factors:        factors,
+			MatchedTerms:   ranked.MatchedTerms,
+			FinalRelevance: ranked.FinalRelevance,
+			Returned:       i < limit,
+		}
+	}
+	return ranking
+}
+
 // createRankedResult creates a ranked result from LLM recommendation
 func (rg *ResponseGenerator) createRankedResult(
 	llmResult ParsedKeybindingResult,
@@ -580,6 +646,8 @@ func (rg *ResponseGenerator) createRankedResult(
 		keybinding.Metadata["command"] = getMetadataStringFromResult(*vectorResult, "command")
 		keybinding.Metadata["keys"] = getMetadataStringFromResult(*vectorResult, "keys")
 		keybinding.Metadata["plugin"] = getMetadataStringFromResult(*vectorResult, "plugin")
+		keybinding.Metadata["buffer_local"] = getMetadataStringFromResult(*vectorResult, "buffer_local")
+		keybinding.Metadata["filetype"] = getMetadataStringFromResult(*vectorResult, "filetype")
 	} else {
 		// Generate ID for LLM-only result
 		keybinding.ID = fmt.Sprintf("llm_%s", strings.ReplaceAll(llmResult.Keys, " ", "_"))
@@ -639,6 +707,8 @@ func (rg *ResponseGenerator) createRankedResultFromVector(
 	keybinding.Metadata["source"] = getMetadataStringFromResult(vectorResult, "source")
 	keybinding.Metadata["updated_at"] = getMetadataStringFromResult(vectorResult, "updated_at")
 	keybinding.Metadata["vectorized_at"] = getMetadataStringFromResult(vectorResult, "vectorized_at")
+	keybinding.Metadata["buffer_local"] = getMetadataStringFromResult(vectorResult, "buffer_local")
+	keybinding.Metadata["filetype"] = getMetadataStringFromResult(vectorResult, "filetype")
 
 	// Generate basic explanation
 	explanation := rg.generateBasicExplanation(query, keybinding)
@@ -660,8 +730,16 @@ func (rg *ResponseGenerator) createRankedResultFromVector(
 
 // applyFinalRanking applies the final ranking algorithm to all results
 func (rg *ResponseGenerator) applyFinalRanking(results []RankedResult, processedQuery *ProcessedQuery) {
+	var editor *EditorContext
+	if processedQuery != nil {
+		editor = processedQuery.Editor
+	}
+
 	for i := range results {
 		result := &results[i]
+		for factor, value := range editor.rankingFactors(result.Keybinding) {
+			result.RankingFactors[factor] = value
+		}
 
 		// Base score combination (weighted average)
 		vectorWeight := 0.4
@@ -684,7 +762,7 @@ func (rg *ResponseGenerator) applyFinalRanking(results []RankedResult, processed
 				result.FinalRelevance += value
 			case "rank_position":
 				result.FinalRelevance += value * 0.05
-			case "vector_only":
+			case "vector_only", "mode_match", "filetype_match":
 				result.FinalRelevance += value
 			}
 		}
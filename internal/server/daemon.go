@@ -0,0 +1,386 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DaemonConfig holds configuration for the shared daemon
+type DaemonConfig struct {
+	// SocketPath is the Unix socket the daemon listens on
+	SocketPath string
+
+	// IdleTimeout shuts the daemon down once it has had no clients for this long (0 to never)
+	IdleTimeout time.Duration
+}
+
+// DefaultDaemonConfig returns default daemon configuration
+func DefaultDaemonConfig() *DaemonConfig {
+	return &DaemonConfig{
+		SocketPath:  DefaultSocketPath(),
+		IdleTimeout: 5 * time.Minute,
+	}
+}
+
+// DefaultSocketPath returns the well-known socket path shared by all clients of a user.
+// The socket is kept in a directory only that user may enter, as the temporary directory
+// it falls back to is shared with other users.
+func DefaultSocketPath() string {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		base = os.TempDir()
+	}
+	return filepath.Join(base, fmt.Sprintf("nvim-smart-keybind-search-%d", os.Getuid()), "daemon.sock")
+}
+
+// ErrDaemonRunning is returned when another daemon is already listening on the socket
+var ErrDaemonRunning = errors.New("daemon already running")
+
+// errUntrustedSocket is returned for sockets other users own or could have replaced
+var errUntrustedSocket = errors.New("socket is not private to the current user")
+
+// EnsureSocketDir creates the directory holding socketPath, closed to other users, or
+// checks that an existing one is
+func EnsureSocketDir(socketPath string) error {
+	dir := filepath.Dir(socketPath)
+	if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := os.Mkdir(dir, 0700); err != nil && !errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	return checkSocketDir(dir)
+}
+
+// checkSocketDir returns an error unless dir is a directory of the current user that
+// other users can neither enter nor write to
+func checkSocketDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", errUntrustedSocket, dir)
+	}
+	return checkPrivate(dir, info, true)
+}
+
+// checkSocket returns an error unless the socket file at path and its directory belong
+// to the current user, so whoever listens on it was started by that user
+func checkSocket(path string) error {
+	if err := checkSocketDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	return checkPrivate(path, info, false)
+}
+
+// dialDaemon connects to the daemon at socketPath once the socket is known to be ours
+func dialDaemon(socketPath string) (net.Conn, error) {
+	if err := checkSocket(socketPath); err != nil {
+		return nil, err
+	}
+	return net.Dial("unix", socketPath)
+}
+
+// ClientInfo describes a client connected to the daemon
+type ClientInfo struct {
+	ID          int       `json:"id"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// Daemon serves the JSON-RPC dispatcher to many clients over a Unix socket. Each
+// connection gets its own session, so ids and cancellations never cross clients.
+type Daemon struct {
+	dispatcher *Dispatcher
+	config     *DaemonConfig
+
+	mu        sync.Mutex
+	listener  net.Listener
+	clients   map[int]*ClientInfo
+	conns     map[int]net.Conn
+	nextID    int
+	idleTimer *time.Timer
+	closed    bool
+}
+
+// NewDaemon creates a new daemon for the given dispatcher
+func NewDaemon(dispatcher *Dispatcher, config *DaemonConfig) *Daemon {
+	if config == nil {
+		config = DefaultDaemonConfig()
+	}
+	if config.SocketPath == "" {
+		config.SocketPath = DefaultSocketPath()
+	}
+
+	return &Daemon{
+		dispatcher: dispatcher,
+		config:     config,
+		clients:    make(map[int]*ClientInfo),
+		conns:      make(map[int]net.Conn),
+	}
+}
+
+// Listen binds the socket, replacing a stale socket file left behind by a dead daemon.
+// It returns ErrDaemonRunning if a live daemon already owns the socket.
+func (d *Daemon) Listen() error {
+	path := d.config.SocketPath
+
+	// Binding inside a directory closed to other users leaves no window in which they
+	// could reach the socket before its permissions are set
+	if err := EnsureSocketDir(path); err != nil {
+		return fmt.Errorf("refusing socket directory of %s: %w", path, err)
+	}
+
+	if _, err := os.Lstat(path); err == nil {
+		if err := checkSocket(path); err != nil {
+			return fmt.Errorf("refusing socket %s: %w", path, err)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return ErrDaemonRunning
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		// Lost a race with another daemon starting at the same time
+		if checkSocket(path) != nil {
+			return fmt.Errorf("failed to listen on %s: %w", path, err)
+		}
+		if conn, dialErr := net.DialTimeout("unix", path, time.Second); dialErr == nil {
+			conn.Close()
+			return ErrDaemonRunning
+		}
+		return fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+
+	// Only the owning user may talk to the daemon
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	d.mu.Lock()
+	d.listener = listener
+	d.mu.Unlock()
+	return nil
+}
+
+// Serve accepts clients until the daemon is closed or stays idle for IdleTimeout.
+// Listen must have been called first.
+func (d *Daemon) Serve() error {
+	d.mu.Lock()
+	listener := d.listener
+	d.resetIdleTimerLocked()
+	d.mu.Unlock()
+
+	if listener == nil {
+		return fmt.Errorf("daemon is not listening")
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			d.mu.Lock()
+			closed := d.closed
+			d.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return fmt.Errorf("failed to accept client: %w", err)
+		}
+
+		id, ok := d.register(conn)
+		if !ok {
+			conn.Close()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer d.unregister(id)
+			defer conn.Close()
+
+			if err := d.dispatcher.Serve(conn, conn); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Client %d disconnected with error: %v", id, err)
+			}
+		}()
+	}
+}
+
+// Close stops accepting clients, disconnects the existing ones and removes the socket
+func (d *Daemon) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+
+	if d.idleTimer != nil {
+		d.idleTimer.Stop()
+	}
+	for _, conn := range d.conns {
+		conn.Close()
+	}
+
+	// Closing a unix listener also unlinks its socket file
+	if d.listener != nil {
+		return d.listener.Close()
+	}
+	return nil
+}
+
+// Clients returns the currently connected clients ordered by id
+func (d *Daemon) Clients() []ClientInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	clients := make([]ClientInfo, 0, len(d.clients))
+	for _, client := range d.clients {
+		clients = append(clients, *client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients
+}
+
+// register adds a new connection to the client registry
+func (d *Daemon) register(conn net.Conn) (int, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return 0, false
+	}
+
+	d.nextID++
+	id := d.nextID
+	d.clients[id] = &ClientInfo{ID: id, ConnectedAt: time.Now()}
+	d.conns[id] = conn
+
+	if d.idleTimer != nil {
+		d.idleTimer.Stop()
+		d.idleTimer = nil
+	}
+
+	log.Printf("Client %d connected (%d active)", id, len(d.clients))
+	return id, true
+}
+
+// unregister removes a connection from the client registry
+func (d *Daemon) unregister(id int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.clients, id)
+	delete(d.conns, id)
+	log.Printf("Client %d disconnected (%d active)", id, len(d.clients))
+
+	if len(d.clients) == 0 && !d.closed {
+		d.resetIdleTimerLocked()
+	}
+}
+
+// resetIdleTimerLocked arms the idle shutdown timer; d.mu must be held
+func (d *Daemon) resetIdleTimerLocked() {
+	if d.config.IdleTimeout <= 0 || len(d.clients) > 0 {
+		return
+	}
+
+	if d.idleTimer != nil {
+		d.idleTimer.Stop()
+	}
+	d.idleTimer = time.AfterFunc(d.config.IdleTimeout, func() {
+		d.mu.Lock()
+		idle := len(d.clients) == 0
+		d.mu.Unlock()
+
+		if idle {
+			log.Printf("No clients for %v, shutting down daemon", d.config.IdleTimeout)
+			d.Close()
+		}
+	})
+}
+
+// ConnectDaemon connects to the daemon at socketPath. If none is running it calls
+// start and waits up to timeout for the new daemon to accept connections. Sockets that
+// other users own or could have replaced are refused rather than trusted.
+func ConnectDaemon(socketPath string, start func() error, timeout time.Duration) (net.Conn, error) {
+	conn, err := dialDaemon(socketPath)
+	if err == nil {
+		return conn, nil
+	}
+	if errors.Is(err, errUntrustedSocket) {
+		return nil, fmt.Errorf("refusing to connect to %s: %w", socketPath, err)
+	}
+
+	if start == nil {
+		return nil, fmt.Errorf("no daemon listening on %s", socketPath)
+	}
+	if err := start(); err != nil {
+		return nil, fmt.Errorf("failed to start daemon: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := dialDaemon(socketPath)
+		if err == nil {
+			return conn, nil
+		}
+		if errors.Is(err, errUntrustedSocket) {
+			return nil, fmt.Errorf("refusing to connect to %s: %w", socketPath, err)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("daemon did not start listening on %s within %v: %w", socketPath, timeout, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// ProxyConn relays messages between a local stdio pair and a daemon connection until
+// either side closes. Framing is passed through untouched.
+func ProxyConn(conn net.Conn, r io.Reader, w io.Writer) error {
+	responsesDone := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(w, conn)
+		responsesDone <- err
+	}()
+
+	_, err := io.Copy(conn, r)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to forward requests: %w", err)
+	}
+
+	// Half-close so the daemon sees EOF and answers the requests still in flight
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		unixConn.CloseWrite()
+	} else {
+		conn.Close()
+	}
+
+	if err := <-responsesDone; err != nil && !errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("failed to forward responses: %w", err)
+	}
+	return conn.Close()
+}
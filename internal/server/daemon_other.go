@@ -0,0 +1,18 @@
+//go:build !unix
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// DetachedProcAttr returns no special attributes on platforms without sessions
+func DetachedProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// checkPrivate accepts every file, as file ownership cannot be checked here
+func checkPrivate(path string, info os.FileInfo, dir bool) error {
+	return nil
+}
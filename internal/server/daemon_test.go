@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSocketPath returns a socket path in a directory Listen creates for it
+func testSocketPath(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "run", "daemon.sock")
+}
+
+// newTestDaemon starts a daemon echoing request methods on a temporary socket
+func newTestDaemon(t *testing.T, idleTimeout time.Duration) (*Daemon, string, <-chan error) {
+	t.Helper()
+
+	socketPath := testSocketPath(t)
+	dispatcher := NewDispatcher(func(ctx context.Context, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+		return req.Method, nil
+	}, nil)
+
+	daemon := NewDaemon(dispatcher, &DaemonConfig{SocketPath: socketPath, IdleTimeout: idleTimeout})
+	if err := daemon.Listen(); err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- daemon.Serve() }()
+	t.Cleanup(func() { daemon.Close() })
+
+	return daemon, socketPath, done
+}
+
+// call sends a single request over conn and returns its result
+func call(t *testing.T, conn net.Conn, reader *bufio.Reader, method string, id int) interface{} {
+	t.Helper()
+
+	request, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "method": method, "id": id})
+	if _, err := conn.Write(append(request, '\n')); err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+
+	var response JSONRPCResponse
+	if err := json.Unmarshal(line, &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.ID != float64(id) {
+		t.Errorf("expected response id %d, got %v", id, response.ID)
+	}
+	return response.Result
+}
+
+func TestDaemon_ServesMultipleClients(t *testing.T) {
+	daemon, socketPath, _ := newTestDaemon(t, 0)
+
+	first, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer first.Close()
+	second, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	// Both clients use the same id; each session answers only its own request
+	if result := call(t, first, bufio.NewReader(first), "HealthCheck", 1); result != "HealthCheck" {
+		t.Errorf("unexpected result for first client: %v", result)
+	}
+	if result := call(t, second, bufio.NewReader(second), "GetMetrics", 1); result != "GetMetrics" {
+		t.Errorf("unexpected result for second client: %v", result)
+	}
+
+	if clients := daemon.Clients(); len(clients) != 2 {
+		t.Fatalf("expected 2 registered clients, got %d", len(clients))
+	}
+
+	second.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(daemon.Clients()) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if clients := daemon.Clients(); len(clients) != 1 || clients[0].ID != 1 {
+		t.Errorf("expected only the first client to remain, got %+v", clients)
+	}
+}
+
+func TestDaemon_IdleTimeout(t *testing.T) {
+	_, socketPath, done := newTestDaemon(t, 100*time.Millisecond)
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	// A connected client keeps the daemon alive past the idle timeout
+	time.Sleep(200 * time.Millisecond)
+	if result := call(t, conn, bufio.NewReader(conn), "HealthCheck", 1); result != "HealthCheck" {
+		t.Errorf("unexpected result: %v", result)
+	}
+	conn.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected serve error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("daemon did not shut down after idle timeout")
+	}
+
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("expected socket to be removed on shutdown, got %v", err)
+	}
+}
+
+func TestDaemon_Listen(t *testing.T) {
+	_, socketPath, _ := newTestDaemon(t, 0)
+
+	// A live daemon keeps the socket
+	other := NewDaemon(nil, &DaemonConfig{SocketPath: socketPath})
+	if err := other.Listen(); err != ErrDaemonRunning {
+		t.Errorf("expected ErrDaemonRunning, got %v", err)
+	}
+
+	// A stale socket file is replaced
+	stalePath := testSocketPath(t)
+	if err := os.Mkdir(filepath.Dir(stalePath), 0700); err != nil {
+		t.Fatalf("failed to create socket directory: %v", err)
+	}
+	if err := os.WriteFile(stalePath, nil, 0600); err != nil {
+		t.Fatalf("failed to create stale socket: %v", err)
+	}
+	stale := NewDaemon(nil, &DaemonConfig{SocketPath: stalePath})
+	if err := stale.Listen(); err != nil {
+		t.Fatalf("expected stale socket to be replaced, got %v", err)
+	}
+	stale.Close()
+}
+
+func TestDaemon_PrivateSocketDirectory(t *testing.T) {
+	_, socketPath, _ := newTestDaemon(t, 0)
+	info, err := os.Stat(filepath.Dir(socketPath))
+	if err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("expected the socket directory to be created with mode 700, got %v (%v)", info.Mode(), err)
+	}
+
+	if path := DefaultSocketPath(); !strings.HasPrefix(filepath.Base(filepath.Dir(path)), "nvim-smart-keybind-search-") {
+		t.Errorf("expected the default socket in a directory of its own, got %s", path)
+	}
+}
+
+func TestDaemon_RefusesSharedSocketDirectory(t *testing.T) {
+	// Another user could have created the directory, or placed a socket in it
+	dir := filepath.Join(t.TempDir(), "shared")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatalf("failed to open directory: %v", err)
+	}
+	socketPath := filepath.Join(dir, "daemon.sock")
+
+	if err := NewDaemon(nil, &DaemonConfig{SocketPath: socketPath}).Listen(); !errors.Is(err, errUntrustedSocket) {
+		t.Errorf("expected Listen to refuse a shared directory, got %v", err)
+	}
+
+	// A socket someone else is listening on is not trusted either
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	started := false
+	start := func() error {
+		started = true
+		return nil
+	}
+	if _, err := ConnectDaemon(socketPath, start, time.Second); !errors.Is(err, errUntrustedSocket) || started {
+		t.Errorf("expected ConnectDaemon to refuse the socket without starting a daemon, got %v", err)
+	}
+}
+
+func TestConnectDaemon(t *testing.T) {
+	socketPath := testSocketPath(t)
+
+	if _, err := ConnectDaemon(socketPath, nil, time.Second); err == nil {
+		t.Fatalf("expected error without a daemon or start function")
+	}
+
+	var daemon *Daemon
+	start := func() error {
+		dispatcher := NewDispatcher(func(ctx context.Context, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+			return req.Method, nil
+		}, nil)
+		daemon = NewDaemon(dispatcher, &DaemonConfig{SocketPath: socketPath})
+		if err := daemon.Listen(); err != nil {
+			return err
+		}
+		go daemon.Serve()
+		return nil
+	}
+
+	conn, err := ConnectDaemon(socketPath, start, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer daemon.Close()
+
+	// Relay a request through the proxy as the Neovim client would
+	input := `{"jsonrpc":"2.0","method":"HealthCheck","id":7}` + "\n"
+	pr, pw := io.Pipe()
+	go func() {
+		defer pw.Close()
+		if err := ProxyConn(conn, strings.NewReader(input), pw); err != nil {
+			t.Errorf("unexpected proxy error: %v", err)
+		}
+	}()
+
+	responses := readResponses(t, pr)
+	if len(responses) != 1 || responses[0].Result != "HealthCheck" {
+		t.Errorf("expected relayed HealthCheck response, got %+v", responses)
+	}
+}
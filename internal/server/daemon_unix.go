@@ -0,0 +1,31 @@
+//go:build unix
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// DetachedProcAttr returns process attributes that start the daemon in its own session,
+// so it outlives the client that launched it and ignores that client's terminal signals
+func DetachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// checkPrivate returns an error unless path belongs to the current user. Directories
+// must also be closed to other users, so they cannot replace the files in them.
+func checkPrivate(path string, info os.FileInfo, dir bool) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("%w: cannot tell the owner of %s", errUntrustedSocket, path)
+	}
+	if int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%w: %s is owned by user %d", errUntrustedSocket, path, stat.Uid)
+	}
+	if dir && info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%w: %s has mode %o, expected 700", errUntrustedSocket, path, info.Mode().Perm())
+	}
+	return nil
+}
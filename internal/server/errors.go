@@ -1,8 +1,10 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"time"
 )
 
 // ErrorCode represents standardized error codes for RPC responses
@@ -10,10 +12,12 @@ type ErrorCode int
 
 const (
 	// Client errors (4xx equivalent)
-	ErrorCodeInvalidRequest ErrorCode = 4000
-	ErrorCodeInvalidQuery   ErrorCode = 4001
-	ErrorCodeQueryTooLong   ErrorCode = 4002
-	ErrorCodeRateLimited    ErrorCode = 4003
+	ErrorCodeInvalidRequest      ErrorCode = 4000
+	ErrorCodeInvalidQuery        ErrorCode = 4001
+	ErrorCodeQueryTooLong        ErrorCode = 4002
+	ErrorCodeRateLimited         ErrorCode = 4003
+	ErrorCodeRequestCancelled    ErrorCode = 4004
+	ErrorCodeUnsupportedProtocol ErrorCode = 4005
 
 	// Server errors (5xx equivalent)
 	ErrorCodeInternalError       ErrorCode = 5000
@@ -24,11 +28,64 @@ const (
 	ErrorCodeInitializationError ErrorCode = 5005
 )
 
+// Components named in error data, telling the client which part of the backend failed
+const (
+	ComponentRequest  = "request"
+	ComponentServer   = "server"
+	ComponentRAGAgent = "rag"
+	ComponentVectorDB = "vectordb"
+	ComponentLLM      = "llm"
+)
+
+// Retryable reports whether a request that failed with this code may succeed if sent
+// again later unchanged
+func (c ErrorCode) Retryable() bool {
+	switch c {
+	case ErrorCodeRateLimited, ErrorCodeRequestCancelled, ErrorCodeServiceUnavailable,
+		ErrorCodeRAGAgentError, ErrorCodeVectorDBError, ErrorCodeLLMError:
+		return true
+	}
+	return false
+}
+
+// Component returns the part of the backend an error with this code usually comes from
+func (c ErrorCode) Component() string {
+	switch {
+	case c == ErrorCodeRAGAgentError:
+		return ComponentRAGAgent
+	case c == ErrorCodeVectorDBError:
+		return ComponentVectorDB
+	case c == ErrorCodeLLMError:
+		return ComponentLLM
+	case c >= 4000 && c < 5000:
+		return ComponentRequest
+	}
+	return ComponentServer
+}
+
 // RPCError represents a structured error response
 type RPCError struct {
 	Code    ErrorCode `json:"code"`
 	Message string    `json:"message"`
 	Details string    `json:"details,omitempty"`
+
+	// Component overrides the component derived from Code
+	Component string `json:"component,omitempty"`
+
+	// RetryAfter tells the client how long to wait before retrying, if known
+	RetryAfter time.Duration `json:"-"`
+}
+
+// ErrorData is sent as the data of a JSON-RPC error so clients can react to the
+// failure instead of only showing its message
+type ErrorData struct {
+	Code      ErrorCode `json:"code"`
+	Details   string    `json:"details,omitempty"`
+	Retryable bool      `json:"retryable"`
+	Component string    `json:"component"`
+
+	// RetryAfterMs is how many milliseconds to wait before retrying, if known
+	RetryAfterMs int64 `json:"retry_after_ms,omitempty"`
 }
 
 // Error implements the error interface
@@ -51,6 +108,41 @@ func NewRPCError(code ErrorCode, message string, details ...string) *RPCError {
 	return err
 }
 
+// WithComponent sets the component that failed, for errors whose code does not tell
+func (e *RPCError) WithComponent(component string) *RPCError {
+	e.Component = component
+	return e
+}
+
+// Data returns the error data sent to the client
+func (e *RPCError) Data() *ErrorData {
+	component := e.Component
+	if component == "" {
+		component = e.Code.Component()
+	}
+	return &ErrorData{
+		Code:         e.Code,
+		Details:      e.Details,
+		Retryable:    e.Code.Retryable(),
+		Component:    component,
+		RetryAfterMs: e.RetryAfter.Milliseconds(),
+	}
+}
+
+// ToJSONRPCError converts an error returned by a method into a JSON-RPC error. An
+// RPCError anywhere in the chain keeps its code, details and component in the data.
+func ToJSONRPCError(err error) *JSONRPCError {
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) {
+		return &JSONRPCError{Code: JSONRPCInternalError, Message: err.Error()}
+	}
+	return &JSONRPCError{
+		Code:    JSONRPCInternalError,
+		Message: rpcErr.Message,
+		Data:    rpcErr.Data(),
+	}
+}
+
 // WrapError wraps a regular error into an RPC error with appropriate code
 func WrapError(err error, code ErrorCode, message string) *RPCError {
 	return &RPCError{
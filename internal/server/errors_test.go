@@ -2,6 +2,7 @@ package server
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 )
 
@@ -120,3 +121,56 @@ func TestIsServerError(t *testing.T) {
 		})
 	}
 }
+
+func TestToJSONRPCError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		message string
+		data    *ErrorData
+	}{
+		{
+			name:    "plain error",
+			err:     errors.New("boom"),
+			message: "boom",
+		},
+		{
+			name:    "client error",
+			err:     NewRPCError(ErrorCodeQueryTooLong, "query too long"),
+			message: "query too long",
+			data:    &ErrorData{Code: ErrorCodeQueryTooLong, Component: ComponentRequest},
+		},
+		{
+			name:    "wrapped dependency error",
+			err:     fmt.Errorf("sync: %w", WrapError(errors.New("connection refused"), ErrorCodeLLMError, "LLM unavailable")),
+			message: "LLM unavailable",
+			data:    &ErrorData{Code: ErrorCodeLLMError, Details: "connection refused", Retryable: true, Component: ComponentLLM},
+		},
+		{
+			name:    "component override",
+			err:     NewRPCError(ErrorCodeServiceUnavailable, "RAG agent not initialized").WithComponent(ComponentRAGAgent),
+			message: "RAG agent not initialized",
+			data:    &ErrorData{Code: ErrorCodeServiceUnavailable, Retryable: true, Component: ComponentRAGAgent},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonErr := ToJSONRPCError(tt.err)
+			if jsonErr.Code != JSONRPCInternalError || jsonErr.Message != tt.message {
+				t.Errorf("expected internal error %q, got %+v", tt.message, jsonErr)
+			}
+
+			if tt.data == nil {
+				if jsonErr.Data != nil {
+					t.Errorf("expected no data, got %+v", jsonErr.Data)
+				}
+				return
+			}
+			data, ok := jsonErr.Data.(*ErrorData)
+			if !ok || *data != *tt.data {
+				t.Errorf("expected data %+v, got %+v", tt.data, jsonErr.Data)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenMetricsContentType is the content type of the exported metrics
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// metricsPrefix prefixes the name of every exported metric
+const metricsPrefix = "keybind_search_"
+
+// collectionCounter is implemented by RAG agents that can count the documents of the
+// collections they search
+type collectionCounter interface {
+	CollectionCounts() (map[string]int, error)
+}
+
+// MetricsExporter serves the server's metrics, dependency health, collection sizes and
+// model information over HTTP in the OpenMetrics text format, for Prometheus to scrape
+type MetricsExporter struct {
+	address string
+	service func() *RPCService
+
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewMetricsExporter creates an exporter listening on address. service returns the RPC
+// service to report on, which changes each time the service manager is started.
+func NewMetricsExporter(address string, service func() *RPCService) *MetricsExporter {
+	exporter := &MetricsExporter{
+		address: address,
+		service: service,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", exporter.ServeHTTP)
+	exporter.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return exporter
+}
+
+// Start binds the listener and serves scrapes in the background
+func (e *MetricsExporter) Start() error {
+	listener, err := net.Listen("tcp", e.address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", e.address, err)
+	}
+	e.listener = listener
+
+	go func() {
+		if err := e.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Metrics exporter stopped: %v", err)
+		}
+	}()
+	log.Printf("Exporting metrics on http://%s/metrics", listener.Addr())
+	return nil
+}
+
+// Addr returns the address the exporter listens on, once started
+func (e *MetricsExporter) Addr() net.Addr {
+	if e.listener == nil {
+		return nil
+	}
+	return e.listener.Addr()
+}
+
+// Close stops the listener, waiting for scrapes in progress until ctx is done
+func (e *MetricsExporter) Close(ctx context.Context) error {
+	return e.server.Shutdown(ctx)
+}
+
+// ServeHTTP writes the current metrics
+func (e *MetricsExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	service := e.service()
+	if service == nil {
+		http.Error(w, "service not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", OpenMetricsContentType)
+	w.Write(WriteOpenMetrics(service))
+}
+
+// WriteOpenMetrics renders the metrics of a service in the OpenMetrics text format
+func WriteOpenMetrics(service *RPCService) []byte {
+	w := &openMetricsWriter{}
+
+	w.family("build", "info", "Version of the server")
+	w.sample("build_info", 1, "version", Version)
+
+	if service.healthMonitor != nil {
+		writePerformanceMetrics(w, service.healthMonitor.GetMetricsCollector())
+		writeHealth(w, service.healthMonitor.GetDetailedHealthStatus(service))
+	}
+
+	if counter, ok := service.ragAgent.(collectionCounter); ok {
+		if counts, err := counter.CollectionCounts(); err != nil {
+			log.Printf("Metrics exporter: failed to count collections: %v", err)
+		} else {
+			w.family("collection_documents", "gauge", "Number of documents in each collection")
+			for _, name := range sortedNames(counts) {
+				w.sample("collection_documents", float64(counts[name]), "collection", name)
+			}
+		}
+	}
+
+	if service.llmClient != nil {
+		if model, err := service.llmClient.GetModelInfo(); err == nil && model != nil {
+			w.family("llm_model", "info", "Model loaded by the LLM client")
+			w.sample("llm_model_info", 1, "name", model.Name, "version", model.Version, "size", model.Size, "status", model.Status)
+		}
+	}
+
+	w.buf.WriteString("# EOF\n")
+	return w.buf.Bytes()
+}
+
+// writePerformanceMetrics writes the query counters, throttled calls and latency histograms
+func writePerformanceMetrics(w *openMetricsWriter, collector *MetricsCollector) {
+	metrics := collector.GetMetrics()
+
+	w.family("start_time_seconds", "gauge", "Time the server started, in seconds since the epoch")
+	w.sample("start_time_seconds", float64(metrics.StartTime.UnixNano())/1e9)
+
+	w.family("queries", "counter", "Queries processed, by outcome")
+	w.sample("queries_total", float64(metrics.SuccessfulQueries), "result", "success")
+	w.sample("queries_total", float64(metrics.FailedQueries), "result", "failure")
+
+	w.family("throttled_requests", "counter", "Calls rejected by the rate limiter, by method")
+	for _, method := range sortedNames(metrics.ThrottledByMethod) {
+		w.sample("throttled_requests_total", float64(metrics.ThrottledByMethod[method]), "method", method)
+	}
+
+	methods, stages := collector.histograms()
+	w.histograms("rpc_duration_seconds", "Latency of RPC calls, by method", "method", methods)
+	w.histograms("query_stage_duration_seconds", "Latency of the stages of processing a query", "stage", stages)
+}
+
+// writeHealth writes the health of the service and of each dependency
+func writeHealth(w *openMetricsWriter, health *DetailedHealthStatus) {
+	w.family("up", "gauge", "Whether the service and all its dependencies are healthy")
+	w.sample("up", boolValue(health.Status == "healthy"))
+
+	w.family("dependency_up", "gauge", "Whether a dependency passed its last health check")
+	for _, name := range sortedNames(health.Dependencies) {
+		w.sample("dependency_up", boolValue(health.Dependencies[name].Status == "healthy"), "dependency", name)
+	}
+
+	w.family("dependency_check_duration_seconds", "gauge", "Duration of the last health check of a dependency")
+	for _, name := range sortedNames(health.Dependencies) {
+		w.sample("dependency_check_duration_seconds", health.Dependencies[name].ResponseTime.Seconds(), "dependency", name)
+	}
+}
+
+// openMetricsWriter builds an OpenMetrics text exposition
+type openMetricsWriter struct {
+	buf bytes.Buffer
+}
+
+// family writes the metadata of a metric family
+func (w *openMetricsWriter) family(name, metricType, help string) {
+	fmt.Fprintf(&w.buf, "# TYPE %s%s %s\n", metricsPrefix, name, metricType)
+	fmt.Fprintf(&w.buf, "# HELP %s%s %s\n", metricsPrefix, name, help)
+}
+
+// sample writes a single sample; labels are name-value pairs
+func (w *openMetricsWriter) sample(name string, value float64, labels ...string) {
+	w.buf.WriteString(metricsPrefix + name)
+	if len(labels) > 0 {
+		w.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&w.buf, "%s=\"%s\"", labels[i], escapeLabelValue(labels[i+1]))
+		}
+		w.buf.WriteByte('}')
+	}
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(formatValue(value))
+	w.buf.WriteByte('\n')
+}
+
+// histograms writes a histogram family with one histogram per label value
+func (w *openMetricsWriter) histograms(name, help, label string, histograms map[string]*latencyHistogram) {
+	w.family(name, "histogram", help)
+	for _, value := range sortedNames(histograms) {
+		histogram := histograms[value]
+
+		var cumulative int64
+		for i, bound := range latencyBuckets {
+			cumulative += histogram.counts[i]
+			w.sample(name+"_bucket", float64(cumulative), label, value, "le", formatValue(bound.Seconds()))
+		}
+		w.sample(name+"_bucket", float64(histogram.count), label, value, "le", "+Inf")
+		w.sample(name+"_count", float64(histogram.count), label, value)
+		w.sample(name+"_sum", histogram.sum.Seconds(), label, value)
+	}
+}
+
+// escapeLabelValue escapes a label value as the text format requires
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatValue formats a sample value
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// boolValue returns 1 for true and 0 for false
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// sortedNames returns the keys of a map in sorted order, so scrapes are stable
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingRAGAgent reports collection sizes, like the real agent
+type countingRAGAgent struct {
+	MockRAGAgent
+}
+
+func (m *countingRAGAgent) CollectionCounts() (map[string]int, error) {
+	return map[string]int{"user_keybindings": 3, "vim_knowledge": 120}, nil
+}
+
+func TestMetricsExporter(t *testing.T) {
+	service := NewRPCService(&countingRAGAgent{}, &MockVectorDB{}, &MockLLMClient{})
+	collector := service.healthMonitor.GetMetricsCollector()
+	collector.RecordQuery(30*time.Millisecond, true)
+	collector.RecordMethod("Query", 30*time.Millisecond)
+	collector.RecordThrottled("UpdateKeybindings")
+
+	exporter := NewMetricsExporter("127.0.0.1:0", func() *RPCService { return service })
+	if err := exporter.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer exporter.Close(context.Background())
+
+	resp, err := http.Get("http://" + exporter.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	text := string(body)
+
+	if resp.Header.Get("Content-Type") != OpenMetricsContentType {
+		t.Errorf("expected OpenMetrics content type, got %q", resp.Header.Get("Content-Type"))
+	}
+	for _, want := range []string{
+		`keybind_search_queries_total{result="success"} 1`,
+		`keybind_search_throttled_requests_total{method="UpdateKeybindings"} 1`,
+		`keybind_search_rpc_duration_seconds_bucket{method="Query",le="0.025"} 0`,
+		`keybind_search_rpc_duration_seconds_bucket{method="Query",le="0.05"} 1`,
+		`keybind_search_rpc_duration_seconds_count{method="Query"} 1`,
+		`keybind_search_dependency_up{dependency="vector_db"} 1`,
+		`keybind_search_collection_documents{collection="vim_knowledge"} 120`,
+		`keybind_search_llm_model_info{name="mock-model",version="",size="",status="loaded"} 1`,
+	} {
+		if !strings.Contains(text, want+"\n") {
+			t.Errorf("expected %q in:\n%s", want, text)
+		}
+	}
+	if !strings.HasSuffix(text, "# EOF\n") {
+		t.Errorf("expected exposition to end with # EOF")
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	if got := escapeLabelValue("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Errorf("unexpected escaping: %s", got)
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// Supported message framings
+const (
+	// FramingLine separates messages with newlines (the default)
+	FramingLine = "line"
+	// FramingContentLength prefixes each message with LSP-style headers
+	FramingContentLength = "content-length"
+)
+
+// ErrMessageTooLarge is returned for a message exceeding the configured size limit.
+// The offending message has been skipped, so reading can continue with the next one.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// MessageReader reads one framed JSON-RPC message at a time
+type MessageReader interface {
+	// ReadMessage returns the next message body, io.EOF at the end of the stream,
+	// or ErrMessageTooLarge for a message that was skipped
+	ReadMessage() ([]byte, error)
+}
+
+// MessageWriter writes one framed JSON-RPC message at a time
+type MessageWriter interface {
+	WriteMessage(body []byte) error
+}
+
+// NewMessageReader creates a reader for the given framing. maxSize bounds the size of
+// a single message in bytes; zero or less means unlimited.
+func NewMessageReader(r io.Reader, framing string, maxSize int) (MessageReader, error) {
+	switch framing {
+	case "", FramingLine:
+		return &lineReader{reader: bufio.NewReader(r), maxSize: maxSize}, nil
+	case FramingContentLength:
+		return &contentLengthReader{reader: bufio.NewReader(r), maxSize: maxSize}, nil
+	default:
+		return nil, fmt.Errorf("unknown framing %q", framing)
+	}
+}
+
+// NewMessageWriter creates a writer for the given framing
+func NewMessageWriter(w io.Writer, framing string) (MessageWriter, error) {
+	switch framing {
+	case "", FramingLine:
+		return &lineWriter{writer: w}, nil
+	case FramingContentLength:
+		return &contentLengthWriter{writer: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown framing %q", framing)
+	}
+}
+
+// lineReader reads newline-delimited messages of any length. Unlike bufio.Scanner it
+// has no fixed token limit, it only enforces maxSize.
+type lineReader struct {
+	reader  *bufio.Reader
+	maxSize int
+}
+
+// ReadMessage returns the next non-empty line
+func (lr *lineReader) ReadMessage() ([]byte, error) {
+	for {
+		var message []byte
+		tooLarge := false
+
+		for {
+			chunk, err := lr.reader.ReadSlice('\n')
+			if !tooLarge {
+				message = append(message, chunk...)
+				if lr.maxSize > 0 && len(bytes.TrimRight(message, "\r\n")) > lr.maxSize {
+					// Keep consuming the line so the next read starts on a fresh message
+					tooLarge = true
+					message = nil
+				}
+			}
+
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err != nil {
+				if err == io.EOF && (tooLarge || len(bytes.TrimSpace(message)) > 0) {
+					break
+				}
+				return nil, err
+			}
+			break
+		}
+
+		if tooLarge {
+			return nil, ErrMessageTooLarge
+		}
+
+		message = bytes.TrimSpace(message)
+		if len(message) > 0 {
+			return message, nil
+		}
+	}
+}
+
+// contentLengthReader reads messages framed by a Content-Length header, as in LSP
+type contentLengthReader struct {
+	reader  *bufio.Reader
+	maxSize int
+}
+
+// ReadMessage reads the headers and then exactly Content-Length bytes of body
+func (cr *contentLengthReader) ReadMessage() ([]byte, error) {
+	headers, err := textproto.NewReader(cr.reader).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(headers) == 0 {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("failed to read message headers: %w", err)
+	}
+
+	value := strings.TrimSpace(headers.Get("Content-Length"))
+	if value == "" {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+
+	length, err := strconv.Atoi(value)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length header: %q", value)
+	}
+
+	if cr.maxSize > 0 && length > cr.maxSize {
+		// Skip the body so the stream stays in sync
+		if _, err := io.CopyN(io.Discard, cr.reader, int64(length)); err != nil {
+			return nil, fmt.Errorf("failed to skip oversized message: %w", err)
+		}
+		return nil, ErrMessageTooLarge
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(cr.reader, body); err != nil {
+		return nil, fmt.Errorf("failed to read message body: %w", err)
+	}
+	return bytes.TrimSpace(body), nil
+}
+
+// lineWriter writes each message followed by a newline
+type lineWriter struct {
+	writer io.Writer
+}
+
+// WriteMessage writes the body and a trailing newline in a single write
+func (lw *lineWriter) WriteMessage(body []byte) error {
+	message := make([]byte, 0, len(body)+1)
+	message = append(message, body...)
+	message = append(message, '\n')
+	_, err := lw.writer.Write(message)
+	return err
+}
+
+// contentLengthWriter writes each message prefixed with a Content-Length header
+type contentLengthWriter struct {
+	writer io.Writer
+}
+
+// WriteMessage writes the header and body in a single write
+func (cw *contentLengthWriter) WriteMessage(body []byte) error {
+	header := fmt.Sprintf("Content-Length: %d\r\n\r\n", len(body))
+	message := make([]byte, 0, len(header)+len(body))
+	message = append(message, header...)
+	message = append(message, body...)
+	_, err := cw.writer.Write(message)
+	return err
+}
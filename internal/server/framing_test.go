@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLineReader_LargeMessages(t *testing.T) {
+	// Well beyond bufio.Scanner's 64KB default and bufio.Reader's 4KB buffer
+	large := `{"data":"` + strings.Repeat("x", 1<<20) + `"}`
+	input := large + "\n\n" + `{"small":true}` + "\r\n" + `{"last":1}`
+
+	reader, err := NewMessageReader(strings.NewReader(input), FramingLine, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{large, `{"small":true}`, `{"last":1}`}
+	for i, want := range expected {
+		message, err := reader.ReadMessage()
+		if err != nil {
+			t.Fatalf("message %d: unexpected error: %v", i, err)
+		}
+		if string(message) != want {
+			t.Errorf("message %d: expected %d bytes, got %d", i, len(want), len(message))
+		}
+	}
+
+	if _, err := reader.ReadMessage(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestLineReader_MaxSize(t *testing.T) {
+	input := strings.Repeat("x", 10000) + "\n" + `{"ok":true}` + "\n" + strings.Repeat("y", 101)
+
+	reader, _ := NewMessageReader(strings.NewReader(input), FramingLine, 100)
+
+	if _, err := reader.ReadMessage(); err != ErrMessageTooLarge {
+		t.Fatalf("expected ErrMessageTooLarge, got %v", err)
+	}
+
+	// The oversized line is skipped entirely and reading resumes with the next one
+	message, err := reader.ReadMessage()
+	if err != nil || string(message) != `{"ok":true}` {
+		t.Errorf("expected next message after oversized one, got %q (%v)", message, err)
+	}
+
+	if _, err := reader.ReadMessage(); err != ErrMessageTooLarge {
+		t.Errorf("expected ErrMessageTooLarge for unterminated last line, got %v", err)
+	}
+	if _, err := reader.ReadMessage(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestContentLengthFraming(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := NewMessageWriter(&buf, FramingContentLength)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Bodies may contain newlines, which line framing could not carry
+	messages := []string{`{"a":1}`, "{\n\"b\": 2\n}", strings.Repeat("z", 500), `{"c":3}`}
+	for _, message := range messages {
+		if err := writer.WriteMessage([]byte(message)); err != nil {
+			t.Fatalf("unexpected write error: %v", err)
+		}
+	}
+
+	if !strings.HasPrefix(buf.String(), "Content-Length: 7\r\n\r\n{\"a\":1}") {
+		t.Errorf("unexpected wire format: %q", buf.String()[:30])
+	}
+
+	reader, _ := NewMessageReader(&buf, FramingContentLength, 100)
+	for i, want := range messages {
+		message, err := reader.ReadMessage()
+		if len(want) > 100 {
+			if err != ErrMessageTooLarge {
+				t.Errorf("message %d: expected ErrMessageTooLarge, got %v", i, err)
+			}
+			continue
+		}
+		if err != nil || string(message) != want {
+			t.Errorf("message %d: expected %q, got %q (%v)", i, want, message, err)
+		}
+	}
+
+	if _, err := reader.ReadMessage(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestContentLengthReader_InvalidHeaders(t *testing.T) {
+	tests := []string{
+		"Content-Type: application/json\r\n\r\n{}",
+		"Content-Length: abc\r\n\r\n{}",
+		"Content-Length: 10\r\n\r\n{}",
+	}
+
+	for _, input := range tests {
+		reader, _ := NewMessageReader(strings.NewReader(input), FramingContentLength, 0)
+		if _, err := reader.ReadMessage(); err == nil || err == io.EOF || err == ErrMessageTooLarge {
+			t.Errorf("expected framing error for %q, got %v", input, err)
+		}
+	}
+}
+
+func TestNewMessageReader_UnknownFraming(t *testing.T) {
+	if _, err := NewMessageReader(strings.NewReader(""), "xml", 0); err == nil {
+		t.Errorf("expected error for unknown framing")
+	}
+	if _, err := NewMessageWriter(io.Discard, "xml"); err == nil {
+		t.Errorf("expected error for unknown framing")
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ProtocolVersion is the RPC protocol version spoken by this server. Clients with a
+// different major version are rejected during Initialize.
+const ProtocolVersion = "1.0"
+
+// InitializeMethod is the handshake method clients call before anything else
+const InitializeMethod = "Initialize"
+
+// Optional protocol features negotiated during Initialize
+const (
+	FeatureCancellation = "cancellation"
+	FeatureStreaming    = "streaming"
+	FeatureProgress     = "progress"
+)
+
+// supportedFeatures lists the features this server can enable for a client
+var supportedFeatures = []string{FeatureCancellation, FeatureStreaming, FeatureProgress}
+
+// SessionState holds per-connection handshake state shared by all requests of a session
+type SessionState struct {
+	mu          sync.RWMutex
+	initialized bool
+	client      InitializeArgs
+	features    map[string]bool
+}
+
+// NewSessionState creates the state of a fresh, not yet initialized session
+func NewSessionState() *SessionState {
+	return &SessionState{features: make(map[string]bool)}
+}
+
+// Initialized reports whether the client has completed the handshake
+func (st *SessionState) Initialized() bool {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.initialized
+}
+
+// HasFeature reports whether a feature was negotiated for this session
+func (st *SessionState) HasFeature(feature string) bool {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.features[feature]
+}
+
+// Client returns the client information sent during the handshake
+func (st *SessionState) Client() InitializeArgs {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.client
+}
+
+// initialize records a completed handshake
+func (st *SessionState) initialize(client InitializeArgs, features []string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.initialized = true
+	st.client = client
+	st.features = make(map[string]bool, len(features))
+	for _, feature := range features {
+		st.features[feature] = true
+	}
+}
+
+type sessionStateKey struct{}
+
+// WithSessionState returns a context carrying the session state
+func WithSessionState(ctx context.Context, state *SessionState) context.Context {
+	return context.WithValue(ctx, sessionStateKey{}, state)
+}
+
+// SessionStateFromContext returns the session state of the request, or nil outside a session
+func SessionStateFromContext(ctx context.Context) *SessionState {
+	state, _ := ctx.Value(sessionStateKey{}).(*SessionState)
+	return state
+}
+
+// InitializeArgs represents the arguments for the Initialize handshake
+type InitializeArgs struct {
+	ProtocolVersion string   `json:"protocol_version"`
+	ClientName      string   `json:"client_name,omitempty"`
+	NvimVersion     string   `json:"nvim_version,omitempty"`
+	Features        []string `json:"features,omitempty"`
+}
+
+// InitializeResult represents the server's side of the handshake
+type InitializeResult struct {
+	ServerVersion        string   `json:"server_version"`
+	ProtocolVersion      string   `json:"protocol_version"`
+	Methods              []string `json:"methods"`
+	Features             []string `json:"features"`
+	Model                string   `json:"model,omitempty"`
+	ModelVersion         string   `json:"model_version,omitempty"`
+	KnowledgeBaseVersion string   `json:"knowledge_base_version,omitempty"`
+}
+
+// knowledgeBaseVersioner is implemented by vector databases that know the version of
+// their bundled knowledge base
+type knowledgeBaseVersioner interface {
+	KnowledgeBaseVersion() string
+}
+
+// Initialize performs the handshake: it checks protocol compatibility, enables the
+// requested features this server supports and describes the server to the client
+func (s *RPCService) Initialize(ctx context.Context, args *InitializeArgs, result *InitializeResult) error {
+	if args == nil {
+		rpcErr := NewRPCError(ErrorCodeInvalidRequest, "arguments cannot be nil")
+		LogError(rpcErr, "Initialize")
+		return rpcErr
+	}
+
+	if !compatibleProtocol(args.ProtocolVersion) {
+		rpcErr := NewRPCError(ErrorCodeUnsupportedProtocol,
+			fmt.Sprintf("unsupported protocol version %q (server speaks %s)", args.ProtocolVersion, ProtocolVersion))
+		LogError(rpcErr, "Initialize")
+		return rpcErr
+	}
+
+	features := negotiateFeatures(args.Features)
+	if state := SessionStateFromContext(ctx); state != nil {
+		state.initialize(*args, features)
+	}
+
+	result.ServerVersion = Version
+	result.ProtocolVersion = ProtocolVersion
+	result.Features = features
+	if s.registry != nil {
+		result.Methods = s.registry.Methods()
+	}
+
+	if s.llmClient != nil {
+		if modelInfo, err := s.llmClient.GetModelInfo(); err == nil {
+			result.Model = modelInfo.Name
+			result.ModelVersion = modelInfo.Version
+		}
+	}
+
+	if versioner, ok := s.vectorDB.(knowledgeBaseVersioner); ok {
+		result.KnowledgeBaseVersion = versioner.KnowledgeBaseVersion()
+	}
+
+	return nil
+}
+
+// compatibleProtocol reports whether a client protocol version shares our major version
+func compatibleProtocol(version string) bool {
+	if version == "" {
+		return false
+	}
+	major := strings.SplitN(version, ".", 2)[0]
+	return major == strings.SplitN(ProtocolVersion, ".", 2)[0]
+}
+
+// negotiateFeatures returns the requested features this server supports, sorted
+func negotiateFeatures(requested []string) []string {
+	features := []string{}
+	for _, feature := range requested {
+		if containsString(supportedFeatures, feature) && !containsString(features, feature) {
+			features = append(features, feature)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
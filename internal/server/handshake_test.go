@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRPCService_Initialize(t *testing.T) {
+	registry := NewRegistry("test", Version)
+	service := NewRPCService(&MockRAGAgent{}, &MockVectorDB{}, &MockLLMClient{})
+	service.RegisterMethods(registry)
+
+	state := NewSessionState()
+	ctx := WithSessionState(context.Background(), state)
+
+	var result InitializeResult
+	err := service.Initialize(ctx, &InitializeArgs{
+		ProtocolVersion: "1.3",
+		NvimVersion:     "0.10.0",
+		Features:        []string{FeatureStreaming, FeatureCancellation, "telepathy"},
+	}, &result)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if result.ServerVersion != Version || result.ProtocolVersion != ProtocolVersion {
+		t.Errorf("unexpected versions: %+v", result)
+	}
+	if result.Model != "mock-model" {
+		t.Errorf("expected loaded model to be reported, got %q", result.Model)
+	}
+	if !containsString(result.Methods, "Query") || !containsString(result.Methods, InitializeMethod) {
+		t.Errorf("expected registered methods, got %v", result.Methods)
+	}
+	if len(result.Features) != 2 || result.Features[0] != FeatureCancellation || result.Features[1] != FeatureStreaming {
+		t.Errorf("expected only supported features to be enabled, got %v", result.Features)
+	}
+
+	if !state.Initialized() || !state.HasFeature(FeatureStreaming) || state.HasFeature("telepathy") {
+		t.Errorf("unexpected session state after handshake")
+	}
+	if state.Client().NvimVersion != "0.10.0" {
+		t.Errorf("expected client info to be recorded, got %+v", state.Client())
+	}
+
+	for _, version := range []string{"", "2.0", "0.9"} {
+		var result InitializeResult
+		err := service.Initialize(context.Background(), &InitializeArgs{ProtocolVersion: version}, &result)
+		if rpcErr, ok := err.(*RPCError); !ok || rpcErr.Code != ErrorCodeUnsupportedProtocol {
+			t.Errorf("expected unsupported protocol error for %q, got %v", version, err)
+		}
+	}
+}
+
+func TestDispatcher_RequireInitialize(t *testing.T) {
+	registry := NewRegistry("test", Version)
+	service := NewRPCService(&MockRAGAgent{}, &MockVectorDB{}, &MockLLMClient{})
+	service.RegisterMethods(registry)
+
+	dispatcher := NewDispatcher(registry.Handle, &DispatcherConfig{MaxInFlight: 1, RequireInitialize: true})
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	go func() {
+		defer outW.Close()
+		dispatcher.Serve(inR, outW)
+	}()
+
+	decoder := json.NewDecoder(outR)
+	send := func(line string) JSONRPCResponse {
+		t.Helper()
+		io.WriteString(inW, line+"\n")
+		var response JSONRPCResponse
+		if err := decoder.Decode(&response); err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		return response
+	}
+
+	if response := send(`{"jsonrpc":"2.0","method":"HealthCheck","id":1}`); response.Error == nil || response.Error.Code != JSONRPCServerNotInitialized {
+		t.Errorf("expected server not initialized error, got %+v", response)
+	}
+	if response := send(`{"jsonrpc":"2.0","method":"rpc.discover","id":2}`); response.Error != nil {
+		t.Errorf("expected rpc.discover to be allowed before Initialize, got %+v", response.Error)
+	}
+	if response := send(`{"jsonrpc":"2.0","method":"Initialize","params":{"protocol_version":"1.0"},"id":3}`); response.Error != nil {
+		t.Fatalf("unexpected Initialize error: %+v", response.Error)
+	}
+	if response := send(`{"jsonrpc":"2.0","method":"HealthCheck","id":4}`); response.Error != nil {
+		t.Errorf("expected HealthCheck to succeed after Initialize, got %+v", response.Error)
+	}
+	inW.Close()
+
+	// A new session starts uninitialized again
+	var output strings.Builder
+	dispatcher.Serve(strings.NewReader(`{"jsonrpc":"2.0","method":"HealthCheck","id":5}`), &output)
+	if !strings.Contains(output.String(), "-32002") {
+		t.Errorf("expected new session to require Initialize, got %s", output.String())
+	}
+}
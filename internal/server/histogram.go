@@ -0,0 +1,144 @@
+package server
+
+import (
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the latency histogram buckets; slower
+// observations go to an extra overflow bucket
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+}
+
+// LatencySummary describes the latencies observed by a histogram
+type LatencySummary struct {
+	Count int64         `json:"count"`
+	Mean  time.Duration `json:"mean"`
+	Min   time.Duration `json:"min"`
+	Max   time.Duration `json:"max"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+}
+
+// latencyHistogram counts durations in fixed buckets, so it takes constant memory
+// however many observations it holds. It is not safe for concurrent use.
+type latencyHistogram struct {
+	counts []int64
+	count  int64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// newLatencyHistogram creates an empty histogram
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{counts: make([]int64, len(latencyBuckets)+1)}
+}
+
+// observe adds a duration to the histogram
+func (h *latencyHistogram) observe(duration time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && duration > latencyBuckets[i] {
+		i++
+	}
+	h.counts[i]++
+
+	if h.count == 0 || duration < h.min {
+		h.min = duration
+	}
+	if duration > h.max {
+		h.max = duration
+	}
+	h.count++
+	h.sum += duration
+}
+
+// quantile estimates the q-th quantile (0-1) by interpolating within its bucket
+func (h *latencyHistogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := q * float64(h.count)
+	var seen int64
+	for i, count := range h.counts {
+		if count == 0 || float64(seen+count) < rank {
+			seen += count
+			continue
+		}
+
+		lower, upper := time.Duration(0), h.max
+		if i > 0 {
+			lower = latencyBuckets[i-1]
+		}
+		if i < len(latencyBuckets) {
+			upper = latencyBuckets[i]
+		}
+		estimate := lower + time.Duration(float64(upper-lower)*(rank-float64(seen))/float64(count))
+
+		// The observed extremes are exact, the interpolation is not
+		if estimate < h.min {
+			return h.min
+		}
+		if estimate > h.max {
+			return h.max
+		}
+		return estimate
+	}
+	return h.max
+}
+
+// summary describes the observations in the histogram
+func (h *latencyHistogram) summary() LatencySummary {
+	summary := LatencySummary{
+		Count: h.count,
+		Min:   h.min,
+		Max:   h.max,
+		P50:   h.quantile(0.5),
+		P90:   h.quantile(0.9),
+		P99:   h.quantile(0.99),
+	}
+	if h.count > 0 {
+		summary.Mean = h.sum / time.Duration(h.count)
+	}
+	return summary
+}
+
+// copyHistograms returns a deep copy of a set of histograms
+func copyHistograms(histograms map[string]*latencyHistogram) map[string]*latencyHistogram {
+	copies := make(map[string]*latencyHistogram, len(histograms))
+	for name, histogram := range histograms {
+		histogramCopy := *histogram
+		histogramCopy.counts = append([]int64(nil), histogram.counts...)
+		copies[name] = &histogramCopy
+	}
+	return copies
+}
+
+// summarize describes every histogram of a set, or returns nil for an empty set
+func summarize(histograms map[string]*latencyHistogram) map[string]LatencySummary {
+	if len(histograms) == 0 {
+		return nil
+	}
+
+	summaries := make(map[string]LatencySummary, len(histograms))
+	for name, histogram := range histograms {
+		summaries[name] = histogram.summary()
+	}
+	return summaries
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"nvim-smart-keybind-search/internal/interfaces"
+)
+
+func TestLatencyHistogram_Summary(t *testing.T) {
+	histogram := newLatencyHistogram()
+	if summary := histogram.summary(); summary != (LatencySummary{}) {
+		t.Errorf("expected empty summary, got %+v", summary)
+	}
+
+	// 90 fast observations and 10 slow ones
+	for i := 0; i < 90; i++ {
+		histogram.observe(3 * time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		histogram.observe(2 * time.Second)
+	}
+
+	summary := histogram.summary()
+	if summary.Count != 100 || summary.Min != 3*time.Millisecond || summary.Max != 2*time.Second {
+		t.Errorf("unexpected count or extremes: %+v", summary)
+	}
+	if summary.P50 < 2500*time.Microsecond || summary.P50 > 5*time.Millisecond {
+		t.Errorf("expected p50 in the 2.5-5ms bucket, got %v", summary.P50)
+	}
+	if summary.P90 > 5*time.Millisecond {
+		t.Errorf("expected p90 among the fast observations, got %v", summary.P90)
+	}
+	if summary.P99 < time.Second || summary.P99 > 2*time.Second {
+		t.Errorf("expected p99 among the slow observations, got %v", summary.P99)
+	}
+	if want := (90*3*time.Millisecond + 10*2*time.Second) / 100; summary.Mean != want {
+		t.Errorf("expected mean %v, got %v", want, summary.Mean)
+	}
+}
+
+// stageRAGAgent records a stage while processing a query, like the real agent
+type stageRAGAgent struct {
+	MockRAGAgent
+}
+
+func (m *stageRAGAgent) ProcessRequest(ctx context.Context, request *interfaces.QueryRequest) (*interfaces.QueryResult, error) {
+	interfaces.RecordStage(ctx, interfaces.StageLLMGeneration, time.Now().Add(-20*time.Millisecond))
+	return m.MockRAGAgent.ProcessRequest(ctx, request)
+}
+
+func TestRPCService_LatencyMetrics(t *testing.T) {
+	registry := NewRegistry("test", "1.0.0")
+	service := NewRPCService(&stageRAGAgent{}, &MockVectorDB{}, &MockLLMClient{})
+	service.RegisterMethods(registry)
+
+	for _, req := range []*JSONRPCRequest{
+		{JSONRPC: "2.0", Method: "Query", Params: json.RawMessage(`{"query":"delete line"}`)},
+		{JSONRPC: "2.0", Method: "HealthCheck"},
+		{JSONRPC: "2.0", Method: "HealthCheck"},
+	} {
+		if _, rpcErr := registry.Handle(context.Background(), req); rpcErr != nil {
+			t.Fatalf("unexpected error calling %s: %+v", req.Method, rpcErr)
+		}
+	}
+
+	var metrics PerformanceMetrics
+	service.GetMetrics(&GetMetricsArgs{}, &metrics)
+	if metrics.Methods["Query"].Count != 1 || metrics.Methods["HealthCheck"].Count != 2 {
+		t.Errorf("expected per-method latencies, got %+v", metrics.Methods)
+	}
+	if stage := metrics.Stages[interfaces.StageLLMGeneration]; stage.Count != 1 || stage.Max < 20*time.Millisecond {
+		t.Errorf("expected the LLM generation stage to be recorded, got %+v", metrics.Stages)
+	}
+}
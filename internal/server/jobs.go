@@ -0,0 +1,690 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"nvim-smart-keybind-search/internal/interfaces"
+)
+
+// Job states. Queued and running jobs are persisted and resumed after a restart.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Kinds of background jobs, named after the methods that start them
+const (
+	JobKindSyncKeybindings = "SyncKeybindings"
+	JobKindPullModel       = "PullModel"
+	JobKindRebuildDatabase = "RebuildDatabase"
+)
+
+// maxFinishedJobs bounds how many finished jobs are remembered
+const maxFinishedJobs = 50
+
+// ErrJobNotFound is returned for an unknown job id
+var ErrJobNotFound = errors.New("job not found")
+
+// JobProgress reports how far a running job has got
+type JobProgress struct {
+	Phase     string `json:"phase,omitempty"`
+	Processed int64  `json:"processed"`
+	Total     int64  `json:"total"`
+}
+
+// Job describes a background job
+type Job struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`
+	State      string          `json:"state"`
+	Progress   *JobProgress    `json:"progress,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Resumed    int             `json:"resumed,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the job has reached a final state
+func (j *Job) Finished() bool {
+	return j.State == JobSucceeded || j.State == JobFailed || j.State == JobCancelled
+}
+
+// JobFunc runs a job of one kind with the params it was submitted with. It should stop
+// early once ctx is cancelled and pass its progress to report.
+type JobFunc func(ctx context.Context, params json.RawMessage, report func(JobProgress)) (interface{}, error)
+
+// jobRecord is a job together with the params needed to run it again, as persisted
+type jobRecord struct {
+	Job
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// JobManager runs heavy operations in the background, one at a time in submission
+// order. Jobs are saved to a file, when one is configured, so that jobs interrupted by
+// a restart run again once the manager is started anew.
+type JobManager struct {
+	path    string
+	runners map[string]JobFunc
+
+	mu      sync.Mutex
+	jobs    map[string]*jobRecord
+	order   []string
+	cancels map[string]context.CancelFunc
+
+	started bool
+	wake    chan struct{}
+	ctx     context.Context
+	stop    context.CancelFunc
+	stopped chan struct{}
+}
+
+// NewJobManager creates a job manager persisting its jobs to path, or keeping them in
+// memory only when path is empty
+func NewJobManager(path string) *JobManager {
+	ctx, stop := context.WithCancel(context.Background())
+	return &JobManager{
+		path:    path,
+		runners: make(map[string]JobFunc),
+		jobs:    make(map[string]*jobRecord),
+		cancels: make(map[string]context.CancelFunc),
+		wake:    make(chan struct{}, 1),
+		ctx:     ctx,
+		stop:    stop,
+		stopped: make(chan struct{}),
+	}
+}
+
+// Handle sets the function running jobs of a kind. Handlers must be set before Start.
+func (jm *JobManager) Handle(kind string, run JobFunc) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	jm.runners[kind] = run
+}
+
+// Start loads the saved jobs and starts running them. Jobs that were queued or running
+// when the previous server stopped are queued again.
+func (jm *JobManager) Start() error {
+	err := jm.load()
+
+	jm.mu.Lock()
+	jm.started = true
+	jm.mu.Unlock()
+
+	go jm.work()
+	return err
+}
+
+// Close stops the manager. The running job is interrupted and stays saved as running,
+// so it resumes on the next Start.
+func (jm *JobManager) Close() {
+	jm.stop()
+
+	jm.mu.Lock()
+	started := jm.started
+	jm.mu.Unlock()
+	if started {
+		<-jm.stopped
+	}
+}
+
+// Submit queues a job of the given kind and returns it
+func (jm *JobManager) Submit(kind string, params interface{}) (*Job, error) {
+	if _, ok := jm.runners[kind]; !ok {
+		return nil, fmt.Errorf("unknown job kind %q", kind)
+	}
+
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal job params: %w", err)
+	}
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	jm.mu.Lock()
+	record := &jobRecord{
+		Job:    Job{ID: id, Kind: kind, State: JobQueued, CreatedAt: time.Now()},
+		Params: raw,
+	}
+	jm.jobs[id] = record
+	jm.order = append(jm.order, id)
+	jm.saveLocked()
+	job := record.Job
+	jm.mu.Unlock()
+
+	log.Printf("Queued %s job %s", kind, id)
+	jm.signal()
+	return &job, nil
+}
+
+// Get returns a snapshot of a job
+func (jm *JobManager) Get(id string) (*Job, error) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	record, ok := jm.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	job := record.Job
+	return &job, nil
+}
+
+// List returns snapshots of all known jobs, oldest first
+func (jm *JobManager) List() []Job {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	jobs := make([]Job, 0, len(jm.order))
+	for _, id := range jm.order {
+		jobs = append(jobs, jm.jobs[id].Job)
+	}
+	return jobs
+}
+
+// Cancel cancels a job. A queued job is cancelled right away; a running one is asked to
+// stop and is cancelled once its function returns.
+func (jm *JobManager) Cancel(id string) (*Job, error) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	record, ok := jm.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	switch record.State {
+	case JobQueued:
+		record.finish(JobCancelled)
+		jm.saveLocked()
+	case JobRunning:
+		if cancel, ok := jm.cancels[id]; ok {
+			cancel()
+		}
+	default:
+		return nil, fmt.Errorf("job %s already %s", id, record.State)
+	}
+
+	job := record.Job
+	return &job, nil
+}
+
+// signal wakes the worker up to look for queued jobs
+func (jm *JobManager) signal() {
+	select {
+	case jm.wake <- struct{}{}:
+	default:
+	}
+}
+
+// work runs queued jobs one at a time until the manager is closed
+func (jm *JobManager) work() {
+	defer close(jm.stopped)
+
+	for {
+		if id, ok := jm.nextQueued(); ok {
+			jm.run(id)
+			continue
+		}
+
+		select {
+		case <-jm.wake:
+		case <-jm.ctx.Done():
+			return
+		}
+	}
+}
+
+// nextQueued returns the oldest queued job, if any, unless the manager is closing
+func (jm *JobManager) nextQueued() (string, bool) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	if jm.ctx.Err() != nil {
+		return "", false
+	}
+	for _, id := range jm.order {
+		if jm.jobs[id].State == JobQueued {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// run runs a single job and records its outcome
+func (jm *JobManager) run(id string) {
+	jm.mu.Lock()
+	record := jm.jobs[id]
+	if record.State != JobQueued {
+		// Cancelled since it was picked
+		jm.mu.Unlock()
+		return
+	}
+	runner, ok := jm.runners[record.Kind]
+	if !ok {
+		record.Error = fmt.Sprintf("unknown job kind %q", record.Kind)
+		record.finish(JobFailed)
+		jm.saveLocked()
+		jm.mu.Unlock()
+		return
+	}
+
+	ctx, cancel := context.WithCancel(jm.ctx)
+	defer cancel()
+	jm.cancels[id] = cancel
+
+	now := time.Now()
+	record.State = JobRunning
+	record.StartedAt = &now
+	jm.saveLocked()
+	params := record.Params
+	jm.mu.Unlock()
+
+	log.Printf("Running %s job %s", record.Kind, id)
+	result, err := jm.runSafely(ctx, runner, params, func(progress JobProgress) {
+		jm.mu.Lock()
+		record.Progress = &progress
+		jm.mu.Unlock()
+	})
+
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	delete(jm.cancels, id)
+
+	switch {
+	case err != nil && jm.ctx.Err() != nil:
+		// Interrupted by Close: leave it running so the next Start resumes it
+		log.Printf("Interrupted %s job %s, it will resume on restart", record.Kind, id)
+	case err != nil && ctx.Err() != nil:
+		record.finish(JobCancelled)
+	case err != nil:
+		record.Error = err.Error()
+		record.finish(JobFailed)
+	default:
+		if result != nil {
+			raw, marshalErr := json.Marshal(result)
+			if marshalErr != nil {
+				log.Printf("Failed to marshal result of job %s: %v", id, marshalErr)
+			}
+			record.Result = raw
+		}
+		record.finish(JobSucceeded)
+	}
+	if record.Finished() {
+		log.Printf("Job %s %s", id, record.State)
+	}
+
+	jm.pruneLocked()
+	jm.saveLocked()
+}
+
+// runSafely runs a job function, turning a panic into an error
+func (jm *JobManager) runSafely(ctx context.Context, runner JobFunc, params json.RawMessage, report func(JobProgress)) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return runner(ctx, params, report)
+}
+
+// finish moves the job to a final state; its params are no longer needed
+func (r *jobRecord) finish(state string) {
+	now := time.Now()
+	r.State = state
+	r.FinishedAt = &now
+	r.Params = nil
+}
+
+// pruneLocked forgets the oldest finished jobs beyond maxFinishedJobs
+func (jm *JobManager) pruneLocked() {
+	finished := 0
+	for _, id := range jm.order {
+		if jm.jobs[id].Finished() {
+			finished++
+		}
+	}
+
+	kept := jm.order[:0]
+	for _, id := range jm.order {
+		if finished > maxFinishedJobs && jm.jobs[id].Finished() {
+			delete(jm.jobs, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	jm.order = kept
+}
+
+// load reads the saved jobs and queues the unfinished ones again
+func (jm *JobManager) load() error {
+	if jm.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(jm.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read jobs: %w", err)
+	}
+
+	var records []*jobRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("failed to parse jobs file %s: %w", jm.path, err)
+	}
+
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	for _, record := range records {
+		switch record.State {
+		case JobRunning:
+			record.Resumed++
+			record.State = JobQueued
+			record.StartedAt = nil
+			log.Printf("Resuming interrupted %s job %s", record.Kind, record.ID)
+		case JobQueued:
+			log.Printf("Requeuing %s job %s", record.Kind, record.ID)
+		}
+		jm.jobs[record.ID] = record
+		jm.order = append(jm.order, record.ID)
+	}
+	return nil
+}
+
+// saveLocked writes all jobs to the jobs file. The file is replaced atomically so a
+// crash never leaves it half written.
+func (jm *JobManager) saveLocked() {
+	if jm.path == "" {
+		return
+	}
+
+	records := make([]*jobRecord, 0, len(jm.order))
+	for _, id := range jm.order {
+		records = append(records, jm.jobs[id])
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(jm.path), 0755)
+	}
+	if err == nil {
+		tmp := jm.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, jm.path)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to save jobs to %s: %v", jm.path, err)
+	}
+}
+
+// newJobID returns a random job id
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// modelPuller is implemented by LLM clients that can download models
+type modelPuller interface {
+	PullModel(ctx context.Context, modelName string, report func(status string, completed, total int64)) error
+}
+
+// databaseRebuilder is implemented by vector databases that can be rebuilt from their
+// pre-built knowledge base
+type databaseRebuilder interface {
+	RebuildDatabase() error
+}
+
+// JobArgs represents the arguments of the methods taking a job id
+type JobArgs struct {
+	ID string `json:"id"`
+}
+
+// ListJobsArgs represents the arguments for the ListJobs RPC method
+type ListJobsArgs struct {
+	State string `json:"state,omitempty"`
+}
+
+// ListJobsResult represents the result of the ListJobs RPC method
+type ListJobsResult struct {
+	Jobs []Job `json:"jobs"`
+}
+
+// JobStartedResult represents the result of the methods that start a job
+type JobStartedResult struct {
+	JobID string `json:"job_id"`
+}
+
+// PullModelArgs represents the arguments for the PullModel RPC method
+type PullModelArgs struct {
+	Model string `json:"model"`
+	// Load makes the model the one used for queries once it is downloaded
+	Load bool `json:"load,omitempty"`
+}
+
+// PullModelResult represents the result of a finished PullModel job
+type PullModelResult struct {
+	Model  string `json:"model"`
+	Loaded bool   `json:"loaded"`
+}
+
+// RebuildDatabaseArgs represents the arguments for the RebuildDatabase RPC method
+type RebuildDatabaseArgs struct{}
+
+// RebuildDatabaseResult represents the result of a finished RebuildDatabase job
+type RebuildDatabaseResult struct {
+	Success bool `json:"success"`
+
+	// ResyncRequired is set as the rebuild drops the user's keybindings, which the client
+	// must sync again
+	ResyncRequired bool   `json:"resync_required"`
+	Message        string `json:"message"`
+}
+
+// SetJobManager sets the job manager running the service's background jobs and
+// registers the functions running each kind of job
+func (s *RPCService) SetJobManager(jobs *JobManager) {
+	s.jobs = jobs
+	jobs.Handle(JobKindSyncKeybindings, s.runSyncJob)
+	jobs.Handle(JobKindPullModel, s.runPullModelJob)
+	jobs.Handle(JobKindRebuildDatabase, s.runRebuildDatabaseJob)
+}
+
+// GetJobStatus reports the state, progress, timing and outcome of a job
+func (s *RPCService) GetJobStatus(args *JobArgs, result *Job) error {
+	if err := s.checkJobArgs(args, "GetJobStatus"); err != nil {
+		return err
+	}
+
+	job, err := s.jobs.Get(args.ID)
+	if err != nil {
+		rpcErr := WrapError(err, ErrorCodeInvalidRequest, "unknown job "+args.ID)
+		LogError(rpcErr, "GetJobStatus")
+		return rpcErr
+	}
+	*result = *job
+	return nil
+}
+
+// ListJobs lists the known jobs, oldest first, optionally only those in one state
+func (s *RPCService) ListJobs(args *ListJobsArgs, result *ListJobsResult) error {
+	if s.jobs == nil {
+		rpcErr := NewRPCError(ErrorCodeServiceUnavailable, "job manager not initialized")
+		LogError(rpcErr, "ListJobs")
+		return rpcErr
+	}
+
+	result.Jobs = []Job{}
+	for _, job := range s.jobs.List() {
+		if args == nil || args.State == "" || job.State == args.State {
+			result.Jobs = append(result.Jobs, job)
+		}
+	}
+	return nil
+}
+
+// CancelJob cancels a queued or running job
+func (s *RPCService) CancelJob(args *JobArgs, result *Job) error {
+	if err := s.checkJobArgs(args, "CancelJob"); err != nil {
+		return err
+	}
+
+	job, err := s.jobs.Cancel(args.ID)
+	if err != nil {
+		rpcErr := WrapError(err, ErrorCodeInvalidRequest, "cannot cancel job "+args.ID)
+		LogError(rpcErr, "CancelJob")
+		return rpcErr
+	}
+	*result = *job
+	return nil
+}
+
+// PullModel starts a job downloading a model
+func (s *RPCService) PullModel(args *PullModelArgs, result *JobStartedResult) error {
+	if args == nil || strings.TrimSpace(args.Model) == "" {
+		rpcErr := NewRPCError(ErrorCodeInvalidRequest, "model cannot be empty")
+		LogError(rpcErr, "PullModel")
+		return rpcErr
+	}
+	if _, ok := s.llmClient.(modelPuller); !ok {
+		rpcErr := NewRPCError(ErrorCodeServiceUnavailable, "LLM client cannot download models").WithComponent(ComponentLLM)
+		LogError(rpcErr, "PullModel")
+		return rpcErr
+	}
+	return s.startJob(JobKindPullModel, args, result, "PullModel")
+}
+
+// RebuildDatabase starts a job rebuilding the vector database from the pre-built
+// knowledge base. The user's keybindings have to be synced again afterwards.
+func (s *RPCService) RebuildDatabase(args *RebuildDatabaseArgs, result *JobStartedResult) error {
+	if _, ok := s.vectorDB.(databaseRebuilder); !ok {
+		rpcErr := NewRPCError(ErrorCodeServiceUnavailable, "vector database cannot be rebuilt").WithComponent(ComponentVectorDB)
+		LogError(rpcErr, "RebuildDatabase")
+		return rpcErr
+	}
+	return s.startJob(JobKindRebuildDatabase, &RebuildDatabaseArgs{}, result, "RebuildDatabase")
+}
+
+// checkJobArgs validates the arguments of the methods taking a job id
+func (s *RPCService) checkJobArgs(args *JobArgs, operation string) error {
+	if s.jobs == nil {
+		rpcErr := NewRPCError(ErrorCodeServiceUnavailable, "job manager not initialized")
+		LogError(rpcErr, operation)
+		return rpcErr
+	}
+	if args == nil || args.ID == "" {
+		rpcErr := NewRPCError(ErrorCodeInvalidRequest, "job id cannot be empty")
+		LogError(rpcErr, operation)
+		return rpcErr
+	}
+	return nil
+}
+
+// startJob submits a job and reports its id
+func (s *RPCService) startJob(kind string, params interface{}, result *JobStartedResult, operation string) error {
+	if s.jobs == nil {
+		rpcErr := NewRPCError(ErrorCodeServiceUnavailable, "job manager not initialized")
+		LogError(rpcErr, operation)
+		return rpcErr
+	}
+
+	job, err := s.jobs.Submit(kind, params)
+	if err != nil {
+		rpcErr := WrapError(err, ErrorCodeInternalError, "failed to start job")
+		LogError(rpcErr, operation)
+		return rpcErr
+	}
+	result.JobID = job.ID
+	return nil
+}
+
+// runSyncJob stores the keybindings of a SyncKeybindings job. Stores are upserts, so a
+// resumed job simply runs again from the start.
+func (s *RPCService) runSyncJob(ctx context.Context, params json.RawMessage, report func(JobProgress)) (interface{}, error) {
+	var args SyncKeybindingsArgs
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, fmt.Errorf("invalid job params: %w", err)
+	}
+
+	err := s.syncVectorDB(ctx, convertFromRPCKeybindings(args.Keybindings), args.ClearExisting, func(progress interfaces.SyncProgress) {
+		report(JobProgress{Phase: progress.Phase, Processed: int64(progress.Processed), Total: int64(progress.Total)})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SyncKeybindingsResult{Success: true, ProcessedCount: len(args.Keybindings)}, nil
+}
+
+// runPullModelJob downloads the model of a PullModel job
+func (s *RPCService) runPullModelJob(ctx context.Context, params json.RawMessage, report func(JobProgress)) (interface{}, error) {
+	var args PullModelArgs
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, fmt.Errorf("invalid job params: %w", err)
+	}
+
+	puller, ok := s.llmClient.(modelPuller)
+	if !ok {
+		return nil, fmt.Errorf("LLM client cannot download models")
+	}
+	err := puller.PullModel(ctx, args.Model, func(status string, completed, total int64) {
+		report(JobProgress{Phase: status, Processed: completed, Total: total})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PullModelResult{Model: args.Model}
+	if args.Load {
+		if err := s.llmClient.LoadModel(args.Model); err != nil {
+			return nil, fmt.Errorf("downloaded model %s but failed to load it: %w", args.Model, err)
+		}
+		result.Loaded = true
+	}
+	return result, nil
+}
+
+// runRebuildDatabaseJob rebuilds the vector database. The rebuild cannot be interrupted
+// half way, so cancelling only takes effect before it starts.
+func (s *RPCService) runRebuildDatabaseJob(ctx context.Context, params json.RawMessage, report func(JobProgress)) (interface{}, error) {
+	rebuilder, ok := s.vectorDB.(databaseRebuilder)
+	if !ok {
+		return nil, fmt.Errorf("vector database cannot be rebuilt")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	report(JobProgress{Phase: "rebuild"})
+	if err := rebuilder.RebuildDatabase(); err != nil {
+		return nil, err
+	}
+	return &RebuildDatabaseResult{
+		Success:        true,
+		ResyncRequired: true,
+		Message:        "the database was rebuilt without the user keybindings; sync them again",
+	}, nil
+}
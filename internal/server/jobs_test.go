@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitForJob polls a job until it reaches one of the given states
+func waitForJob(t *testing.T, jm *JobManager, id string, states ...string) *Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := jm.Get(id)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, state := range states {
+			if job.State == state {
+				return job
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not reach %v", id, states)
+	return nil
+}
+
+// blockingJob runs until its context is cancelled
+func blockingJob(ctx context.Context, params json.RawMessage, report func(JobProgress)) (interface{}, error) {
+	report(JobProgress{Phase: "waiting"})
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestJobManager_RunAndPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+
+	jm := NewJobManager(path)
+	jm.Handle("count", func(ctx context.Context, params json.RawMessage, report func(JobProgress)) (interface{}, error) {
+		var n int64
+		json.Unmarshal(params, &n)
+		report(JobProgress{Phase: "counting", Processed: n, Total: n})
+		return map[string]int64{"counted": n}, nil
+	})
+	if err := jm.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := jm.Submit("unknown", nil); err == nil {
+		t.Error("expected error for unknown job kind")
+	}
+
+	submitted, err := jm.Submit("count", 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	job := waitForJob(t, jm, submitted.ID, JobSucceeded)
+	if job.Progress == nil || job.Progress.Processed != 3 || string(job.Result) != `{"counted":3}` {
+		t.Errorf("unexpected job: %+v", job)
+	}
+	if job.StartedAt == nil || job.FinishedAt == nil || job.FinishedAt.Before(*job.StartedAt) {
+		t.Errorf("expected start and finish times, got %+v", job)
+	}
+	jm.Close()
+
+	// A new manager sees the finished job
+	reloaded := NewJobManager(path)
+	reloaded.Handle("count", blockingJob)
+	if err := reloaded.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reloaded.Close()
+
+	jobs := reloaded.List()
+	if len(jobs) != 1 || jobs[0].ID != submitted.ID || jobs[0].State != JobSucceeded {
+		t.Errorf("expected finished job to be reloaded, got %+v", jobs)
+	}
+}
+
+func TestJobManager_ResumeInterrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+
+	jm := NewJobManager(path)
+	jm.Handle("sync", blockingJob)
+	jm.Start()
+
+	submitted, _ := jm.Submit("sync", []string{"dd"})
+	waitForJob(t, jm, submitted.ID, JobRunning)
+	jm.Close()
+
+	if job, _ := jm.Get(submitted.ID); job.State != JobRunning {
+		t.Fatalf("expected interrupted job to stay running, got %s", job.State)
+	}
+
+	var resumedParams []string
+	resumed := NewJobManager(path)
+	resumed.Handle("sync", func(ctx context.Context, params json.RawMessage, report func(JobProgress)) (interface{}, error) {
+		return nil, json.Unmarshal(params, &resumedParams)
+	})
+	if err := resumed.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resumed.Close()
+
+	job := waitForJob(t, resumed, submitted.ID, JobSucceeded, JobFailed)
+	if job.State != JobSucceeded || job.Resumed != 1 {
+		t.Errorf("expected job to resume and succeed, got %+v", job)
+	}
+	if len(resumedParams) != 1 || resumedParams[0] != "dd" {
+		t.Errorf("expected resumed job to get its params, got %v", resumedParams)
+	}
+}
+
+func TestJobManager_Cancel(t *testing.T) {
+	jm := NewJobManager("")
+	jm.Handle("block", blockingJob)
+	jm.Start()
+	defer jm.Close()
+
+	running, _ := jm.Submit("block", nil)
+	queued, _ := jm.Submit("block", nil)
+	waitForJob(t, jm, running.ID, JobRunning)
+
+	// Jobs run one at a time, so the second one is still queued
+	if job, err := jm.Cancel(queued.ID); err != nil || job.State != JobCancelled {
+		t.Errorf("expected queued job to be cancelled right away, got %+v, %v", job, err)
+	}
+
+	if _, err := jm.Cancel(running.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitForJob(t, jm, running.ID, JobCancelled)
+
+	if _, err := jm.Cancel(running.ID); err == nil {
+		t.Error("expected error cancelling a finished job")
+	}
+	if _, err := jm.Cancel("missing"); err != ErrJobNotFound {
+		t.Errorf("expected ErrJobNotFound, got %v", err)
+	}
+}
+
+func TestRPCService_SyncKeybindingsAsync(t *testing.T) {
+	service := NewRPCService(&MockRAGAgent{}, &MockVectorDB{}, &MockLLMClient{})
+	jobs := NewJobManager("")
+	service.SetJobManager(jobs)
+	jobs.Start()
+	defer jobs.Close()
+
+	var result SyncKeybindingsResult
+	err := service.SyncKeybindings(&SyncKeybindingsArgs{
+		Keybindings: []Keybinding{{ID: "test1", Keys: "dd", Command: "delete line", Mode: "n"}},
+		Async:       true,
+	}, &result)
+	if err != nil || !result.Success || result.JobID == "" {
+		t.Fatalf("expected a job id, got %+v, %v", result, err)
+	}
+
+	waitForJob(t, jobs, result.JobID, JobSucceeded)
+
+	var status Job
+	if err := service.GetJobStatus(&JobArgs{ID: result.JobID}, &status); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var syncResult SyncKeybindingsResult
+	if err := json.Unmarshal(status.Result, &syncResult); err != nil || syncResult.ProcessedCount != 1 {
+		t.Errorf("expected sync result in job, got %s", string(status.Result))
+	}
+
+	var list ListJobsResult
+	service.ListJobs(&ListJobsArgs{State: JobSucceeded}, &list)
+	if len(list.Jobs) != 1 || list.Jobs[0].Kind != JobKindSyncKeybindings {
+		t.Errorf("expected the sync job to be listed, got %+v", list.Jobs)
+	}
+
+	if err := service.GetJobStatus(&JobArgs{ID: "missing"}, &status); err == nil {
+		t.Error("expected error for unknown job")
+	}
+	var started JobStartedResult
+	if err := service.PullModel(&PullModelArgs{}, &started); err == nil {
+		t.Error("expected error for missing model name")
+	}
+}
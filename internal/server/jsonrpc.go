@@ -0,0 +1,677 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
+
+// Standard JSON-RPC 2.0 error codes
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+
+	// JSONRPCMessageTooLarge is returned for messages exceeding DispatcherConfig.MaxMessageSize
+	JSONRPCMessageTooLarge = -32001
+
+	// JSONRPCServerNotInitialized is returned in strict mode for calls made before Initialize (as in LSP)
+	JSONRPCServerNotInitialized = -32002
+
+	// JSONRPCRequestCancelled is returned for requests abandoned via $/cancelRequest (as in LSP)
+	JSONRPCRequestCancelled = -32800
+)
+
+// CancelRequestMethod is the notification clients send to abandon an in-flight request
+const CancelRequestMethod = "$/cancelRequest"
+
+// CancelParams represents the params of a $/cancelRequest notification
+type CancelParams struct {
+	ID interface{} `json:"id"`
+}
+
+// JSONRPCRequest represents a JSON-RPC 2.0 request. A request without an id is a
+// notification and never receives a response.
+type JSONRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      interface{}     `json:"id,omitempty"`
+
+	// hasID distinguishes an absent id (notification) from an explicit null id
+	hasID bool
+}
+
+// UnmarshalJSON decodes a request and records whether the id member was present
+func (r *JSONRPCRequest) UnmarshalJSON(data []byte) error {
+	type plainRequest JSONRPCRequest
+	var raw struct {
+		plainRequest
+		RawID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*r = JSONRPCRequest(raw.plainRequest)
+	r.hasID = len(raw.RawID) > 0
+	if r.hasID {
+		if err := json.Unmarshal(raw.RawID, &r.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsNotification reports whether the request was sent without an id
+func (r *JSONRPCRequest) IsNotification() bool {
+	return !r.hasID
+}
+
+// JSONRPCResponse represents a JSON-RPC 2.0 response
+type JSONRPCResponse struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Result  interface{}   `json:"result,omitempty"`
+	Error   *JSONRPCError `json:"error,omitempty"`
+	ID      interface{}   `json:"id"`
+}
+
+// JSONRPCNotification represents a JSON-RPC 2.0 notification sent by the server
+type JSONRPCNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// JSONRPCError represents a JSON-RPC error object
+type JSONRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// HandlerFunc handles a single JSON-RPC request and returns its result or error.
+// ctx is cancelled when the client sends $/cancelRequest for the request's id.
+type HandlerFunc func(ctx context.Context, req *JSONRPCRequest) (interface{}, *JSONRPCError)
+
+// DispatcherConfig holds configuration for the JSON-RPC dispatcher
+type DispatcherConfig struct {
+	// MaxInFlight bounds the number of requests handled concurrently
+	MaxInFlight int
+
+	// Transport selects the wire protocol (TransportJSON or TransportMsgpack)
+	Transport string
+
+	// Framing selects how JSON messages are delimited on the wire (FramingLine or FramingContentLength)
+	Framing string
+
+	// MaxMessageSize bounds the size of a single incoming message in bytes (0 for no limit).
+	// Larger messages are skipped and answered with a JSONRPCMessageTooLarge error.
+	MaxMessageSize int
+
+	// RequireInitialize rejects every call but Initialize, rpc.discover and Exit until
+	// the session has completed the handshake
+	RequireInitialize bool
+}
+
+// DefaultDispatcherConfig returns default dispatcher configuration
+func DefaultDispatcherConfig() *DispatcherConfig {
+	return &DispatcherConfig{
+		MaxInFlight:    8,
+		Transport:      TransportJSON,
+		Framing:        FramingLine,
+		MaxMessageSize: 64 * 1024 * 1024,
+	}
+}
+
+// Supported wire protocols
+const (
+	// TransportJSON speaks JSON-RPC 2.0 (the default)
+	TransportJSON = "json"
+	// TransportMsgpack speaks msgpack-RPC, as used natively by Neovim
+	TransportMsgpack = "msgpack"
+)
+
+// Dispatcher reads framed JSON-RPC messages and handles them concurrently. Each
+// message holds either a single request or a batch array, as in JSON-RPC 2.0.
+// With TransportMsgpack the same handler is served over msgpack-RPC instead.
+type Dispatcher struct {
+	handler HandlerFunc
+	config  *DispatcherConfig
+
+	// Requests in flight across all sessions, for Shutdown to drain
+	mu           sync.Mutex
+	active       int
+	activeChange chan struct{} // closed and replaced whenever active decreases
+	shuttingDown bool
+
+	// closed ends every Serve call, see Close
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewDispatcher creates a new dispatcher for the given handler
+func NewDispatcher(handler HandlerFunc, config *DispatcherConfig) *Dispatcher {
+	if config == nil {
+		config = DefaultDispatcherConfig()
+	}
+	if config.MaxInFlight < 1 {
+		config.MaxInFlight = 1
+	}
+
+	return &Dispatcher{
+		handler:      handler,
+		config:       config,
+		activeChange: make(chan struct{}),
+		closed:       make(chan struct{}),
+	}
+}
+
+type dispatcherKey struct{}
+
+// Shutdown makes the dispatcher refuse new requests, except Exit, and waits until the
+// requests in flight have been answered or ctx is done. When called from a handler,
+// the calling request itself is not waited for.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	own := 0
+	if ctx.Value(dispatcherKey{}) == d {
+		own = 1
+	}
+
+	d.mu.Lock()
+	d.shuttingDown = true
+	for d.active > own {
+		change := d.activeChange
+		d.mu.Unlock()
+
+		select {
+		case <-change:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		d.mu.Lock()
+	}
+	d.mu.Unlock()
+	return nil
+}
+
+// Close stops every Serve call from reading further messages. Requests already read
+// are still answered before Serve returns.
+func (d *Dispatcher) Close() {
+	d.closeOnce.Do(func() {
+		close(d.closed)
+	})
+}
+
+// begin counts a request as in flight, unless the dispatcher is shutting down
+func (d *Dispatcher) begin(req *JSONRPCRequest) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.shuttingDown && req.Method != ExitMethod {
+		return false
+	}
+	d.active++
+	return true
+}
+
+// end marks a request counted by begin as answered
+func (d *Dispatcher) end() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.active--
+	close(d.activeChange)
+	d.activeChange = make(chan struct{})
+}
+
+// received is a message read by receive
+type received[T any] struct {
+	message T
+	err     error
+}
+
+// receive reads messages on their own goroutine, so that Serve can stop at Close
+// without waiting for the client to send another message. Reading stops after the
+// first error other than ErrMessageTooLarge.
+func receive[T any](read func() (T, error), closed <-chan struct{}) <-chan received[T] {
+	messages := make(chan received[T])
+	go func() {
+		for {
+			message, err := read()
+			select {
+			case messages <- received[T]{message: message, err: err}:
+			case <-closed:
+				return
+			}
+			if err != nil && err != ErrMessageTooLarge {
+				return
+			}
+		}
+	}()
+	return messages
+}
+
+// next returns the next message from receive, or io.EOF once closed is closed
+func next[T any](messages <-chan received[T], closed <-chan struct{}) (T, error) {
+	select {
+	case m := <-messages:
+		return m.message, m.err
+	case <-closed:
+		var zero T
+		return zero, io.EOF
+	}
+}
+
+// session holds the state of a single Serve call
+type session struct {
+	dispatcher *Dispatcher
+
+	// Outgoing messages, consumed by the single writer goroutine
+	outgoing   chan interface{}
+	writerDone chan struct{}
+
+	// slots bounds the number of in-flight requests
+	slots    chan struct{}
+	inFlight sync.WaitGroup
+
+	// lastTurn is closed once the latest dispatched request has taken a slot or given up
+	// waiting, so requests start in the order they arrived
+	lastTurn chan struct{}
+
+	// Cancel functions of in-flight requests, keyed by encoded request id
+	mu      sync.Mutex
+	pending map[string]context.CancelFunc
+
+	// Handshake state, passed to handlers through the request context
+	state *SessionState
+}
+
+// Serve reads messages from r until EOF, dispatching each request on a worker goroutine.
+// Responses are written to w by a single writer as each request completes, so they
+// may arrive out of order and must be matched by id. Serve returns once all
+// in-flight requests have been answered.
+func (d *Dispatcher) Serve(r io.Reader, w io.Writer) error {
+	switch d.config.Transport {
+	case "", TransportJSON:
+	case TransportMsgpack:
+		return d.serveMsgpack(r, w)
+	default:
+		return fmt.Errorf("unknown transport %q", d.config.Transport)
+	}
+
+	reader, err := NewMessageReader(r, d.config.Framing, d.config.MaxMessageSize)
+	if err != nil {
+		return err
+	}
+	writer, err := NewMessageWriter(w, d.config.Framing)
+	if err != nil {
+		return err
+	}
+
+	s := d.newSession(func(message interface{}) error {
+		messageBytes, err := json.Marshal(message)
+		if err != nil {
+			return fmt.Errorf("failed to marshal response: %w", err)
+		}
+		return writer.WriteMessage(messageBytes)
+	})
+
+	messages := receive(reader.ReadMessage, d.closed)
+
+	var readErr error
+	for {
+		message, err := next(messages, d.closed)
+		if err == ErrMessageTooLarge {
+			// The id is unknown without decoding the message, so the error goes out with a null id
+			log.Printf("Rejected message larger than %d bytes", d.config.MaxMessageSize)
+			s.outgoing <- newErrorResponse(JSONRPCMessageTooLarge,
+				fmt.Sprintf("Message too large (limit %d bytes)", d.config.MaxMessageSize), nil)
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				readErr = err
+			}
+			break
+		}
+
+		if len(message) == 0 {
+			continue
+		}
+
+		if message[0] == '[' {
+			s.serveBatch(message)
+		} else {
+			s.serveSingle(message)
+		}
+	}
+
+	s.finish()
+
+	if readErr != nil {
+		return fmt.Errorf("error reading requests: %w", readErr)
+	}
+	return nil
+}
+
+// newSession starts a session whose outgoing messages are written with write
+func (d *Dispatcher) newSession(write func(message interface{}) error) *session {
+	s := &session{
+		dispatcher: d,
+		outgoing:   make(chan interface{}, d.config.MaxInFlight),
+		writerDone: make(chan struct{}),
+		slots:      make(chan struct{}, d.config.MaxInFlight),
+		lastTurn:   make(chan struct{}),
+		pending:    make(map[string]context.CancelFunc),
+		state:      NewSessionState(),
+	}
+	close(s.lastTurn)
+
+	go s.writeMessages(write)
+	return s
+}
+
+// finish waits for all in-flight requests to be answered and stops the writer
+func (s *session) finish() {
+	s.inFlight.Wait()
+	close(s.outgoing)
+	<-s.writerDone
+}
+
+// serveSingle handles a message holding one request
+func (s *session) serveSingle(line []byte) {
+	req, errResponse := parseRequest(line)
+	if errResponse != nil {
+		s.outgoing <- errResponse
+		return
+	}
+
+	// Cancellation is handled inline so it is never stuck behind the requests it cancels
+	if req.Method == CancelRequestMethod {
+		s.cancelRequest(req.Params)
+		return
+	}
+
+	s.dispatch(req, func(response *JSONRPCResponse) {
+		if !req.IsNotification() {
+			s.outgoing <- response
+		}
+	})
+}
+
+// serveBatch handles a message holding a batch array. The requests run concurrently and
+// their responses are sent together as one array once all of them are done; a batch
+// made only of notifications gets no reply at all.
+func (s *session) serveBatch(line []byte) {
+	var messages []json.RawMessage
+	if err := json.Unmarshal(line, &messages); err != nil {
+		s.outgoing <- newErrorResponse(JSONRPCParseError, "Parse error", nil)
+		return
+	}
+	if len(messages) == 0 {
+		s.outgoing <- newErrorResponse(JSONRPCInvalidRequest, "Invalid Request", nil)
+		return
+	}
+
+	var mu sync.Mutex
+	var batch sync.WaitGroup
+	responses := make([]*JSONRPCResponse, 0, len(messages))
+	collect := func(response *JSONRPCResponse) {
+		mu.Lock()
+		responses = append(responses, response)
+		mu.Unlock()
+	}
+
+	for _, message := range messages {
+		req, errResponse := parseRequest(message)
+		if errResponse != nil {
+			collect(errResponse)
+			continue
+		}
+
+		if req.Method == CancelRequestMethod {
+			s.cancelRequest(req.Params)
+			continue
+		}
+
+		batch.Add(1)
+		s.dispatch(req, func(response *JSONRPCResponse) {
+			if !req.IsNotification() {
+				collect(response)
+			}
+			batch.Done()
+		})
+	}
+
+	// The batch itself counts as in flight until its combined response is queued
+	s.inFlight.Add(1)
+	go func() {
+		defer s.inFlight.Done()
+		batch.Wait()
+		if len(responses) > 0 {
+			s.outgoing <- responses
+		}
+	}()
+}
+
+// dispatch runs a request on a worker goroutine once a slot is free and passes its
+// response to done. It never blocks, so the caller can keep reading messages.
+func (s *session) dispatch(req *JSONRPCRequest, done func(*JSONRPCResponse)) {
+	if s.dispatcher.config.RequireInitialize && !s.state.Initialized() &&
+		req.Method != InitializeMethod && req.Method != DiscoverMethod && req.Method != ExitMethod {
+		done(newErrorResponse(JSONRPCServerNotInitialized, "Server not initialized", req.ID))
+		return
+	}
+
+	// As in LSP, everything but Exit is refused once Shutdown has been requested
+	if !s.dispatcher.begin(req) {
+		done(newErrorResponse(JSONRPCInvalidRequest, "Server is shutting down", req.ID))
+		return
+	}
+
+	// Tracked before waiting for a slot, so queued requests can be cancelled too
+	s.inFlight.Add(1)
+	ctx, cancel := s.track(req)
+	previous, turn := s.lastTurn, make(chan struct{})
+	s.lastTurn = turn
+	go func() {
+		defer func() {
+			s.untrack(req)
+			cancel()
+			s.inFlight.Done()
+			s.dispatcher.end()
+		}()
+
+		// Wait for a free slot so a burst of slow requests cannot run without bound. The
+		// reader keeps going meanwhile, so cancellations and notifications still get through.
+		if !s.acquireSlot(ctx, previous) {
+			done(newErrorResponse(JSONRPCRequestCancelled, "Request cancelled", req.ID))
+			<-previous
+			close(turn)
+			return
+		}
+		close(turn)
+		defer func() { <-s.slots }()
+
+		done(s.dispatcher.handle(ctx, req))
+	}()
+}
+
+// acquireSlot takes a slot once the request dispatched before has, reporting false when
+// ctx is cancelled first
+func (s *session) acquireSlot(ctx context.Context, previous <-chan struct{}) bool {
+	select {
+	case <-previous:
+	case <-ctx.Done():
+		return false
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// handle runs the handler for a single request and builds its response
+func (d *Dispatcher) handle(ctx context.Context, req *JSONRPCRequest) (response *JSONRPCResponse) {
+	response = &JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      req.ID,
+	}
+
+	// A panicking handler must not take the whole server down with it
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Recovered from panic handling %s: %v", req.Method, r)
+			response.Result = nil
+			response.Error = &JSONRPCError{Code: JSONRPCInternalError, Message: "Internal error"}
+		}
+	}()
+
+	result, rpcErr := d.handler(ctx, req)
+	if ctx.Err() == context.Canceled {
+		response.Error = &JSONRPCError{Code: JSONRPCRequestCancelled, Message: "Request cancelled"}
+	} else if rpcErr != nil {
+		response.Error = rpcErr
+	} else {
+		response.Result = result
+	}
+	return response
+}
+
+// requestInfo identifies the request a handler is running for
+type requestInfo struct {
+	session *session
+	req     *JSONRPCRequest
+}
+
+type requestInfoKey struct{}
+
+// RequestIDFromContext returns the id of the request a handler is running for. It
+// reports false for notifications and outside a dispatcher.
+func RequestIDFromContext(ctx context.Context) (interface{}, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(requestInfo)
+	if !ok || info.req.IsNotification() {
+		return nil, false
+	}
+	return info.req.ID, true
+}
+
+// Notify sends a notification to the client of the request a handler is running for.
+// Notifications are queued with the responses, so everything sent before the handler
+// returns reaches the client ahead of its response.
+func Notify(ctx context.Context, method string, params interface{}) error {
+	info, ok := ctx.Value(requestInfoKey{}).(requestInfo)
+	if !ok {
+		return fmt.Errorf("no client to notify of %s", method)
+	}
+
+	info.session.outgoing <- &JSONRPCNotification{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  params,
+	}
+	return nil
+}
+
+// track registers a cancellable context for the request
+func (s *session) track(req *JSONRPCRequest) (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(WithSessionState(context.Background(), s.state), dispatcherKey{}, s.dispatcher)
+	ctx = context.WithValue(ctx, requestInfoKey{}, requestInfo{session: s, req: req})
+	ctx, cancel := context.WithCancel(ctx)
+	if req.IsNotification() {
+		return ctx, cancel
+	}
+
+	s.mu.Lock()
+	s.pending[requestKey(req.ID)] = cancel
+	s.mu.Unlock()
+	return ctx, cancel
+}
+
+// untrack forgets the cancel function of a finished request
+func (s *session) untrack(req *JSONRPCRequest) {
+	if req.IsNotification() {
+		return
+	}
+
+	s.mu.Lock()
+	delete(s.pending, requestKey(req.ID))
+	s.mu.Unlock()
+}
+
+// cancelRequest cancels the in-flight request named by a $/cancelRequest notification
+func (s *session) cancelRequest(params json.RawMessage) {
+	var cancelParams CancelParams
+	if err := json.Unmarshal(params, &cancelParams); err != nil || cancelParams.ID == nil {
+		log.Printf("Ignoring malformed %s: %s", CancelRequestMethod, string(params))
+		return
+	}
+
+	s.mu.Lock()
+	cancel, ok := s.pending[requestKey(cancelParams.ID)]
+	s.mu.Unlock()
+
+	// Requests that already finished are silently ignored, as the client may race us
+	if ok {
+		cancel()
+	}
+}
+
+// requestKey encodes a request id so that 1 and "1" remain distinct map keys
+func requestKey(id interface{}) string {
+	key, _ := json.Marshal(id)
+	return string(key)
+}
+
+// writeMessages is the only goroutine writing output, which keeps messages from interleaving
+func (s *session) writeMessages(write func(message interface{}) error) {
+	defer close(s.writerDone)
+
+	for message := range s.outgoing {
+		if err := write(message); err != nil {
+			log.Printf("Failed to write response: %v", err)
+		}
+	}
+}
+
+// parseRequest decodes and validates a single request, returning the error response to
+// send back when the message is not a valid JSON-RPC 2.0 request
+func parseRequest(message []byte) (*JSONRPCRequest, *JSONRPCResponse) {
+	var req JSONRPCRequest
+	if err := json.Unmarshal(message, &req); err != nil {
+		// A batch element that is valid JSON but not an object is an invalid request
+		if json.Valid(message) {
+			return nil, newErrorResponse(JSONRPCInvalidRequest, "Invalid Request", nil)
+		}
+		return nil, newErrorResponse(JSONRPCParseError, "Parse error", nil)
+	}
+
+	// Validate JSON-RPC version
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return nil, newErrorResponse(JSONRPCInvalidRequest, "Invalid Request", req.ID)
+	}
+
+	return &req, nil
+}
+
+// newErrorResponse creates an error response for the given request id
+func newErrorResponse(code int, message string, id interface{}) *JSONRPCResponse {
+	return &JSONRPCResponse{
+		JSONRPC: "2.0",
+		Error: &JSONRPCError{
+			Code:    code,
+			Message: message,
+		},
+		ID: id,
+	}
+}
@@ -0,0 +1,425 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// readResponses decodes newline-delimited responses from the dispatcher output
+func readResponses(t *testing.T, r io.Reader) []JSONRPCResponse {
+	t.Helper()
+
+	var responses []JSONRPCResponse
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var response JSONRPCResponse
+		if err := json.Unmarshal(scanner.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode response %q: %v", scanner.Text(), err)
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+func TestDispatcher_Serve(t *testing.T) {
+	dispatcher := NewDispatcher(func(ctx context.Context, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+		switch req.Method {
+		case "Echo":
+			var params map[string]string
+			json.Unmarshal(req.Params, &params)
+			return params["text"], nil
+		case "Panic":
+			panic("boom")
+		default:
+			return nil, &JSONRPCError{Code: JSONRPCMethodNotFound, Message: "Method not found"}
+		}
+	}, nil)
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","method":"Echo","params":{"text":"hi"},"id":1}`,
+		``,
+		`not json`,
+		`{"jsonrpc":"1.0","method":"Echo","id":2}`,
+		`{"jsonrpc":"2.0","method":"Missing","id":3}`,
+		`{"jsonrpc":"2.0","method":"Panic","id":4}`,
+	}, "\n")
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer pw.Close()
+		if err := dispatcher.Serve(strings.NewReader(input), pw); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+
+	byID := make(map[float64]JSONRPCResponse)
+	var parseErrors int
+	for _, response := range readResponses(t, pr) {
+		if response.ID == nil {
+			parseErrors++
+			continue
+		}
+		byID[response.ID.(float64)] = response
+	}
+
+	if parseErrors != 1 {
+		t.Errorf("expected 1 parse error response, got %d", parseErrors)
+	}
+	if byID[1].Result != "hi" {
+		t.Errorf("expected echo result 'hi', got %v", byID[1].Result)
+	}
+	if byID[2].Error == nil || byID[2].Error.Code != JSONRPCInvalidRequest {
+		t.Errorf("expected invalid request error for id 2, got %+v", byID[2].Error)
+	}
+	if byID[3].Error == nil || byID[3].Error.Code != JSONRPCMethodNotFound {
+		t.Errorf("expected method not found error for id 3, got %+v", byID[3].Error)
+	}
+	if byID[4].Error == nil || byID[4].Error.Code != JSONRPCInternalError {
+		t.Errorf("expected internal error for panicking handler, got %+v", byID[4].Error)
+	}
+}
+
+func TestDispatcher_SlowRequestDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	dispatcher := NewDispatcher(func(ctx context.Context, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+		if req.Method == "Slow" {
+			<-release
+		}
+		return req.Method, nil
+	}, nil)
+
+	input := `{"jsonrpc":"2.0","method":"Slow","id":1}` + "\n" +
+		`{"jsonrpc":"2.0","method":"Fast","id":2}` + "\n"
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer pw.Close()
+		dispatcher.Serve(strings.NewReader(input), pw)
+	}()
+
+	reader := bufio.NewReader(pr)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("failed to read first response: %v", err)
+	}
+
+	var first JSONRPCResponse
+	json.Unmarshal(line, &first)
+	if first.Result != "Fast" {
+		t.Errorf("expected fast response first, got %v", first.Result)
+	}
+
+	close(release)
+	line, err = reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("failed to read second response: %v", err)
+	}
+
+	var second JSONRPCResponse
+	json.Unmarshal(line, &second)
+	if second.Result != "Slow" {
+		t.Errorf("expected slow response second, got %v", second.Result)
+	}
+}
+
+func TestDispatcher_MaxInFlight(t *testing.T) {
+	var current, peak int32
+	dispatcher := NewDispatcher(func(ctx context.Context, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+		n := atomic.AddInt32(&current, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&current, -1)
+		return nil, nil
+	}, &DispatcherConfig{MaxInFlight: 2})
+
+	var input strings.Builder
+	for i := 0; i < 10; i++ {
+		input.WriteString(`{"jsonrpc":"2.0","method":"Work","id":` + string(rune('0'+i)) + "}\n")
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer pw.Close()
+		dispatcher.Serve(strings.NewReader(input.String()), pw)
+	}()
+
+	if responses := readResponses(t, pr); len(responses) != 10 {
+		t.Errorf("expected 10 responses, got %d", len(responses))
+	}
+	if peak > 2 {
+		t.Errorf("expected at most 2 requests in flight, got %d", peak)
+	}
+}
+
+func TestDispatcher_CancelRequest(t *testing.T) {
+	started := make(chan struct{})
+	dispatcher := NewDispatcher(func(ctx context.Context, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+		close(started)
+		<-ctx.Done()
+		return nil, &JSONRPCError{Code: JSONRPCInternalError, Message: ctx.Err().Error()}
+	}, nil)
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	go func() {
+		defer outW.Close()
+		dispatcher.Serve(inR, outW)
+	}()
+
+	go func() {
+		io.WriteString(inW, `{"jsonrpc":"2.0","method":"Query","id":"q1"}`+"\n")
+		<-started
+		// Unknown ids are ignored; only the matching request is cancelled
+		io.WriteString(inW, `{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":"other"}}`+"\n")
+		io.WriteString(inW, `{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":"q1"}}`+"\n")
+		inW.Close()
+	}()
+
+	responses := readResponses(t, outR)
+	if len(responses) != 1 {
+		t.Fatalf("expected exactly 1 response (no reply to cancel notifications), got %d", len(responses))
+	}
+	if responses[0].ID != "q1" {
+		t.Errorf("expected response for q1, got %v", responses[0].ID)
+	}
+	if responses[0].Error == nil || responses[0].Error.Code != JSONRPCRequestCancelled {
+		t.Errorf("expected request cancelled error, got %+v", responses[0].Error)
+	}
+}
+
+func TestDispatcher_CancelWhileSlotsSaturated(t *testing.T) {
+	dispatcher := NewDispatcher(func(ctx context.Context, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+		<-ctx.Done()
+		return nil, nil
+	}, &DispatcherConfig{MaxInFlight: 1})
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	go func() {
+		defer outW.Close()
+		dispatcher.Serve(inR, outW)
+	}()
+
+	// q1 takes the only slot and q2 waits for it, which must not stop the cancellations
+	// behind them from being read
+	go func() {
+		io.WriteString(inW, `{"jsonrpc":"2.0","method":"Query","id":"q1"}`+"\n")
+		io.WriteString(inW, `{"jsonrpc":"2.0","method":"Query","id":"q2"}`+"\n")
+		io.WriteString(inW, `{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":"q2"}}`+"\n")
+		io.WriteString(inW, `{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":"q1"}}`+"\n")
+		inW.Close()
+	}()
+
+	done := make(chan []JSONRPCResponse)
+	go func() { done <- readResponses(t, outR) }()
+
+	var responses []JSONRPCResponse
+	select {
+	case responses = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancellations were not read while the slots were saturated")
+	}
+
+	if len(responses) != 2 {
+		t.Fatalf("expected 2 responses, got %d", len(responses))
+	}
+	for _, response := range responses {
+		if response.Error == nil || response.Error.Code != JSONRPCRequestCancelled {
+			t.Errorf("expected request cancelled error for %v, got %+v", response.ID, response.Error)
+		}
+	}
+}
+
+func TestDispatcher_Notifications(t *testing.T) {
+	var handled int32
+	dispatcher := NewDispatcher(func(ctx context.Context, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+		atomic.AddInt32(&handled, 1)
+		if req.Method == "Fail" {
+			return nil, &JSONRPCError{Code: JSONRPCInternalError, Message: "failed"}
+		}
+		return "ok", nil
+	}, nil)
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","method":"UpdateKeybindings","params":{"keybindings":[]}}`,
+		`{"jsonrpc":"2.0","method":"Fail"}`,
+		`{"jsonrpc":"2.0","method":"HealthCheck","id":null}`,
+	}, "\n")
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer pw.Close()
+		dispatcher.Serve(strings.NewReader(input), pw)
+	}()
+
+	responses := readResponses(t, pr)
+	if handled != 3 {
+		t.Errorf("expected all 3 messages to be handled, got %d", handled)
+	}
+	// Only the request with an explicit (null) id gets a reply
+	if len(responses) != 1 {
+		t.Fatalf("expected 1 response, got %d", len(responses))
+	}
+	if responses[0].ID != nil || responses[0].Result != "ok" {
+		t.Errorf("expected ok response with null id, got %+v", responses[0])
+	}
+}
+
+func TestDispatcher_Batch(t *testing.T) {
+	dispatcher := NewDispatcher(func(ctx context.Context, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+		if req.Method == "Missing" {
+			return nil, &JSONRPCError{Code: JSONRPCMethodNotFound, Message: "Method not found"}
+		}
+		return req.Method, nil
+	}, &DispatcherConfig{MaxInFlight: 1})
+
+	input := strings.Join([]string{
+		`[{"jsonrpc":"2.0","method":"HealthCheck","id":1},{"jsonrpc":"2.0","method":"GetMetrics","id":2},{"jsonrpc":"2.0","method":"UpdateKeybindings"},{"jsonrpc":"2.0","method":"Missing","id":3},1]`,
+		`[{"jsonrpc":"2.0","method":"UpdateKeybindings"},{"jsonrpc":"2.0","method":"UpdateKeybindings"}]`,
+		`[]`,
+		`[{"jsonrpc":"2.0","method":"HealthCheck","id":1}`,
+	}, "\n")
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer pw.Close()
+		dispatcher.Serve(strings.NewReader(input), pw)
+	}()
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(pr)
+	for scanner.Scan() {
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+	}
+
+	// Batch response, empty batch error and parse error; the notification-only batch is silent
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 output lines, got %d: %s", len(lines), lines)
+	}
+
+	var batch []JSONRPCResponse
+	var singles []JSONRPCResponse
+	for _, line := range lines {
+		if line[0] == '[' {
+			if err := json.Unmarshal(line, &batch); err != nil {
+				t.Fatalf("failed to decode batch response: %v", err)
+			}
+			continue
+		}
+		var response JSONRPCResponse
+		json.Unmarshal(line, &response)
+		singles = append(singles, response)
+	}
+
+	if len(batch) != 4 {
+		t.Fatalf("expected 4 batch responses, got %d", len(batch))
+	}
+	codes := make(map[int]int)
+	results := make(map[interface{}]interface{})
+	for _, response := range batch {
+		if response.Error != nil {
+			codes[response.Error.Code]++
+		} else {
+			results[response.ID] = response.Result
+		}
+	}
+	if results[float64(1)] != "HealthCheck" || results[float64(2)] != "GetMetrics" {
+		t.Errorf("unexpected batch results: %v", results)
+	}
+	if codes[JSONRPCMethodNotFound] != 1 || codes[JSONRPCInvalidRequest] != 1 {
+		t.Errorf("expected one method-not-found and one invalid-request error, got %v", codes)
+	}
+
+	singleCodes := make(map[int]bool)
+	for _, response := range singles {
+		if response.Error != nil {
+			singleCodes[response.Error.Code] = true
+		}
+	}
+	if !singleCodes[JSONRPCInvalidRequest] || !singleCodes[JSONRPCParseError] {
+		t.Errorf("expected invalid request for empty batch and parse error for malformed batch, got %v", singleCodes)
+	}
+}
+
+func TestDispatcher_MessageSizeLimit(t *testing.T) {
+	dispatcher := NewDispatcher(func(ctx context.Context, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+		return len(req.Params), nil
+	}, &DispatcherConfig{MaxInFlight: 1, MaxMessageSize: 200 * 1024})
+
+	// 100KB passes the limit but would have broken the old 64KB scanner; 300KB does not
+	large := `{"jsonrpc":"2.0","method":"SyncKeybindings","params":"` + strings.Repeat("k", 100*1024) + `","id":1}`
+	tooLarge := `{"jsonrpc":"2.0","method":"SyncKeybindings","params":"` + strings.Repeat("k", 300*1024) + `","id":2}`
+	input := large + "\n" + tooLarge + "\n" + `{"jsonrpc":"2.0","method":"HealthCheck","id":3}` + "\n"
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer pw.Close()
+		if err := dispatcher.Serve(strings.NewReader(input), pw); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+
+	var results []interface{}
+	var tooLargeErrors int
+	for _, response := range readResponses(t, pr) {
+		if response.Error != nil {
+			if response.Error.Code == JSONRPCMessageTooLarge && response.ID == nil {
+				tooLargeErrors++
+			}
+			continue
+		}
+		results = append(results, response.ID)
+	}
+
+	if tooLargeErrors != 1 {
+		t.Errorf("expected 1 message too large error, got %d", tooLargeErrors)
+	}
+	if len(results) != 2 {
+		t.Errorf("expected responses for ids 1 and 3, got %v", results)
+	}
+}
+
+func TestDispatcher_ContentLengthFraming(t *testing.T) {
+	dispatcher := NewDispatcher(func(ctx context.Context, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+		return req.Method, nil
+	}, &DispatcherConfig{MaxInFlight: 1, Framing: FramingContentLength})
+
+	var input bytes.Buffer
+	writer, _ := NewMessageWriter(&input, FramingContentLength)
+	writer.WriteMessage([]byte("{\n  \"jsonrpc\": \"2.0\",\n  \"method\": \"HealthCheck\",\n  \"id\": 1\n}"))
+	writer.WriteMessage([]byte(`[{"jsonrpc":"2.0","method":"GetMetrics","id":2}]`))
+
+	var output bytes.Buffer
+	if err := dispatcher.Serve(&input, &output); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reader, _ := NewMessageReader(&output, FramingContentLength, 0)
+	var messages []string
+	for {
+		message, err := reader.ReadMessage()
+		if err != nil {
+			break
+		}
+		messages = append(messages, string(message))
+	}
+
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 framed responses, got %d: %v", len(messages), messages)
+	}
+	if !strings.Contains(messages[0], `"HealthCheck"`) || !strings.HasPrefix(messages[1], "[") {
+		t.Errorf("unexpected responses: %v", messages)
+	}
+}
@@ -0,0 +1,422 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"nvim-smart-keybind-search/internal/interfaces"
+	"nvim-smart-keybind-search/internal/keybindings"
+)
+
+// Page sizes of ListKeybindings
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// keybindingLister is implemented by RAG agents that can list the keybindings they store
+type keybindingLister interface {
+	ListKeybindings(ctx context.Context, request *interfaces.ListKeybindingsRequest) (*interfaces.KeybindingPage, error)
+}
+
+// keybindingDeleter is implemented by RAG agents that can delete stored user keybindings
+type keybindingDeleter interface {
+	DeleteKeybindings(ctx context.Context, selector *interfaces.KeybindingSelector) ([]string, error)
+}
+
+// DeleteKeybindingsArgs selects the user keybindings to delete by id, mode and plugin.
+// The fields combine and at least one has to be set; SyncKeybindings with clear_existing
+// deletes every user keybinding. Built-in keybindings cannot be deleted.
+type DeleteKeybindingsArgs struct {
+	IDs     []string `json:"ids,omitempty"`
+	Modes   []string `json:"modes,omitempty"`
+	Plugins []string `json:"plugins,omitempty"`
+}
+
+// DeleteKeybindingsResult represents the result of the DeleteKeybindings RPC method
+type DeleteKeybindingsResult struct {
+	Success      bool     `json:"success"`
+	DeletedCount int      `json:"deleted_count"`
+	IDs          []string `json:"ids"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// ListKeybindingsArgs represents the arguments for the ListKeybindings RPC method
+type ListKeybindingsArgs struct {
+	// Sources selects the collections listed; without them the user and built-in
+	// keybindings are
+	Sources []string `json:"sources,omitempty"`
+	Modes   []string `json:"modes,omitempty"`
+	Plugins []string `json:"plugins,omitempty"`
+
+	// Prefix keeps the keybindings whose keys, command or description start with it,
+	// ignoring case
+	Prefix string `json:"prefix,omitempty"`
+
+	// Cursor continues a listing from the next_cursor of its previous page
+	Cursor string `json:"cursor,omitempty"`
+
+	Limit int `json:"limit,omitempty"`
+}
+
+// ListedKeybinding is a keybinding returned by ListKeybindings
+type ListedKeybinding struct {
+	Keybinding
+	Source string `json:"source"`
+}
+
+// ListKeybindingsResult represents the result of the ListKeybindings RPC method.
+// NextCursor is empty on the last page.
+type ListKeybindingsResult struct {
+	Keybindings []ListedKeybinding `json:"keybindings"`
+	NextCursor  string             `json:"next_cursor,omitempty"`
+}
+
+// AnalyzeConflictsArgs represents the arguments for the AnalyzeConflicts RPC method
+type AnalyzeConflictsArgs struct {
+	// Keybindings are analyzed when present, such as a SyncKeybindings payload before it
+	// is sent; otherwise the stored user keybindings are
+	Keybindings []Keybinding `json:"keybindings,omitempty"`
+}
+
+// FindShadowedBuiltinsArgs represents the arguments for the FindShadowedBuiltins RPC method
+type FindShadowedBuiltinsArgs struct {
+	// Keybindings are compared with the built-in keybindings when present; otherwise the
+	// stored user keybindings are
+	Keybindings []Keybinding `json:"keybindings,omitempty"`
+}
+
+// ExplainKeysArgs represents the arguments for the ExplainKeys RPC method
+type ExplainKeysArgs struct {
+	Keys string `json:"keys"`
+
+	// Mode is the mode the keys are typed in, normal mode by default
+	Mode string `json:"mode,omitempty"`
+
+	// Leader and LocalLeader expand <leader> and <localleader> in Keys, as mapped keys are
+	// stored expanded; a backslash by default
+	Leader      string `json:"leader,omitempty"`
+	LocalLeader string `json:"local_leader,omitempty"`
+
+	// UseLLM asks the language model for a summary on top of the lookup
+	UseLLM bool `json:"use_llm,omitempty"`
+}
+
+// ExplainKeysResult represents the result of the ExplainKeys RPC method
+type ExplainKeysResult struct {
+	Keys string `json:"keys"`
+	Mode string `json:"mode"`
+
+	// Matches are the keybindings mapped to exactly these keys, user keybindings first
+	Matches []ListedKeybinding `json:"matches"`
+
+	// Sequence breaks normal mode keys into count, register, operator, motion and text
+	// object
+	Sequence *keybindings.KeySequence `json:"sequence,omitempty"`
+
+	// Explanation is the summary of the language model, when asked for and available
+	Explanation string `json:"explanation,omitempty"`
+}
+
+// DeleteKeybindings deletes the user keybindings matching the arguments
+func (s *RPCService) DeleteKeybindings(ctx context.Context, args *DeleteKeybindingsArgs, result *DeleteKeybindingsResult) error {
+	deleter, ok := s.ragAgent.(keybindingDeleter)
+	if !ok {
+		rpcErr := NewRPCError(ErrorCodeServiceUnavailable, "RAG agent cannot delete keybindings").WithComponent(ComponentRAGAgent)
+		result.Error = rpcErr.Message
+		LogError(rpcErr, "DeleteKeybindings")
+		return rpcErr
+	}
+
+	selector := &interfaces.KeybindingSelector{}
+	if args != nil {
+		selector = &interfaces.KeybindingSelector{IDs: args.IDs, Modes: args.Modes, Plugins: args.Plugins}
+	}
+	if selector.IsEmpty() {
+		rpcErr := NewRPCError(ErrorCodeInvalidRequest, "no keybindings selected",
+			"set ids, modes or plugins, or use SyncKeybindings with clear_existing to delete all keybindings")
+		result.Error = rpcErr.Message
+		LogError(rpcErr, "DeleteKeybindings")
+		return rpcErr
+	}
+
+	ids, err := deleter.DeleteKeybindings(ctx, selector)
+	if err != nil {
+		rpcErr := WrapError(err, ErrorCodeVectorDBError, "failed to delete keybindings")
+		result.Error = rpcErr.Message
+		LogError(rpcErr, "DeleteKeybindings")
+		return rpcErr
+	}
+
+	result.Success = true
+	result.DeletedCount = len(ids)
+	result.IDs = ids
+	if result.IDs == nil {
+		result.IDs = []string{}
+	}
+	return nil
+}
+
+// ListKeybindings returns a page of the stored keybindings, user keybindings first
+func (s *RPCService) ListKeybindings(ctx context.Context, args *ListKeybindingsArgs, result *ListKeybindingsResult) error {
+	lister, ok := s.ragAgent.(keybindingLister)
+	if !ok {
+		rpcErr := NewRPCError(ErrorCodeServiceUnavailable, "RAG agent cannot list keybindings").WithComponent(ComponentRAGAgent)
+		LogError(rpcErr, "ListKeybindings")
+		return rpcErr
+	}
+	if args == nil {
+		args = &ListKeybindingsArgs{}
+	}
+
+	request := &interfaces.ListKeybindingsRequest{
+		Filter: &interfaces.SearchFilter{Sources: args.Sources, Modes: args.Modes, Plugins: args.Plugins},
+		Prefix: args.Prefix,
+		Limit:  args.Limit,
+	}
+	if rpcErr := validateSearchFilter(request.Filter); rpcErr != nil {
+		LogError(rpcErr, "ListKeybindings")
+		return rpcErr
+	}
+	if request.Limit < 0 || request.Limit > maxListLimit {
+		rpcErr := NewRPCError(ErrorCodeInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+		LogError(rpcErr, "ListKeybindings")
+		return rpcErr
+	}
+	if request.Limit == 0 {
+		request.Limit = defaultListLimit
+	}
+	if args.Cursor != "" {
+		start, err := decodeListCursor(args.Cursor)
+		if err != nil || !listsSource(args.Sources, start.Source) {
+			rpcErr := NewRPCError(ErrorCodeInvalidRequest, "invalid cursor",
+				"pass the next_cursor of the previous page with the same sources")
+			LogError(rpcErr, "ListKeybindings")
+			return rpcErr
+		}
+		request.Start = start
+	}
+
+	page, err := lister.ListKeybindings(ctx, request)
+	if err != nil {
+		rpcErr := WrapError(err, ErrorCodeVectorDBError, "failed to list keybindings")
+		LogError(rpcErr, "ListKeybindings")
+		return rpcErr
+	}
+
+	result.Keybindings = make([]ListedKeybinding, len(page.Keybindings))
+	for i, keybinding := range page.Keybindings {
+		result.Keybindings[i] = ListedKeybinding{Keybinding: convertToRPCKeybinding(keybinding.Keybinding), Source: keybinding.Source}
+	}
+	if page.Next != nil {
+		result.NextCursor = encodeListCursor(page.Next)
+	}
+	return nil
+}
+
+// AnalyzeConflicts reports the keybindings that duplicate each other, wait for longer
+// mappings or clash across modes, with a severity and a suggested fix for each
+func (s *RPCService) AnalyzeConflicts(ctx context.Context, args *AnalyzeConflictsArgs, result *keybindings.ConflictReport) error {
+	var analyzed []interfaces.Keybinding
+	if args != nil && args.Keybindings != nil {
+		if len(args.Keybindings) > 10000 {
+			rpcErr := NewRPCError(ErrorCodeInvalidRequest, "too many keybindings to analyze (max 10000)")
+			LogError(rpcErr, "AnalyzeConflicts")
+			return rpcErr
+		}
+		analyzed = convertFromRPCKeybindings(args.Keybindings)
+	} else {
+		stored, err := s.storedKeybindings(ctx, interfaces.SourceUser)
+		if err != nil {
+			LogError(err, "AnalyzeConflicts")
+			return err
+		}
+		analyzed = stored
+	}
+
+	*result = *keybindings.NewKeybindingParser().AnalyzeConflicts(analyzed)
+	return nil
+}
+
+// FindShadowedBuiltins reports the built-in keybindings that user mappings take the keys
+// of, and whether another mapping restores them
+func (s *RPCService) FindShadowedBuiltins(ctx context.Context, args *FindShadowedBuiltinsArgs, result *keybindings.ShadowReport) error {
+	var user []interfaces.Keybinding
+	if args != nil && args.Keybindings != nil {
+		if len(args.Keybindings) > 10000 {
+			rpcErr := NewRPCError(ErrorCodeInvalidRequest, "too many keybindings to analyze (max 10000)")
+			LogError(rpcErr, "FindShadowedBuiltins")
+			return rpcErr
+		}
+		user = convertFromRPCKeybindings(args.Keybindings)
+	} else {
+		stored, err := s.storedKeybindings(ctx, interfaces.SourceUser)
+		if err != nil {
+			LogError(err, "FindShadowedBuiltins")
+			return err
+		}
+		user = stored
+	}
+
+	builtins, err := s.storedKeybindings(ctx, interfaces.SourceBuiltin)
+	if err != nil {
+		LogError(err, "FindShadowedBuiltins")
+		return err
+	}
+	if len(builtins) == 0 {
+		rpcErr := NewRPCError(ErrorCodeServiceUnavailable, "no built-in keybindings stored",
+			"populate the vim_knowledge collection with scripts/populate-builtin").WithComponent(ComponentVectorDB)
+		LogError(rpcErr, "FindShadowedBuiltins")
+		return rpcErr
+	}
+
+	*result = *keybindings.NewKeybindingParser().FindShadowedBuiltins(user, builtins)
+	return nil
+}
+
+// ExplainKeys looks up what a key sequence does in a mode, in the user and built-in
+// keybindings, and breaks it into the parts of a Vim command
+func (s *RPCService) ExplainKeys(ctx context.Context, args *ExplainKeysArgs, result *ExplainKeysResult) error {
+	if args == nil || strings.TrimSpace(args.Keys) == "" {
+		rpcErr := NewRPCError(ErrorCodeInvalidRequest, "keys cannot be empty")
+		LogError(rpcErr, "ExplainKeys")
+		return rpcErr
+	}
+	mode := args.Mode
+	if mode == "" {
+		mode = "n"
+	}
+	parser := keybindings.NewKeybindingParser()
+	if parser.MappingModes(mode) == nil {
+		rpcErr := NewRPCError(ErrorCodeInvalidRequest, fmt.Sprintf("invalid mode %q", mode))
+		LogError(rpcErr, "ExplainKeys")
+		return rpcErr
+	}
+
+	result.Keys = args.Keys
+	result.Mode = mode
+	result.Matches = []ListedKeybinding{}
+	keys := keybindings.ExpandLeader(args.Keys, args.Leader, args.LocalLeader)
+	for _, source := range []string{interfaces.SourceUser, interfaces.SourceBuiltin} {
+		stored, err := s.storedKeybindings(ctx, source)
+		if err != nil {
+			LogError(err, "ExplainKeys")
+			return err
+		}
+		for _, keybinding := range parser.FindKeys(stored, keys, mode) {
+			result.Matches = append(result.Matches, ListedKeybinding{Keybinding: convertToRPCKeybinding(keybinding), Source: source})
+		}
+	}
+
+	if mode == "n" {
+		result.Sequence = parser.ParseKeySequence(args.Keys)
+	}
+
+	if args.UseLLM && s.llmClient != nil {
+		explanation, err := s.explainKeysWithLLM(ctx, result)
+		if err != nil {
+			// The lookup stands on its own; the summary is only an extra
+			LogError(WrapError(err, ErrorCodeLLMError, "failed to summarize keys"), "ExplainKeys")
+		} else {
+			result.Explanation = explanation
+		}
+	}
+	return nil
+}
+
+// explainKeysWithLLM asks the language model to summarize what the lookup found
+func (s *RPCService) explainKeysWithLLM(ctx context.Context, result *ExplainKeysResult) (string, error) {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Explain in one or two sentences what typing %s in Neovim %s mode does.\n", result.Keys, result.Mode)
+	for _, match := range result.Matches {
+		fmt.Fprintf(&prompt, "- %s keybinding: %s runs %s (%s)\n", match.Source, match.Keys, match.Command, match.Description)
+	}
+	if result.Sequence != nil {
+		for _, part := range result.Sequence.Parts {
+			fmt.Fprintf(&prompt, "- %s %s: %s\n", part.Kind, part.Keys, part.Explanation)
+		}
+	}
+	prompt.WriteString("A user keybinding replaces a built-in one of the same keys.")
+
+	response, err := s.llmClient.GenerateContext(ctx, interfaces.LLMRequest{
+		Prompt:      prompt.String(),
+		MaxTokens:   150,
+		Temperature: 0.2,
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(response.Text), nil
+}
+
+// storedKeybindings returns every keybinding stored for source
+func (s *RPCService) storedKeybindings(ctx context.Context, source string) ([]interfaces.Keybinding, error) {
+	lister, ok := s.ragAgent.(keybindingLister)
+	if !ok {
+		return nil, NewRPCError(ErrorCodeServiceUnavailable, "RAG agent cannot list keybindings").WithComponent(ComponentRAGAgent)
+	}
+
+	request := &interfaces.ListKeybindingsRequest{
+		Filter: &interfaces.SearchFilter{Sources: []string{source}},
+		Limit:  maxListLimit,
+	}
+	var stored []interfaces.Keybinding
+	for {
+		page, err := lister.ListKeybindings(ctx, request)
+		if err != nil {
+			return nil, WrapError(err, ErrorCodeVectorDBError, "failed to list keybindings")
+		}
+		for _, keybinding := range page.Keybindings {
+			stored = append(stored, keybinding.Keybinding)
+		}
+		if page.Next == nil {
+			return stored, nil
+		}
+		request.Start = page.Next
+	}
+}
+
+// listsSource reports whether a listing of sources includes source. General knowledge
+// holds no keybindings and is only listed when asked for.
+func listsSource(sources []string, source string) bool {
+	if len(sources) == 0 {
+		return source != interfaces.SourceGeneral
+	}
+	for _, s := range sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeListCursor turns a listing position into an opaque cursor
+func encodeListCursor(position *interfaces.ListPosition) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(position.Source + ":" + strconv.Itoa(position.Offset)))
+}
+
+// decodeListCursor parses a cursor made by encodeListCursor
+func decodeListCursor(cursor string) (*interfaces.ListPosition, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	source, offset, found := strings.Cut(string(raw), ":")
+	if !found {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	switch source {
+	case interfaces.SourceUser, interfaces.SourceBuiltin, interfaces.SourceGeneral:
+	default:
+		return nil, fmt.Errorf("unknown source %q", source)
+	}
+
+	position := &interfaces.ListPosition{Source: source}
+	if position.Offset, err = strconv.Atoi(offset); err != nil || position.Offset < 0 {
+		return nil, fmt.Errorf("malformed cursor offset")
+	}
+	return position, nil
+}
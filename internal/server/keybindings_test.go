@@ -0,0 +1,274 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"nvim-smart-keybind-search/internal/interfaces"
+	"nvim-smart-keybind-search/internal/keybindings"
+)
+
+// catalogMockRAGAgent lists and deletes from a fixed set of stored keybindings
+type catalogMockRAGAgent struct {
+	MockRAGAgent
+	stored   []interfaces.StoredKeybinding
+	builtins []interfaces.StoredKeybinding
+	selector *interfaces.KeybindingSelector
+	request  *interfaces.ListKeybindingsRequest
+}
+
+func (m *catalogMockRAGAgent) ListKeybindings(ctx context.Context, request *interfaces.ListKeybindingsRequest) (*interfaces.KeybindingPage, error) {
+	m.request = request
+	stored, source := m.stored, interfaces.SourceUser
+	if len(request.Filter.Sources) == 1 && request.Filter.Sources[0] == interfaces.SourceBuiltin {
+		stored, source = m.builtins, interfaces.SourceBuiltin
+	}
+	offset := 0
+	if request.Start != nil {
+		offset = request.Start.Offset
+	}
+
+	page := &interfaces.KeybindingPage{}
+	end := min(offset+request.Limit, len(stored))
+	page.Keybindings = stored[offset:end]
+	if end < len(stored) {
+		page.Next = &interfaces.ListPosition{Source: source, Offset: end}
+	}
+	return page, nil
+}
+
+func (m *catalogMockRAGAgent) DeleteKeybindings(ctx context.Context, selector *interfaces.KeybindingSelector) ([]string, error) {
+	m.selector = selector
+	var ids []string
+	for _, kb := range m.stored {
+		for _, id := range selector.IDs {
+			if kb.ID == id {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+func TestRPCService_ListKeybindings(t *testing.T) {
+	agent := &catalogMockRAGAgent{stored: []interfaces.StoredKeybinding{
+		{Keybinding: interfaces.Keybinding{ID: "1", Keys: "<leader>f"}, Source: interfaces.SourceUser},
+		{Keybinding: interfaces.Keybinding{ID: "2", Keys: "<leader>g"}, Source: interfaces.SourceUser},
+		{Keybinding: interfaces.Keybinding{ID: "3", Keys: "<leader>h"}, Source: interfaces.SourceUser},
+	}}
+	service := NewRPCService(agent, &MockVectorDB{}, &MockLLMClient{})
+
+	var first ListKeybindingsResult
+	args := &ListKeybindingsArgs{Modes: []string{"n"}, Prefix: "<leader>", Limit: 2}
+	if err := service.ListKeybindings(context.Background(), args, &first); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(first.Keybindings) != 2 || first.Keybindings[0].Source != interfaces.SourceUser || first.NextCursor == "" {
+		t.Fatalf("expected a first page of 2 with a cursor, got %+v", first)
+	}
+	if agent.request.Prefix != "<leader>" || agent.request.Filter.Modes[0] != "n" {
+		t.Errorf("expected the filters to reach the agent, got %+v", agent.request)
+	}
+
+	var second ListKeybindingsResult
+	args.Cursor = first.NextCursor
+	if err := service.ListKeybindings(context.Background(), args, &second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(second.Keybindings) != 1 || second.Keybindings[0].ID != "3" || second.NextCursor != "" {
+		t.Errorf("expected a last page with the third keybinding, got %+v", second)
+	}
+
+	var defaults ListKeybindingsResult
+	if err := service.ListKeybindings(context.Background(), nil, &defaults); err != nil || agent.request.Limit != defaultListLimit {
+		t.Errorf("expected the default page size, got %v, %+v", err, agent.request)
+	}
+
+	for name, bad := range map[string]*ListKeybindingsArgs{
+		"malformed cursor":       {Cursor: "not a cursor"},
+		"cursor of other source": {Cursor: encodeListCursor(&interfaces.ListPosition{Source: interfaces.SourceBuiltin}), Sources: []string{interfaces.SourceUser}},
+		"general by default":     {Cursor: encodeListCursor(&interfaces.ListPosition{Source: interfaces.SourceGeneral})},
+		"unknown source":         {Sources: []string{"plugins"}},
+		"limit too large":        {Limit: maxListLimit + 1},
+	} {
+		var result ListKeybindingsResult
+		err := service.ListKeybindings(context.Background(), bad, &result)
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) || rpcErr.Code != ErrorCodeInvalidRequest {
+			t.Errorf("%s: expected an invalid request error, got %v", name, err)
+		}
+	}
+}
+
+func TestListCursorRoundTrip(t *testing.T) {
+	position := &interfaces.ListPosition{Source: interfaces.SourceBuiltin, Offset: 250}
+	decoded, err := decodeListCursor(encodeListCursor(position))
+	if err != nil || *decoded != *position {
+		t.Errorf("expected %+v back, got %+v, %v", position, decoded, err)
+	}
+}
+
+func TestRPCService_DeleteKeybindings(t *testing.T) {
+	agent := &catalogMockRAGAgent{stored: []interfaces.StoredKeybinding{
+		{Keybinding: interfaces.Keybinding{ID: "1", Keys: "gd"}, Source: interfaces.SourceUser},
+	}}
+	service := NewRPCService(agent, &MockVectorDB{}, &MockLLMClient{})
+
+	var result DeleteKeybindingsResult
+	err := service.DeleteKeybindings(context.Background(), &DeleteKeybindingsArgs{IDs: []string{"1", "missing"}, Plugins: []string{"lsp"}}, &result)
+	if err != nil || !result.Success || result.DeletedCount != 1 || result.IDs[0] != "1" {
+		t.Fatalf("expected one keybinding deleted, got %+v, %v", result, err)
+	}
+	if agent.selector.Plugins[0] != "lsp" {
+		t.Errorf("expected the plugin to reach the agent, got %+v", agent.selector)
+	}
+
+	result = DeleteKeybindingsResult{}
+	if err := service.DeleteKeybindings(context.Background(), &DeleteKeybindingsArgs{IDs: []string{"missing"}}, &result); err != nil || result.IDs == nil || result.DeletedCount != 0 {
+		t.Errorf("expected nothing deleted, got %+v, %v", result, err)
+	}
+
+	var rpcErr *RPCError
+	err = service.DeleteKeybindings(context.Background(), &DeleteKeybindingsArgs{}, &result)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != ErrorCodeInvalidRequest {
+		t.Errorf("expected an empty selection to be rejected, got %v", err)
+	}
+
+	service = NewRPCService(&MockRAGAgent{}, &MockVectorDB{}, &MockLLMClient{})
+	err = service.DeleteKeybindings(context.Background(), &DeleteKeybindingsArgs{IDs: []string{"1"}}, &result)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != ErrorCodeServiceUnavailable {
+		t.Errorf("expected service unavailable without delete support, got %v", err)
+	}
+}
+
+func TestRPCService_AnalyzeConflicts(t *testing.T) {
+	agent := &catalogMockRAGAgent{stored: []interfaces.StoredKeybinding{
+		{Keybinding: interfaces.Keybinding{ID: "1", Keys: "<leader>g", Command: ":Git<CR>", Mode: "n"}, Source: interfaces.SourceUser},
+		{Keybinding: interfaces.Keybinding{ID: "2", Keys: "<leader>gs", Command: ":Git status<CR>", Mode: "n"}, Source: interfaces.SourceUser},
+	}}
+	service := NewRPCService(agent, &MockVectorDB{}, &MockLLMClient{})
+
+	var stored keybindings.ConflictReport
+	if err := service.AnalyzeConflicts(context.Background(), &AnalyzeConflictsArgs{}, &stored); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored.Analyzed != 2 || len(stored.Conflicts) != 1 || stored.Conflicts[0].Kind != keybindings.ConflictPrefix {
+		t.Errorf("expected the stored keybindings to have a prefix conflict, got %+v", stored)
+	}
+	if len(agent.request.Filter.Sources) != 1 || agent.request.Filter.Sources[0] != interfaces.SourceUser {
+		t.Errorf("expected only user keybindings to be analyzed, got %+v", agent.request.Filter)
+	}
+
+	var payload keybindings.ConflictReport
+	args := &AnalyzeConflictsArgs{Keybindings: []Keybinding{
+		{ID: "a", Keys: "gx", Command: ":Open<CR>", Mode: "n", Plugin: "netrw"},
+		{ID: "b", Keys: "gx", Command: ":Browse<CR>", Mode: "n", Plugin: "gx.nvim"},
+	}}
+	if err := service.AnalyzeConflicts(context.Background(), args, &payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if payload.Analyzed != 2 || payload.Counts[keybindings.SeverityError] != 1 {
+		t.Errorf("expected the payload to have a duplicate, got %+v", payload)
+	}
+
+	service = NewRPCService(&MockRAGAgent{}, &MockVectorDB{}, &MockLLMClient{})
+	var rpcErr *RPCError
+	err := service.AnalyzeConflicts(context.Background(), nil, &stored)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != ErrorCodeServiceUnavailable {
+		t.Errorf("expected service unavailable without a listing agent, got %v", err)
+	}
+}
+
+func TestRPCService_FindShadowedBuiltins(t *testing.T) {
+	agent := &catalogMockRAGAgent{
+		stored: []interfaces.StoredKeybinding{
+			{Keybinding: interfaces.Keybinding{ID: "1", Keys: "Y", Command: "y$", Mode: "n"}, Source: interfaces.SourceUser},
+		},
+	}
+	service := NewRPCService(agent, &MockVectorDB{}, &MockLLMClient{})
+
+	var rpcErr *RPCError
+	var report keybindings.ShadowReport
+	err := service.FindShadowedBuiltins(context.Background(), nil, &report)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != ErrorCodeServiceUnavailable {
+		t.Errorf("expected service unavailable without built-in keybindings, got %v", err)
+	}
+
+	agent.builtins = []interfaces.StoredKeybinding{
+		{Keybinding: interfaces.Keybinding{ID: "builtin_1", Keys: "Y", Command: "yank line", Mode: "n"}, Source: interfaces.SourceBuiltin},
+		{Keybinding: interfaces.Keybinding{ID: "builtin_2", Keys: "s", Command: "substitute", Mode: "n"}, Source: interfaces.SourceBuiltin},
+	}
+	if err := service.FindShadowedBuiltins(context.Background(), nil, &report); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Analyzed != 1 || len(report.Shadowed) != 1 || report.Shadowed[0].Builtin.Keys != "Y" || report.Lost != 1 {
+		t.Errorf("expected the stored Y mapping to shadow the built-in, got %+v", report)
+	}
+
+	args := &FindShadowedBuiltinsArgs{Keybindings: []Keybinding{{ID: "a", Keys: "s", Command: "<Plug>(leap)", Mode: "n"}}}
+	if err := service.FindShadowedBuiltins(context.Background(), args, &report); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(report.Shadowed) != 1 || report.Shadowed[0].Builtin.Keys != "s" {
+		t.Errorf("expected the payload to shadow s, got %+v", report)
+	}
+}
+
+func TestRPCService_ExplainKeys(t *testing.T) {
+	agent := &catalogMockRAGAgent{
+		stored: []interfaces.StoredKeybinding{
+			{Keybinding: interfaces.Keybinding{ID: "1", Keys: "<Space>ff", Command: ":Telescope find_files<CR>", Mode: "n"}, Source: interfaces.SourceUser},
+			{Keybinding: interfaces.Keybinding{ID: "2", Keys: "gq", Command: ":Format<CR>", Mode: "x"}, Source: interfaces.SourceUser},
+		},
+		builtins: []interfaces.StoredKeybinding{
+			{Keybinding: interfaces.Keybinding{ID: "builtin_1", Keys: "gq", Command: "format", Mode: "n"}, Source: interfaces.SourceBuiltin},
+		},
+	}
+	llm := &MockLLMClient{}
+	service := NewRPCService(agent, &MockVectorDB{}, llm)
+
+	var result ExplainKeysResult
+	if err := service.ExplainKeys(context.Background(), &ExplainKeysArgs{Keys: "<leader>ff", Leader: " "}, &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Mode != "n" || len(result.Matches) != 1 || result.Matches[0].ID != "1" || result.Matches[0].Source != interfaces.SourceUser {
+		t.Errorf("expected the leader mapping to be found, got %+v", result.Matches)
+	}
+	if result.Sequence == nil || len(result.Sequence.Parts) != 0 || result.Explanation != "" {
+		t.Errorf("expected a leader mapping without parts or summary, got %+v", result)
+	}
+
+	result = ExplainKeysResult{}
+	if err := service.ExplainKeys(context.Background(), &ExplainKeysArgs{Keys: "gqip", UseLLM: true}, &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Matches) != 0 || len(result.Sequence.Parts) != 2 || result.Explanation != "mock response" {
+		t.Errorf("expected gqip to be broken down and summarized, got %+v", result)
+	}
+
+	result = ExplainKeysResult{}
+	if err := service.ExplainKeys(context.Background(), &ExplainKeysArgs{Keys: "gq", Mode: "x"}, &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Matches) != 1 || result.Matches[0].ID != "2" || result.Sequence != nil {
+		t.Errorf("expected only the visual mapping of gq, got %+v", result)
+	}
+
+	llm.shouldError = true
+	result = ExplainKeysResult{}
+	if err := service.ExplainKeys(context.Background(), &ExplainKeysArgs{Keys: "gq", UseLLM: true}, &result); err != nil || len(result.Matches) != 1 {
+		t.Errorf("expected the lookup to survive a failing language model, got %+v, %v", result, err)
+	}
+
+	for name, bad := range map[string]*ExplainKeysArgs{
+		"no keys":      {},
+		"invalid mode": {Keys: "gq", Mode: "q"},
+	} {
+		var rpcErr *RPCError
+		err := service.ExplainKeys(context.Background(), bad, &result)
+		if !errors.As(err, &rpcErr) || rpcErr.Code != ErrorCodeInvalidRequest {
+			t.Errorf("%s: expected an invalid request error, got %v", name, err)
+		}
+	}
+}
@@ -5,6 +5,10 @@ import (
 	"time"
 )
 
+// Version is the server version, overridable at build time with
+// -ldflags "-X nvim-smart-keybind-search/internal/server.Version=..."
+var Version = "1.0.0"
+
 // PerformanceMetrics holds performance-related metrics
 type PerformanceMetrics struct {
 	mu                  sync.RWMutex
@@ -19,11 +23,23 @@ type PerformanceMetrics struct {
 	FailedQueries       int64         `json:"failed_queries"`
 	TotalResponseTime   time.Duration `json:"-"` // Used for calculating average
 	StartTime           time.Time     `json:"start_time"`
+
+	// Calls rejected by the rate limiter, in total and per method
+	ThrottledRequests int64            `json:"throttled_requests"`
+	ThrottledByMethod map[string]int64 `json:"throttled_by_method,omitempty"`
+
+	// Latencies of every RPC method, and of the stages of processing a query
+	Methods map[string]LatencySummary `json:"methods,omitempty"`
+	Stages  map[string]LatencySummary `json:"stages,omitempty"`
 }
 
 // MetricsCollector collects and manages performance metrics
 type MetricsCollector struct {
 	metrics *PerformanceMetrics
+
+	// Latency histograms by method and by stage, guarded by metrics.mu
+	methods map[string]*latencyHistogram
+	stages  map[string]*latencyHistogram
 }
 
 // NewMetricsCollector creates a new metrics collector
@@ -33,6 +49,8 @@ func NewMetricsCollector() *MetricsCollector {
 			StartTime:       time.Now(),
 			MinResponseTime: time.Duration(0), // Will be set on first query
 		},
+		methods: make(map[string]*latencyHistogram),
+		stages:  make(map[string]*latencyHistogram),
 	}
 }
 
@@ -66,11 +84,58 @@ func (mc *MetricsCollector) RecordQuery(duration time.Duration, success bool) {
 	}
 }
 
+// RecordMethod records how long a call of an RPC method took
+func (mc *MetricsCollector) RecordMethod(method string, duration time.Duration) {
+	mc.metrics.mu.Lock()
+	defer mc.metrics.mu.Unlock()
+
+	observe(mc.methods, method, duration)
+}
+
+// RecordStage records how long a stage of processing a query took; it is an
+// interfaces.StageRecorder
+func (mc *MetricsCollector) RecordStage(stage string, duration time.Duration) {
+	mc.metrics.mu.Lock()
+	defer mc.metrics.mu.Unlock()
+
+	observe(mc.stages, stage, duration)
+}
+
+// observe adds a duration to the named histogram of a set, creating it if needed
+func observe(histograms map[string]*latencyHistogram, name string, duration time.Duration) {
+	histogram, ok := histograms[name]
+	if !ok {
+		histogram = newLatencyHistogram()
+		histograms[name] = histogram
+	}
+	histogram.observe(duration)
+}
+
+// RecordThrottled records a call of method rejected by the rate limiter
+func (mc *MetricsCollector) RecordThrottled(method string) {
+	mc.metrics.mu.Lock()
+	defer mc.metrics.mu.Unlock()
+
+	mc.metrics.ThrottledRequests++
+	if mc.metrics.ThrottledByMethod == nil {
+		mc.metrics.ThrottledByMethod = make(map[string]int64)
+	}
+	mc.metrics.ThrottledByMethod[method]++
+}
+
 // GetMetrics returns a copy of the current metrics
 func (mc *MetricsCollector) GetMetrics() PerformanceMetrics {
 	mc.metrics.mu.RLock()
 	defer mc.metrics.mu.RUnlock()
 
+	var throttledByMethod map[string]int64
+	if len(mc.metrics.ThrottledByMethod) > 0 {
+		throttledByMethod = make(map[string]int64, len(mc.metrics.ThrottledByMethod))
+		for method, count := range mc.metrics.ThrottledByMethod {
+			throttledByMethod[method] = count
+		}
+	}
+
 	// Return a copy to avoid race conditions
 	return PerformanceMetrics{
 		QueryCount:          mc.metrics.QueryCount,
@@ -83,9 +148,21 @@ func (mc *MetricsCollector) GetMetrics() PerformanceMetrics {
 		SuccessfulQueries:   mc.metrics.SuccessfulQueries,
 		FailedQueries:       mc.metrics.FailedQueries,
 		StartTime:           mc.metrics.StartTime,
+		ThrottledRequests:   mc.metrics.ThrottledRequests,
+		ThrottledByMethod:   throttledByMethod,
+		Methods:             summarize(mc.methods),
+		Stages:              summarize(mc.stages),
 	}
 }
 
+// histograms returns copies of the latency histograms by method and by stage
+func (mc *MetricsCollector) histograms() (methods, stages map[string]*latencyHistogram) {
+	mc.metrics.mu.RLock()
+	defer mc.metrics.mu.RUnlock()
+
+	return copyHistograms(mc.methods), copyHistograms(mc.stages)
+}
+
 // Reset resets all metrics
 func (mc *MetricsCollector) Reset() {
 	mc.metrics.mu.Lock()
@@ -101,6 +178,10 @@ func (mc *MetricsCollector) Reset() {
 	mc.metrics.SuccessfulQueries = 0
 	mc.metrics.FailedQueries = 0
 	mc.metrics.TotalResponseTime = 0
+	mc.metrics.ThrottledRequests = 0
+	mc.metrics.ThrottledByMethod = nil
+	mc.methods = make(map[string]*latencyHistogram)
+	mc.stages = make(map[string]*latencyHistogram)
 	mc.metrics.StartTime = time.Now()
 }
 
@@ -111,6 +192,9 @@ type DetailedHealthStatus struct {
 	Dependencies map[string]DependencyStatus `json:"dependencies"`
 	SystemInfo   SystemInfo                  `json:"system_info"`
 	Uptime       time.Duration               `json:"uptime"`
+
+	// Config is the effective server configuration, when one was set on the service
+	Config interface{} `json:"config,omitempty"`
 }
 
 // DependencyStatus represents the status of a specific dependency
@@ -144,7 +228,7 @@ func NewHealthMonitor() *HealthMonitor {
 	return &HealthMonitor{
 		metricsCollector: NewMetricsCollector(),
 		systemInfo: SystemInfo{
-			Version:   "1.0.0", // This could be set from build flags
+			Version:   Version,
 			StartTime: time.Now(),
 		},
 		startTime: time.Now(),
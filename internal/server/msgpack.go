@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// msgpack-RPC message types
+const (
+	msgpackRequest      = 0
+	msgpackResponse     = 1
+	msgpackNotification = 2
+)
+
+// ReplyRequestMethod is the notification msgpack clients wrap a request in when they
+// cannot block on rpcrequest(), as Neovim would freeze until the response. The response
+// and the notifications sent after it are delivered by calling nvim_exec_lua with the
+// reply chunk, passing the JSON-RPC message as its only argument.
+const ReplyRequestMethod = "$/request"
+
+// ReplyRequestParams represents the params of a $/request notification
+type ReplyRequestParams struct {
+	ID     interface{}     `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Reply  string          `json:"reply"`
+}
+
+// msgpackReply is a JSON-RPC message delivered through the reply chunk of a $/request
+type msgpackReply struct {
+	chunk   string
+	message interface{}
+}
+
+// serveMsgpack serves the handler over msgpack-RPC, the protocol Neovim speaks on
+// channels opened with jobstart(..., {rpc = true}). Requests are mapped onto the
+// JSON-RPC handler, so both transports expose exactly the same methods and payloads.
+func (d *Dispatcher) serveMsgpack(r io.Reader, w io.Writer) error {
+	decoder := msgpack.NewDecoder(bufio.NewReader(r))
+	decoder.UseLooseInterfaceDecoding(true)
+
+	writer := bufio.NewWriter(w)
+	encoder := msgpack.NewEncoder(writer)
+
+	// Reply chunk of the latest $/request, which notifications are delivered through too
+	var reply atomic.Value
+	s := d.newSession(func(message interface{}) error {
+		if notification, ok := message.(*JSONRPCNotification); ok {
+			if chunk, _ := reply.Load().(string); chunk != "" {
+				message = &msgpackReply{chunk: chunk, message: notification}
+			}
+		}
+		if err := encodeMsgpackMessage(encoder, message); err != nil {
+			return err
+		}
+		return writer.Flush()
+	})
+
+	messages := receive(decoder.DecodeInterfaceLoose, d.closed)
+
+	var readErr error
+	for {
+		message, err := next(messages, d.closed)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				readErr = err
+			}
+			break
+		}
+
+		req, err := parseMsgpackMessage(message)
+		if err != nil {
+			log.Printf("Ignoring invalid msgpack-RPC message: %v", err)
+			if req != nil && !req.IsNotification() {
+				s.outgoing <- newErrorResponse(JSONRPCInvalidRequest, "Invalid Request", req.ID)
+			}
+			continue
+		}
+
+		if req.Method == CancelRequestMethod {
+			s.cancelRequest(req.Params)
+			continue
+		}
+
+		if req.Method == ReplyRequestMethod {
+			chunk, err := unwrapReplyRequest(req)
+			if err != nil {
+				log.Printf("Ignoring malformed %s: %v", ReplyRequestMethod, err)
+				continue
+			}
+			reply.Store(chunk)
+			s.dispatch(req, func(response *JSONRPCResponse) {
+				s.outgoing <- &msgpackReply{chunk: chunk, message: response}
+			})
+			continue
+		}
+
+		s.dispatch(req, func(response *JSONRPCResponse) {
+			if !req.IsNotification() {
+				s.outgoing <- response
+			}
+		})
+	}
+
+	s.finish()
+
+	if readErr != nil {
+		return fmt.Errorf("error reading requests: %w", readErr)
+	}
+	return nil
+}
+
+// parseMsgpackMessage converts a msgpack-RPC request ([0, msgid, method, params]) or
+// notification ([2, method, params]) into a JSON-RPC request. On error the returned
+// request, if any, carries the msgid to report the error against.
+func parseMsgpackMessage(message interface{}) (*JSONRPCRequest, error) {
+	fields, ok := message.([]interface{})
+	if !ok || len(fields) < 3 {
+		return nil, fmt.Errorf("expected message array, got %T", message)
+	}
+
+	messageType, ok := fields[0].(int64)
+	if !ok {
+		if unsigned, isUnsigned := fields[0].(uint64); isUnsigned {
+			messageType, ok = int64(unsigned), true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("invalid message type %v", fields[0])
+	}
+
+	req := &JSONRPCRequest{JSONRPC: "2.0"}
+	switch {
+	case messageType == msgpackRequest && len(fields) == 4:
+		req.ID = fields[1]
+		req.hasID = true
+		fields = fields[2:]
+	case messageType == msgpackNotification && len(fields) == 3:
+		fields = fields[1:]
+	default:
+		return nil, fmt.Errorf("unsupported message type %d with %d fields", messageType, len(fields))
+	}
+
+	method, ok := fields[0].(string)
+	if !ok || method == "" {
+		return req, fmt.Errorf("invalid method %v", fields[0])
+	}
+	req.Method = method
+
+	params, err := msgpackParams(fields[1])
+	if err != nil {
+		return req, err
+	}
+	req.Params = params
+	return req, nil
+}
+
+// unwrapReplyRequest turns a $/request notification into the request it carries and
+// returns the chunk its response is delivered through
+func unwrapReplyRequest(req *JSONRPCRequest) (string, error) {
+	var params ReplyRequestParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return "", fmt.Errorf("invalid params: %w", err)
+	}
+	if params.ID == nil || params.Method == "" || params.Reply == "" {
+		return "", fmt.Errorf("id, method and reply are required")
+	}
+
+	req.ID = params.ID
+	req.hasID = true
+	req.Method = params.Method
+	req.Params = params.Params
+	// Lua encodes an empty params table as an empty array
+	if string(req.Params) == "[]" {
+		req.Params = nil
+	}
+	return params.Reply, nil
+}
+
+// msgpackParams maps msgpack-RPC positional arguments onto JSON-RPC params. Clients
+// call rpcrequest(chan, method, {args}), so a single argument is the params object and
+// an empty one (Lua encodes {} as an empty array) means no params.
+func msgpackParams(params interface{}) (json.RawMessage, error) {
+	args, ok := params.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("params must be an array, got %T", params)
+	}
+
+	var value interface{} = args
+	if len(args) == 0 {
+		return nil, nil
+	}
+	if len(args) == 1 {
+		if inner, isArray := args[0].([]interface{}); isArray && len(inner) == 0 {
+			return nil, nil
+		}
+		value = args[0]
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid params: %w", err)
+	}
+	return raw, nil
+}
+
+// encodeMsgpackMessage writes a JSON-RPC response as a msgpack-RPC response
+// ([1, msgid, error, result]), a notification as a msgpack-RPC notification
+// ([2, method, [params]]) and a reply as a call of its chunk
+// ([2, "nvim_exec_lua", [chunk, [message]]])
+func encodeMsgpackMessage(encoder *msgpack.Encoder, message interface{}) error {
+	switch m := message.(type) {
+	case *JSONRPCResponse:
+		var rpcErr interface{}
+		if m.Error != nil {
+			// Neovim reports [code, message] errors from rpcrequest() as readable messages
+			errorFields := []interface{}{m.Error.Code, m.Error.Message}
+			if m.Error.Data != nil {
+				data, err := toMsgpackValue(m.Error.Data)
+				if err != nil {
+					return err
+				}
+				errorFields = append(errorFields, data)
+			}
+			rpcErr = errorFields
+		}
+
+		result, err := toMsgpackValue(m.Result)
+		if err != nil {
+			return err
+		}
+		return encoder.Encode([]interface{}{msgpackResponse, m.ID, rpcErr, result})
+	case *JSONRPCNotification:
+		params, err := toMsgpackValue(m.Params)
+		if err != nil {
+			return err
+		}
+		args := []interface{}{}
+		if params != nil {
+			args = append(args, params)
+		}
+		return encoder.Encode([]interface{}{msgpackNotification, m.Method, args})
+	case *msgpackReply:
+		// The JSON-RPC shape keeps error.data, which rpcrequest() errors lose
+		message, err := toMsgpackValue(m.message)
+		if err != nil {
+			return err
+		}
+		return encoder.Encode([]interface{}{msgpackNotification, "nvim_exec_lua", []interface{}{m.chunk, []interface{}{message}}})
+	default:
+		return fmt.Errorf("unsupported msgpack-RPC message %T", message)
+	}
+}
+
+// toMsgpackValue converts a result through its JSON form so that msgpack clients see
+// the same field names, omitted fields and timestamp strings as JSON clients
+func toMsgpackValue(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal result: %w", err)
+	}
+
+	var converted interface{}
+	if err := json.Unmarshal(raw, &converted); err != nil {
+		return nil, fmt.Errorf("failed to convert result: %w", err)
+	}
+	return converted, nil
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestDispatcher_ServeMsgpack(t *testing.T) {
+	var notified int32
+	dispatcher := NewDispatcher(func(ctx context.Context, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+		switch req.Method {
+		case "Query":
+			var args QueryArgs
+			if err := json.Unmarshal(req.Params, &args); err != nil {
+				return nil, &JSONRPCError{Code: JSONRPCInvalidParams, Message: "Invalid params"}
+			}
+			return &QueryResult{Reasoning: args.Query}, nil
+		case "HealthCheck":
+			if len(req.Params) != 0 {
+				return nil, &JSONRPCError{Code: JSONRPCInvalidParams, Message: "unexpected params"}
+			}
+			return &HealthStatus{Status: "healthy", Timestamp: time.Unix(0, 0).UTC()}, nil
+		case "UpdateKeybindings":
+			atomic.AddInt32(&notified, 1)
+			return nil, nil
+		default:
+			return nil, &JSONRPCError{Code: JSONRPCMethodNotFound, Message: "Method not found"}
+		}
+	}, &DispatcherConfig{MaxInFlight: 1, Transport: TransportMsgpack})
+
+	var input bytes.Buffer
+	encoder := msgpack.NewEncoder(&input)
+	encoder.Encode([]interface{}{0, 1, "Query", []interface{}{map[string]interface{}{"query": "delete line", "limit": 5}}})
+	// Lua encodes an empty table as an empty array
+	encoder.Encode([]interface{}{0, 2, "HealthCheck", []interface{}{[]interface{}{}}})
+	encoder.Encode([]interface{}{2, "UpdateKeybindings", []interface{}{map[string]interface{}{"keybindings": []interface{}{}}}})
+	encoder.Encode([]interface{}{0, 3, "Missing", []interface{}{}})
+	encoder.Encode([]interface{}{0, 4, 42, []interface{}{}})
+
+	var output bytes.Buffer
+	if err := dispatcher.Serve(&input, &output); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	responses := make(map[uint64][]interface{})
+	decoder := msgpack.NewDecoder(&output)
+	for {
+		var message []interface{}
+		if err := decoder.Decode(&message); err != nil {
+			break
+		}
+		if len(message) != 4 {
+			t.Fatalf("expected 4-element response, got %v", message)
+		}
+		if kind, _ := toInt64(message[0]); kind != msgpackResponse {
+			t.Errorf("expected response type 1, got %v", message[0])
+		}
+		id, _ := toUint64(message[1])
+		responses[id] = message
+	}
+
+	if len(responses) != 4 {
+		t.Fatalf("expected 4 responses (none for the notification), got %d", len(responses))
+	}
+	if notified != 1 {
+		t.Errorf("expected notification to be handled once, got %d", notified)
+	}
+
+	query, ok := responses[1][3].(map[string]interface{})
+	if responses[1][2] != nil || !ok || query["reasoning"] != "delete line" {
+		t.Errorf("unexpected Query response: %v", responses[1])
+	}
+
+	// Results keep their JSON shape, including timestamps as strings
+	health, _ := responses[2][3].(map[string]interface{})
+	if health["status"] != "healthy" || health["timestamp"] != "1970-01-01T00:00:00Z" {
+		t.Errorf("unexpected HealthCheck response: %v", responses[2])
+	}
+
+	for id, code := range map[uint64]int{3: JSONRPCMethodNotFound, 4: JSONRPCInvalidRequest} {
+		rpcErr, ok := responses[id][2].([]interface{})
+		if !ok || len(rpcErr) < 2 {
+			t.Errorf("expected [code, message] error for id %d, got %v", id, responses[id][2])
+			continue
+		}
+		if got, _ := toInt64(rpcErr[0]); got != int64(code) {
+			t.Errorf("expected error code %d for id %d, got %v", code, id, rpcErr[0])
+		}
+	}
+}
+
+func TestDispatcher_ServeMsgpackReplyRequest(t *testing.T) {
+	dispatcher := NewDispatcher(func(ctx context.Context, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+		switch req.Method {
+		case "SyncKeybindings":
+			Notify(ctx, "$/progress", map[string]interface{}{"token": "sync-1", "processed": 1})
+			return map[string]interface{}{"synced": true}, nil
+		case "Query":
+			if len(req.Params) != 0 {
+				return nil, &JSONRPCError{Code: JSONRPCInvalidParams, Message: "unexpected params"}
+			}
+			return nil, &JSONRPCError{Code: 4003, Message: "Rate limited", Data: map[string]interface{}{"retryable": true}}
+		default:
+			return nil, &JSONRPCError{Code: JSONRPCMethodNotFound, Message: "Method not found"}
+		}
+	}, &DispatcherConfig{MaxInFlight: 1, Transport: TransportMsgpack})
+
+	const chunk = "return handle_message(...)"
+	var input bytes.Buffer
+	encoder := msgpack.NewEncoder(&input)
+	encoder.Encode([]interface{}{2, ReplyRequestMethod, []interface{}{map[string]interface{}{
+		"id": 1, "method": "SyncKeybindings", "params": map[string]interface{}{"keybindings": []interface{}{}}, "reply": chunk,
+	}}})
+	// Lua encodes an empty params table as an empty array
+	encoder.Encode([]interface{}{2, ReplyRequestMethod, []interface{}{map[string]interface{}{
+		"id": 2, "method": "Query", "params": []interface{}{}, "reply": chunk,
+	}}})
+	encoder.Encode([]interface{}{2, ReplyRequestMethod, []interface{}{map[string]interface{}{"id": 3, "method": "Query"}}})
+
+	var output bytes.Buffer
+	if err := dispatcher.Serve(&input, &output); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var messages []map[string]interface{}
+	decoder := msgpack.NewDecoder(&output)
+	for {
+		var message []interface{}
+		if err := decoder.Decode(&message); err != nil {
+			break
+		}
+		args, _ := message[2].([]interface{})
+		if kind, _ := toInt64(message[0]); kind != msgpackNotification || message[1] != "nvim_exec_lua" || len(args) != 2 || args[0] != chunk {
+			t.Fatalf("expected a call of the reply chunk, got %v", message)
+		}
+		callArgs, _ := args[1].([]interface{})
+		if len(callArgs) != 1 {
+			t.Fatalf("expected the message as the only argument, got %v", args[1])
+		}
+		messages = append(messages, callArgs[0].(map[string]interface{}))
+	}
+
+	// The request missing its reply chunk is ignored
+	if len(messages) != 3 {
+		t.Fatalf("expected a progress notification and 2 responses, got %v", messages)
+	}
+	if messages[0]["method"] != "$/progress" {
+		t.Errorf("expected the progress notification ahead of its response, got %v", messages[0])
+	}
+	// Ids go through their JSON form, as numbers
+	if messages[1]["result"] == nil || messages[1]["id"] != float64(1) {
+		t.Errorf("unexpected SyncKeybindings response: %v", messages[1])
+	}
+
+	rpcErr, _ := messages[2]["error"].(map[string]interface{})
+	data, _ := rpcErr["data"].(map[string]interface{})
+	if messages[2]["id"] != float64(2) || rpcErr["message"] != "Rate limited" || data["retryable"] != true {
+		t.Errorf("expected the Query error with its data, got %v", messages[2])
+	}
+}
+
+func TestParseMsgpackMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message interface{}
+		wantErr bool
+		params  string
+	}{
+		{name: "request", message: []interface{}{int64(0), uint64(1), "Query", []interface{}{map[string]interface{}{"query": "x"}}}, params: `{"query":"x"}`},
+		{name: "positional params", message: []interface{}{int64(0), uint64(1), "Query", []interface{}{"a", "b"}}, params: `["a","b"]`},
+		{name: "notification", message: []interface{}{int64(2), "Query", []interface{}{}}},
+		{name: "response is not a request", message: []interface{}{int64(1), uint64(1), nil, nil}, wantErr: true},
+		{name: "not an array", message: "Query", wantErr: true},
+		{name: "params not an array", message: []interface{}{int64(2), "Query", "x"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := parseMsgpackMessage(tt.message)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(req.Params) != tt.params {
+				t.Errorf("expected params %q, got %q", tt.params, string(req.Params))
+			}
+		})
+	}
+}
+
+// toUint64 converts any msgpack-decoded integer to uint64
+func toUint64(value interface{}) (uint64, bool) {
+	n, ok := toInt64(value)
+	return uint64(n), ok
+}
+
+// toInt64 converts any msgpack-decoded integer to int64
+func toInt64(value interface{}) (int64, bool) {
+	switch n := value.(type) {
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OpenRPCVersion is the version of the OpenRPC specification the documents follow
+const OpenRPCVersion = "1.2.6"
+
+// OpenRPCDocument is the service description returned by rpc.discover
+type OpenRPCDocument struct {
+	OpenRPC    string            `json:"openrpc"`
+	Info       OpenRPCInfo       `json:"info"`
+	Methods    []OpenRPCMethod   `json:"methods"`
+	Components OpenRPCComponents `json:"components"`
+}
+
+// OpenRPCInfo describes the service itself
+type OpenRPCInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenRPCMethod describes a single method
+type OpenRPCMethod struct {
+	Name           string                     `json:"name"`
+	Summary        string                     `json:"summary,omitempty"`
+	ParamStructure string                     `json:"paramStructure"`
+	Params         []OpenRPCContentDescriptor `json:"params"`
+	Result         OpenRPCContentDescriptor   `json:"result"`
+}
+
+// OpenRPCContentDescriptor describes a named param or result
+type OpenRPCContentDescriptor struct {
+	Name     string      `json:"name"`
+	Required bool        `json:"required,omitempty"`
+	Schema   *JSONSchema `json:"schema"`
+}
+
+// OpenRPCComponents holds the schemas referenced by methods
+type OpenRPCComponents struct {
+	Schemas map[string]*JSONSchema `json:"schemas"`
+}
+
+// JSONSchema is the subset of JSON Schema needed to describe the RPC types
+type JSONSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+}
+
+// Discover builds the OpenRPC document for all registered methods. Args structs are
+// flattened into by-name params; named struct types are shared as components.
+func (r *Registry) Discover() *OpenRPCDocument {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	generator := &schemaGenerator{schemas: make(map[string]*JSONSchema)}
+	doc := &OpenRPCDocument{
+		OpenRPC: OpenRPCVersion,
+		Info:    r.info,
+		Methods: make([]OpenRPCMethod, 0, len(r.methods)),
+	}
+
+	for _, name := range r.sortedNamesLocked() {
+		method := r.methods[name]
+
+		params := []OpenRPCContentDescriptor{}
+		argsSchema := generator.objectSchema(method.argsType)
+		for _, field := range sortedKeys(argsSchema.Properties) {
+			params = append(params, OpenRPCContentDescriptor{
+				Name:     field,
+				Required: containsString(argsSchema.Required, field),
+				Schema:   argsSchema.Properties[field],
+			})
+		}
+
+		doc.Methods = append(doc.Methods, OpenRPCMethod{
+			Name:           name,
+			Summary:        method.summary,
+			ParamStructure: "by-name",
+			Params:         params,
+			Result: OpenRPCContentDescriptor{
+				Name:   method.resultType.Name(),
+				Schema: generator.schema(method.resultType),
+			},
+		})
+	}
+
+	doc.Components.Schemas = generator.schemas
+	return doc
+}
+
+// sortedNamesLocked returns method names in sorted order; r.mu must be held
+func (r *Registry) sortedNamesLocked() []string {
+	names := make([]string, 0, len(r.methods))
+	for name := range r.methods {
+		names = append(names, name)
+	}
+	return sortedStrings(names)
+}
+
+// schemaGenerator derives JSON schemas from Go types using their json tags
+type schemaGenerator struct {
+	schemas map[string]*JSONSchema
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	durationType   = reflect.TypeOf(time.Duration(0))
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// schema returns the schema for t, referencing named structs through components
+func (g *schemaGenerator) schema(t reflect.Type) *JSONSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &JSONSchema{Type: "integer", Description: "duration in nanoseconds"}
+	case rawMessageType:
+		return &JSONSchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.objectSchema(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			// Reserve the name first so self-referencing types terminate
+			g.schemas[t.Name()] = &JSONSchema{}
+			*g.schemas[t.Name()] = *g.objectSchema(t)
+		}
+		return &JSONSchema{Ref: "#/components/schemas/" + t.Name()}
+	default:
+		// interface{} and anything else accepts any value
+		return &JSONSchema{}
+	}
+}
+
+// objectSchema returns the inline object schema for a struct type. Fields without
+// omitempty are required, embedded structs are flattened as encoding/json does.
+func (g *schemaGenerator) objectSchema(t reflect.Type) *JSONSchema {
+	schema := &JSONSchema{Type: "object", Properties: make(map[string]*JSONSchema)}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, omitEmpty, skip := parseJSONTag(field)
+		if skip {
+			continue
+		}
+
+		if field.Anonymous && field.Tag.Get("json") == "" && field.Type.Kind() == reflect.Struct {
+			embedded := g.objectSchema(field.Type)
+			for property, propertySchema := range embedded.Properties {
+				schema.Properties[property] = propertySchema
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+
+		schema.Properties[name] = g.schema(field.Type)
+		if !omitEmpty {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+
+	schema.Required = sortedStrings(schema.Required)
+	return schema
+}
+
+// parseJSONTag returns the wire name of a field and whether it is omitempty or skipped
+func parseJSONTag(field reflect.StructField) (name string, omitEmpty bool, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty, false
+}
+
+// sortedKeys returns the keys of a schema map in sorted order
+func sortedKeys(m map[string]*JSONSchema) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return sortedStrings(keys)
+}
+
+// sortedStrings sorts s in place and returns it
+func sortedStrings(s []string) []string {
+	sort.Strings(s)
+	return s
+}
+
+// containsString reports whether s includes value
+func containsString(s []string, value string) bool {
+	for _, item := range s {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// idleClientTimeout is how long the buckets of a client that stopped calling are kept
+const idleClientTimeout = 10 * time.Minute
+
+// RateLimit is a token bucket allowing Rate calls per second on average, in bursts of
+// up to Burst calls. A zero Rate means no limit.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Unlimited reports whether the limit lets every call through
+func (l RateLimit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// RateLimitConfig holds the limits applied to RPC calls. Every client connection gets
+// its own buckets, so one misbehaving client cannot starve the others.
+type RateLimitConfig struct {
+	// Connection limits all calls of a connection together
+	Connection RateLimit
+
+	// Methods limits the calls of individual methods; other methods are only subject to
+	// the connection limit
+	Methods map[string]RateLimit
+}
+
+// DefaultRateLimitConfig returns limits generous enough for interactive use that still
+// stop a runaway autocmd from saturating Ollama and ChromaDB
+func DefaultRateLimitConfig() *RateLimitConfig {
+	return &RateLimitConfig{
+		Connection: RateLimit{Rate: 50, Burst: 100},
+		Methods: map[string]RateLimit{
+			"Query":             {Rate: 5, Burst: 20},
+			"ExplainKeys":       {Rate: 5, Burst: 20},
+			"UpdateKeybindings": {Rate: 2, Burst: 10},
+			"DeleteKeybindings": {Rate: 2, Burst: 10},
+			"SyncKeybindings":   {Rate: 0.2, Burst: 3},
+			"PullModel":         {Rate: 0.1, Burst: 2},
+			"RebuildDatabase":   {Rate: 0.1, Burst: 2},
+		},
+	}
+}
+
+// tokenBucket holds the tokens left for one limit
+type tokenBucket struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+// newTokenBucket creates a full bucket; it always holds at least one token
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	capacity := math.Max(1, float64(limit.Burst))
+	return &tokenBucket{rate: limit.Rate, capacity: capacity, tokens: capacity, last: now}
+}
+
+// refill adds the tokens earned since the last call
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+// wait returns how long until the bucket has a token, or zero if it has one now
+func (b *tokenBucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - b.tokens) / b.rate * float64(time.Second)))
+}
+
+// clientBuckets holds the buckets of one client connection
+type clientBuckets struct {
+	connection *tokenBucket
+	methods    map[string]*tokenBucket
+	lastSeen   time.Time
+}
+
+// RateLimiter rejects calls exceeding the configured limits. It is safe for concurrent use.
+type RateLimiter struct {
+	config *RateLimitConfig
+	now    func() time.Time
+
+	mu        sync.Mutex
+	clients   map[*SessionState]*clientBuckets
+	lastPrune time.Time
+}
+
+// NewRateLimiter creates a rate limiter with the given limits
+func NewRateLimiter(config *RateLimitConfig) *RateLimiter {
+	if config == nil {
+		config = DefaultRateLimitConfig()
+	}
+
+	return &RateLimiter{
+		config:  config,
+		now:     time.Now,
+		clients: make(map[*SessionState]*clientBuckets),
+	}
+}
+
+// Allow takes a token for a call of method by the client of ctx. Calls made outside a
+// session share one set of buckets. When a limit is exceeded, no token is taken and
+// Allow returns an ErrorCodeRateLimited error saying when to retry.
+func (rl *RateLimiter) Allow(ctx context.Context, method string) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.pruneLocked(now)
+
+	client := rl.clientLocked(SessionStateFromContext(ctx), now)
+
+	buckets := make([]*tokenBucket, 0, 2)
+	if client.connection != nil {
+		buckets = append(buckets, client.connection)
+	}
+	if limit, ok := rl.config.Methods[method]; ok && !limit.Unlimited() {
+		bucket, ok := client.methods[method]
+		if !ok {
+			bucket = newTokenBucket(limit, now)
+			client.methods[method] = bucket
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	// A call takes a token from every bucket or from none of them
+	var retryAfter time.Duration
+	for _, bucket := range buckets {
+		bucket.refill(now)
+		if wait := bucket.wait(); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		return NewRateLimitedError(method, retryAfter)
+	}
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	return nil
+}
+
+// clientLocked returns the buckets of a client, creating them on its first call
+func (rl *RateLimiter) clientLocked(state *SessionState, now time.Time) *clientBuckets {
+	client, ok := rl.clients[state]
+	if !ok {
+		client = &clientBuckets{methods: make(map[string]*tokenBucket)}
+		if !rl.config.Connection.Unlimited() {
+			client.connection = newTokenBucket(rl.config.Connection, now)
+		}
+		rl.clients[state] = client
+	}
+	client.lastSeen = now
+	return client
+}
+
+// pruneLocked forgets clients that have been idle long enough for their buckets to be
+// full again, such as closed connections
+func (rl *RateLimiter) pruneLocked(now time.Time) {
+	if now.Sub(rl.lastPrune) < idleClientTimeout {
+		return
+	}
+	rl.lastPrune = now
+
+	for state, client := range rl.clients {
+		if now.Sub(client.lastSeen) >= idleClientTimeout {
+			delete(rl.clients, state)
+		}
+	}
+}
+
+// NewRateLimitedError creates the error returned for a call rejected by the rate limiter
+func NewRateLimitedError(method string, retryAfter time.Duration) *RPCError {
+	rpcErr := NewRPCError(ErrorCodeRateLimited, "too many "+method+" requests",
+		fmt.Sprintf("retry after %v", retryAfter.Round(time.Millisecond)))
+	rpcErr.RetryAfter = retryAfter
+	return rpcErr
+}
+
+// SetRateLimiter sets the limiter applied to calls of the registered methods; calls
+// are not limited without one
+func (s *RPCService) SetRateLimiter(limiter *RateLimiter) {
+	s.limiter = limiter
+}
+
+// checkRateLimit rejects a call of method exceeding the rate limits and counts it
+func (s *RPCService) checkRateLimit(ctx context.Context, method string) error {
+	if s.limiter == nil {
+		return nil
+	}
+
+	err := s.limiter.Allow(ctx, method)
+	if err != nil {
+		LogError(err, method)
+		if s.healthMonitor != nil {
+			s.healthMonitor.GetMetricsCollector().RecordThrottled(method)
+		}
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(&RateLimitConfig{
+		Connection: RateLimit{Rate: 100, Burst: 100},
+		Methods:    map[string]RateLimit{"UpdateKeybindings": {Rate: 1, Burst: 2}},
+	})
+	limiter.now = func() time.Time { return now }
+
+	client := WithSessionState(context.Background(), NewSessionState())
+	other := WithSessionState(context.Background(), NewSessionState())
+
+	for i := 0; i < 2; i++ {
+		if err := limiter.Allow(client, "UpdateKeybindings"); err != nil {
+			t.Fatalf("expected call %d within the burst to pass, got %v", i, err)
+		}
+	}
+
+	err := limiter.Allow(client, "UpdateKeybindings")
+	rpcErr, ok := err.(*RPCError)
+	if !ok || rpcErr.Code != ErrorCodeRateLimited || rpcErr.RetryAfter != time.Second {
+		t.Fatalf("expected rate limited error with a one second retry hint, got %+v", err)
+	}
+
+	// Other methods and other connections have their own buckets
+	if err := limiter.Allow(client, "Query"); err != nil {
+		t.Errorf("expected unlimited method to pass, got %v", err)
+	}
+	if err := limiter.Allow(other, "UpdateKeybindings"); err != nil {
+		t.Errorf("expected other connection to pass, got %v", err)
+	}
+
+	now = now.Add(time.Second)
+	if err := limiter.Allow(client, "UpdateKeybindings"); err != nil {
+		t.Errorf("expected bucket to refill, got %v", err)
+	}
+}
+
+func TestRateLimiter_ConnectionLimit(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(&RateLimitConfig{
+		Connection: RateLimit{Rate: 2, Burst: 1},
+		Methods:    map[string]RateLimit{"Query": {Rate: 1, Burst: 5}},
+	})
+	limiter.now = func() time.Time { return now }
+
+	if err := limiter.Allow(context.Background(), "HealthCheck"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := limiter.Allow(context.Background(), "Query")
+	if rpcErr, ok := err.(*RPCError); !ok || rpcErr.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("expected connection limit to apply to all methods, got %v", err)
+	}
+
+	// The rejected call took no token from the method bucket
+	now = now.Add(500 * time.Millisecond)
+	if err := limiter.Allow(context.Background(), "Query"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if tokens := limiter.clients[nil].methods["Query"].tokens; tokens != 4 {
+		t.Errorf("expected 4 tokens left in the method bucket, got %v", tokens)
+	}
+}
+
+func TestRPCService_RateLimited(t *testing.T) {
+	registry := NewRegistry("test", "1.0.0")
+	service := NewRPCService(&MockRAGAgent{}, &MockVectorDB{}, &MockLLMClient{})
+	service.SetRateLimiter(NewRateLimiter(&RateLimitConfig{
+		Methods: map[string]RateLimit{"UpdateKeybindings": {Rate: 0.001, Burst: 1}},
+	}))
+	service.RegisterMethods(registry)
+
+	req := &JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "UpdateKeybindings",
+		Params:  json.RawMessage(`{"keybindings":[{"id":"dd","keys":"dd","command":"delete line","mode":"n"}]}`),
+	}
+	if _, rpcErr := registry.Handle(context.Background(), req); rpcErr != nil {
+		t.Fatalf("unexpected error: %+v", rpcErr)
+	}
+
+	_, rpcErr := registry.Handle(context.Background(), req)
+	if rpcErr == nil {
+		t.Fatal("expected second call to be rate limited")
+	}
+	data, ok := rpcErr.Data.(*ErrorData)
+	if !ok || data.Code != ErrorCodeRateLimited || !data.Retryable || data.RetryAfterMs <= 0 {
+		t.Errorf("expected rate limited error data with a retry hint, got %+v", rpcErr.Data)
+	}
+
+	var metrics PerformanceMetrics
+	service.GetMetrics(&GetMetricsArgs{}, &metrics)
+	if metrics.ThrottledRequests != 1 || metrics.ThrottledByMethod["UpdateKeybindings"] != 1 {
+		t.Errorf("expected one throttled call in metrics, got %d %v", metrics.ThrottledRequests, metrics.ThrottledByMethod)
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// DiscoverMethod returns the OpenRPC document describing all registered methods
+const DiscoverMethod = "rpc.discover"
+
+// MethodFunc is an RPC method bound to typed args and result structs
+type MethodFunc[A, R any] func(ctx context.Context, args *A, result *R) error
+
+// WithoutContext adapts a net/rpc style method that takes no context
+func WithoutContext[A, R any](fn func(args *A, result *R) error) MethodFunc[A, R] {
+	return func(ctx context.Context, args *A, result *R) error {
+		return fn(args, result)
+	}
+}
+
+// registeredMethod holds a method's handler together with the types describing it
+type registeredMethod struct {
+	name       string
+	summary    string
+	argsType   reflect.Type
+	resultType reflect.Type
+	call       func(ctx context.Context, params json.RawMessage) (interface{}, *JSONRPCError)
+}
+
+// Registry maps method names to typed handlers and describes them as OpenRPC
+type Registry struct {
+	mu      sync.RWMutex
+	methods map[string]*registeredMethod
+	info    OpenRPCInfo
+}
+
+// NewRegistry creates an empty registry; title and version describe the service in rpc.discover
+func NewRegistry(title, version string) *Registry {
+	return &Registry{
+		methods: make(map[string]*registeredMethod),
+		info:    OpenRPCInfo{Title: title, Version: version},
+	}
+}
+
+// Register binds fn to name. Params are decoded into a fresh A for every call and the
+// filled-in R is returned as the result. Registering a name twice replaces the method.
+func Register[A, R any](r *Registry, name, summary string, fn MethodFunc[A, R]) {
+	method := &registeredMethod{
+		name:       name,
+		summary:    summary,
+		argsType:   reflect.TypeOf((*A)(nil)).Elem(),
+		resultType: reflect.TypeOf((*R)(nil)).Elem(),
+		call: func(ctx context.Context, params json.RawMessage) (interface{}, *JSONRPCError) {
+			args := new(A)
+			if err := unmarshalParams(params, args); err != nil {
+				return nil, &JSONRPCError{Code: JSONRPCInvalidParams, Message: "Invalid params"}
+			}
+
+			result := new(R)
+			if err := fn(ctx, args, result); err != nil {
+				return nil, ToJSONRPCError(err)
+			}
+			return result, nil
+		},
+	}
+
+	r.mu.Lock()
+	r.methods[name] = method
+	r.mu.Unlock()
+}
+
+// Handle dispatches a request to its registered method; it satisfies HandlerFunc
+func (r *Registry) Handle(ctx context.Context, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+	if req.Method == DiscoverMethod {
+		return r.Discover(), nil
+	}
+
+	r.mu.RLock()
+	method, ok := r.methods[req.Method]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, &JSONRPCError{Code: JSONRPCMethodNotFound, Message: "Method not found"}
+	}
+	return method.call(ctx, req.Params)
+}
+
+// Methods returns the names of all registered methods in sorted order
+func (r *Registry) Methods() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.sortedNamesLocked()
+}
+
+// unmarshalParams decodes request params into args, treating absent, null and empty
+// array params (how Lua encodes an empty table) as no arguments
+func unmarshalParams(params json.RawMessage, args interface{}) error {
+	var empty []interface{}
+	if len(params) == 0 || (json.Unmarshal(params, &empty) == nil && len(empty) == 0) {
+		return nil
+	}
+	if err := json.Unmarshal(params, args); err != nil {
+		return fmt.Errorf("invalid params: %w", err)
+	}
+	return nil
+}
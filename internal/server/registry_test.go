@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestRegistry_Handle(t *testing.T) {
+	registry := NewRegistry("test", "1.0.0")
+	Register(registry, "Echo", "Echo the query", func(ctx context.Context, args *QueryArgs, result *QueryResult) error {
+		if args.Query == "fail" {
+			return fmt.Errorf("echo failed")
+		}
+		result.Reasoning = args.Query
+		return nil
+	})
+
+	tests := []struct {
+		name      string
+		method    string
+		params    string
+		wantCode  int
+		reasoning string
+	}{
+		{name: "typed params", method: "Echo", params: `{"query":"hello","limit":3}`, reasoning: "hello"},
+		{name: "absent params", method: "Echo", params: ``},
+		{name: "empty array params", method: "Echo", params: `[]`},
+		{name: "invalid params", method: "Echo", params: `{"query":5}`, wantCode: JSONRPCInvalidParams},
+		{name: "method error", method: "Echo", params: `{"query":"fail"}`, wantCode: JSONRPCInternalError},
+		{name: "unknown method", method: "Missing", wantCode: JSONRPCMethodNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &JSONRPCRequest{JSONRPC: "2.0", Method: tt.method, Params: json.RawMessage(tt.params)}
+			result, rpcErr := registry.Handle(context.Background(), req)
+
+			if tt.wantCode != 0 {
+				if rpcErr == nil || rpcErr.Code != tt.wantCode {
+					t.Errorf("expected error code %d, got %+v", tt.wantCode, rpcErr)
+				}
+				return
+			}
+			if rpcErr != nil {
+				t.Fatalf("unexpected error: %+v", rpcErr)
+			}
+			if queryResult, ok := result.(*QueryResult); !ok || queryResult.Reasoning != tt.reasoning {
+				t.Errorf("expected reasoning %q, got %+v", tt.reasoning, result)
+			}
+		})
+	}
+}
+
+func TestRegistry_Discover(t *testing.T) {
+	registry := NewRegistry("nvim-smart-keybind-search", "1.2.3")
+	service := NewRPCService(&MockRAGAgent{}, &MockVectorDB{}, &MockLLMClient{})
+	service.RegisterMethods(registry)
+
+	result, rpcErr := registry.Handle(context.Background(), &JSONRPCRequest{JSONRPC: "2.0", Method: DiscoverMethod})
+	if rpcErr != nil {
+		t.Fatalf("unexpected error: %+v", rpcErr)
+	}
+
+	// Round-trip through JSON as a client generating stubs would see it
+	raw, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("failed to marshal document: %v", err)
+	}
+	var doc OpenRPCDocument
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatalf("failed to decode document: %v", err)
+	}
+
+	if doc.OpenRPC != OpenRPCVersion || doc.Info.Version != "1.2.3" {
+		t.Errorf("unexpected document header: %+v %+v", doc.OpenRPC, doc.Info)
+	}
+
+	methods := make(map[string]OpenRPCMethod)
+	for _, method := range doc.Methods {
+		methods[method.Name] = method
+	}
+	for _, name := range registry.Methods() {
+		if _, ok := methods[name]; !ok {
+			t.Errorf("method %s missing from document", name)
+		}
+	}
+	if _, ok := methods[DiscoverMethod]; ok {
+		t.Errorf("rpc.discover should not describe itself")
+	}
+
+	query := methods["Query"]
+	params := make(map[string]OpenRPCContentDescriptor)
+	for _, param := range query.Params {
+		params[param.Name] = param
+	}
+	if !params["query"].Required || params["query"].Schema.Type != "string" {
+		t.Errorf("expected required string query param, got %+v", params["query"])
+	}
+	if params["limit"].Required || params["limit"].Schema.Type != "integer" {
+		t.Errorf("expected optional integer limit param, got %+v", params["limit"])
+	}
+	if params["context"].Schema.Type != "object" || params["context"].Schema.AdditionalProperties.Type != "string" {
+		t.Errorf("expected string map context param, got %+v", params["context"])
+	}
+	if query.Result.Schema.Ref != "#/components/schemas/QueryResult" {
+		t.Errorf("expected QueryResult reference, got %+v", query.Result.Schema)
+	}
+
+	keybinding := doc.Components.Schemas["Keybinding"]
+	if keybinding == nil || keybinding.Properties["keys"].Type != "string" {
+		t.Fatalf("expected Keybinding component schema, got %+v", keybinding)
+	}
+
+	// Embedded HealthStatus fields are flattened and time fields are date-time strings
+	detailed := doc.Components.Schemas["DetailedHealthStatus"]
+	if detailed == nil || detailed.Properties["status"] == nil || detailed.Properties["timestamp"].Format != "date-time" {
+		t.Errorf("expected flattened DetailedHealthStatus schema, got %+v", detailed)
+	}
+
+	// Unexported and json:"-" fields are not part of the schema
+	metrics := doc.Components.Schemas["PerformanceMetrics"]
+	if metrics == nil || metrics.Properties["mu"] != nil || metrics.Properties["TotalResponseTime"] != nil {
+		t.Errorf("unexpected PerformanceMetrics schema: %+v", metrics)
+	}
+}
@@ -1,7 +1,9 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"strings"
 	"time"
 	"unicode"
@@ -15,6 +17,18 @@ type RPCService struct {
 	vectorDB      interfaces.VectorDB
 	llmClient     interfaces.LLMClient
 	healthMonitor *HealthMonitor
+
+	// registry the methods are bound to, reported during Initialize
+	registry *Registry
+
+	// effective configuration, reported by DetailedHealthCheck
+	config interface{}
+
+	// background jobs started by SyncKeybindings, PullModel and RebuildDatabase
+	jobs *JobManager
+
+	// limiter rejects calls exceeding the rate limits, if set
+	limiter *RateLimiter
 }
 
 // NewRPCService creates a new RPC service instance
@@ -27,18 +41,99 @@ func NewRPCService(ragAgent interfaces.RAGAgent, vectorDB interfaces.VectorDB, l
 	}
 }
 
+// RegisterMethods binds all RPC methods of the service to the registry
+func (s *RPCService) RegisterMethods(registry *Registry) {
+	s.registry = registry
+
+	Register(registry, InitializeMethod, "Negotiate protocol version and features with the server", instrumented(s, InitializeMethod, s.Initialize))
+	Register(registry, "Query", "Search keybindings with a natural language query", instrumented(s, "Query", s.QueryContext))
+	Register(registry, "SyncKeybindings", "Replace the user's keybindings in the vector database", instrumented(s, "SyncKeybindings", s.SyncKeybindingsContext))
+	Register(registry, "UpdateKeybindings", "Add or update individual keybindings", instrumented(s, "UpdateKeybindings", WithoutContext(s.UpdateKeybindings)))
+	Register(registry, "DeleteKeybindings", "Delete user keybindings by id, mode or plugin", instrumented(s, "DeleteKeybindings", s.DeleteKeybindings))
+	Register(registry, "ListKeybindings", "List the stored keybindings a page at a time", instrumented(s, "ListKeybindings", s.ListKeybindings))
+	Register(registry, "AnalyzeConflicts", "Report duplicate, prefix-ambiguous and mode-overlapping keybindings", instrumented(s, "AnalyzeConflicts", s.AnalyzeConflicts))
+	Register(registry, "FindShadowedBuiltins", "Report built-in keybindings that user mappings replace", instrumented(s, "FindShadowedBuiltins", s.FindShadowedBuiltins))
+	Register(registry, "ExplainKeys", "Look up what a key sequence does and break it into its parts", instrumented(s, "ExplainKeys", s.ExplainKeys))
+	Register(registry, "HealthCheck", "Report the health of the service and its dependencies", instrumented(s, "HealthCheck", WithoutContext(s.HealthCheck)))
+	Register(registry, "DetailedHealthCheck", "Report health with metrics, dependency details and system info", instrumented(s, "DetailedHealthCheck", WithoutContext(s.DetailedHealthCheck)))
+	Register(registry, "GetMetrics", "Report query performance metrics", instrumented(s, "GetMetrics", WithoutContext(s.GetMetrics)))
+	Register(registry, "PullModel", "Download a model in a background job", instrumented(s, "PullModel", WithoutContext(s.PullModel)))
+	Register(registry, "RebuildDatabase", "Rebuild the vector database from the pre-built knowledge base in a background job", instrumented(s, "RebuildDatabase", WithoutContext(s.RebuildDatabase)))
+	Register(registry, "GetJobStatus", "Report the state, progress and outcome of a background job", instrumented(s, "GetJobStatus", WithoutContext(s.GetJobStatus)))
+	Register(registry, "ListJobs", "List background jobs", instrumented(s, "ListJobs", WithoutContext(s.ListJobs)))
+	Register(registry, "CancelJob", "Cancel a queued or running background job", instrumented(s, "CancelJob", WithoutContext(s.CancelJob)))
+}
+
+// instrumented wraps a method so that calls exceeding the service's rate limits are
+// rejected before reaching it, and the latency of the others is recorded
+func instrumented[A, R any](s *RPCService, method string, fn MethodFunc[A, R]) MethodFunc[A, R] {
+	return func(ctx context.Context, args *A, result *R) error {
+		if err := s.checkRateLimit(ctx, method); err != nil {
+			return err
+		}
+
+		if s.healthMonitor != nil {
+			defer func(start time.Time) {
+				s.healthMonitor.GetMetricsCollector().RecordMethod(method, time.Since(start))
+			}(time.Now())
+		}
+		return fn(ctx, args, result)
+	}
+}
+
+// SetConfig sets the effective configuration reported by DetailedHealthCheck
+func (s *RPCService) SetConfig(config interface{}) {
+	s.config = config
+}
+
 // QueryArgs represents the arguments for the Query RPC method
 type QueryArgs struct {
-	Query   string            `json:"query"`
+	Query string `json:"query"`
+
+	// Context describes the editor state the query was made in, using the keys
+	// interfaces.ContextMode, ContextFiletype, ContextBufferMaps, ContextCwd and ContextNvimVersion
 	Context map[string]string `json:"context,omitempty"`
 	Limit   int               `json:"limit,omitempty"`
+
+	// Filter restricts the keybindings retrieved, by mode, plugin, source and relevance
+	Filter *interfaces.SearchFilter `json:"filter,omitempty"`
+
+	// Stream asks for intermediate results as QueryPartialMethod notifications. It takes
+	// effect only for sessions that negotiated FeatureStreaming.
+	Stream bool `json:"stream,omitempty"`
+
+	// Debug asks for a trace of how the query was processed in QueryResult.Debug
+	Debug bool `json:"debug,omitempty"`
+}
+
+// QueryPartialMethod is the notification carrying intermediate results of a streamed Query
+const QueryPartialMethod = "$/queryPartial"
+
+// QueryPartialParams represents the params of a $/queryPartial notification. ID is the
+// id of the Query request the results belong to.
+type QueryPartialParams struct {
+	ID        interface{}    `json:"id"`
+	Stage     string         `json:"stage"`
+	Results   []SearchResult `json:"results"`
+	Reasoning string         `json:"reasoning,omitempty"`
+}
+
+// streamingRAGAgent is implemented by RAG agents that can report intermediate results
+type streamingRAGAgent interface {
+	ProcessRequestStream(ctx context.Context, request *interfaces.QueryRequest, emit func(interfaces.QueryUpdate)) (*interfaces.QueryResult, error)
 }
 
+// maxQueryContextSize limits the total size of the editor context sent with a query
+const maxQueryContextSize = 64 * 1024
+
 // QueryResult represents the result of a query operation for RPC
 type QueryResult struct {
 	Results   []SearchResult `json:"results"`
 	Reasoning string         `json:"reasoning"`
 	Error     string         `json:"error,omitempty"`
+
+	// Debug traces the processing of the query, when the query asked for it
+	Debug *interfaces.QueryTrace `json:"debug,omitempty"`
 }
 
 // SearchResult represents a single keybinding search result for RPC
@@ -61,6 +156,11 @@ type Keybinding struct {
 
 // Query processes a natural language query and returns keybinding suggestions
 func (s *RPCService) Query(args *QueryArgs, result *QueryResult) error {
+	return s.QueryContext(context.Background(), args, result)
+}
+
+// QueryContext processes a query like Query, abandoning it with ErrorCodeRequestCancelled once ctx is cancelled
+func (s *RPCService) QueryContext(ctx context.Context, args *QueryArgs, result *QueryResult) error {
 	// Start timing for performance metrics
 	start := time.Now()
 	success := false
@@ -80,7 +180,7 @@ func (s *RPCService) Query(args *QueryArgs, result *QueryResult) error {
 
 	// Validate RAG agent availability
 	if s.ragAgent == nil {
-		rpcErr := NewRPCError(ErrorCodeServiceUnavailable, "RAG agent not initialized")
+		rpcErr := NewRPCError(ErrorCodeServiceUnavailable, "RAG agent not initialized").WithComponent(ComponentRAGAgent)
 		result.Error = rpcErr.Message
 		LogError(rpcErr, "Query")
 		return rpcErr
@@ -110,6 +210,23 @@ func (s *RPCService) Query(args *QueryArgs, result *QueryResult) error {
 		return rpcErr
 	}
 
+	contextSize := 0
+	for key, value := range args.Context {
+		contextSize += len(key) + len(value)
+	}
+	if contextSize > maxQueryContextSize {
+		rpcErr := NewRPCError(ErrorCodeInvalidRequest, fmt.Sprintf("query context too large (max %d bytes)", maxQueryContextSize))
+		result.Error = rpcErr.Message
+		LogError(rpcErr, "Query")
+		return rpcErr
+	}
+
+	if err := validateSearchFilter(args.Filter); err != nil {
+		result.Error = err.Message
+		LogError(err, "Query")
+		return err
+	}
+
 	// Set default limit if not specified
 	if args.Limit <= 0 {
 		args.Limit = 10
@@ -117,8 +234,20 @@ func (s *RPCService) Query(args *QueryArgs, result *QueryResult) error {
 		args.Limit = 50
 	}
 
+	var trace *interfaces.QueryTrace
+	if args.Debug {
+		trace = &interfaces.QueryTrace{}
+		ctx = interfaces.WithQueryTrace(ctx, trace)
+	}
+
 	// Process query through RAG agent
-	queryResult, err := s.ragAgent.ProcessQuery(query)
+	queryResult, err := s.processQuery(ctx, query, args)
+	if ctx.Err() != nil {
+		rpcErr := NewRPCError(ErrorCodeRequestCancelled, "query cancelled")
+		result.Error = rpcErr.Message
+		LogError(rpcErr, "Query")
+		return rpcErr
+	}
 	if err != nil {
 		rpcErr := WrapError(err, ErrorCodeRAGAgentError, "failed to process query")
 		result.Error = rpcErr.Message
@@ -133,14 +262,109 @@ func (s *RPCService) Query(args *QueryArgs, result *QueryResult) error {
 
 	// Convert interface types to RPC types
 	*result = convertToRPCQueryResult(queryResult)
+	result.Debug = trace
 	success = true
 	return err
 }
 
+// validateSearchFilter checks the filter of a query, which may be nil
+func validateSearchFilter(filter *interfaces.SearchFilter) *RPCError {
+	if filter == nil {
+		return nil
+	}
+
+	for _, source := range filter.Sources {
+		switch source {
+		case interfaces.SourceUser, interfaces.SourceBuiltin, interfaces.SourceGeneral:
+		default:
+			return NewRPCError(ErrorCodeInvalidRequest, "unknown source in filter",
+				fmt.Sprintf("source %q is not one of %q, %q or %q", source,
+					interfaces.SourceUser, interfaces.SourceBuiltin, interfaces.SourceGeneral))
+		}
+	}
+	if filter.MinRelevance < 0 || filter.MinRelevance > 1 {
+		return NewRPCError(ErrorCodeInvalidRequest, "filter min_relevance must be between 0 and 1")
+	}
+	return nil
+}
+
+// processQuery runs the query through the RAG agent, streaming intermediate results to
+// the client when it asked for them and the agent can produce them
+func (s *RPCService) processQuery(ctx context.Context, query string, args *QueryArgs) (*interfaces.QueryResult, error) {
+	if s.healthMonitor != nil {
+		ctx = interfaces.WithStageRecorder(ctx, s.healthMonitor.GetMetricsCollector().RecordStage)
+	}
+
+	request := &interfaces.QueryRequest{
+		Query:   query,
+		Context: args.Context,
+		Limit:   args.Limit,
+		Filter:  args.Filter,
+	}
+
+	agent, canStream := s.ragAgent.(streamingRAGAgent)
+	id, hasID := RequestIDFromContext(ctx)
+	state := SessionStateFromContext(ctx)
+	if !args.Stream || !canStream || !hasID || state == nil || !state.HasFeature(FeatureStreaming) {
+		return s.ragAgent.ProcessRequest(ctx, request)
+	}
+
+	return agent.ProcessRequestStream(ctx, request, func(update interfaces.QueryUpdate) {
+		partial := convertToRPCQueryResult(&interfaces.QueryResult{Results: update.Results})
+		if len(partial.Results) > args.Limit {
+			partial.Results = partial.Results[:args.Limit]
+		}
+
+		params := &QueryPartialParams{
+			ID:        id,
+			Stage:     update.Stage,
+			Results:   partial.Results,
+			Reasoning: update.Reasoning,
+		}
+		if err := Notify(ctx, QueryPartialMethod, params); err != nil {
+			log.Printf("Failed to send %s: %v", QueryPartialMethod, err)
+		}
+	})
+}
+
 // SyncKeybindingsArgs represents the arguments for bulk keybinding synchronization
 type SyncKeybindingsArgs struct {
-	Keybindings   []Keybinding `json:"keybindings"`
-	ClearExisting bool         `json:"clear_existing,omitempty"`
+	Keybindings []Keybinding `json:"keybindings"`
+
+	// ClearExisting replaces every stored user keybinding with Keybindings instead of
+	// adding to them. The previous keybindings stay searchable until the whole set is
+	// stored and are kept when the sync fails.
+	ClearExisting bool `json:"clear_existing,omitempty"`
+
+	// ProgressToken asks for ProgressMethod notifications carrying this token. It takes
+	// effect only for sessions that negotiated FeatureProgress.
+	ProgressToken interface{} `json:"progress_token,omitempty"`
+
+	// Async runs the sync as a background job and returns its id right away
+	Async bool `json:"async,omitempty"`
+}
+
+// ProgressMethod is the notification reporting the progress of a long-running request
+const ProgressMethod = "$/progress"
+
+// ProgressParams represents the params of a $/progress notification. Token is the
+// progress token the client sent with its request.
+type ProgressParams struct {
+	Token     interface{} `json:"token"`
+	Phase     string      `json:"phase"`
+	Processed int         `json:"processed"`
+	Total     int         `json:"total"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// progressRAGAgent is implemented by RAG agents that can report the progress of an update
+type progressRAGAgent interface {
+	UpdateVectorDBProgress(ctx context.Context, keybindings []interfaces.Keybinding, report func(interfaces.SyncProgress)) error
+}
+
+// resyncRAGAgent is implemented by RAG agents that can replace all stored user keybindings
+type resyncRAGAgent interface {
+	ReplaceVectorDBProgress(ctx context.Context, keybindings []interfaces.Keybinding, report func(interfaces.SyncProgress)) error
 }
 
 // SyncKeybindingsResult represents the result of bulk synchronization
@@ -148,10 +372,19 @@ type SyncKeybindingsResult struct {
 	Success        bool   `json:"success"`
 	ProcessedCount int    `json:"processed_count"`
 	Error          string `json:"error,omitempty"`
+
+	// JobID identifies the background job of an async sync
+	JobID string `json:"job_id,omitempty"`
 }
 
 // SyncKeybindings performs bulk initialization of keybindings in the vector database
 func (s *RPCService) SyncKeybindings(args *SyncKeybindingsArgs, result *SyncKeybindingsResult) error {
+	return s.SyncKeybindingsContext(context.Background(), args, result)
+}
+
+// SyncKeybindingsContext synchronizes keybindings like SyncKeybindings, reporting
+// progress to the client of ctx when it asked for it
+func (s *RPCService) SyncKeybindingsContext(ctx context.Context, args *SyncKeybindingsArgs, result *SyncKeybindingsResult) error {
 	var err error
 	// Panic recovery
 	defer func() {
@@ -163,7 +396,7 @@ func (s *RPCService) SyncKeybindings(args *SyncKeybindingsArgs, result *SyncKeyb
 	}()
 
 	if s.ragAgent == nil {
-		rpcErr := NewRPCError(ErrorCodeServiceUnavailable, "RAG agent not initialized")
+		rpcErr := NewRPCError(ErrorCodeServiceUnavailable, "RAG agent not initialized").WithComponent(ComponentRAGAgent)
 		result.Success = false
 		result.Error = rpcErr.Message
 		LogError(rpcErr, "SyncKeybindings")
@@ -192,15 +425,30 @@ func (s *RPCService) SyncKeybindings(args *SyncKeybindingsArgs, result *SyncKeyb
 		return rpcErr
 	}
 
-	// If clearing existing, we could add logic here to clear the database
-	// For now, we'll just update with the new keybindings
-	if len(args.Keybindings) > 0 {
-		// Convert RPC keybindings to interface keybindings
-		interfaceKeybindings := make([]interfaces.Keybinding, len(args.Keybindings))
-		for i, rpcKeybinding := range args.Keybindings {
-			interfaceKeybindings[i] = convertFromRPCKeybinding(rpcKeybinding)
+	if _, ok := s.ragAgent.(resyncRAGAgent); args.ClearExisting && !ok {
+		rpcErr := NewRPCError(ErrorCodeServiceUnavailable, "RAG agent cannot replace existing keybindings").WithComponent(ComponentRAGAgent)
+		result.Success = false
+		result.Error = rpcErr.Message
+		LogError(rpcErr, "SyncKeybindings")
+		return rpcErr
+	}
+
+	if args.Async {
+		var started JobStartedResult
+		jobArgs := &SyncKeybindingsArgs{Keybindings: args.Keybindings, ClearExisting: args.ClearExisting}
+		if err := s.startJob(JobKindSyncKeybindings, jobArgs, &started, "SyncKeybindings"); err != nil {
+			result.Success = false
+			result.Error = err.Error()
+			return err
 		}
-		err := s.ragAgent.UpdateVectorDB(interfaceKeybindings)
+		result.Success = true
+		result.JobID = started.JobID
+		return nil
+	}
+
+	// A full resync with no keybindings clears them all
+	if len(args.Keybindings) > 0 || args.ClearExisting {
+		err := s.syncVectorDB(ctx, convertFromRPCKeybindings(args.Keybindings), args.ClearExisting, s.progressReporter(ctx, args.ProgressToken))
 		if err != nil {
 			rpcErr := WrapError(err, ErrorCodeVectorDBError, "failed to sync keybindings")
 			result.Success = false
@@ -215,6 +463,51 @@ func (s *RPCService) SyncKeybindings(args *SyncKeybindingsArgs, result *SyncKeyb
 	return err
 }
 
+// syncVectorDB stores keybindings through the RAG agent like updateVectorDB, or with
+// clearExisting replaces all stored user keybindings with them
+func (s *RPCService) syncVectorDB(ctx context.Context, keybindings []interfaces.Keybinding, clearExisting bool, report func(interfaces.SyncProgress)) error {
+	if !clearExisting {
+		return s.updateVectorDB(ctx, keybindings, report)
+	}
+
+	agent, ok := s.ragAgent.(resyncRAGAgent)
+	if !ok {
+		return fmt.Errorf("RAG agent cannot replace existing keybindings")
+	}
+	return agent.ReplaceVectorDBProgress(ctx, keybindings, report)
+}
+
+// updateVectorDB stores keybindings through the RAG agent, passing its progress to
+// report (which may be nil) and stopping early on cancellation when the agent supports it
+func (s *RPCService) updateVectorDB(ctx context.Context, keybindings []interfaces.Keybinding, report func(interfaces.SyncProgress)) error {
+	if agent, ok := s.ragAgent.(progressRAGAgent); ok {
+		return agent.UpdateVectorDBProgress(ctx, keybindings, report)
+	}
+	return s.ragAgent.UpdateVectorDB(keybindings)
+}
+
+// progressReporter returns a function sending progress notifications with token to the
+// client of ctx, or nil when the client did not ask for them
+func (s *RPCService) progressReporter(ctx context.Context, token interface{}) func(interfaces.SyncProgress) {
+	state := SessionStateFromContext(ctx)
+	if token == nil || state == nil || !state.HasFeature(FeatureProgress) {
+		return nil
+	}
+
+	return func(progress interfaces.SyncProgress) {
+		params := &ProgressParams{
+			Token:     token,
+			Phase:     progress.Phase,
+			Processed: progress.Processed,
+			Total:     progress.Total,
+			Error:     progress.Error,
+		}
+		if err := Notify(ctx, ProgressMethod, params); err != nil {
+			log.Printf("Failed to send %s: %v", ProgressMethod, err)
+		}
+	}
+}
+
 // UpdateKeybindingsArgs represents the arguments for updating keybindings
 type UpdateKeybindingsArgs struct {
 	Keybindings []Keybinding `json:"keybindings"`
@@ -240,7 +533,7 @@ func (s *RPCService) UpdateKeybindings(args *UpdateKeybindingsArgs, result *Upda
 	}()
 
 	if s.ragAgent == nil {
-		rpcErr := NewRPCError(ErrorCodeServiceUnavailable, "RAG agent not initialized")
+		rpcErr := NewRPCError(ErrorCodeServiceUnavailable, "RAG agent not initialized").WithComponent(ComponentRAGAgent)
 		result.Success = false
 		result.Error = rpcErr.Message
 		LogError(rpcErr, "UpdateKeybindings")
@@ -318,11 +611,12 @@ func (s *RPCService) DetailedHealthCheck(args *DetailedHealthCheckArgs, result *
 		result.Metrics = PerformanceMetrics{}
 		result.Dependencies = make(map[string]DependencyStatus)
 		result.SystemInfo = SystemInfo{
-			Version:   "1.0.0",
+			Version:   Version,
 			StartTime: time.Now(),
 		}
 		result.Uptime = time.Since(time.Now())
 	}
+	result.Config = s.config
 
 	return err
 }
@@ -353,6 +647,19 @@ func (s *RPCService) GetMetrics(args *GetMetricsArgs, result *PerformanceMetrics
 	return err
 }
 
+// FlushMetrics writes the final performance metrics to the log, as they are only kept
+// in memory and would otherwise be lost on shutdown
+func (s *RPCService) FlushMetrics() {
+	if s.healthMonitor == nil {
+		return
+	}
+
+	metrics := s.healthMonitor.GetMetricsCollector().GetMetrics()
+	log.Printf("Final metrics: %d queries (%d successful, %d failed), average response time %v, max %v, uptime %v",
+		metrics.QueryCount, metrics.SuccessfulQueries, metrics.FailedQueries,
+		metrics.AverageResponseTime, metrics.MaxResponseTime, time.Since(metrics.StartTime).Round(time.Second))
+}
+
 // HealthStatus represents the health status of the service
 type HealthStatus struct {
 	Status    string            `json:"status"`
@@ -491,6 +798,15 @@ func convertToRPCKeybinding(interfaceKeybinding interfaces.Keybinding) Keybindin
 	}
 }
 
+// convertFromRPCKeybindings converts RPC keybindings to interface keybindings
+func convertFromRPCKeybindings(rpcKeybindings []Keybinding) []interfaces.Keybinding {
+	keybindings := make([]interfaces.Keybinding, len(rpcKeybindings))
+	for i, rpcKeybinding := range rpcKeybindings {
+		keybindings[i] = convertFromRPCKeybinding(rpcKeybinding)
+	}
+	return keybindings
+}
+
 // convertFromRPCKeybinding converts RPC Keybinding to interface Keybinding
 func convertFromRPCKeybinding(rpcKeybinding Keybinding) interfaces.Keybinding {
 	return interfaces.Keybinding{
@@ -1,6 +1,10 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -37,6 +41,17 @@ func (m *MockRAGAgent) ProcessQuery(query string) (*interfaces.QueryResult, erro
 	}, nil
 }
 
+func (m *MockRAGAgent) ProcessQueryContext(ctx context.Context, query string) (*interfaces.QueryResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.ProcessQuery(query)
+}
+
+func (m *MockRAGAgent) ProcessRequest(ctx context.Context, request *interfaces.QueryRequest) (*interfaces.QueryResult, error) {
+	return m.ProcessQueryContext(ctx, request.Query)
+}
+
 func (m *MockRAGAgent) UpdateVectorDB(keybindings []interfaces.Keybinding) error {
 	if m.shouldError {
 		return fmt.Errorf("mock update error")
@@ -117,6 +132,13 @@ func (m *MockLLMClient) Generate(request interfaces.LLMRequest) (*interfaces.LLM
 	return &interfaces.LLMResponse{Text: "mock response"}, nil
 }
 
+func (m *MockLLMClient) GenerateContext(ctx context.Context, request interfaces.LLMRequest) (*interfaces.LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.Generate(request)
+}
+
 func (m *MockLLMClient) Embed(text string) ([]float64, error) {
 	if m.shouldError {
 		return nil, fmt.Errorf("mock embed error")
@@ -214,6 +236,190 @@ func TestRPCService_Query(t *testing.T) {
 	}
 }
 
+func TestRPCService_QueryContextCancelled(t *testing.T) {
+	service := NewRPCService(&MockRAGAgent{}, &MockVectorDB{}, &MockLLMClient{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var result QueryResult
+	err := service.QueryContext(ctx, &QueryArgs{Query: "delete line"}, &result)
+	if err == nil {
+		t.Fatalf("expected error for cancelled query")
+	}
+
+	rpcErr, ok := err.(*RPCError)
+	if !ok || rpcErr.Code != ErrorCodeRequestCancelled {
+		t.Errorf("expected ErrorCodeRequestCancelled, got %v", err)
+	}
+	if !IsClientError(err) {
+		t.Errorf("expected cancellation to be reported as a client error")
+	}
+}
+
+// streamingMockRAGAgent reports each result as a retrieval and a ranked update before returning
+type streamingMockRAGAgent struct {
+	MockRAGAgent
+}
+
+func (m *streamingMockRAGAgent) ProcessRequestStream(ctx context.Context, request *interfaces.QueryRequest, emit func(interfaces.QueryUpdate)) (*interfaces.QueryResult, error) {
+	result, err := m.ProcessRequest(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	emit(interfaces.QueryUpdate{Stage: interfaces.QueryStageRetrieval, Results: result.Results})
+	emit(interfaces.QueryUpdate{Stage: interfaces.QueryStageRanked, Results: result.Results, Reasoning: result.Reasoning})
+	return result, nil
+}
+
+func TestRPCService_QueryStream(t *testing.T) {
+	registry := NewRegistry("test", Version)
+	service := NewRPCService(&streamingMockRAGAgent{}, &MockVectorDB{}, &MockLLMClient{})
+	service.RegisterMethods(registry)
+
+	dispatcher := NewDispatcher(registry.Handle, &DispatcherConfig{MaxInFlight: 1})
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","method":"Initialize","params":{"protocol_version":"1.0","features":["streaming"]},"id":1}`,
+		`{"jsonrpc":"2.0","method":"Query","params":{"query":"delete line","stream":true},"id":2}`,
+		`{"jsonrpc":"2.0","method":"Query","params":{"query":"delete line"},"id":3}`,
+	}, "\n")
+
+	var output bytes.Buffer
+	if err := dispatcher.Serve(strings.NewReader(input), &output); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type message struct {
+		Method string             `json:"method"`
+		Params QueryPartialParams `json:"params"`
+		ID     interface{}        `json:"id"`
+	}
+	var messages []message
+	decoder := json.NewDecoder(&output)
+	for decoder.More() {
+		var m message
+		if err := decoder.Decode(&m); err != nil {
+			t.Fatalf("failed to decode message: %v", err)
+		}
+		messages = append(messages, m)
+	}
+
+	if len(messages) != 5 {
+		t.Fatalf("expected 3 responses and 2 partials, got %+v", messages)
+	}
+	for i, stage := range []string{interfaces.QueryStageRetrieval, interfaces.QueryStageRanked} {
+		partial := messages[i+1]
+		if partial.Method != QueryPartialMethod || partial.Params.Stage != stage {
+			t.Errorf("expected %s partial before the response, got %+v", stage, partial)
+		}
+		if partial.Params.ID != float64(2) || len(partial.Params.Results) != 1 {
+			t.Errorf("expected partial results for request 2, got %+v", partial.Params)
+		}
+	}
+	if messages[3].ID != float64(2) || messages[4].ID != float64(3) {
+		t.Errorf("expected final responses after the partials, got %+v", messages[3:])
+	}
+}
+
+// tracingMockRAGAgent fills in the query trace of the context it is given
+type tracingMockRAGAgent struct {
+	MockRAGAgent
+}
+
+func (m *tracingMockRAGAgent) ProcessRequest(ctx context.Context, request *interfaces.QueryRequest) (*interfaces.QueryResult, error) {
+	if trace := interfaces.QueryTraceFromContext(ctx); trace != nil {
+		trace.ProcessedQuery = &interfaces.TracedQuery{Original: request.Query, Expanded: request.Query + " remove"}
+		trace.Prompt = "prompt for " + request.Query
+	}
+	return m.MockRAGAgent.ProcessRequest(ctx, request)
+}
+
+func TestRPCService_QueryDebug(t *testing.T) {
+	service := NewRPCService(&tracingMockRAGAgent{}, &MockVectorDB{}, &MockLLMClient{})
+
+	var result QueryResult
+	if err := service.QueryContext(context.Background(), &QueryArgs{Query: "delete line"}, &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Debug != nil {
+		t.Errorf("expected no trace unless asked for, got %+v", result.Debug)
+	}
+
+	if err := service.QueryContext(context.Background(), &QueryArgs{Query: "delete line", Debug: true}, &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Debug == nil || result.Debug.ProcessedQuery == nil || result.Debug.Prompt != "prompt for delete line" {
+		t.Fatalf("expected the agent's trace in the result, got %+v", result.Debug)
+	}
+
+	data, err := json.Marshal(&result)
+	if err != nil {
+		t.Fatalf("failed to marshal result: %v", err)
+	}
+	if !strings.Contains(string(data), `"debug":{"processed_query":{"original":"delete line"`) {
+		t.Errorf("expected the trace under \"debug\", got %s", data)
+	}
+}
+
+// contextMockRAGAgent remembers the request it was given
+type contextMockRAGAgent struct {
+	MockRAGAgent
+	request *interfaces.QueryRequest
+}
+
+func (m *contextMockRAGAgent) ProcessRequest(ctx context.Context, request *interfaces.QueryRequest) (*interfaces.QueryResult, error) {
+	m.request = request
+	return m.MockRAGAgent.ProcessRequest(ctx, request)
+}
+
+func TestRPCService_QueryContext_EditorContext(t *testing.T) {
+	agent := &contextMockRAGAgent{}
+	service := NewRPCService(agent, &MockVectorDB{}, &MockLLMClient{})
+
+	args := &QueryArgs{
+		Query:   "indent selection",
+		Context: map[string]string{interfaces.ContextMode: "v", interfaces.ContextFiletype: "lua"},
+	}
+	var result QueryResult
+	if err := service.QueryContext(context.Background(), args, &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if agent.request == nil || agent.request.Context[interfaces.ContextMode] != "v" || agent.request.Context[interfaces.ContextFiletype] != "lua" {
+		t.Errorf("expected the editor context to reach the agent, got %+v", agent.request)
+	}
+
+	args.Context[interfaces.ContextBufferMaps] = strings.Repeat("n x\n", maxQueryContextSize/4)
+	err := service.QueryContext(context.Background(), args, &result)
+	if rpcErr, ok := err.(*RPCError); !ok || rpcErr.Code != ErrorCodeInvalidRequest {
+		t.Errorf("expected an oversized context to be rejected, got %v", err)
+	}
+}
+
+func TestRPCService_QueryFilter(t *testing.T) {
+	agent := &contextMockRAGAgent{}
+	service := NewRPCService(agent, &MockVectorDB{}, &MockLLMClient{})
+
+	filter := &interfaces.SearchFilter{Modes: []string{"x"}, Sources: []string{interfaces.SourceUser}, MinRelevance: 0.5}
+	var result QueryResult
+	if err := service.QueryContext(context.Background(), &QueryArgs{Query: "indent", Limit: 20, Filter: filter}, &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if agent.request.Filter != filter || agent.request.Limit != 20 {
+		t.Errorf("expected the filter and limit to reach the agent, got %+v", agent.request)
+	}
+
+	for _, filter := range []*interfaces.SearchFilter{
+		{Sources: []string{"plugins"}},
+		{MinRelevance: 1.5},
+	} {
+		err := service.QueryContext(context.Background(), &QueryArgs{Query: "indent", Filter: filter}, &result)
+		if rpcErr, ok := err.(*RPCError); !ok || rpcErr.Code != ErrorCodeInvalidRequest {
+			t.Errorf("expected filter %+v to be rejected, got %v", filter, err)
+		}
+	}
+}
+
 func TestRPCService_SyncKeybindings(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -261,6 +467,118 @@ func TestRPCService_SyncKeybindings(t *testing.T) {
 	}
 }
 
+// progressMockRAGAgent reports a validate and a store phase for every update
+type progressMockRAGAgent struct {
+	MockRAGAgent
+}
+
+func (m *progressMockRAGAgent) UpdateVectorDBProgress(ctx context.Context, keybindings []interfaces.Keybinding, report func(interfaces.SyncProgress)) error {
+	if report != nil {
+		report(interfaces.SyncProgress{Phase: interfaces.SyncPhaseValidate, Processed: len(keybindings), Total: len(keybindings)})
+		report(interfaces.SyncProgress{Phase: interfaces.SyncPhaseStore, Processed: len(keybindings), Total: len(keybindings)})
+	}
+	return m.UpdateVectorDB(keybindings)
+}
+
+func TestRPCService_SyncKeybindingsProgress(t *testing.T) {
+	registry := NewRegistry("test", Version)
+	service := NewRPCService(&progressMockRAGAgent{}, &MockVectorDB{}, &MockLLMClient{})
+	service.RegisterMethods(registry)
+
+	dispatcher := NewDispatcher(registry.Handle, &DispatcherConfig{MaxInFlight: 1})
+
+	keybindings := `[{"id":"test1","keys":"dd","command":"delete line","mode":"n"}]`
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","method":"Initialize","params":{"protocol_version":"1.0","features":["progress"]},"id":1}`,
+		`{"jsonrpc":"2.0","method":"SyncKeybindings","params":{"keybindings":` + keybindings + `,"progress_token":"sync-1"},"id":2}`,
+		`{"jsonrpc":"2.0","method":"SyncKeybindings","params":{"keybindings":` + keybindings + `},"id":3}`,
+	}, "\n")
+
+	var output bytes.Buffer
+	if err := dispatcher.Serve(strings.NewReader(input), &output); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type message struct {
+		Method string         `json:"method"`
+		Params ProgressParams `json:"params"`
+		ID     interface{}    `json:"id"`
+	}
+	var messages []message
+	decoder := json.NewDecoder(&output)
+	for decoder.More() {
+		var m message
+		if err := decoder.Decode(&m); err != nil {
+			t.Fatalf("failed to decode message: %v", err)
+		}
+		messages = append(messages, m)
+	}
+
+	if len(messages) != 5 {
+		t.Fatalf("expected 3 responses and 2 progress notifications, got %+v", messages)
+	}
+	for i, phase := range []string{interfaces.SyncPhaseValidate, interfaces.SyncPhaseStore} {
+		progress := messages[i+1]
+		if progress.Method != ProgressMethod || progress.Params.Token != "sync-1" || progress.Params.Phase != phase {
+			t.Errorf("expected %s progress before the response, got %+v", phase, progress)
+		}
+		if progress.Params.Processed != 1 || progress.Params.Total != 1 {
+			t.Errorf("unexpected progress counts: %+v", progress.Params)
+		}
+	}
+	if messages[3].ID != float64(2) || messages[4].ID != float64(3) {
+		t.Errorf("expected sync responses after the progress, got %+v", messages[3:])
+	}
+}
+
+// resyncMockRAGAgent records full resyncs and fails them when err is set
+type resyncMockRAGAgent struct {
+	MockRAGAgent
+	replaced [][]interfaces.Keybinding
+	err      error
+}
+
+func (m *resyncMockRAGAgent) ReplaceVectorDBProgress(ctx context.Context, keybindings []interfaces.Keybinding, report func(interfaces.SyncProgress)) error {
+	m.replaced = append(m.replaced, keybindings)
+	return m.err
+}
+
+func TestRPCService_SyncKeybindingsClearExisting(t *testing.T) {
+	agent := &resyncMockRAGAgent{}
+	service := NewRPCService(agent, &MockVectorDB{}, &MockLLMClient{})
+	keybindings := []Keybinding{{ID: "1", Keys: "dd", Command: "delete", Description: "Delete line"}}
+
+	var result SyncKeybindingsResult
+	if err := service.SyncKeybindings(&SyncKeybindingsArgs{Keybindings: keybindings}, &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(agent.replaced) != 0 {
+		t.Errorf("expected a plain sync not to replace keybindings, got %v", agent.replaced)
+	}
+
+	if err := service.SyncKeybindings(&SyncKeybindingsArgs{Keybindings: keybindings, ClearExisting: true}, &result); err != nil || !result.Success {
+		t.Fatalf("unexpected error: %v, %+v", err, result)
+	}
+	if err := service.SyncKeybindings(&SyncKeybindingsArgs{ClearExisting: true}, &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(agent.replaced) != 2 || len(agent.replaced[0]) != 1 || agent.replaced[0][0].Keys != "dd" || len(agent.replaced[1]) != 0 {
+		t.Errorf("expected the set of 1 and then an empty set to replace the keybindings, got %v", agent.replaced)
+	}
+
+	agent.err = fmt.Errorf("store failed")
+	if err := service.SyncKeybindings(&SyncKeybindingsArgs{Keybindings: keybindings, ClearExisting: true}, &result); err == nil || result.Success {
+		t.Errorf("expected a failed resync to be reported, got %+v", result)
+	}
+
+	service = NewRPCService(&MockRAGAgent{}, &MockVectorDB{}, &MockLLMClient{})
+	err := service.SyncKeybindings(&SyncKeybindingsArgs{Keybindings: keybindings, ClearExisting: true}, &result)
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != ErrorCodeServiceUnavailable {
+		t.Errorf("expected service unavailable without resync support, got %v", err)
+	}
+}
+
 func TestRPCService_UpdateKeybindings(t *testing.T) {
 	service := NewRPCService(&MockRAGAgent{}, &MockVectorDB{}, &MockLLMClient{})
 
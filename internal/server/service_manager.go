@@ -22,15 +22,39 @@ type ServiceManager struct {
 	isRunning    bool
 	restartCount int
 	maxRestarts  int
+	restartDelay time.Duration
 
 	// Context for graceful shutdown
 	ctx    context.Context
 	cancel context.CancelFunc
 
+	// Background jobs, saved to jobsPath when set
+	jobs     *JobManager
+	jobsPath string
+
+	// Rate limits, shared by every RPC service the manager creates so that a Stop and
+	// Start does not refill the buckets
+	limiter *RateLimiter
+
+	// OpenMetrics exporter, running when metricsAddress is set
+	exporter       *MetricsExporter
+	metricsAddress string
+
 	// Health monitoring
 	healthCheckInterval time.Duration
 	lastHealthCheck     time.Time
 	healthStatus        map[string]bool
+
+	// Graceful shutdown and exit
+	dispatcher      *Dispatcher
+	daemon          *Daemon // clients sharing the service, which Shutdown and Exit must not stop
+	shutdownTimeout time.Duration
+	shutdownOnce    sync.Once
+	shutdownErr     error
+	shutdown        bool
+	exitOnce        sync.Once
+	exitCode        int
+	done            chan struct{}
 }
 
 // ServiceManagerConfig holds configuration for the service manager
@@ -38,6 +62,20 @@ type ServiceManagerConfig struct {
 	MaxRestarts         int
 	HealthCheckInterval time.Duration
 	RestartDelay        time.Duration
+
+	// ShutdownTimeout bounds how long Shutdown waits for in-flight requests
+	ShutdownTimeout time.Duration
+
+	// JobsPath is the file background jobs are saved to so they resume after a restart.
+	// Jobs are kept in memory only when it is empty.
+	JobsPath string
+
+	// RateLimits limits the calls of each client connection; nil disables rate limiting
+	RateLimits *RateLimitConfig
+
+	// MetricsAddress is the localhost address metrics are exported on for Prometheus.
+	// Metrics are not exported when it is empty.
+	MetricsAddress string
 }
 
 // DefaultServiceManagerConfig returns default configuration
@@ -46,6 +84,8 @@ func DefaultServiceManagerConfig() *ServiceManagerConfig {
 		MaxRestarts:         5,
 		HealthCheckInterval: 30 * time.Second,
 		RestartDelay:        5 * time.Second,
+		ShutdownTimeout:     10 * time.Second,
+		RateLimits:          DefaultRateLimitConfig(),
 	}
 }
 
@@ -54,18 +94,33 @@ func NewServiceManager(ragAgent interfaces.RAGAgent, vectorDB interfaces.VectorD
 	if config == nil {
 		config = DefaultServiceManagerConfig()
 	}
+	restartDelay := config.RestartDelay
+	if restartDelay <= 0 {
+		restartDelay = DefaultServiceManagerConfig().RestartDelay
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
+	var limiter *RateLimiter
+	if config.RateLimits != nil {
+		limiter = NewRateLimiter(config.RateLimits)
+	}
+
 	return &ServiceManager{
 		ragAgent:            ragAgent,
 		vectorDB:            vectorDB,
 		llmClient:           llmClient,
 		maxRestarts:         config.MaxRestarts,
+		restartDelay:        restartDelay,
 		healthCheckInterval: config.HealthCheckInterval,
 		ctx:                 ctx,
 		cancel:              cancel,
 		healthStatus:        make(map[string]bool),
+		shutdownTimeout:     config.ShutdownTimeout,
+		jobsPath:            config.JobsPath,
+		limiter:             limiter,
+		metricsAddress:      config.MetricsAddress,
+		done:                make(chan struct{}),
 	}
 }
 
@@ -85,6 +140,22 @@ func (sm *ServiceManager) Start() error {
 
 	// Create RPC service
 	sm.rpcService = NewRPCService(sm.ragAgent, sm.vectorDB, sm.llmClient)
+	sm.rpcService.SetRateLimiter(sm.limiter)
+
+	// Resume the jobs an earlier run left unfinished
+	sm.jobs = NewJobManager(sm.jobsPath)
+	sm.rpcService.SetJobManager(sm.jobs)
+	if err := sm.jobs.Start(); err != nil {
+		log.Printf("Warning: failed to load saved jobs: %v", err)
+	}
+
+	if sm.metricsAddress != "" {
+		sm.exporter = NewMetricsExporter(sm.metricsAddress, sm.GetRPCService)
+		if err := sm.exporter.Start(); err != nil {
+			log.Printf("Warning: failed to export metrics: %v", err)
+			sm.exporter = nil
+		}
+	}
 
 	sm.isRunning = true
 	sm.restartCount = 0
@@ -98,6 +169,9 @@ func (sm *ServiceManager) Start() error {
 
 // Stop gracefully shuts down the service
 func (sm *ServiceManager) Stop() error {
+	// Scrapes read the service under sm.mu, so the exporter must stop before taking it
+	sm.closeExporter()
+
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -108,6 +182,11 @@ func (sm *ServiceManager) Stop() error {
 	// Cancel context to stop background goroutines
 	sm.cancel()
 
+	// Interrupt the running job before its dependencies go away; it resumes on restart
+	if sm.jobs != nil {
+		sm.jobs.Close()
+	}
+
 	// Close dependencies
 	if sm.vectorDB != nil {
 		if err := sm.vectorDB.Close(); err != nil {
@@ -126,6 +205,131 @@ func (sm *ServiceManager) Stop() error {
 	return nil
 }
 
+// closeExporter stops exporting metrics, giving scrapes in progress a moment to finish
+func (sm *ServiceManager) closeExporter() {
+	sm.mu.Lock()
+	exporter := sm.exporter
+	sm.exporter = nil
+	sm.mu.Unlock()
+
+	if exporter == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := exporter.Close(ctx); err != nil {
+		log.Printf("Error closing metrics exporter: %v", err)
+	}
+}
+
+// SetDispatcher sets the dispatcher serving the service, which Shutdown drains
+func (sm *ServiceManager) SetDispatcher(dispatcher *Dispatcher) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.dispatcher = dispatcher
+}
+
+// SetDaemon sets the daemon serving the service, whose other clients keep Shutdown
+// and Exit requests from stopping it
+func (sm *ServiceManager) SetDaemon(daemon *Daemon) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.daemon = daemon
+}
+
+// otherClients returns the number of daemon clients besides the caller, which is 0
+// when the service is not shared
+func (sm *ServiceManager) otherClients() int {
+	sm.mu.RLock()
+	daemon := sm.daemon
+	sm.mu.RUnlock()
+
+	if daemon == nil {
+		return 0
+	}
+	return len(daemon.Clients()) - 1
+}
+
+// refuseWhileShared returns an error when other daemon clients still use the service
+func (sm *ServiceManager) refuseWhileShared(method string) error {
+	others := sm.otherClients()
+	if others <= 0 {
+		return nil
+	}
+
+	rpcErr := NewRPCError(ErrorCodeInvalidRequest, method+" refused while other clients are attached",
+		fmt.Sprintf("%d other clients share the daemon; disconnect instead", others))
+	LogError(rpcErr, method)
+	return rpcErr
+}
+
+// RegisterMethods binds the methods of the running RPC service, plus Shutdown and
+// Exit, to the registry
+func (sm *ServiceManager) RegisterMethods(registry *Registry) {
+	if rpcService := sm.GetRPCService(); rpcService != nil {
+		rpcService.RegisterMethods(registry)
+	}
+
+	Register(registry, ShutdownMethod, "Finish in-flight requests and release resources before Exit", sm.handleShutdown)
+	Register(registry, ExitMethod, "Terminate the server process", WithoutContext(sm.handleExit))
+}
+
+// Shutdown gracefully stops the service: the dispatcher stops taking requests and
+// in-flight ones are drained, the final metrics are flushed and the dependencies are
+// closed, which also stops any ChromaDB or Ollama process they started. Calling it
+// again returns the result of the first call.
+func (sm *ServiceManager) Shutdown(ctx context.Context) error {
+	sm.shutdownOnce.Do(func() {
+		sm.mu.Lock()
+		sm.shutdown = true
+		dispatcher := sm.dispatcher
+		sm.mu.Unlock()
+
+		log.Println("Shutting down service manager")
+
+		if dispatcher != nil {
+			drainCtx, cancel := context.WithTimeout(ctx, sm.shutdownTimeout)
+			if err := dispatcher.Shutdown(drainCtx); err != nil {
+				log.Printf("Stopped waiting for in-flight requests: %v", err)
+			}
+			cancel()
+		}
+
+		if rpcService := sm.GetRPCService(); rpcService != nil {
+			rpcService.FlushMetrics()
+		}
+
+		sm.shutdownErr = sm.Stop()
+	})
+	return sm.shutdownErr
+}
+
+// Exit signals that the process should terminate; see Done and ExitCode
+func (sm *ServiceManager) Exit() {
+	sm.exitOnce.Do(func() {
+		sm.mu.Lock()
+		if !sm.shutdown {
+			sm.exitCode = 1
+		}
+		sm.mu.Unlock()
+		close(sm.done)
+	})
+}
+
+// Done returns a channel that is closed once Exit has been called
+func (sm *ServiceManager) Done() <-chan struct{} {
+	return sm.done
+}
+
+// ExitCode returns the status the process should exit with. As in LSP, it is 1 when
+// Exit was called without a prior Shutdown.
+func (sm *ServiceManager) ExitCode() int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.exitCode
+}
+
 // GetRPCService returns the RPC service instance
 func (sm *ServiceManager) GetRPCService() *RPCService {
 	sm.mu.RLock()
@@ -157,21 +361,21 @@ func (sm *ServiceManager) initializeDependencies() error {
 	// Initialize RAG Agent
 	if sm.ragAgent != nil {
 		if err := sm.ragAgent.Initialize(); err != nil {
-			return WrapError(err, ErrorCodeInitializationError, "failed to initialize RAG agent")
+			return WrapError(err, ErrorCodeInitializationError, "failed to initialize RAG agent").WithComponent(ComponentRAGAgent)
 		}
 	}
 
 	// Initialize Vector Database
 	if sm.vectorDB != nil {
 		if err := sm.vectorDB.Initialize(); err != nil {
-			return WrapError(err, ErrorCodeInitializationError, "failed to initialize vector database")
+			return WrapError(err, ErrorCodeInitializationError, "failed to initialize vector database").WithComponent(ComponentVectorDB)
 		}
 	}
 
 	// Initialize LLM Client
 	if sm.llmClient != nil {
 		if err := sm.llmClient.Initialize(); err != nil {
-			return WrapError(err, ErrorCodeInitializationError, "failed to initialize LLM client")
+			return WrapError(err, ErrorCodeInitializationError, "failed to initialize LLM client").WithComponent(ComponentLLM)
 		}
 	}
 
@@ -193,6 +397,18 @@ func (sm *ServiceManager) healthMonitor() {
 	}
 }
 
+// rebuildReporter is implemented by vector databases that stop serving while they are
+// rebuilt
+type rebuildReporter interface {
+	Rebuilding() bool
+}
+
+// vectorDBRebuilding reports whether the vector database is being rebuilt
+func (sm *ServiceManager) vectorDBRebuilding() bool {
+	reporter, ok := sm.vectorDB.(rebuildReporter)
+	return ok && reporter.Rebuilding()
+}
+
 // performHealthCheck checks the health of all components
 func (sm *ServiceManager) performHealthCheck() {
 	sm.mu.Lock()
@@ -202,6 +418,12 @@ func (sm *ServiceManager) performHealthCheck() {
 		return
 	}
 
+	// ChromaDB is stopped on purpose during a rebuild, so nothing is restarted meanwhile
+	if sm.vectorDBRebuilding() {
+		log.Println("Skipping health check while the vector database is rebuilt")
+		return
+	}
+
 	sm.lastHealthCheck = time.Now()
 	allHealthy := true
 
@@ -244,7 +466,9 @@ func (sm *ServiceManager) performHealthCheck() {
 	}
 }
 
-// attemptRestart attempts to restart failed components
+// attemptRestart attempts to restart failed components. The RPC service is kept, as the
+// transport and the method registry hold it, and only the dependencies that failed their
+// health check are initialized again.
 func (sm *ServiceManager) attemptRestart() {
 	if sm.restartCount >= sm.maxRestarts {
 		log.Printf("Maximum restart attempts (%d) reached, service will remain degraded", sm.maxRestarts)
@@ -257,24 +481,104 @@ func (sm *ServiceManager) attemptRestart() {
 	// Attempt to reinitialize failed components
 	go func() {
 		// Wait before restart attempt
-		time.Sleep(5 * time.Second)
+		select {
+		case <-time.After(sm.restartDelay):
+		case <-sm.ctx.Done():
+			return
+		}
 
 		sm.mu.Lock()
 		defer sm.mu.Unlock()
 
-		// Try to reinitialize dependencies
-		if err := sm.initializeDependencies(); err != nil {
+		if !sm.isRunning {
+			return
+		}
+		if sm.vectorDBRebuilding() {
+			log.Println("Skipping restart while the vector database is rebuilt")
+			return
+		}
+		if err := sm.reinitializeFailedDependencies(); err != nil {
 			log.Printf("Failed to reinitialize dependencies during restart: %v", err)
 			return
 		}
 
-		// Recreate RPC service with reinitialized dependencies
-		sm.rpcService = NewRPCService(sm.ragAgent, sm.vectorDB, sm.llmClient)
-
 		log.Printf("Service restart attempt %d completed", sm.restartCount)
 	}()
 }
 
+// reinitializeFailedDependencies initializes again the dependencies marked unhealthy,
+// those the RAG agent relies on first. sm.mu must be held.
+func (sm *ServiceManager) reinitializeFailedDependencies() error {
+	dependencies := []struct {
+		name       string
+		component  string
+		dependency interface{ Initialize() error }
+	}{
+		{"vector_db", ComponentVectorDB, sm.vectorDB},
+		{"llm_client", ComponentLLM, sm.llmClient},
+		{"rag_agent", ComponentRAGAgent, sm.ragAgent},
+	}
+
+	for _, dependency := range dependencies {
+		if dependency.dependency == nil || sm.healthStatus[dependency.name] {
+			continue
+		}
+		if err := dependency.dependency.Initialize(); err != nil {
+			return WrapError(err, ErrorCodeInitializationError, "failed to initialize "+dependency.name).WithComponent(dependency.component)
+		}
+		log.Printf("Reinitialized %s", dependency.name)
+	}
+	return nil
+}
+
+// Lifecycle methods, named after their LSP counterparts
+const (
+	ShutdownMethod = "Shutdown"
+	ExitMethod     = "Exit"
+)
+
+// ShutdownArgs represents the arguments for the Shutdown RPC method
+type ShutdownArgs struct{}
+
+// ShutdownResult represents the result of the Shutdown RPC method
+type ShutdownResult struct {
+	Success bool `json:"success"`
+}
+
+// ExitArgs represents the arguments for the Exit RPC method
+type ExitArgs struct{}
+
+// ExitResult represents the result of the Exit RPC method
+type ExitResult struct{}
+
+// handleShutdown implements the Shutdown RPC method. A daemon is only shut down by
+// its last client.
+func (sm *ServiceManager) handleShutdown(ctx context.Context, args *ShutdownArgs, result *ShutdownResult) error {
+	if err := sm.refuseWhileShared(ShutdownMethod); err != nil {
+		return err
+	}
+
+	if err := sm.Shutdown(ctx); err != nil {
+		rpcErr := WrapError(err, ErrorCodeInternalError, "shutdown failed")
+		LogError(rpcErr, "Shutdown")
+		return rpcErr
+	}
+
+	result.Success = true
+	return nil
+}
+
+// handleExit implements the Exit RPC method. The process exits once the response
+// has been written, unless other daemon clients are attached.
+func (sm *ServiceManager) handleExit(args *ExitArgs, result *ExitResult) error {
+	if err := sm.refuseWhileShared(ExitMethod); err != nil {
+		return err
+	}
+
+	sm.Exit()
+	return nil
+}
+
 // RecoverFromPanic recovers from panics in RPC methods and returns appropriate errors
 func RecoverFromPanic() error {
 	if r := recover(); r != nil {
@@ -1,6 +1,13 @@
 package server
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -102,6 +109,133 @@ func TestServiceManager_HealthMonitoring(t *testing.T) {
 	}
 }
 
+// initCountingRAGAgent counts how often it is initialized
+type initCountingRAGAgent struct {
+	MockRAGAgent
+	inits atomic.Int32
+}
+
+func (m *initCountingRAGAgent) Initialize() error {
+	m.inits.Add(1)
+	return nil
+}
+
+// initCountingVectorDB counts how often it is initialized
+type initCountingVectorDB struct {
+	MockVectorDB
+	inits atomic.Int32
+}
+
+func (m *initCountingVectorDB) Initialize() error {
+	m.inits.Add(1)
+	return nil
+}
+
+func TestServiceManager_RestartKeepsRPCService(t *testing.T) {
+	config := &ServiceManagerConfig{
+		MaxRestarts:         1,
+		HealthCheckInterval: 20 * time.Millisecond,
+		RestartDelay:        10 * time.Millisecond,
+	}
+
+	ragAgent := &initCountingRAGAgent{MockRAGAgent: MockRAGAgent{shouldError: true}}
+	vectorDB := &initCountingVectorDB{}
+	sm := NewServiceManager(ragAgent, vectorDB, &MockLLMClient{}, config)
+	if err := sm.Start(); err != nil {
+		t.Fatalf("unexpected error starting service: %v", err)
+	}
+	defer sm.Stop()
+	service := sm.GetRPCService()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for ragAgent.inits.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if ragAgent.inits.Load() != 2 {
+		t.Errorf("expected the failing RAG agent to be initialized again once, got %d initializations", ragAgent.inits.Load())
+	}
+	if vectorDB.inits.Load() != 1 {
+		t.Errorf("expected the healthy vector database not to be initialized again, got %d initializations", vectorDB.inits.Load())
+	}
+	if sm.GetRPCService() != service {
+		t.Errorf("expected the restart to keep the registered RPC service")
+	}
+}
+
+// rebuildingVectorDB is unreachable while it is rebuilt, as ChromaDB is stopped then
+type rebuildingVectorDB struct {
+	initCountingVectorDB
+	rebuilding atomic.Bool
+	started    chan struct{}
+	release    chan struct{}
+}
+
+func (m *rebuildingVectorDB) Rebuilding() bool {
+	return m.rebuilding.Load()
+}
+
+func (m *rebuildingVectorDB) HealthCheck() error {
+	if m.rebuilding.Load() {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (m *rebuildingVectorDB) RebuildDatabase() error {
+	m.rebuilding.Store(true)
+	defer m.rebuilding.Store(false)
+	close(m.started)
+	<-m.release
+	return nil
+}
+
+func TestServiceManager_HealthCheckDuringRebuild(t *testing.T) {
+	config := &ServiceManagerConfig{
+		MaxRestarts:         1,
+		HealthCheckInterval: time.Hour,
+		RestartDelay:        10 * time.Millisecond,
+	}
+
+	vectorDB := &rebuildingVectorDB{started: make(chan struct{}), release: make(chan struct{})}
+	sm := NewServiceManager(&MockRAGAgent{}, vectorDB, &MockLLMClient{}, config)
+	if err := sm.Start(); err != nil {
+		t.Fatalf("unexpected error starting service: %v", err)
+	}
+	defer sm.Stop()
+
+	results := make(chan interface{}, 1)
+	go func() {
+		result, err := sm.GetRPCService().runRebuildDatabaseJob(context.Background(), nil, func(JobProgress) {})
+		if err != nil {
+			t.Errorf("unexpected rebuild error: %v", err)
+		}
+		results <- result
+	}()
+	<-vectorDB.started
+
+	// A tick while ChromaDB is down for the rebuild must not start it again
+	sm.performHealthCheck()
+	time.Sleep(50 * time.Millisecond)
+	if inits := vectorDB.inits.Load(); inits != 1 {
+		t.Errorf("expected the vector database not to be initialized during the rebuild, got %d initializations", inits)
+	}
+	if healthy, checked := sm.GetHealthStatus()["vector_db"]; checked && !healthy {
+		t.Errorf("expected the rebuild not to mark the vector database unhealthy")
+	}
+
+	close(vectorDB.release)
+	result, _ := (<-results).(*RebuildDatabaseResult)
+	if result == nil || !result.ResyncRequired {
+		t.Errorf("expected the rebuild result to ask for a resync, got %+v", result)
+	}
+
+	sm.performHealthCheck()
+	if !sm.GetHealthStatus()["vector_db"] {
+		t.Errorf("expected the vector database to be healthy after the rebuild")
+	}
+}
+
 // MockRAGAgentWithInitError implements RAGAgent with initialization error
 type MockRAGAgentWithInitError struct {
 	MockRAGAgent
@@ -127,6 +261,227 @@ func TestServiceManager_InitializationFailure(t *testing.T) {
 	}
 }
 
+// closeTrackingVectorDB records whether the service manager closed it
+type closeTrackingVectorDB struct {
+	MockVectorDB
+	closed bool
+}
+
+func (m *closeTrackingVectorDB) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestServiceManager_ShutdownExit(t *testing.T) {
+	vectorDB := &closeTrackingVectorDB{}
+	sm := NewServiceManager(&MockRAGAgent{}, vectorDB, &MockLLMClient{}, nil)
+	if err := sm.Start(); err != nil {
+		t.Fatalf("unexpected error starting service: %v", err)
+	}
+
+	registry := NewRegistry("test", Version)
+	release := make(chan struct{})
+	Register(registry, "Slow", "Block until released", WithoutContext(func(args *HealthCheckArgs, result *HealthStatus) error {
+		<-release
+		result.Status = "done"
+		return nil
+	}))
+
+	dispatcher := NewDispatcher(registry.Handle, nil)
+	sm.SetDispatcher(dispatcher)
+	sm.RegisterMethods(registry)
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		defer outW.Close()
+		dispatcher.Serve(inR, outW)
+	}()
+
+	responses := make(chan JSONRPCResponse)
+	go func() {
+		decoder := json.NewDecoder(outR)
+		for {
+			var response JSONRPCResponse
+			if err := decoder.Decode(&response); err != nil {
+				close(responses)
+				return
+			}
+			responses <- response
+		}
+	}()
+	receive := func() JSONRPCResponse {
+		t.Helper()
+		select {
+		case response := <-responses:
+			return response
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for a response")
+			return JSONRPCResponse{}
+		}
+	}
+
+	io.WriteString(inW, `{"jsonrpc":"2.0","method":"Slow","id":1}`+"\n")
+	io.WriteString(inW, `{"jsonrpc":"2.0","method":"Shutdown","id":2}`+"\n")
+
+	// New requests are refused while Shutdown waits for the slow one
+	time.Sleep(50 * time.Millisecond)
+	io.WriteString(inW, `{"jsonrpc":"2.0","method":"HealthCheck","id":3}`+"\n")
+	if response := receive(); response.ID != float64(3) || response.Error == nil || response.Error.Code != JSONRPCInvalidRequest {
+		t.Errorf("expected request during shutdown to be refused, got %+v", response)
+	}
+	if vectorDB.closed {
+		t.Errorf("expected dependencies to stay open until in-flight requests finish")
+	}
+
+	close(release)
+	got := map[float64]JSONRPCResponse{}
+	for i := 0; i < 2; i++ {
+		response := receive()
+		got[response.ID.(float64)] = response
+	}
+	if got[1].Error != nil {
+		t.Errorf("expected in-flight request to complete, got %+v", got[1].Error)
+	}
+	if got[2].Error != nil {
+		t.Errorf("unexpected Shutdown error: %+v", got[2].Error)
+	}
+	if !vectorDB.closed || sm.IsRunning() {
+		t.Errorf("expected Shutdown to stop the service and close its dependencies")
+	}
+
+	io.WriteString(inW, `{"jsonrpc":"2.0","method":"Exit","id":4}`+"\n")
+	if response := receive(); response.Error != nil {
+		t.Errorf("expected Exit to be accepted after Shutdown, got %+v", response.Error)
+	}
+	select {
+	case <-sm.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected Exit to close Done")
+	}
+	if code := sm.ExitCode(); code != 0 {
+		t.Errorf("expected exit code 0 after Shutdown, got %d", code)
+	}
+
+	// Close ends Serve even though the client never closed its end
+	dispatcher.Close()
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatalf("expected Serve to return after Close")
+	}
+}
+
+func TestServiceManager_ExitWithoutShutdown(t *testing.T) {
+	sm := NewServiceManager(&MockRAGAgent{}, &MockVectorDB{}, &MockLLMClient{}, nil)
+	if err := sm.Start(); err != nil {
+		t.Fatalf("unexpected error starting service: %v", err)
+	}
+
+	if code := sm.ExitCode(); code != 0 {
+		t.Errorf("expected exit code 0 before Exit, got %d", code)
+	}
+
+	sm.Exit()
+	if code := sm.ExitCode(); code != 1 {
+		t.Errorf("expected exit code 1 for Exit without Shutdown, got %d", code)
+	}
+
+	// Shutdown still releases resources afterwards and may be repeated
+	if err := sm.Shutdown(context.Background()); err != nil {
+		t.Errorf("unexpected Shutdown error: %v", err)
+	}
+	if err := sm.Shutdown(context.Background()); err != nil {
+		t.Errorf("unexpected error on repeated Shutdown: %v", err)
+	}
+	if sm.IsRunning() {
+		t.Errorf("expected service to be stopped")
+	}
+	if code := sm.ExitCode(); code != 1 {
+		t.Errorf("expected exit code to stay 1 after a late Shutdown, got %d", code)
+	}
+}
+
+func TestServiceManager_ShutdownRefusedWhileShared(t *testing.T) {
+	sm := NewServiceManager(&MockRAGAgent{}, &MockVectorDB{}, &MockLLMClient{}, nil)
+	if err := sm.Start(); err != nil {
+		t.Fatalf("unexpected error starting service: %v", err)
+	}
+	defer sm.Stop()
+
+	registry := NewRegistry("test", Version)
+	dispatcher := NewDispatcher(registry.Handle, nil)
+	sm.SetDispatcher(dispatcher)
+	sm.RegisterMethods(registry)
+
+	socketPath := testSocketPath(t)
+	daemon := NewDaemon(dispatcher, &DaemonConfig{SocketPath: socketPath})
+	if err := daemon.Listen(); err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go daemon.Serve()
+	defer daemon.Close()
+	sm.SetDaemon(daemon)
+
+	request := func(conn net.Conn, reader *bufio.Reader, method string, id int) JSONRPCResponse {
+		t.Helper()
+		io.WriteString(conn, `{"jsonrpc":"2.0","method":"`+method+`","id":`+string(rune('0'+id))+"}\n")
+		var response JSONRPCResponse
+		line, err := reader.ReadBytes('\n')
+		if err != nil || json.Unmarshal(line, &response) != nil {
+			t.Fatalf("failed to read %s response: %v", method, err)
+		}
+		return response
+	}
+
+	first, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer first.Close()
+	firstReader := bufio.NewReader(first)
+	second, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	request(second, bufio.NewReader(second), "HealthCheck", 1)
+
+	// Another nvim still uses the daemon, so neither request may stop it
+	for i, method := range []string{ShutdownMethod, ExitMethod} {
+		response := request(first, firstReader, method, i+1)
+		if response.Error == nil {
+			t.Fatalf("expected %s to be refused", method)
+		}
+		if data, _ := response.Error.Data.(map[string]interface{}); data["code"] != float64(ErrorCodeInvalidRequest) {
+			t.Errorf("expected %s to be refused as an invalid request, got %+v", method, response.Error)
+		}
+	}
+	if !sm.IsRunning() {
+		t.Fatalf("expected the service to keep running for the other client")
+	}
+	select {
+	case <-sm.Done():
+		t.Fatalf("expected Exit to be refused")
+	default:
+	}
+
+	second.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(daemon.Clients()) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The last client may shut the daemon down
+	if response := request(first, firstReader, ShutdownMethod, 3); response.Error != nil {
+		t.Errorf("expected the last client to shut down the daemon, got %+v", response.Error)
+	}
+	if sm.IsRunning() {
+		t.Errorf("expected Shutdown to stop the service")
+	}
+}
+
 func TestRecoverFromPanic(t *testing.T) {
 	// Test normal execution (no panic)
 	err := RecoverFromPanic()
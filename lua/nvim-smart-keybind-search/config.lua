@@ -30,6 +30,13 @@ local default_config = {
 		auto_start = true,
 		-- Backend log level (debug, info, warn, error)
 		log_level = "info",
+		-- Wire protocol: "json" (JSON-RPC over stdio) or "msgpack" (native Neovim RPC channel)
+		transport = "json",
+		-- Share one backend daemon between all Neovim instances over a Unix socket
+		daemon = false,
+		-- Socket path of the shared daemon (nil for the default per-user path); its directory
+		-- must belong to you and be closed to other users (mode 700)
+		socket_path = nil,
 	},
 
 	-- Keybinding scanner configuration
@@ -149,6 +156,10 @@ local function validate_config(config)
 		return false, "Backend binary not found or not executable: " .. config.backend.binary_path
 	end
 
+	if config.backend.transport and config.backend.transport ~= "json" and config.backend.transport ~= "msgpack" then
+		return false, "Backend transport must be 'json' or 'msgpack'"
+	end
+
 	-- Validate picker configuration
 	if config.picker.min_query_length and config.picker.min_query_length < 1 then
 		return false, "Minimum query length must be at least 1"
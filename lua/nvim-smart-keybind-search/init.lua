@@ -198,11 +198,24 @@ function M.search_keybindings(query)
 	picker.open(query, M._config.picker, M._config.ui)
 end
 
+--- Show the progress of a keybinding sync in the command line; failed batches are
+--- reported as warnings, the final result by the sync callback
+--- @param progress table $/progress params: phase, processed, total and error
+local function show_sync_progress(progress)
+	if progress.error and progress.error ~= "" then
+		vim.notify("Keybinding sync (" .. progress.phase .. "): " .. progress.error, vim.log.levels.WARN)
+	end
+	vim.api.nvim_echo({
+		{ string.format("Syncing keybindings: %s %d/%d", progress.phase, progress.processed, progress.total) },
+	}, false, {})
+end
+
 ---Sync all keybindings with the backend
 ---@tag nvim-smart-keybind-search-sync
 ---
 ---Scans all current keybindings and syncs them with the backend server.
----This includes both built-in and custom keybindings.
+---This includes both built-in and custom keybindings. The scan replaces the
+---stored set, so deleted mappings stop showing up in results.
 ---
 ---@usage
 ---```lua
@@ -224,13 +237,16 @@ function M.sync_keybindings()
 	end
 
 	local keybindings = keybind_scanner.scan_all()
-	rpc_client.sync_keybindings(keybindings, function(success, error_msg)
+	rpc_client.sync_keybindings(keybindings, function(success, error_msg, error_data)
 		if success then
 			vim.notify("Keybindings synced successfully", vim.log.levels.INFO)
 		else
-			vim.notify("Failed to sync keybindings: " .. (error_msg or "Unknown error"), vim.log.levels.ERROR)
+			vim.notify(
+				"Failed to sync keybindings: " .. rpc_client.describe_error(error_msg, error_data),
+				vim.log.levels.ERROR
+			)
 		end
-	end)
+	end, show_sync_progress, { clear_existing = true })
 end
 
 --- Update specific keybindings (for incremental updates)
@@ -240,9 +256,12 @@ function M.update_keybindings(changed_keybindings)
 		return
 	end
 
-	rpc_client.update_keybindings(changed_keybindings, function(success, error_msg)
+	rpc_client.update_keybindings(changed_keybindings, function(success, error_msg, error_data)
 		if not success then
-			vim.notify("Failed to update keybindings: " .. (error_msg or "Unknown error"), vim.log.levels.WARN)
+			vim.notify(
+				"Failed to update keybindings: " .. rpc_client.describe_error(error_msg, error_data),
+				vim.log.levels.WARN
+			)
 		end
 	end)
 end
@@ -410,16 +429,19 @@ function M.force_sync()
 
 	-- Force a fresh scan
 	local keybindings = keybind_scanner.scan_all()
-	rpc_client.sync_keybindings(keybindings, function(success, error_msg)
+	rpc_client.sync_keybindings(keybindings, function(success, error_msg, error_data)
 		if success then
 			vim.notify(
 				"Keybindings force-synced successfully (" .. #keybindings .. " keybindings)",
 				vim.log.levels.INFO
 			)
 		else
-			vim.notify("Failed to force-sync keybindings: " .. (error_msg or "Unknown error"), vim.log.levels.ERROR)
+			vim.notify(
+				"Failed to force-sync keybindings: " .. rpc_client.describe_error(error_msg, error_data),
+				vim.log.levels.ERROR
+			)
 		end
-	end)
+	end, show_sync_progress, { clear_existing = true })
 end
 
 ---Get scanner statistics
@@ -163,6 +163,7 @@ local function scan_mode_keybindings(mode, config)
 			plugin = plugin,
 			metadata = {
 				buffer_local = mapping.buffer_local and "true" or "false",
+				filetype = mapping.buffer_local and vim.bo.filetype ~= "" and vim.bo.filetype or nil,
 				silent = mapping.silent and "true" or "false",
 				noremap = mapping.noremap and "true" or "false",
 				nowait = mapping.nowait and "true" or "false",
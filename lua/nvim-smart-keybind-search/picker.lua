@@ -5,6 +5,7 @@ local M = {}
 
 -- Import required modules
 local rpc_client = require("nvim-smart-keybind-search.rpc_client")
+local utils = require("nvim-smart-keybind-search.utils")
 
 -- Picker state
 local picker_state = {
@@ -15,6 +16,7 @@ local picker_state = {
 	is_searching = false,
 	loading_timer = nil,
 	query_suggestions = {},
+	editor_context = nil, -- Editor state when the picker was opened
 }
 
 -- Common query suggestions for auto-completion
@@ -204,8 +206,31 @@ local function debounced_search(query, config, ui_config, callback, picker)
 		picker_state.last_query = query
 		picker_state.is_searching = true
 
+		-- Format results for picker with enhanced customization
+		local function format_results(results)
+			local formatted_results = {}
+			local max_results = config.max_results or 20
+
+			for i, result in ipairs(results) do
+				if i > max_results then
+					break
+				end
+				table.insert(formatted_results, format_result(result, config, ui_config))
+			end
+
+			return formatted_results
+		end
+
+		-- Show vector hits while the LLM is still ranking them; the loading indicator
+		-- stays until the final results arrive
+		local function show_partial(partial)
+			if picker_state.is_searching and picker_state.last_query == query and partial.results then
+				callback(format_results(partial.results))
+			end
+		end
+
 		-- Perform RPC query
-		rpc_client.query(query, function(results, error_msg)
+		rpc_client.query(query, function(results, error_msg, error_data)
 			picker_state.is_searching = false
 			hide_loading_indicator(picker)
 
@@ -215,7 +240,7 @@ local function debounced_search(query, config, ui_config, callback, picker)
 					picker:set_prompt("Error - Press Esc to close")
 				end
 
-				vim.notify("Search error: " .. error_msg, vim.log.levels.WARN)
+				vim.notify("Search error: " .. rpc_client.describe_error(error_msg, error_data), vim.log.levels.WARN)
 				callback({})
 				return
 			end
@@ -225,20 +250,10 @@ local function debounced_search(query, config, ui_config, callback, picker)
 				return
 			end
 
-			-- Format results for picker with enhanced customization
-			local formatted_results = {}
-			local max_results = config.max_results or 20
-
-			for i, result in ipairs(results.results) do
-				if i > max_results then
-					break
-				end
-				table.insert(formatted_results, format_result(result, config, ui_config))
-			end
-
+			local formatted_results = format_results(results.results)
 			picker_state.search_results = formatted_results
 			callback(formatted_results)
-		end)
+		end, show_partial, picker_state.editor_context)
 	end)
 end
 
@@ -434,6 +449,9 @@ function M.open(initial_query, picker_config, ui_config)
 		return
 	end
 
+	-- Capture the editor state before the picker window takes focus
+	picker_state.editor_context = utils.editor_context()
+
 	-- Reset picker state
 	picker_state.last_query = ""
 	picker_state.search_results = {}
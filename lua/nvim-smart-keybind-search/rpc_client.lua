@@ -9,8 +9,13 @@ local client_state = {
 	config = nil,
 	request_id = 0,
 	pending_requests = {},
+	progress_handlers = {}, -- $/progress callbacks of in-flight requests, keyed by progress token
 	request_queue = {},
+	stdout_partial = "", -- incomplete line carried over between on_stdout callbacks
 	is_connected = false,
+	initialized = false, -- whether the Initialize handshake has completed
+	server_info = nil, -- Initialize result: server version, methods, features, model
+
 	reconnect_timer = nil,
 	reconnect_attempts = 0,
 	max_reconnect_attempts = 5,
@@ -20,6 +25,29 @@ local client_state = {
 -- JSON-RPC message types
 local JSON_RPC_VERSION = "2.0"
 
+-- Protocol version sent during the Initialize handshake
+local PROTOCOL_VERSION = "1.0"
+
+-- Optional features requested during the handshake
+local CLIENT_FEATURES = { "cancellation", "streaming", "progress" }
+
+-- The handshake waits for the backend to finish starting its dependencies
+local INITIALIZE_TIMEOUT = 60000
+
+-- How long disconnecting waits for the backend to finish in-flight requests
+local SHUTDOWN_TIMEOUT = 3000
+
+-- Over msgpack, requests are wrapped in this notification and the backend delivers
+-- responses and notifications by calling REPLY_CHUNK through nvim_exec_lua
+local REPLY_REQUEST_METHOD = "$/request"
+local REPLY_CHUNK = 'return require("nvim-smart-keybind-search.rpc_client").handle_message(...)'
+
+--- Check whether the backend speaks msgpack-RPC over a native Neovim RPC channel
+--- @return boolean
+local function use_msgpack()
+	return client_state.config ~= nil and client_state.config.backend.transport == "msgpack"
+end
+
 --- Generate next request ID
 --- @return number Request ID
 local function next_request_id()
@@ -78,12 +106,34 @@ local function handle_response(response)
 
 	-- Call callback with result or error
 	if response.error then
-		pending.callback(nil, response.error.message or "Unknown error")
+		-- error.data carries the backend's error code, component and whether to retry
+		pending.callback(nil, response.error.message or "Unknown error", response.error.data)
 	else
 		pending.callback(response.result, nil)
 	end
 end
 
+--- Handle a notification sent by the backend
+--- @param notification table JSON-RPC notification
+local function handle_notification(notification)
+	if notification.method == "$/queryPartial" then
+		-- Intermediate results of a streamed Query, delivered before its response
+		local params = notification.params or {}
+		local pending = client_state.pending_requests[params.id]
+		if pending and pending.on_partial then
+			pending.on_partial(params)
+		end
+	elseif notification.method == "$/progress" then
+		local params = notification.params or {}
+		local on_progress = client_state.progress_handlers[params.token]
+		if on_progress then
+			on_progress(params)
+		end
+	else
+		log_debug("Ignoring notification: " .. tostring(notification.method))
+	end
+end
+
 --- Handle backend output (stdout)
 --- @param job_id number Job ID
 --- @param data table Output data lines
@@ -92,12 +142,24 @@ local function handle_stdout(job_id, data)
 		return
 	end
 
+	-- Neovim splits output at arbitrary points: the first chunk continues the previous
+	-- partial line and the last chunk is incomplete until the next callback (or EOF)
+	data[1] = client_state.stdout_partial .. (data[1] or "")
+	client_state.stdout_partial = table.remove(data) or ""
+
 	for _, line in ipairs(data) do
 		if line and line ~= "" then
 			log_debug("Received: " .. line)
 
 			local ok, response = pcall(vim.json.decode, line)
-			if ok and response then
+			if ok and type(response) == "table" and vim.tbl_islist(response) then
+				-- Batch response: one entry per request in the batch
+				for _, item in ipairs(response) do
+					handle_response(item)
+				end
+			elseif ok and response and response.method and response.id == nil then
+				handle_notification(response)
+			elseif ok and response then
 				handle_response(response)
 			else
 				log_error("Failed to parse JSON response: " .. line)
@@ -132,6 +194,9 @@ local function handle_exit(job_id, exit_code)
 	log_debug("Backend exited with code: " .. exit_code)
 	client_state.is_connected = false
 	client_state.job_id = nil
+	client_state.stdout_partial = ""
+	client_state.initialized = false
+	client_state.server_info = nil
 
 	-- Fail all pending requests
 	for id, pending in pairs(client_state.pending_requests) do
@@ -141,10 +206,14 @@ local function handle_exit(job_id, exit_code)
 		pending.callback(nil, "Backend disconnected")
 	end
 	client_state.pending_requests = {}
+	client_state.progress_handlers = {}
 
 	-- Note: Reconnection logic removed for simplicity
 end
 
+-- Defined once send_request is available
+local initialize_session
+
 --- Start the Go backend process
 --- @return boolean Success
 local function start_backend()
@@ -175,13 +244,32 @@ local function start_backend()
 	vim.notify("nvim-smart-keybind-search: Starting backend server (this may take a moment)...", vim.log.levels.INFO)
 
 	-- Start the backend process
-	local job_id = vim.fn.jobstart({ binary_path }, {
-		on_stdout = handle_stdout,
+	-- In daemon mode the process is only a relay to the shared daemon, which it starts if needed
+	local cmd = { binary_path }
+	if client_state.config.backend.daemon then
+		table.insert(cmd, "-connect")
+		if client_state.config.backend.socket_path then
+			table.insert(cmd, "-socket")
+			table.insert(cmd, client_state.config.backend.socket_path)
+		end
+	end
+
+	local job_opts = {
 		on_stderr = handle_stderr,
 		on_exit = handle_exit,
-		stdout_buffered = false,
 		stderr_buffered = false,
-	})
+	}
+	if use_msgpack() then
+		-- Neovim owns stdout and decodes msgpack-RPC itself
+		table.insert(cmd, "-transport")
+		table.insert(cmd, "msgpack")
+		job_opts.rpc = true
+	else
+		job_opts.on_stdout = handle_stdout
+		job_opts.stdout_buffered = false
+	end
+
+	local job_id = vim.fn.jobstart(cmd, job_opts)
 
 	if job_id <= 0 then
 		log_error("Failed to start backend process")
@@ -190,9 +278,13 @@ local function start_backend()
 
 	client_state.job_id = job_id
 	client_state.is_connected = true
+	client_state.stdout_partial = ""
 	client_state.reconnect_attempts = 0
+	client_state.initialized = false
+	client_state.server_info = nil
 
 	log_debug("Backend started with job ID: " .. job_id)
+	initialize_session()
 	return true
 end
 
@@ -206,12 +298,13 @@ local function connect_backend()
 	return start_backend()
 end
 
---- Send JSON-RPC request to backend
+--- Send JSON-RPC request to backend, applying the timeout over both transports
 --- @param method string RPC method name
 --- @param params any Method parameters
 --- @param callback function Callback function(result, error)
 --- @param timeout? number Optional timeout in milliseconds
-local function send_request(method, params, callback, timeout)
+--- @param on_partial? function Optional callback(partial) for $/queryPartial notifications
+local function send_request(method, params, callback, timeout, on_partial)
 	-- Queue request if not connected
 	if not client_state.is_connected then
 		table.insert(client_state.request_queue, {
@@ -219,6 +312,7 @@ local function send_request(method, params, callback, timeout)
 			params = params,
 			callback = callback,
 			timeout = timeout,
+			on_partial = on_partial,
 		})
 
 		-- Try to connect
@@ -228,12 +322,25 @@ local function send_request(method, params, callback, timeout)
 		return
 	end
 
+	-- Hold requests until the handshake completes so servers in strict mode accept them
+	if not client_state.initialized and method ~= "Initialize" then
+		table.insert(client_state.request_queue, {
+			method = method,
+			params = params,
+			callback = callback,
+			timeout = timeout,
+			on_partial = on_partial,
+		})
+		return
+	end
+
 	local request = create_request(method, params)
 	local request_timeout = timeout or (client_state.config and client_state.config.backend.timeout) or 5000
 
 	-- Store pending request
 	client_state.pending_requests[request.id] = {
 		callback = callback,
+		on_partial = on_partial,
 		timer = nil,
 	}
 
@@ -247,11 +354,24 @@ local function send_request(method, params, callback, timeout)
 	end)
 
 	-- Send request
-	local json_data = vim.json.encode(request) .. "\n"
-	log_debug("Sending: " .. json_data:sub(1, -2)) -- Remove newline for logging
+	local sent
+	if use_msgpack() then
+		-- rpcrequest() would block the editor until the response, so the request goes out as
+		-- a notification and the backend answers by running REPLY_CHUNK with the response
+		log_debug("Sending request: " .. method)
+		sent = pcall(vim.rpcnotify, client_state.job_id, REPLY_REQUEST_METHOD, {
+			id = request.id,
+			method = method,
+			params = params or vim.empty_dict(),
+			reply = REPLY_CHUNK,
+		})
+	else
+		local json_data = vim.json.encode(request) .. "\n"
+		log_debug("Sending: " .. json_data:sub(1, -2)) -- Remove newline for logging
+		sent = vim.fn.chansend(client_state.job_id, json_data) ~= 0
+	end
 
-	local success = vim.fn.chansend(client_state.job_id, json_data)
-	if success == 0 then
+	if not sent then
 		-- Failed to send, clean up
 		if client_state.pending_requests[request.id] then
 			if client_state.pending_requests[request.id].timer then
@@ -263,6 +383,32 @@ local function send_request(method, params, callback, timeout)
 	end
 end
 
+--- Send JSON-RPC notification to backend (no id, no response expected)
+--- @param method string RPC method name
+--- @param params any Method parameters
+--- @return boolean Whether the notification was written to the backend
+local function send_notification(method, params)
+	if not client_state.is_connected and not connect_backend() then
+		return false
+	end
+
+	if use_msgpack() then
+		log_debug("Sending notification: " .. method)
+		return pcall(vim.rpcnotify, client_state.job_id, method, params or vim.empty_dict())
+	end
+
+	local notification = {
+		jsonrpc = JSON_RPC_VERSION,
+		method = method,
+		params = params or {},
+	}
+
+	local json_data = vim.json.encode(notification) .. "\n"
+	log_debug("Sending notification: " .. json_data:sub(1, -2))
+
+	return vim.fn.chansend(client_state.job_id, json_data) ~= 0
+end
+
 --- Process queued requests
 local function process_queue()
 	if not client_state.is_connected or #client_state.request_queue == 0 then
@@ -273,10 +419,46 @@ local function process_queue()
 	client_state.request_queue = {}
 
 	for _, queued_request in ipairs(queue) do
-		send_request(queued_request.method, queued_request.params, queued_request.callback, queued_request.timeout)
+		send_request(
+			queued_request.method,
+			queued_request.params,
+			queued_request.callback,
+			queued_request.timeout,
+			queued_request.on_partial
+		)
 	end
 end
 
+--- Perform the Initialize handshake and release queued requests once it completes.
+--- Servers predating the handshake answer with an error and are used without it.
+initialize_session = function()
+	local version = vim.version()
+	local params = {
+		protocol_version = PROTOCOL_VERSION,
+		client_name = "nvim-smart-keybind-search",
+		nvim_version = string.format("%d.%d.%d", version.major, version.minor, version.patch),
+		features = CLIENT_FEATURES,
+	}
+
+	send_request("Initialize", params, function(result, error)
+		if error then
+			if error:find("unsupported protocol version", 1, true) then
+				vim.notify(
+					"nvim-smart-keybind-search: Backend does not support this plugin version, please rebuild it: " .. error,
+					vim.log.levels.WARN
+				)
+			end
+			log_debug("Initialize failed: " .. error)
+		else
+			client_state.server_info = result
+			log_debug("Initialized with server version " .. tostring(result.server_version))
+		end
+
+		client_state.initialized = true
+		process_queue()
+	end, INITIALIZE_TIMEOUT)
+end
+
 --- Setup the RPC client with backend configuration
 --- @param backend_config table Backend configuration
 function M.setup(backend_config)
@@ -295,23 +477,49 @@ end
 
 --- Query the backend for keybinding search
 --- @param query string Search query
---- @param callback function Callback function(results, error)
-function M.query(query, callback)
+--- @param callback function Callback function(results, error, error_data)
+--- @param on_partial? function Callback function(partial) receiving early results ({stage, results, reasoning})
+--- before the final ones; only called with a server supporting streaming
+--- @param context? table Editor state the query was made in (see utils.editor_context), used to rank results
+function M.query(query, callback, on_partial, context)
 	if not query or query == "" then
 		callback(nil, "Query cannot be empty")
 		return
 	end
 
-	send_request("Query", { query = query }, callback)
+	local params = { query = query, context = context }
+	if on_partial then
+		params.stream = true
+	end
+
+	send_request("Query", params, callback, nil, on_partial)
 end
 
 --- Sync all keybindings with the backend
 --- @param keybindings table List of keybindings
---- @param callback function Callback function(success, error)
-function M.sync_keybindings(keybindings, callback)
-	send_request("SyncKeybindings", { keybindings = keybindings or {} }, function(result, error)
+--- @param callback function Callback function(success, error, error_data)
+--- @param on_progress? function Callback function(progress) receiving {phase, processed, total, error}
+--- while the backend works; only called with a server supporting progress
+--- @param opts? table Options: clear_existing replaces all stored keybindings with this set
+function M.sync_keybindings(keybindings, callback, on_progress, opts)
+	local params = { keybindings = keybindings or {} }
+	if opts and opts.clear_existing then
+		params.clear_existing = true
+	end
+	local token
+	if on_progress then
+		token = "sync-" .. next_request_id()
+		params.progress_token = token
+		client_state.progress_handlers[token] = on_progress
+	end
+
+	send_request("SyncKeybindings", params, function(result, error, error_data)
+		if token then
+			client_state.progress_handlers[token] = nil
+		end
+
 		if error then
-			callback(false, error)
+			callback(false, error, error_data)
 		else
 			callback(true, nil)
 		end
@@ -320,23 +528,182 @@ end
 
 --- Update specific keybindings
 --- @param keybindings table List of changed keybindings
---- @param callback function Callback function(success, error)
+--- @param callback function Callback function(success, error, error_data)
 function M.update_keybindings(keybindings, callback)
-	send_request("UpdateKeybindings", { keybindings = keybindings or {} }, function(result, error)
+	send_request("UpdateKeybindings", { keybindings = keybindings or {} }, function(result, error, error_data)
 		if error then
-			callback(false, error)
+			callback(false, error, error_data)
 		else
 			callback(true, nil)
 		end
 	end)
 end
 
+--- Delete stored user keybindings
+--- @param selector table {ids?, modes?, plugins?}; the fields combine and at least one must be set
+--- @param callback function Callback function(result, error, error_data) with result {deleted_count, ids}
+function M.delete_keybindings(selector, callback)
+	send_request("DeleteKeybindings", selector or {}, callback)
+end
+
+--- List a page of the stored keybindings
+--- @param opts table {sources?, modes?, plugins?, prefix?, cursor?, limit?}; pass the next_cursor
+--- of a page as cursor to get the following one
+--- @param callback function Callback function(result, error, error_data) with result {keybindings, next_cursor}
+function M.list_keybindings(opts, callback)
+	send_request("ListKeybindings", opts or {}, callback)
+end
+
+--- Find keybindings that duplicate each other, wait for longer mappings or clash across modes
+--- @param keybindings? table Keybindings to analyze, such as a sync payload; the stored ones when nil
+--- @param callback function Callback function(report, error, error_data) with report {analyzed, conflicts, counts}
+function M.analyze_conflicts(keybindings, callback)
+	send_request("AnalyzeConflicts", { keybindings = keybindings }, callback)
+end
+
+--- Find built-in keybindings that user mappings replace, and whether they are restored elsewhere
+--- @param keybindings? table Keybindings to compare, such as a sync payload; the stored ones when nil
+--- @param callback function Callback function(report, error, error_data) with report {analyzed, shadowed, lost}
+function M.find_shadowed_builtins(keybindings, callback)
+	send_request("FindShadowedBuiltins", { keybindings = keybindings }, callback)
+end
+
+--- Look up what a key sequence does and break it into its parts
+--- @param keys string Key sequence such as "gq", "<C-w>o" or "<leader>ff"
+--- @param opts? table Options {mode?, use_llm?}; the leaders come from vim.g.mapleader and vim.g.maplocalleader
+--- @param callback function Callback function(result, error, error_data) with result {keys, mode, matches, sequence?, explanation?}
+function M.explain_keys(keys, opts, callback)
+	opts = opts or {}
+	send_request("ExplainKeys", {
+		keys = keys,
+		mode = opts.mode,
+		leader = vim.g.mapleader,
+		local_leader = vim.g.maplocalleader,
+		use_llm = opts.use_llm,
+	}, callback)
+end
+
+--- Get the state, progress and result of a background job
+--- @param job_id string Job ID returned by an async SyncKeybindings, PullModel or RebuildDatabase
+--- @param callback function Callback function(job, error)
+function M.get_job_status(job_id, callback)
+	send_request("GetJobStatus", { id = job_id }, callback)
+end
+
+--- List background jobs, optionally only those in one state
+--- @param state? string queued, running, succeeded, failed or cancelled
+--- @param callback function Callback function(jobs, error)
+function M.list_jobs(state, callback)
+	send_request("ListJobs", { state = state }, function(result, error)
+		callback(result and result.jobs or {}, error)
+	end)
+end
+
+--- Cancel a queued or running background job
+--- @param job_id string Job ID
+--- @param callback function Callback function(job, error)
+function M.cancel_job(job_id, callback)
+	send_request("CancelJob", { id = job_id }, callback)
+end
+
+-- Backend error codes with their own guidance (see internal/server/errors.go)
+local ERROR_CODE_QUERY_TOO_LONG = 4002
+local ERROR_CODE_RATE_LIMITED = 4003
+
+-- Guidance for retryable failures, by the backend component that failed
+local COMPONENT_GUIDANCE = {
+	llm = "Ollama is not responding; try again once it is running",
+	vectordb = "ChromaDB is not responding; try again shortly or check :SmartKeybindHealth",
+	rag = "the search backend is still starting or recovering; try again shortly",
+}
+
+--- Describe a failed request, adding guidance based on the backend's error data
+--- @param message string Error message passed to a callback
+--- @param error_data? table error.data from the backend ({code, details, retryable, component})
+--- @return string
+function M.describe_error(message, error_data)
+	message = message or "Unknown error"
+	if type(error_data) ~= "table" then
+		return message
+	end
+
+	local guidance
+	if error_data.code == ERROR_CODE_QUERY_TOO_LONG then
+		guidance = "shorten the query to 1000 characters or less"
+	elseif error_data.code == ERROR_CODE_RATE_LIMITED then
+		guidance = "too many requests; wait a moment and try again"
+	elseif error_data.retryable then
+		guidance = COMPONENT_GUIDANCE[error_data.component] or "try again shortly"
+	end
+
+	if error_data.details and error_data.details ~= "" then
+		message = message .. " (" .. error_data.details .. ")"
+	end
+	if guidance then
+		message = message .. ": " .. guidance
+	end
+	return message
+end
+
+--- Handle a message the backend delivers over msgpack by running REPLY_CHUNK
+--- @param message table JSON-RPC response or notification
+function M.handle_message(message)
+	if type(message) ~= "table" then
+		log_error("Received invalid message: " .. vim.inspect(message))
+	elseif message.method and message.id == nil then
+		handle_notification(message)
+	else
+		handle_response(message)
+	end
+end
+
+--- Send a fire-and-forget notification
+--- @param method string RPC method name
+--- @param params any Method parameters
+--- @return boolean Whether the notification was sent
+function M.notify(method, params)
+	return send_notification(method, params)
+end
+
+--- Get the server description returned by the Initialize handshake
+--- @return table|nil Server version, protocol version, methods, features, model and knowledge base version
+function M.server_info()
+	return client_state.server_info
+end
+
+--- Check whether the connected server implements a method
+--- @param method string RPC method name
+--- @return boolean
+function M.supports(method)
+	local info = client_state.server_info
+	return info ~= nil and vim.tbl_contains(info.methods or {}, method)
+end
+
 --- Check backend health
 --- @param callback function Callback function(health_status, error)
 function M.health_check(callback)
 	send_request("HealthCheck", {}, callback, 2000) -- Shorter timeout for health checks
 end
 
+--- Ask a backend we own to shut down gracefully, so it can finish in-flight requests and
+--- stop the services it started. A shared daemon is left running for other clients.
+local function shutdown_backend()
+	local backend = client_state.config and client_state.config.backend or {}
+	if not client_state.is_connected or backend.daemon then
+		return
+	end
+
+	local done = false
+	send_request("Shutdown", {}, function()
+		send_notification("Exit", {})
+		done = true
+	end, SHUTDOWN_TIMEOUT)
+
+	vim.wait(SHUTDOWN_TIMEOUT, function()
+		return done
+	end, 50)
+end
+
 --- Disconnect from backend
 function M.disconnect()
 	if client_state.reconnect_timer then
@@ -344,6 +711,8 @@ function M.disconnect()
 		client_state.reconnect_timer = nil
 	end
 
+	shutdown_backend()
+
 	if client_state.job_id then
 		vim.fn.jobstop(client_state.job_id)
 		client_state.job_id = nil
@@ -351,6 +720,8 @@ function M.disconnect()
 
 	client_state.is_connected = false
 	client_state.reconnect_attempts = 0
+	client_state.initialized = false
+	client_state.server_info = nil
 
 	-- Clear pending requests
 	for id, pending in pairs(client_state.pending_requests) do
@@ -360,6 +731,7 @@ function M.disconnect()
 		pending.callback(nil, "Client disconnected")
 	end
 	client_state.pending_requests = {}
+	client_state.progress_handlers = {}
 	client_state.request_queue = {}
 
 	log_debug("RPC client disconnected")
@@ -102,6 +102,27 @@ function M.mode_to_name(mode)
 	return mode_names[mode] or mode
 end
 
+--- Describe the editor state for ranking search results: the mode, the filetype and
+--- the mappings local to the current buffer, as "mode lhs" lines
+--- @return table Context sent with a Query request
+function M.editor_context()
+	local buffer_maps = {}
+	for _, mode in ipairs({ "n", "i", "v", "x", "s", "o", "c", "t" }) do
+		for _, mapping in ipairs(vim.api.nvim_buf_get_keymap(0, mode)) do
+			table.insert(buffer_maps, mode .. " " .. mapping.lhs)
+		end
+	end
+
+	local version = vim.version()
+	return {
+		mode = vim.api.nvim_get_mode().mode,
+		filetype = vim.bo.filetype,
+		buffer_maps = table.concat(buffer_maps, "\n"),
+		cwd = vim.fn.getcwd(),
+		nvim_version = string.format("%d.%d.%d", version.major, version.minor, version.patch),
+	}
+end
+
 --- Generate a unique ID for a keybinding
 --- @param keys string Key sequence
 --- @param mode string Mode